@@ -0,0 +1,202 @@
+package client
+
+import (
+	"io"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+
+	"github.com/cloud9-tools/go-cas/common"
+	"github.com/cloud9-tools/go-cas/proto"
+)
+
+// CAS is a typed API over a Client.  It takes and returns common.Addr and
+// common.Block rather than proto messages, checks that every block it
+// receives really has the address it was asked for, and returns errors of
+// type *Error (or ErrNotFound) rather than raw gRPC errors.
+type CAS struct {
+	Client Client
+
+	// Hints, if set, are sent with every request that accepts them.
+	Hints *proto.CacheHints
+}
+
+func NewCAS(c Client) *CAS {
+	return &CAS{Client: c}
+}
+
+func DialCAS(target string, opts ...grpc.DialOption) (*CAS, error) {
+	c, err := DialClient(target, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return NewCAS(c), nil
+}
+
+func (cas *CAS) Close() error {
+	return cas.Client.Close()
+}
+
+// Get returns the block at addr, or ErrNotFound if there isn't one.
+func (cas *CAS) Get(ctx context.Context, addr common.Addr) (*common.Block, error) {
+	out, err := cas.Client.Get(ctx, &proto.GetRequest{
+		Addr:  addr.String(),
+		Hints: cas.Hints,
+	})
+	if err != nil {
+		return nil, mapError("get", err)
+	}
+	if !out.Found {
+		return nil, ErrNotFound
+	}
+	block := &common.Block{}
+	if err := block.Pad(out.Block); err != nil {
+		return nil, &Error{Op: "get", Err: ErrCorrupt, Desc: err.Error()}
+	}
+	if err := common.Verify(addr, block.Addr()); err != nil {
+		return nil, &Error{Op: "get", Err: ErrCorrupt, Desc: err.Error()}
+	}
+	return block, nil
+}
+
+// Has returns true iff there is a block at addr.
+func (cas *CAS) Has(ctx context.Context, addr common.Addr) (bool, error) {
+	out, err := cas.Client.Get(ctx, &proto.GetRequest{
+		Addr:    addr.String(),
+		NoBlock: true,
+		Hints:   cas.Hints,
+	})
+	if err != nil {
+		return false, mapError("has", err)
+	}
+	return out.Found, nil
+}
+
+// Put stores block, and returns its address.
+func (cas *CAS) Put(ctx context.Context, block *common.Block) (common.Addr, error) {
+	addr := block.Addr()
+	out, err := cas.Client.Put(ctx, &proto.PutRequest{
+		Addr:  addr.String(),
+		Block: block.Trim(),
+		Hints: cas.Hints,
+	})
+	if err != nil {
+		return common.Addr{}, mapError("put", err)
+	}
+	var got common.Addr
+	if err := got.Parse(out.Addr); err != nil {
+		return common.Addr{}, &Error{Op: "put", Err: ErrServer, Desc: err.Error()}
+	}
+	if err := common.Verify(addr, got); err != nil {
+		return common.Addr{}, &Error{Op: "put", Err: ErrCorrupt, Desc: err.Error()}
+	}
+	return addr, nil
+}
+
+// Remove deletes the block at addr, and returns true iff there was one.
+func (cas *CAS) Remove(ctx context.Context, addr common.Addr) (bool, error) {
+	out, err := cas.Client.Remove(ctx, &proto.RemoveRequest{Addr: addr.String()})
+	if err != nil {
+		return false, mapError("remove", err)
+	}
+	return out.Deleted, nil
+}
+
+// WalkOptions controls what Walk returns.
+type WalkOptions struct {
+	// WantBlocks asks for the contents of each block, not just addresses.
+	WantBlocks bool
+
+	// Regexp, if set, limits the walk to blocks whose contents match it.
+	Regexp string
+}
+
+// Walk iterates over the blocks in the CAS.  The Walker must be closed.
+//
+//	w := cas.Walk(ctx, client.WalkOptions{})
+//	defer w.Close()
+//	for w.Next() {
+//		fmt.Println(w.Addr())
+//	}
+//	if err := w.Err(); err != nil { ... }
+func (cas *CAS) Walk(ctx context.Context, opts WalkOptions) *Walker {
+	ctx, cancel := context.WithCancel(ctx)
+	w := &Walker{cancel: cancel, wantBlocks: opts.WantBlocks}
+	stream, err := cas.Client.Walk(ctx, &proto.WalkRequest{
+		WantBlocks: opts.WantBlocks,
+		Regexp:     opts.Regexp,
+		Hints:      cas.Hints,
+	})
+	if err != nil {
+		w.err = mapError("walk", err)
+		return w
+	}
+	w.stream = stream
+	return w
+}
+
+// Walker is an iterator over the results of a Walk.
+type Walker struct {
+	stream     proto.CAS_WalkClient
+	cancel     context.CancelFunc
+	wantBlocks bool
+	addr       common.Addr
+	block      *common.Block
+	err        error
+}
+
+// Next advances to the next block, and returns false when there are no
+// more blocks or the walk has failed.
+func (w *Walker) Next() bool {
+	if w.err != nil || w.stream == nil {
+		return false
+	}
+	item, err := w.stream.Recv()
+	if err == io.EOF {
+		w.stream = nil
+		return false
+	}
+	if err != nil {
+		w.err = mapError("walk", err)
+		return false
+	}
+	w.block = nil
+	if err := w.addr.Parse(item.Addr); err != nil {
+		w.err = &Error{Op: "walk", Err: ErrServer, Desc: err.Error()}
+		return false
+	}
+	if w.wantBlocks {
+		w.block = &common.Block{}
+		if err := w.block.Pad(item.Block); err != nil {
+			w.err = &Error{Op: "walk", Err: ErrCorrupt, Desc: err.Error()}
+			return false
+		}
+		if err := common.Verify(w.addr, w.block.Addr()); err != nil {
+			w.err = &Error{Op: "walk", Err: ErrCorrupt, Desc: err.Error()}
+			return false
+		}
+	}
+	return true
+}
+
+// Addr returns the address of the current block.
+func (w *Walker) Addr() common.Addr {
+	return w.addr
+}
+
+// Block returns the current block, or nil if WantBlocks wasn't set.
+func (w *Walker) Block() *common.Block {
+	return w.block
+}
+
+// Err returns the error, if any, that ended the walk.
+func (w *Walker) Err() error {
+	return w.err
+}
+
+// Close stops the walk and releases its resources.
+func (w *Walker) Close() error {
+	w.cancel()
+	w.stream = nil
+	return nil
+}
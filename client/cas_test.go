@@ -0,0 +1,236 @@
+package client
+
+import (
+	"io"
+	"sort"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/cloud9-tools/go-cas/common"
+	"github.com/cloud9-tools/go-cas/proto"
+)
+
+// fakeClient is an in-memory Client.  Blocks are stored untrimmed, so that
+// tests can corrupt them.
+type fakeClient struct {
+	blocks map[string][]byte
+	err    error
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{blocks: make(map[string][]byte)}
+}
+
+func (c *fakeClient) Close() error { return nil }
+
+func (c *fakeClient) Get(ctx context.Context, in *proto.GetRequest, opts ...grpc.CallOption) (*proto.GetReply, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	data, found := c.blocks[in.Addr]
+	out := &proto.GetReply{Found: found}
+	if found && !in.NoBlock {
+		out.Block = data
+	}
+	return out, nil
+}
+
+func (c *fakeClient) Put(ctx context.Context, in *proto.PutRequest, opts ...grpc.CallOption) (*proto.PutReply, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	var block common.Block
+	if err := block.Pad(in.Block); err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	addr := block.Addr().String()
+	_, found := c.blocks[addr]
+	c.blocks[addr] = block[:]
+	return &proto.PutReply{Addr: addr, Inserted: !found}, nil
+}
+
+func (c *fakeClient) Remove(ctx context.Context, in *proto.RemoveRequest, opts ...grpc.CallOption) (*proto.RemoveReply, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	_, found := c.blocks[in.Addr]
+	delete(c.blocks, in.Addr)
+	return &proto.RemoveReply{Deleted: found}, nil
+}
+
+func (c *fakeClient) Stat(ctx context.Context, in *proto.StatRequest, opts ...grpc.CallOption) (*proto.StatReply, error) {
+	return &proto.StatReply{BlocksUsed: int64(len(c.blocks))}, c.err
+}
+
+func (c *fakeClient) Walk(ctx context.Context, in *proto.WalkRequest, opts ...grpc.CallOption) (proto.CAS_WalkClient, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	var items []*proto.WalkReply
+	for addr, data := range c.blocks {
+		item := &proto.WalkReply{Addr: addr}
+		if in.WantBlocks {
+			item.Block = data
+		}
+		items = append(items, item)
+	}
+	sort.Sort(byAddr(items))
+	return &fakeWalkClient{items: items}, nil
+}
+
+func (c *fakeClient) CacheStats(ctx context.Context, in *proto.CacheStatsRequest, opts ...grpc.CallOption) (*proto.CacheStatsReply, error) {
+	return nil, grpc.Errorf(codes.Unimplemented, "not a cache")
+}
+
+type byAddr []*proto.WalkReply
+
+func (x byAddr) Len() int           { return len(x) }
+func (x byAddr) Less(i, j int) bool { return x[i].Addr < x[j].Addr }
+func (x byAddr) Swap(i, j int)      { x[i], x[j] = x[j], x[i] }
+
+type fakeWalkClient struct {
+	grpc.ClientStream
+	items []*proto.WalkReply
+}
+
+func (w *fakeWalkClient) Recv() (*proto.WalkReply, error) {
+	if len(w.items) == 0 {
+		return nil, io.EOF
+	}
+	item := w.items[0]
+	w.items = w.items[1:]
+	return item, nil
+}
+
+func blockOf(data string) *common.Block {
+	block := &common.Block{}
+	block.Pad([]byte(data))
+	return block
+}
+
+func TestCAS(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeClient()
+	cas := NewCAS(fake)
+
+	foo := blockOf("foo")
+	addr, err := cas.Put(ctx, foo)
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if addr != foo.Addr() {
+		t.Errorf("Put: expected %v, got %v", foo.Addr(), addr)
+	}
+	if _, err := cas.Put(ctx, blockOf("bar")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	block, err := cas.Get(ctx, addr)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if *block != *foo {
+		t.Errorf("Get: expected %q, got %q", foo.Trim(), block.Trim())
+	}
+	if found, err := cas.Has(ctx, addr); !found || err != nil {
+		t.Errorf("Has: expected true, nil, got %v, %v", found, err)
+	}
+
+	missing := blockOf("missing").Addr()
+	if _, err := cas.Get(ctx, missing); err != ErrNotFound {
+		t.Errorf("Get missing: expected ErrNotFound, got %v", err)
+	}
+	if found, err := cas.Has(ctx, missing); found || err != nil {
+		t.Errorf("Has missing: expected false, nil, got %v, %v", found, err)
+	}
+
+	w := cas.Walk(ctx, WalkOptions{WantBlocks: true})
+	n := 0
+	for w.Next() {
+		if w.Block() == nil || w.Block().Addr() != w.Addr() {
+			t.Errorf("Walk: block does not match %v", w.Addr())
+		}
+		n++
+	}
+	if err := w.Err(); err != nil {
+		t.Errorf("Walk: %v", err)
+	}
+	w.Close()
+	if n != 2 {
+		t.Errorf("Walk: expected 2 blocks, got %d", n)
+	}
+
+	if deleted, err := cas.Remove(ctx, addr); !deleted || err != nil {
+		t.Errorf("Remove: expected true, nil, got %v, %v", deleted, err)
+	}
+	if deleted, err := cas.Remove(ctx, addr); deleted || err != nil {
+		t.Errorf("Remove again: expected false, nil, got %v, %v", deleted, err)
+	}
+}
+
+func TestCAS_Corrupt(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeClient()
+	cas := NewCAS(fake)
+
+	addr, err := cas.Put(ctx, blockOf("foo"))
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	fake.blocks[addr.String()] = []byte("not foo")
+
+	if _, err := cas.Get(ctx, addr); Cause(err) != ErrCorrupt {
+		t.Errorf("Get: expected ErrCorrupt, got %v", err)
+	}
+	w := cas.Walk(ctx, WalkOptions{WantBlocks: true})
+	defer w.Close()
+	if w.Next() {
+		t.Errorf("Walk: expected no blocks")
+	}
+	if Cause(w.Err()) != ErrCorrupt {
+		t.Errorf("Walk: expected ErrCorrupt, got %v", w.Err())
+	}
+}
+
+func TestCAS_Errors(t *testing.T) {
+	type testrow struct {
+		In  error
+		Out error
+	}
+	ctx := context.Background()
+	fake := newFakeClient()
+	cas := NewCAS(fake)
+	addr := blockOf("foo").Addr()
+	for i, row := range []testrow{
+		testrow{grpc.Errorf(codes.PermissionDenied, "denied"), ErrPermissionDenied},
+		testrow{grpc.Errorf(codes.Unauthenticated, "who?"), ErrUnauthenticated},
+		testrow{grpc.Errorf(codes.ResourceExhausted, "quota exceeded"), ErrResourceExhausted},
+		testrow{grpc.Errorf(codes.InvalidArgument, "bad addr"), ErrInvalidArgument},
+		testrow{grpc.Errorf(codes.DataLoss, "corrupt"), ErrCorrupt},
+		testrow{grpc.Errorf(codes.Unavailable, "down"), ErrUnavailable},
+		testrow{grpc.Errorf(codes.Unknown, "oops"), ErrServer},
+		testrow{context.DeadlineExceeded, ErrTimeout},
+	} {
+		fake.err = row.In
+		if _, err := cas.Get(ctx, addr); Cause(err) != row.Out {
+			t.Errorf("[%d] Get: expected %v, got %v", i, row.Out, err)
+		}
+		if _, err := cas.Put(ctx, blockOf("foo")); Cause(err) != row.Out {
+			t.Errorf("[%d] Put: expected %v, got %v", i, row.Out, err)
+		}
+		w := cas.Walk(ctx, WalkOptions{})
+		if w.Next() || Cause(w.Err()) != row.Out {
+			t.Errorf("[%d] Walk: expected %v, got %v", i, row.Out, w.Err())
+		}
+		w.Close()
+	}
+
+	fake.err = grpc.Errorf(codes.ResourceExhausted, "quota exceeded")
+	_, err := cas.Get(ctx, addr)
+	if expected := "get: go-cas/client: resource exhausted: quota exceeded"; err == nil || err.Error() != expected {
+		t.Errorf("expected %q, got %v", expected, err)
+	}
+}
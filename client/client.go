@@ -15,7 +15,14 @@ type Client interface {
 	proto.CASClient
 }
 
+// DialClient connects to target.  If target names several endpoints (see
+// IsMultiSpec), the result is a round-robin MultiClient over all of them.
 func DialClient(target string, opts ...grpc.DialOption) (Client, error) {
+	if IsMultiSpec(target) {
+		return DialMultiClient(target, MultiOptions{}, func(spec string) (Client, error) {
+			return DialSimpleClient(spec, opts...)
+		})
+	}
 	return DialSimpleClient(target, opts...)
 }
 
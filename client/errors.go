@@ -0,0 +1,79 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+var (
+	ErrNotFound          = errors.New("go-cas/client: block not found")
+	ErrCorrupt           = errors.New("go-cas/client: block is corrupt")
+	ErrInvalidArgument   = errors.New("go-cas/client: invalid argument")
+	ErrPermissionDenied  = errors.New("go-cas/client: permission denied")
+	ErrUnauthenticated   = errors.New("go-cas/client: unauthenticated")
+	ErrResourceExhausted = errors.New("go-cas/client: resource exhausted")
+	ErrUnavailable       = errors.New("go-cas/client: server unavailable")
+	ErrTimeout           = errors.New("go-cas/client: deadline exceeded")
+	ErrCanceled          = errors.New("go-cas/client: canceled")
+	ErrServer            = errors.New("go-cas/client: server error")
+)
+
+// Error describes a failed CAS operation.  Err is one of the Err* variables
+// above, so callers can tell failures apart with Cause; Desc has the
+// details, if any, that the server or the client gave.
+type Error struct {
+	Op   string
+	Err  error
+	Desc string
+}
+
+func (e *Error) Error() string {
+	if e.Desc == "" {
+		return fmt.Sprintf("%s: %v", e.Op, e.Err)
+	}
+	return fmt.Sprintf("%s: %v: %s", e.Op, e.Err, e.Desc)
+}
+
+// Cause returns the Err* variable that err stands for, or err itself if it
+// isn't an *Error.
+func Cause(err error) error {
+	if e, ok := err.(*Error); ok {
+		return e.Err
+	}
+	return err
+}
+
+var codeErrors = map[codes.Code]error{
+	codes.NotFound:           ErrNotFound,
+	codes.DataLoss:           ErrCorrupt,
+	codes.InvalidArgument:    ErrInvalidArgument,
+	codes.PermissionDenied:   ErrPermissionDenied,
+	codes.Unauthenticated:    ErrUnauthenticated,
+	codes.ResourceExhausted:  ErrResourceExhausted,
+	codes.Unavailable:        ErrUnavailable,
+	codes.DeadlineExceeded:   ErrTimeout,
+	codes.Canceled:           ErrCanceled,
+	codes.FailedPrecondition: ErrInvalidArgument,
+}
+
+// mapError converts an error from a gRPC call into an *Error.
+func mapError(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	switch err {
+	case context.DeadlineExceeded:
+		return &Error{Op: op, Err: ErrTimeout}
+	case context.Canceled:
+		return &Error{Op: op, Err: ErrCanceled}
+	}
+	cause, found := codeErrors[grpc.Code(err)]
+	if !found {
+		cause = ErrServer
+	}
+	return &Error{Op: op, Err: cause, Desc: grpc.ErrorDesc(err)}
+}
@@ -0,0 +1,135 @@
+package libcasutil
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cloud9-tools/go-cas/server/audit"
+	"github.com/cloud9-tools/go-cas/server/auth"
+	"golang.org/x/net/context"
+)
+
+const AuditHelpText = `Usage: casutil audit [--role=<role>] [--op=<op>] [--addr=<addr>] [--since=<time>] [--until=<time>] [--json] <log>
+	Prints the records in a server's --audit_log that match the flags,
+	oldest first, including the rotated logs <log>.1, <log>.2, and so on.
+	Times are either RFC 3339 (2015-12-01T09:00:00Z) or durations before
+	now (90m).  Each record is printed as tab-separated fields: time, role,
+	operation, addr, result, bytes, and peer.
+`
+
+type AuditFlags struct {
+	Role  string
+	Op    string
+	Addr  string
+	Since string
+	Until string
+	JSON  bool
+}
+
+func AuditAddFlags(fs *flag.FlagSet) interface{} {
+	f := &AuditFlags{}
+	fs.StringVar(&f.Role, "role", "", "only show records for this role")
+	fs.StringVar(&f.Op, "op", "", "only show records for this operation")
+	fs.StringVar(&f.Addr, "addr", "", "only show records for this CAS block")
+	fs.StringVar(&f.Since, "since", "", "only show records at or after this time")
+	fs.StringVar(&f.Until, "until", "", "only show records before this time")
+	fs.BoolVar(&f.JSON, "json", false, "print records as JSON lines")
+	return f
+}
+
+func AuditCmd(d *Dispatcher, ctx context.Context, args []string, fval interface{}) int {
+	f := fval.(*AuditFlags)
+
+	if len(args) != 1 {
+		d.Errorf("audit takes exactly one argument!  got %q", args)
+		return 2
+	}
+
+	now := time.Now()
+	filter := audit.Filter{Role: f.Role, Addr: f.Addr}
+	if f.Op != "" {
+		op, err := auth.ParseOperation(f.Op)
+		if err != nil {
+			d.Errorf("invalid flag --op=%q: %v", f.Op, err)
+			return 2
+		}
+		if op != auth.Any {
+			filter.Operation = strings.ToLower(op.String())
+		}
+	}
+	var err error
+	if filter.Since, err = parseAuditTime(f.Since, now); err != nil {
+		d.Errorf("invalid flag --since=%q: %v", f.Since, err)
+		return 2
+	}
+	if filter.Until, err = parseAuditTime(f.Until, now); err != nil {
+		d.Errorf("invalid flag --until=%q: %v", f.Until, err)
+		return 2
+	}
+
+	files, err := audit.Files(args[0])
+	if err != nil {
+		d.Errorf("%v", err)
+		return 1
+	}
+	if len(files) == 0 {
+		d.Errorf("no audit log found at %q", args[0])
+		return 1
+	}
+
+	ret := 0
+	for _, name := range files {
+		fh, err := os.Open(name)
+		if err != nil {
+			d.Errorf("%v", err)
+			ret = 1
+			continue
+		}
+		err = audit.Read(fh, filter, func(rec *audit.Record) error {
+			d.printAuditRecord(rec, f.JSON)
+			return nil
+		})
+		fh.Close()
+		if err != nil {
+			d.Errorf("%s: %v", name, err)
+			ret = 1
+		}
+	}
+	return ret
+}
+
+func (d *Dispatcher) printAuditRecord(rec *audit.Record, asJSON bool) {
+	if asJSON {
+		d.Println(rec.JSON())
+		return
+	}
+	addr := rec.Addr
+	if addr == "" {
+		addr = "-"
+	}
+	peer := rec.Peer
+	if peer == "" {
+		peer = "-"
+	}
+	d.Printf("%s\t%s\t%s\t%s\t%s\t%d\t%s\n",
+		rec.Time.Format(time.RFC3339Nano), rec.Role, rec.Operation,
+		addr, rec.Result, rec.Bytes, peer)
+}
+
+// parseAuditTime parses in as an RFC 3339 time, or as a duration before now.
+// The empty string is the zero time.
+func parseAuditTime(in string, now time.Time) (time.Time, error) {
+	if in == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, in); err == nil {
+		return t, nil
+	}
+	if dur, err := time.ParseDuration(in); err == nil {
+		return now.Add(-dur), nil
+	}
+	return time.Time{}, fmt.Errorf("expected an RFC 3339 time or a duration")
+}
@@ -0,0 +1,127 @@
+package libcasutil
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/cloud9-tools/go-cas/proto"
+	"golang.org/x/net/context"
+)
+
+const CacheStatHelpText = `Usage: casutil cachestat [-s]
+	Displays hit rates and memory use of a CAS caching server.
+`
+
+type CacheStatFlags struct {
+	Backend string
+	Shards  bool
+}
+
+func CacheStatAddFlags(fs *flag.FlagSet) interface{} {
+	f := &CacheStatFlags{}
+	fs.StringVar(&f.Backend, "backend", "", "CAS backend to connect to")
+	fs.StringVar(&f.Backend, "B", "", "alias for --backend")
+	fs.BoolVar(&f.Shards, "shards", false, "also display statistics for each shard")
+	fs.BoolVar(&f.Shards, "s", false, "alias for --shards")
+	return f
+}
+
+func CacheStatCmd(d *Dispatcher, ctx context.Context, args []string, fval interface{}) int {
+	f := fval.(*CacheStatFlags)
+
+	backend := f.Backend
+	if backend == "" {
+		backend = d.Backend
+	}
+	if backend == "" {
+		d.Error("must specify --backend")
+		return 2
+	}
+
+	if len(args) != 0 {
+		d.Errorf("cachestat takes exactly zero arguments!  got %q", args)
+		return 2
+	}
+
+	client, err := d.Dial(backend)
+	if err != nil {
+		d.Errorf("failed to open CAS %q: %v", backend, err)
+		return 1
+	}
+
+	reply, err := client.CacheStats(ctx, &proto.CacheStatsRequest{})
+	if err != nil {
+		d.Errorf("%v", err)
+		return 1
+	}
+
+	var total proto.ShardStats
+	for _, s := range reply.Shards {
+		total.Entries += s.Entries
+		total.Hits += s.Hits
+		total.Misses += s.Misses
+		total.Evictions += s.Evictions
+		total.Busy += s.Busy
+		total.BytesUsed += s.BytesUsed
+		total.NegativeEntries += s.NegativeEntries
+		total.NegativeHits += s.NegativeHits
+		total.Prefetched += s.Prefetched
+		total.PrefetchHits += s.PrefetchHits
+		total.PrefetchWasted += s.PrefetchWasted
+		total.Pinned += s.Pinned
+	}
+	d.Printf("policy=%s\n", reply.Policy)
+	d.Printf("limit=%d\n", reply.Limit)
+	d.Printf("shards=%d\n", len(reply.Shards))
+	printShardStats(d, &total)
+	if reply.L2Limit > 0 {
+		d.Printf("l2_entries=%d\n", reply.L2Entries)
+		d.Printf("l2_limit=%d\n", reply.L2Limit)
+		d.Printf("l2_hits=%d\n", reply.L2Hits)
+		d.Printf("l2_misses=%d\n", reply.L2Misses)
+		d.Printf("l2_hit_rate=%s\n", hitRate(reply.L2Hits, reply.L2Misses))
+		d.Printf("l2_demoted=%d\n", reply.L2Demoted)
+		d.Printf("l2_dropped=%d\n", reply.L2Dropped)
+		d.Printf("l2_failures=%d\n", reply.L2Failures)
+	}
+	d.Printf("write_back_depth=%d\n", reply.WriteBackDepth)
+	d.Printf("write_back_lag_ms=%d\n", reply.WriteBackLagMs)
+	d.Printf("write_back_drained=%d\n", reply.WriteBackDrained)
+	d.Printf("write_back_failures=%d\n", reply.WriteBackFailures)
+	d.Printf("prefetch_issued=%d\n", reply.PrefetchIssued)
+	d.Printf("prefetch_dropped=%d\n", reply.PrefetchDropped)
+	d.Printf("prefetch_failed=%d\n", reply.PrefetchFailed)
+	d.Printf("verify_failures=%d\n", reply.VerifyFailures)
+	if f.Shards {
+		for i, s := range reply.Shards {
+			d.Printf("\n")
+			d.Printf("shard=%d\n", i)
+			printShardStats(d, s)
+		}
+	}
+	return 0
+}
+
+func printShardStats(d *Dispatcher, s *proto.ShardStats) {
+	d.Printf("entries=%d\n", s.Entries)
+	d.Printf("bytes_used=%d\n", s.BytesUsed)
+	d.Printf("hits=%d\n", s.Hits)
+	d.Printf("misses=%d\n", s.Misses)
+	d.Printf("hit_rate=%s\n", hitRate(s.Hits, s.Misses))
+	d.Printf("evictions=%d\n", s.Evictions)
+	d.Printf("busy=%d\n", s.Busy)
+	d.Printf("negative_entries=%d\n", s.NegativeEntries)
+	d.Printf("negative_hits=%d\n", s.NegativeHits)
+	d.Printf("prefetched=%d\n", s.Prefetched)
+	d.Printf("prefetch_hits=%d\n", s.PrefetchHits)
+	d.Printf("prefetch_hit_rate=%s\n", hitRate(s.PrefetchHits, s.PrefetchWasted))
+	d.Printf("prefetch_wasted=%d\n", s.PrefetchWasted)
+	d.Printf("pinned=%d\n", s.Pinned)
+}
+
+func hitRate(hits, misses int64) string {
+	if hits+misses == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.2f%%", 100.0*float64(hits)/float64(hits+misses))
+}
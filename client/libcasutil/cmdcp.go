@@ -3,7 +3,6 @@ package libcasutil
 import (
 	"flag"
 
-	"github.com/cloud9-tools/go-cas/client"
 	"github.com/cloud9-tools/go-cas/proto"
 	"golang.org/x/net/context"
 )
@@ -47,14 +46,14 @@ func CpCmd(d *Dispatcher, ctx context.Context, args []string, fval interface{})
 		return 2
 	}
 
-	dstClient, err := client.DialClient(backend)
+	dstClient, err := d.Dial(backend)
 	if err != nil {
 		d.Errorf("failed to connect to dst CAS: %q: %v", backend, err)
 		return 1
 	}
 	defer dstClient.Close()
 
-	srcClient, err := client.DialClient(source)
+	srcClient, err := d.Dial(source)
 	if err != nil {
 		d.Errorf("failed to connect to src CAS: %q: %v", source, err)
 		return 1
@@ -5,24 +5,24 @@ import (
 	"flag"
 	"os"
 
-	"github.com/cloud9-tools/go-cas/client"
 	"github.com/cloud9-tools/go-cas/internal"
 	"github.com/cloud9-tools/go-cas/proto"
 	"golang.org/x/net/context"
 )
 
-const GetHelpText = `Usage: casutil get [-z] <addr>...
-Usage: casutil cat [-z] <addr>...
+const GetHelpText = `Usage: casutil get [-z] [<cache hints>] <addr>...
+Usage: casutil cat [-z] [<cache hints>] <addr>...
 	Prints the contents of the named CAS block to stdout.
 	If multiple blocks are given, their contents are concatenated.
 
 	Each CAS block is a fixed size, padded with \x00.
 	Use the -z flag to trim away the trailing \x00's.
-`
+` + cacheHintsHelpText
 
 type GetFlags struct {
-	Backend  string
-	TrimZero bool
+	Backend    string
+	TrimZero   bool
+	CacheHints CacheHintsFlags
 }
 
 func GetAddFlags(fs *flag.FlagSet) interface{} {
@@ -31,6 +31,7 @@ func GetAddFlags(fs *flag.FlagSet) interface{} {
 	fs.StringVar(&f.Backend, "B", "", "alias for --backend")
 	fs.BoolVar(&f.TrimZero, "trim_zero", false, "trim trailing zero bytes")
 	fs.BoolVar(&f.TrimZero, "z", false, "alias for --trim_zero")
+	f.CacheHints.AddFlags(fs)
 	return f
 }
 
@@ -46,7 +47,13 @@ func GetCmd(d *Dispatcher, ctx context.Context, args []string, fval interface{})
 		return 2
 	}
 
-	client, err := client.DialClient(backend)
+	hints, err := f.CacheHints.Hints()
+	if err != nil {
+		d.Errorf("%v", err)
+		return 2
+	}
+
+	client, err := d.Dial(backend)
 	if err != nil {
 		d.Errorf("failed to connect to CAS: %q: %v", backend, err)
 		return 1
@@ -54,7 +61,7 @@ func GetCmd(d *Dispatcher, ctx context.Context, args []string, fval interface{})
 	defer client.Close()
 
 	for _, addr := range args {
-		reply, err := client.Get(ctx, &proto.GetRequest{Addr: addr})
+		reply, err := client.Get(ctx, &proto.GetRequest{Addr: addr, Hints: hints})
 		if err != nil {
 			d.Errorf("failed to retrieve CAS block: %q: %v", addr, err)
 			return 1
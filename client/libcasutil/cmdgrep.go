@@ -5,26 +5,30 @@ import (
 	"io"
 	"regexp"
 
-	"github.com/cloud9-tools/go-cas/client"
 	"github.com/cloud9-tools/go-cas/proto"
 	"golang.org/x/net/context"
 )
 
-const GrepHelpText = `Usage: casutil grep <regexp>
+const GrepHelpText = `Usage: casutil grep [<cache hints>] <regexp>
 	Lists the CAS blocks that match the provided regular expression.
 
 	Uses the https://golang.org/pkg/regexp/ library, which is mostly but
 	not perfectly compatible with Perl, PCRE, and/or RE2.
-`
+
+	A caching server only caches the scanned blocks if cache hints are
+	given that allow it, such as --priority=low.
+` + cacheHintsHelpText
 
 type GrepFlags struct {
-	Backend string
+	Backend    string
+	CacheHints CacheHintsFlags
 }
 
 func GrepAddFlags(fs *flag.FlagSet) interface{} {
 	f := &GrepFlags{}
 	fs.StringVar(&f.Backend, "backend", "", "CAS backend to connect to")
 	fs.StringVar(&f.Backend, "B", "", "alias for --backend")
+	f.CacheHints.AddFlags(fs)
 	return f
 }
 
@@ -51,7 +55,13 @@ func GrepCmd(d *Dispatcher, ctx context.Context, args []string, fval interface{}
 		return 2
 	}
 
-	client, err := client.DialClient(backend)
+	hints, err := f.CacheHints.Hints()
+	if err != nil {
+		d.Errorf("%v", err)
+		return 2
+	}
+
+	client, err := d.Dial(backend)
 	if err != nil {
 		d.Errorf("failed to open CAS %q: %v", backend, err)
 		return 1
@@ -61,6 +71,7 @@ func GrepCmd(d *Dispatcher, ctx context.Context, args []string, fval interface{}
 	stream, err := client.Walk(ctx, &proto.WalkRequest{
 		WantBlocks: true,
 		Regexp:     args[0],
+		Hints:      hints,
 	})
 	if err != nil {
 		d.Errorf("%v", err)
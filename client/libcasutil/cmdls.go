@@ -4,7 +4,6 @@ import (
 	"flag"
 	"io"
 
-	"github.com/cloud9-tools/go-cas/client"
 	"github.com/cloud9-tools/go-cas/proto"
 	"golang.org/x/net/context"
 )
@@ -43,7 +42,7 @@ func LsCmd(d *Dispatcher, ctx context.Context, args []string, fval interface{})
 		return 2
 	}
 
-	client, err := client.DialClient(backend)
+	client, err := d.Dial(backend)
 	if err != nil {
 		d.Errorf("failed to open CAS %q: %v", backend, err)
 		return 1
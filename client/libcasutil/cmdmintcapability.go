@@ -0,0 +1,78 @@
+package libcasutil
+
+import (
+	"flag"
+	"time"
+
+	"github.com/cloud9-tools/go-cas/common"
+	"github.com/cloud9-tools/go-cas/server/auth"
+	"golang.org/x/net/context"
+)
+
+const MintCapabilityHelpText = `Usage: casutil mint-capability --key=<file> [--refs=<decoder>] [--ttl=<duration>] <addr>
+	Prints a capability that lets its holder get the block at <addr> from
+	servers started with --token_key=<file>, whatever their role.  If
+	--refs is given, the holder may also get every block reachable from
+	<addr> by following the references that the named decoder finds (see
+	cascached --prefetch).  Pass the capability to casutil with the global
+	--capability_file flag.
+`
+
+type MintCapabilityFlags struct {
+	KeyFile string
+	Refs    string
+	TTL     time.Duration
+}
+
+func MintCapabilityAddFlags(fs *flag.FlagSet) interface{} {
+	f := &MintCapabilityFlags{}
+	fs.StringVar(&f.KeyFile, "key", "", "file containing the token signing key")
+	fs.StringVar(&f.Refs, "refs", "", "reference decoder to follow from <addr>")
+	fs.DurationVar(&f.TTL, "ttl", 24*time.Hour, "how long the capability is valid for")
+	return f
+}
+
+func MintCapabilityCmd(d *Dispatcher, ctx context.Context, args []string, fval interface{}) int {
+	f := fval.(*MintCapabilityFlags)
+
+	if f.KeyFile == "" {
+		d.Error("must specify --key")
+		return 2
+	}
+	if f.TTL <= 0 {
+		d.Errorf("--ttl must be positive, got %v", f.TTL)
+		return 2
+	}
+	if f.Refs != "" {
+		if _, err := common.RefDecoderByName(f.Refs); err != nil {
+			d.Errorf("invalid flag --refs=%q: %v", f.Refs, err)
+			return 2
+		}
+	}
+	if len(args) != 1 {
+		d.Errorf("mint-capability takes exactly one argument!  got %q", args)
+		return 2
+	}
+
+	c := auth.Capability{
+		Refs:   f.Refs,
+		Expiry: time.Now().Add(f.TTL),
+	}
+	if err := c.Root.Parse(args[0]); err != nil {
+		d.Errorf("%v", err)
+		return 2
+	}
+
+	key, err := auth.LoadTokenKey(f.KeyFile)
+	if err != nil {
+		d.Errorf("failed to load token key: %v", err)
+		return 1
+	}
+	capability, err := c.Mint(key)
+	if err != nil {
+		d.Errorf("%v", err)
+		return 1
+	}
+	d.Println(capability)
+	return 0
+}
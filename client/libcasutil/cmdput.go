@@ -6,26 +6,27 @@ import (
 	"os"
 	"strings"
 
-	"github.com/cloud9-tools/go-cas/client"
 	"github.com/cloud9-tools/go-cas/proto"
 	"golang.org/x/net/context"
 )
 
-const PutHelpText = `Usage: casutil put <file>...
-Usage: ... | casutil put
+const PutHelpText = `Usage: casutil put [<cache hints>] <file>...
+Usage: ... | casutil put [<cache hints>]
 	Stores the data received on stdin as a CAS block, and prints the CAS
 	block's address to stdout.  Each CAS block is a fixed size; if the
 	received data is too short, it will be padded with \x00's.
-`
+` + cacheHintsHelpText
 
 type PutFlags struct {
-	Backend string
+	Backend    string
+	CacheHints CacheHintsFlags
 }
 
 func PutAddFlags(fs *flag.FlagSet) interface{} {
 	f := &PutFlags{}
 	fs.StringVar(&f.Backend, "backend", "", "CAS backend to connect to")
 	fs.StringVar(&f.Backend, "B", "", "alias for --backend")
+	f.CacheHints.AddFlags(fs)
 	return f
 }
 
@@ -41,7 +42,13 @@ func PutCmd(d *Dispatcher, ctx context.Context, args []string, fval interface{})
 		return 2
 	}
 
-	client, err := client.DialClient(backend)
+	hints, err := f.CacheHints.Hints()
+	if err != nil {
+		d.Errorf("%v", err)
+		return 2
+	}
+
+	client, err := d.Dial(backend)
 	if err != nil {
 		d.Errorf("failed to open CAS %q: %v", backend, err)
 		return 1
@@ -69,7 +76,7 @@ func PutCmd(d *Dispatcher, ctx context.Context, args []string, fval interface{})
 				return 3
 			}
 		}
-		reply, err := client.Put(ctx, &proto.PutRequest{Block: data})
+		reply, err := client.Put(ctx, &proto.PutRequest{Block: data, Hints: hints})
 		if err != nil {
 			d.Errorf("failed to put CAS block: %v", err)
 			return 1
@@ -3,7 +3,6 @@ package libcasutil
 import (
 	"flag"
 
-	"github.com/cloud9-tools/go-cas/client"
 	"github.com/cloud9-tools/go-cas/proto"
 	"golang.org/x/net/context"
 )
@@ -38,7 +37,7 @@ func RmCmd(d *Dispatcher, ctx context.Context, args []string, fval interface{})
 		return 2
 	}
 
-	client, err := client.DialClient(backend)
+	client, err := d.Dial(backend)
 	if err != nil {
 		d.Errorf("failed to open CAS %q: %v", backend, err)
 		return 1
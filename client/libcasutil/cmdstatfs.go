@@ -3,7 +3,6 @@ package libcasutil
 import (
 	"flag"
 
-	"github.com/cloud9-tools/go-cas/client"
 	"github.com/cloud9-tools/go-cas/common"
 	"github.com/cloud9-tools/go-cas/proto"
 	"golang.org/x/net/context"
@@ -42,7 +41,7 @@ func StatfsCmd(d *Dispatcher, ctx context.Context, args []string, fval interface
 		return 2
 	}
 
-	client, err := client.DialClient(backend)
+	client, err := d.Dial(backend)
 	if err != nil {
 		d.Errorf("failed to open CAS %q: %v", backend, err)
 		return 1
@@ -61,5 +60,8 @@ func StatfsCmd(d *Dispatcher, ctx context.Context, args []string, fval interface
 	d.Printf("bytes_free=%d\n", reply.BlocksFree*common.BlockSize)
 	d.Printf("bytes_used=%d\n", reply.BlocksUsed*common.BlockSize)
 	d.Printf("bytes_total=%d\n", total*common.BlockSize)
+	if reply.ActiveBackend != "" {
+		d.Printf("active_backend=%s\n", reply.ActiveBackend)
+	}
 	return 0
 }
@@ -0,0 +1,55 @@
+package libcasutil
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cloud9-tools/go-cas/proto"
+)
+
+const cacheHintsHelpText = `
+	Cache hints, for commands that talk to a CAS caching server:
+	  --no_cache       don't read from or write to the cache
+	  --no_admit       use cached blocks, but don't cache new ones
+	  --pin=DURATION   keep the blocks cached for at least DURATION
+	  --priority=P     one of low, normal, high
+`
+
+// CacheHintsFlags are the flags shared by commands that can pass cache
+// hints to a caching server.
+type CacheHintsFlags struct {
+	NoCache  bool
+	NoAdmit  bool
+	Pin      time.Duration
+	Priority string
+}
+
+func (f *CacheHintsFlags) AddFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&f.NoCache, "no_cache", false, "bypass the cache entirely")
+	fs.BoolVar(&f.NoAdmit, "no_admit", false, "don't add new blocks to the cache")
+	fs.DurationVar(&f.Pin, "pin", 0, "keep blocks cached for at least this long")
+	fs.StringVar(&f.Priority, "priority", "normal", "cache priority: low, normal, or high")
+}
+
+// Hints returns the cache hints to send, or nil if there are none.
+func (f *CacheHintsFlags) Hints() (*proto.CacheHints, error) {
+	priority, found := proto.CacheHints_Priority_value[strings.ToUpper(f.Priority)]
+	if !found {
+		return nil, fmt.Errorf("invalid flag --priority=%q: must be one of low, normal, high", f.Priority)
+	}
+	if f.Pin < 0 {
+		return nil, fmt.Errorf("invalid flag --pin=%v: must not be negative", f.Pin)
+	}
+	hints := &proto.CacheHints{
+		Bypass:     f.NoCache,
+		NoAdmit:    f.NoAdmit,
+		PinSeconds: int64((f.Pin + time.Second - 1) / time.Second),
+		Priority:   proto.CacheHints_Priority(priority),
+	}
+	if *hints == (proto.CacheHints{}) {
+		return nil, nil
+	}
+	return hints, nil
+}
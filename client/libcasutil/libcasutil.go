@@ -7,6 +7,8 @@ import (
 	"os"
 	"time"
 
+	"github.com/cloud9-tools/go-cas/client"
+	"github.com/cloud9-tools/go-cas/common"
 	"github.com/cloud9-tools/go-cas/internal"
 	"golang.org/x/net/context"
 )
@@ -22,6 +24,13 @@ type Dispatcher struct {
 	Timeout     time.Duration
 	Backend     string
 	Source      string
+	TLS         common.TLSConfig
+	Token       string
+	Capability  string
+	Retry       client.RetryPolicy
+	Hedge       string
+	HedgeDelay  time.Duration
+	Balance     client.Balance
 }
 
 type Dispatch struct {
@@ -52,13 +61,62 @@ func NewDispatcher(help string) *Dispatcher {
 	d.AddCommand("ls", LsHelpText, LsCmd, LsAddFlags)
 	d.AddCommand("grep", GrepHelpText, GrepCmd, GrepAddFlags)
 	d.AddCommand("statfs", StatfsHelpText, StatfsCmd, StatfsAddFlags)
+	d.AddCommand("cachestat", CacheStatHelpText, CacheStatCmd, CacheStatAddFlags)
 	d.AddCommand("script", ScriptHelpText, ScriptCmd, ScriptAddFlags)
+	d.AddCommand("audit", AuditHelpText, AuditCmd, AuditAddFlags)
+	d.AddCommand("mint-token", MintTokenHelpText, MintTokenCmd, MintTokenAddFlags)
+	d.AddCommand("mint-capability", MintCapabilityHelpText, MintCapabilityCmd, MintCapabilityAddFlags)
 	d.AddCommand("help", HelpHelpText, HelpCmd, HelpAddFlags)
 	d.AddAlias("cat", "get")
 	d.AddAlias("stat", "statfs")
 	return d
 }
 
+// Dial connects to a CAS backend, over TLS and with a bearer token or a
+// capability if the global flags ask for them.  The backend may name several
+// endpoints, as described by client.ParseEndpoints.  Transient failures are
+// retried according to d.Retry, and if d.Hedge is set, slow Gets are also
+// sent there.
+func (d *Dispatcher) Dial(backend string) (client.Client, error) {
+	c, err := d.dialMulti(backend)
+	if err != nil {
+		return nil, err
+	}
+	rc := client.NewRetryClient(c, d.Retry)
+	if d.Hedge != "" {
+		rc.Hedge, err = d.dialMulti(d.Hedge)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		rc.HedgeDelay = d.HedgeDelay
+	}
+	return rc, nil
+}
+
+// dialMulti connects to every endpoint in backend, balancing requests
+// according to d.Balance, if backend names several endpoints.
+func (d *Dispatcher) dialMulti(backend string) (client.Client, error) {
+	if client.IsMultiSpec(backend) {
+		return client.DialMultiClient(backend, client.MultiOptions{Balance: d.Balance}, d.dial)
+	}
+	return d.dial(backend)
+}
+
+func (d *Dispatcher) dial(backend string) (client.Client, error) {
+	opts, err := d.TLS.DialOptions(backend)
+	if err != nil {
+		return nil, err
+	}
+	if d.Token != "" {
+		opts = append(opts, client.WithToken(d.Token))
+	}
+	if d.Capability != "" {
+		opts = append(opts, client.WithCapability(d.Capability))
+	}
+	return client.DialClient(backend, opts...)
+}
+
 func (d *Dispatcher) wout(data []byte) {
 	if err := internal.WriteExactly(d.Out, data); err != nil {
 		panic(err)
@@ -0,0 +1,372 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/cloud9-tools/go-cas/common"
+	"github.com/cloud9-tools/go-cas/proto"
+	"github.com/cloud9-tools/go-multierror"
+)
+
+// IsMultiSpec returns true iff spec names more than one endpoint, in one of
+// the forms accepted by ParseEndpoints, rather than being a single dial spec.
+func IsMultiSpec(spec string) bool {
+	return strings.HasPrefix(spec, "file:") ||
+		strings.HasPrefix(spec, "srv:") ||
+		strings.Contains(spec, ",")
+}
+
+// ParseEndpoints expands a multi-endpoint spec into a list of dial specs.
+// The spec may be:
+//
+//	tcp:host1:port,tcp:host2:port,unix:/path
+//	file:/path/to/list   (one dial spec per line; '#' starts a comment)
+//	srv:_cas._tcp.example.com
+//
+// The list is read, or the SRV record is looked up, only once.
+func ParseEndpoints(spec string) ([]string, error) {
+	var specs []string
+	switch {
+	case strings.HasPrefix(spec, "file:"):
+		path := spec[len("file:"):]
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if i := strings.IndexByte(line, '#'); i >= 0 {
+				line = line[:i]
+			}
+			if line = strings.TrimSpace(line); line != "" {
+				specs = append(specs, line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+
+	case strings.HasPrefix(spec, "srv:"):
+		name := spec[len("srv:"):]
+		_, addrs, err := net.LookupSRV("", "", name)
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			host := strings.TrimSuffix(addr.Target, ".")
+			specs = append(specs, "tcp:"+net.JoinHostPort(host, strconv.Itoa(int(addr.Port))))
+		}
+
+	default:
+		for _, item := range strings.Split(spec, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				specs = append(specs, item)
+			}
+		}
+	}
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("go-cas/client: %q: no endpoints", spec)
+	}
+	for _, item := range specs {
+		if _, _, err := common.ParseDialSpec(item); err != nil {
+			return nil, fmt.Errorf("go-cas/client: %q: %q: %v", spec, item, err)
+		}
+	}
+	return specs, nil
+}
+
+// Balance chooses how a MultiClient spreads requests over its endpoints.
+type Balance uint8
+
+const (
+	// RoundRobin sends each request to the next endpoint in turn.
+	RoundRobin Balance = iota
+
+	// LeastLoaded sends each request to the endpoint with the fewest
+	// requests in flight.
+	LeastLoaded
+)
+
+func (b Balance) String() string {
+	if b == LeastLoaded {
+		return "least-loaded"
+	}
+	return "round-robin"
+}
+
+func (b *Balance) Set(in string) error {
+	switch strings.ToLower(in) {
+	case "round-robin":
+		*b = RoundRobin
+	case "least-loaded":
+		*b = LeastLoaded
+	default:
+		return errors.New("expected \"round-robin\" or \"least-loaded\"")
+	}
+	return nil
+}
+
+func (b *Balance) Get() interface{} {
+	return *b
+}
+
+var _ flag.Getter = (*Balance)(nil)
+
+// MultiOptions configures a MultiClient.
+type MultiOptions struct {
+	Balance Balance
+
+	// HealthInterval is how often every endpoint is checked with a Stat
+	// RPC.  If zero, it defaults to 5 seconds; if negative, endpoints are
+	// never checked, and ejected endpoints are never re-added.
+	HealthInterval time.Duration
+
+	// HealthTimeout is how long a health check may take.  If zero, it
+	// defaults to 1 second.
+	HealthTimeout time.Duration
+}
+
+type endpoint struct {
+	spec     string
+	client   Client
+	healthy  bool
+	inflight int
+}
+
+// MultiClient is a Client that spreads requests over several endpoints.
+//
+// An endpoint is ejected when an RPC to it finds it unreachable, or when it
+// fails a health check, and is re-added when it passes one.  If every
+// endpoint has been ejected, requests go to all of them regardless, so that
+// the caller sees the real error.  A MultiClient doesn't retry failed RPCs
+// itself; wrap it in a RetryClient so that retries go to another endpoint.
+type MultiClient struct {
+	balance Balance
+	timeout time.Duration
+
+	mutex     sync.Mutex
+	endpoints []*endpoint
+	next      int
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewMultiClient returns a MultiClient over clients, where specs[i] names
+// clients[i] in log messages.
+func NewMultiClient(specs []string, clients []Client, opts MultiOptions) *MultiClient {
+	if opts.HealthInterval == 0 {
+		opts.HealthInterval = 5 * time.Second
+	}
+	if opts.HealthTimeout == 0 {
+		opts.HealthTimeout = 1 * time.Second
+	}
+	m := &MultiClient{
+		balance:   opts.Balance,
+		timeout:   opts.HealthTimeout,
+		endpoints: make([]*endpoint, len(clients)),
+		stop:      make(chan struct{}),
+	}
+	for i := range clients {
+		m.endpoints[i] = &endpoint{spec: specs[i], client: clients[i], healthy: true}
+	}
+	if opts.HealthInterval > 0 {
+		m.wg.Add(1)
+		go m.healthLoop(opts.HealthInterval)
+	}
+	return m
+}
+
+// DialMultiClient expands spec with ParseEndpoints, and connects to each
+// endpoint with dial.
+func DialMultiClient(spec string, opts MultiOptions, dial func(string) (Client, error)) (*MultiClient, error) {
+	specs, err := ParseEndpoints(spec)
+	if err != nil {
+		return nil, err
+	}
+	clients := make([]Client, 0, len(specs))
+	for _, item := range specs {
+		c, err := dial(item)
+		if err != nil {
+			for _, c := range clients {
+				c.Close()
+			}
+			return nil, err
+		}
+		clients = append(clients, c)
+	}
+	return NewMultiClient(specs, clients, opts), nil
+}
+
+func (m *MultiClient) Close() error {
+	close(m.stop)
+	m.wg.Wait()
+	var errs []error
+	for _, e := range m.endpoints {
+		if err := e.client.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return multierror.New(errs)
+}
+
+// Healthy returns the specs of the endpoints that are currently in use.
+func (m *MultiClient) Healthy() []string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var specs []string
+	for _, e := range m.endpoints {
+		if e.healthy {
+			specs = append(specs, e.spec)
+		}
+	}
+	return specs
+}
+
+// pick chooses an endpoint for a request, and counts the request as in
+// flight until done is called.
+func (m *MultiClient) pick() *endpoint {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	candidates := make([]*endpoint, 0, len(m.endpoints))
+	for _, e := range m.endpoints {
+		if e.healthy {
+			candidates = append(candidates, e)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = m.endpoints
+	}
+	start := m.next % len(candidates)
+	m.next++
+	best := candidates[start]
+	if m.balance == LeastLoaded {
+		for i := 1; i < len(candidates); i++ {
+			e := candidates[(start+i)%len(candidates)]
+			if e.inflight < best.inflight {
+				best = e
+			}
+		}
+	}
+	best.inflight++
+	return best
+}
+
+func (m *MultiClient) done(e *endpoint, err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	e.inflight--
+	if isConnectionLost(err) {
+		m.setHealthy(e, false, err)
+	}
+}
+
+// setHealthy must be called with the mutex held.
+func (m *MultiClient) setHealthy(e *endpoint, healthy bool, err error) {
+	if e.healthy == healthy {
+		return
+	}
+	e.healthy = healthy
+	if healthy {
+		log.Printf("info: go-cas/client: endpoint %q has recovered", e.spec)
+	} else {
+		log.Printf("warn: go-cas/client: ejecting endpoint %q: %v", e.spec, err)
+	}
+}
+
+func (m *MultiClient) healthLoop(interval time.Duration) {
+	defer m.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+			m.CheckHealth()
+		}
+	}
+}
+
+// CheckHealth sends a Stat RPC to every endpoint, and ejects or re-adds
+// each one according to whether it answers.  An error that comes from the
+// server, such as PermissionDenied, still counts as an answer.
+func (m *MultiClient) CheckHealth() {
+	var wg sync.WaitGroup
+	wg.Add(len(m.endpoints))
+	for _, e := range m.endpoints {
+		go func(e *endpoint) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+			defer cancel()
+			_, err := e.client.Stat(ctx, &proto.StatRequest{})
+			healthy := !isConnectionLost(err) && grpc.Code(err) != codes.DeadlineExceeded
+			m.mutex.Lock()
+			m.setHealthy(e, healthy, err)
+			m.mutex.Unlock()
+		}(e)
+	}
+	wg.Wait()
+}
+
+func (m *MultiClient) Get(ctx context.Context, in *proto.GetRequest, opts ...grpc.CallOption) (out *proto.GetReply, err error) {
+	e := m.pick()
+	out, err = e.client.Get(ctx, in, opts...)
+	m.done(e, err)
+	return
+}
+
+func (m *MultiClient) Put(ctx context.Context, in *proto.PutRequest, opts ...grpc.CallOption) (out *proto.PutReply, err error) {
+	e := m.pick()
+	out, err = e.client.Put(ctx, in, opts...)
+	m.done(e, err)
+	return
+}
+
+func (m *MultiClient) Remove(ctx context.Context, in *proto.RemoveRequest, opts ...grpc.CallOption) (out *proto.RemoveReply, err error) {
+	e := m.pick()
+	out, err = e.client.Remove(ctx, in, opts...)
+	m.done(e, err)
+	return
+}
+
+func (m *MultiClient) Stat(ctx context.Context, in *proto.StatRequest, opts ...grpc.CallOption) (out *proto.StatReply, err error) {
+	e := m.pick()
+	out, err = e.client.Stat(ctx, in, opts...)
+	m.done(e, err)
+	return
+}
+
+// Walk walks a single endpoint.  Only opening the stream counts towards
+// the endpoint's load.
+func (m *MultiClient) Walk(ctx context.Context, in *proto.WalkRequest, opts ...grpc.CallOption) (out proto.CAS_WalkClient, err error) {
+	e := m.pick()
+	out, err = e.client.Walk(ctx, in, opts...)
+	m.done(e, err)
+	return
+}
+
+func (m *MultiClient) CacheStats(ctx context.Context, in *proto.CacheStatsRequest, opts ...grpc.CallOption) (out *proto.CacheStatsReply, err error) {
+	e := m.pick()
+	out, err = e.client.CacheStats(ctx, in, opts...)
+	m.done(e, err)
+	return
+}
+
+var _ Client = (*MultiClient)(nil)
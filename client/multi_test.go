@@ -0,0 +1,205 @@
+package client
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"runtime"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/cloud9-tools/go-cas/proto"
+)
+
+func TestParseEndpoints(t *testing.T) {
+	f, err := ioutil.TempFile("", "endpoints")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("# replicas\ntcp:a:1\n\n  unix:/run/cas.sock  # local\n")
+	f.Close()
+
+	type testrow struct {
+		In  string
+		Out []string
+		Err bool
+	}
+	for i, row := range []testrow{
+		testrow{"tcp:a:1,tcp:b:2", []string{"tcp:a:1", "tcp:b:2"}, false},
+		testrow{" tcp:a:1 , unix:@cas ,", []string{"tcp:a:1", "unix:@cas"}, false},
+		testrow{"file:" + f.Name(), []string{"tcp:a:1", "unix:/run/cas.sock"}, false},
+		testrow{"tcp:a:1,bogus", nil, true},
+		testrow{",", nil, true},
+		testrow{"file:/nonexistent", nil, true},
+	} {
+		out, err := ParseEndpoints(row.In)
+		if (err != nil) != row.Err {
+			t.Errorf("[%d] %q: expected error=%v, got %v", i, row.In, row.Err, err)
+			continue
+		}
+		if !reflect.DeepEqual(out, row.Out) {
+			t.Errorf("[%d] %q: expected %q, got %q", i, row.In, row.Out, out)
+		}
+	}
+
+	for _, spec := range []string{"tcp:a:1,tcp:b:2", "file:x", "srv:_cas._tcp.example.com"} {
+		if !IsMultiSpec(spec) {
+			t.Errorf("%q: expected a multi-endpoint spec", spec)
+		}
+	}
+	if IsMultiSpec("tcp:localhost:8080") {
+		t.Errorf("tcp:localhost:8080: expected a single endpoint")
+	}
+}
+
+// replica is a Client that counts its Gets, can be taken down, and can be
+// made to hold Gets until released.
+type replica struct {
+	*fakeClient
+
+	mutex sync.Mutex
+	down  bool
+	gets  int
+	hold  chan struct{}
+}
+
+func (r *replica) state() (bool, chan struct{}) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.gets++
+	return r.down, r.hold
+}
+
+func (r *replica) Gets() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.gets
+}
+
+func (r *replica) SetDown(down bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.down = down
+}
+
+func (r *replica) Get(ctx context.Context, in *proto.GetRequest, opts ...grpc.CallOption) (*proto.GetReply, error) {
+	down, hold := r.state()
+	if hold != nil {
+		<-hold
+	}
+	if down {
+		return nil, grpc.Errorf(codes.Unavailable, "down")
+	}
+	return r.fakeClient.Get(ctx, in, opts...)
+}
+
+func (r *replica) Stat(ctx context.Context, in *proto.StatRequest, opts ...grpc.CallOption) (*proto.StatReply, error) {
+	r.mutex.Lock()
+	down := r.down
+	r.mutex.Unlock()
+	if down {
+		return nil, grpc.Errorf(codes.Unavailable, "down")
+	}
+	return nil, grpc.Errorf(codes.PermissionDenied, "access denied")
+}
+
+func newReplicas(n int, balance Balance) ([]*replica, *MultiClient) {
+	replicas := make([]*replica, n)
+	specs := make([]string, n)
+	clients := make([]Client, n)
+	for i := range replicas {
+		replicas[i] = &replica{fakeClient: newFakeClient()}
+		specs[i] = fmt.Sprintf("tcp:replica:%d", i)
+		clients[i] = replicas[i]
+	}
+	return replicas, NewMultiClient(specs, clients, MultiOptions{Balance: balance, HealthInterval: -1})
+}
+
+func TestMultiClient_RoundRobin(t *testing.T) {
+	ctx := context.Background()
+	replicas, m := newReplicas(3, RoundRobin)
+	defer m.Close()
+	for i := 0; i < 9; i++ {
+		if _, err := m.Get(ctx, &proto.GetRequest{}); err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+	}
+	for i, r := range replicas {
+		if n := r.Gets(); n != 3 {
+			t.Errorf("replica %d: expected 3 gets, got %d", i, n)
+		}
+	}
+}
+
+func TestMultiClient_LeastLoaded(t *testing.T) {
+	ctx := context.Background()
+	replicas, m := newReplicas(2, LeastLoaded)
+	defer m.Close()
+	hold := make(chan struct{})
+	replicas[0].hold = hold
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		m.Get(ctx, &proto.GetRequest{})
+	}()
+	for replicas[0].Gets() == 0 {
+		runtime.Gosched()
+	}
+	for i := 0; i < 4; i++ {
+		if _, err := m.Get(ctx, &proto.GetRequest{}); err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+	}
+	close(hold)
+	wg.Wait()
+	if busy, idle := replicas[0].Gets(), replicas[1].Gets(); busy != 1 || idle != 4 {
+		t.Errorf("expected 1 get to the busy replica and 4 to the idle one, got %d and %d", busy, idle)
+	}
+}
+
+func TestMultiClient_Health(t *testing.T) {
+	ctx := context.Background()
+	replicas, m := newReplicas(2, RoundRobin)
+	defer m.Close()
+
+	replicas[0].SetDown(true)
+	m.Get(ctx, &proto.GetRequest{})
+	m.Get(ctx, &proto.GetRequest{})
+	if healthy := m.Healthy(); !reflect.DeepEqual(healthy, []string{"tcp:replica:1"}) {
+		t.Errorf("expected replica 0 to be ejected, got %q", healthy)
+	}
+	before := replicas[0].Gets()
+	for i := 0; i < 4; i++ {
+		if _, err := m.Get(ctx, &proto.GetRequest{}); err != nil {
+			t.Errorf("Get: %v", err)
+		}
+	}
+	if n := replicas[0].Gets(); n != before {
+		t.Errorf("expected no gets to the ejected replica, got %d", n-before)
+	}
+
+	m.CheckHealth()
+	if n := len(m.Healthy()); n != 1 {
+		t.Errorf("replica 0 still down: expected 1 healthy endpoint, got %d", n)
+	}
+	replicas[0].SetDown(false)
+	m.CheckHealth()
+	if n := len(m.Healthy()); n != 2 {
+		t.Errorf("replica 0 recovered: expected 2 healthy endpoints, got %d", n)
+	}
+
+	replicas[0].SetDown(true)
+	replicas[1].SetDown(true)
+	m.CheckHealth()
+	if _, err := m.Get(ctx, &proto.GetRequest{}); grpc.Code(err) != codes.Unavailable {
+		t.Errorf("all down: expected Unavailable, got %v", err)
+	}
+}
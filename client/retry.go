@@ -0,0 +1,265 @@
+package client
+
+import (
+	"math/rand"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/cloud9-tools/go-cas/common"
+	"github.com/cloud9-tools/go-cas/proto"
+	"github.com/cloud9-tools/go-multierror"
+)
+
+// RetryPolicy controls how a RetryClient retries failed RPCs.
+type RetryPolicy struct {
+	// MaxAttempts is the most times an RPC is tried, including the first.
+	MaxAttempts int
+
+	// InitialBackoff is the delay before the first retry.  Each further
+	// retry waits Multiplier times as long, up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+
+	// Jitter is the fraction, between 0 and 1, by which each delay is
+	// randomly shortened, so that clients that failed together don't
+	// retry together.
+	Jitter float64
+
+	// Timeout, if positive, is the deadline for each call, including all
+	// of its retries, unless the caller's context has an earlier one.
+	// Walk streams are not subject to it.
+	Timeout time.Duration
+
+	// Retryable decides which errors are worth retrying.  If nil,
+	// IsTransient is used.
+	Retryable func(error) bool
+}
+
+// DefaultRetryPolicy tries each RPC up to 3 times over about a quarter of a
+// second.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: 50 * time.Millisecond,
+		MaxBackoff:     2 * time.Second,
+		Multiplier:     2.0,
+		Jitter:         0.2,
+	}
+}
+
+// backoff returns the delay before retry number n, counting from zero.
+func (p RetryPolicy) backoff(n int) time.Duration {
+	d := float64(p.InitialBackoff)
+	for i := 0; i < n; i++ {
+		d *= p.Multiplier
+		if p.MaxBackoff > 0 && d >= float64(p.MaxBackoff) {
+			d = float64(p.MaxBackoff)
+			break
+		}
+	}
+	if p.Jitter > 0 {
+		d -= d * p.Jitter * rand.Float64()
+	}
+	return time.Duration(d)
+}
+
+func (p RetryPolicy) retryable(err error) bool {
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return IsTransient(err)
+}
+
+// IsTransient returns true iff err suggests that the same RPC might succeed
+// if tried again: the server was unreachable, the connection was lost, or
+// the server asked the client to back off.  Every RPC in the CAS API is
+// idempotent, so retrying such errors is always safe.
+//
+// Other ResourceExhausted errors, such as an exceeded quota or a full disk,
+// are not transient.
+func IsTransient(err error) bool {
+	if grpc.Code(err) == codes.Aborted || IsRateLimited(err) {
+		return true
+	}
+	return isConnectionLost(err)
+}
+
+// IsRateLimited returns true iff err means that the server refused the RPC
+// because the caller is over its request or byte rate.
+func IsRateLimited(err error) bool {
+	return grpc.Code(err) == codes.ResourceExhausted &&
+		strings.HasPrefix(grpc.ErrorDesc(err), common.RateLimitExceeded)
+}
+
+// isConnectionLost returns true iff err means that the server couldn't be
+// reached, or stopped answering partway through the RPC.
+func isConnectionLost(err error) bool {
+	switch grpc.Code(err) {
+	case codes.Unavailable:
+		return true
+	case codes.Internal:
+		// Old gRPC transports report a dropped connection this way.
+		return grpc.ErrorDesc(err) == "transport is closing"
+	}
+	return false
+}
+
+// RetryClient is a Client that retries transient failures of another
+// Client, with exponential backoff.  If Hedge is set, Gets that take longer
+// than HedgeDelay are also sent to Hedge, and whichever answers first wins.
+type RetryClient struct {
+	Client Client
+	Policy RetryPolicy
+
+	Hedge      Client
+	HedgeDelay time.Duration
+}
+
+func NewRetryClient(c Client, policy RetryPolicy) *RetryClient {
+	return &RetryClient{Client: c, Policy: policy}
+}
+
+func (c *RetryClient) Close() error {
+	var errs []error
+	if err := c.Client.Close(); err != nil {
+		errs = append(errs, err)
+	}
+	if c.Hedge != nil {
+		if err := c.Hedge.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return multierror.New(errs)
+}
+
+// do calls fn until it succeeds, fails with an error that isn't worth
+// retrying, or runs out of attempts or time.
+func (c *RetryClient) do(ctx context.Context, fn func(context.Context) error) error {
+	if c.Policy.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Policy.Timeout)
+		defer cancel()
+	}
+	return c.retry(ctx, fn)
+}
+
+// retry is do without the timeout.
+func (c *RetryClient) retry(ctx context.Context, fn func(context.Context) error) error {
+	for attempt := 0; ; attempt++ {
+		err := fn(ctx)
+		if err == nil || attempt+1 >= c.Policy.MaxAttempts || !c.Policy.retryable(err) {
+			return err
+		}
+		select {
+		case <-time.After(c.Policy.backoff(attempt)):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+func (c *RetryClient) Get(ctx context.Context, in *proto.GetRequest, opts ...grpc.CallOption) (out *proto.GetReply, err error) {
+	err = c.do(ctx, func(ctx context.Context) error {
+		out, err = c.hedgedGet(ctx, in, opts...)
+		return err
+	})
+	return
+}
+
+type getResult struct {
+	out *proto.GetReply
+	err error
+}
+
+// hedgedGet sends in to Client, and also to Hedge if Client fails or hasn't
+// answered within HedgeDelay.  The first success is returned, or the last
+// failure if both fail.
+func (c *RetryClient) hedgedGet(ctx context.Context, in *proto.GetRequest, opts ...grpc.CallOption) (*proto.GetReply, error) {
+	if c.Hedge == nil {
+		return c.Client.Get(ctx, in, opts...)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	ch := make(chan getResult, 2)
+	get := func(cc Client) {
+		out, err := cc.Get(ctx, in, opts...)
+		ch <- getResult{out, err}
+	}
+	go get(c.Client)
+	pending := 1
+	hedged := false
+	timer := time.NewTimer(c.HedgeDelay)
+	defer timer.Stop()
+	for {
+		select {
+		case r := <-ch:
+			pending--
+			if r.err == nil {
+				return r.out, nil
+			}
+			if !hedged {
+				hedged = true
+				pending++
+				go get(c.Hedge)
+			} else if pending == 0 {
+				return nil, r.err
+			}
+		case <-timer.C:
+			if !hedged {
+				hedged = true
+				pending++
+				go get(c.Hedge)
+			}
+		}
+	}
+}
+
+func (c *RetryClient) Put(ctx context.Context, in *proto.PutRequest, opts ...grpc.CallOption) (out *proto.PutReply, err error) {
+	err = c.do(ctx, func(ctx context.Context) error {
+		out, err = c.Client.Put(ctx, in, opts...)
+		return err
+	})
+	return
+}
+
+func (c *RetryClient) Remove(ctx context.Context, in *proto.RemoveRequest, opts ...grpc.CallOption) (out *proto.RemoveReply, err error) {
+	err = c.do(ctx, func(ctx context.Context) error {
+		out, err = c.Client.Remove(ctx, in, opts...)
+		return err
+	})
+	return
+}
+
+func (c *RetryClient) Stat(ctx context.Context, in *proto.StatRequest, opts ...grpc.CallOption) (out *proto.StatReply, err error) {
+	err = c.do(ctx, func(ctx context.Context) error {
+		out, err = c.Client.Stat(ctx, in, opts...)
+		return err
+	})
+	return
+}
+
+// Walk retries opening the stream, but not failures partway through it,
+// since the caller has already seen some of the results.
+func (c *RetryClient) Walk(ctx context.Context, in *proto.WalkRequest, opts ...grpc.CallOption) (out proto.CAS_WalkClient, err error) {
+	err = c.retry(ctx, func(ctx context.Context) error {
+		out, err = c.Client.Walk(ctx, in, opts...)
+		return err
+	})
+	return
+}
+
+func (c *RetryClient) CacheStats(ctx context.Context, in *proto.CacheStatsRequest, opts ...grpc.CallOption) (out *proto.CacheStatsReply, err error) {
+	err = c.do(ctx, func(ctx context.Context) error {
+		out, err = c.Client.CacheStats(ctx, in, opts...)
+		return err
+	})
+	return
+}
+
+var _ Client = (*RetryClient)(nil)
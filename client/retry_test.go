@@ -0,0 +1,178 @@
+package client
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/cloud9-tools/go-cas/proto"
+)
+
+// flakyClient fails the first Failures calls to Get and Put with Err, and
+// delays every Get by Delay.
+type flakyClient struct {
+	*fakeClient
+	Failures int
+	Err      error
+	Delay    time.Duration
+
+	mutex sync.Mutex
+	calls int
+}
+
+func (c *flakyClient) fail() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.calls++
+	if c.calls <= c.Failures {
+		return c.Err
+	}
+	return nil
+}
+
+func (c *flakyClient) Calls() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.calls
+}
+
+func (c *flakyClient) Get(ctx context.Context, in *proto.GetRequest, opts ...grpc.CallOption) (*proto.GetReply, error) {
+	select {
+	case <-time.After(c.Delay):
+	case <-ctx.Done():
+		return nil, grpc.Errorf(codes.Canceled, "%v", ctx.Err())
+	}
+	if err := c.fail(); err != nil {
+		return nil, err
+	}
+	return c.fakeClient.Get(ctx, in, opts...)
+}
+
+func (c *flakyClient) Put(ctx context.Context, in *proto.PutRequest, opts ...grpc.CallOption) (*proto.PutReply, error) {
+	if err := c.fail(); err != nil {
+		return nil, err
+	}
+	return c.fakeClient.Put(ctx, in, opts...)
+}
+
+func testPolicy() RetryPolicy {
+	policy := DefaultRetryPolicy()
+	policy.InitialBackoff = time.Millisecond
+	return policy
+}
+
+func TestRetryClient(t *testing.T) {
+	type testrow struct {
+		Failures int
+		Err      error
+		Calls    int
+		OK       bool
+	}
+	unavailable := grpc.Errorf(codes.Unavailable, "down")
+	denied := grpc.Errorf(codes.PermissionDenied, "denied")
+	ctx := context.Background()
+	for i, row := range []testrow{
+		testrow{0, nil, 1, true},
+		testrow{2, unavailable, 3, true},
+		testrow{3, unavailable, 3, false},
+		testrow{1, denied, 1, false},
+		testrow{1, grpc.Errorf(codes.ResourceExhausted, "rate limit exceeded: slow down"), 2, true},
+		testrow{1, grpc.Errorf(codes.ResourceExhausted, "quota exceeded"), 1, false},
+		testrow{1, grpc.Errorf(codes.ResourceExhausted, "storage exhausted"), 1, false},
+	} {
+		flaky := &flakyClient{fakeClient: newFakeClient(), Failures: row.Failures, Err: row.Err}
+		rc := NewRetryClient(flaky, testPolicy())
+		_, err := rc.Put(ctx, &proto.PutRequest{Block: []byte("foo")})
+		if (err == nil) != row.OK {
+			t.Errorf("[%d] expected ok=%v, got %v", i, row.OK, err)
+		}
+		if !row.OK && err != row.Err {
+			t.Errorf("[%d] expected %v, got %v", i, row.Err, err)
+		}
+		if calls := flaky.Calls(); calls != row.Calls {
+			t.Errorf("[%d] expected %d calls, got %d", i, row.Calls, calls)
+		}
+	}
+}
+
+func TestRetryClient_Timeout(t *testing.T) {
+	flaky := &flakyClient{fakeClient: newFakeClient(), Delay: time.Second}
+	policy := testPolicy()
+	policy.Timeout = 10 * time.Millisecond
+	rc := NewRetryClient(flaky, policy)
+	start := time.Now()
+	if _, err := rc.Get(context.Background(), &proto.GetRequest{}); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("expected the timeout to cut the call short, took %v", elapsed)
+	}
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	p := RetryPolicy{
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     time.Second,
+		Multiplier:     2.0,
+		Jitter:         0.5,
+	}
+	for n, max := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		max *= time.Millisecond
+		for i := 0; i < 20; i++ {
+			if d := p.backoff(n); d > max || d < max/2 {
+				t.Errorf("backoff(%d): expected %v to %v, got %v", n, max/2, max, d)
+			}
+		}
+	}
+}
+
+func TestRetryClient_Hedge(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeClient()
+	cas := NewCAS(fake)
+	addr, err := cas.Put(ctx, blockOf("foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := &proto.GetRequest{Addr: addr.String()}
+
+	slow := &flakyClient{fakeClient: fake, Delay: time.Second}
+	fast := &flakyClient{fakeClient: fake}
+	rc := NewRetryClient(slow, testPolicy())
+	rc.Hedge = fast
+	rc.HedgeDelay = 10 * time.Millisecond
+	start := time.Now()
+	out, err := rc.Get(ctx, in)
+	if err != nil || !out.Found {
+		t.Fatalf("slow primary: expected found, got %v, %v", out, err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("slow primary: expected the hedge to answer, took %v", elapsed)
+	}
+
+	broken := &flakyClient{fakeClient: fake, Failures: 1, Err: grpc.Errorf(codes.Internal, "broken")}
+	fast = &flakyClient{fakeClient: fake}
+	rc = NewRetryClient(broken, testPolicy())
+	rc.Hedge = fast
+	rc.HedgeDelay = time.Hour
+	if out, err := rc.Get(ctx, in); err != nil || !out.Found {
+		t.Errorf("failed primary: expected found, got %v, %v", out, err)
+	}
+	if fast.Calls() != 1 {
+		t.Errorf("failed primary: expected 1 hedged call, got %d", fast.Calls())
+	}
+
+	rc = NewRetryClient(&flakyClient{fakeClient: fake}, testPolicy())
+	rc.Hedge = &flakyClient{fakeClient: fake}
+	rc.HedgeDelay = time.Hour
+	if _, err := rc.Get(ctx, in); err != nil {
+		t.Errorf("healthy primary: unexpected error: %v", err)
+	}
+	if calls := rc.Hedge.(*flakyClient).Calls(); calls != 0 {
+		t.Errorf("healthy primary: expected no hedged calls, got %d", calls)
+	}
+}
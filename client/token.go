@@ -0,0 +1,40 @@
+package client
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+// WithToken returns a DialOption that sends token as a bearer token with
+// every RPC.  Tokens are sent whether or not the connection is encrypted, so
+// that they can be used over local sockets; use TLS for anything else.
+func WithToken(token string) grpc.DialOption {
+	return grpc.WithPerRPCCredentials(tokenCredentials(token))
+}
+
+type tokenCredentials string
+
+func (token tokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(token)}, nil
+}
+
+func (token tokenCredentials) RequireTransportSecurity() bool {
+	return false
+}
+
+// WithCapability returns a DialOption that presents capability, as minted by
+// "casutil mint-capability", with every RPC.  Like tokens, capabilities are
+// sent whether or not the connection is encrypted.
+func WithCapability(capability string) grpc.DialOption {
+	return grpc.WithPerRPCCredentials(capabilityCredentials(capability))
+}
+
+type capabilityCredentials string
+
+func (capability capabilityCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"x-cas-capability": string(capability)}, nil
+}
+
+func (capability capabilityCredentials) RequireTransportSecurity() bool {
+	return false
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/cloud9-tools/go-cas/common"
 	"github.com/cloud9-tools/go-cas/proto"
 	"github.com/cloud9-tools/go-cas/server/cacheserver"
+	"github.com/cloud9-tools/go-cas/server/signal"
 )
 
 func main() {
@@ -26,7 +27,24 @@ func main() {
 	if err != nil {
 		log.Fatalf("listen error: %v", err)
 	}
-	s := grpc.NewServer()
-	proto.RegisterCASServer(s, cacheserver.NewServer(cfg))
+	srv := cacheserver.NewServer(cfg)
+	defer srv.Close()
+	if cfg.WarmBeforeServing {
+		srv.Warm()
+	} else {
+		go srv.Warm()
+	}
+
+	opts, err := cfg.TLS.ServerOptions()
+	if err != nil {
+		log.Fatalf("TLS error: %v", err)
+	}
+	s := grpc.NewServer(opts...)
+	sc1 := signal.Catch(signal.ReloadSignals, srv.Reload)
+	defer sc1.Close()
+	sc2 := signal.Catch(signal.ShutdownSignals, s.Stop)
+	defer sc2.Close()
+	proto.RegisterCASServer(s, srv)
 	s.Serve(listen)
+	log.Printf("clean exit")
 }
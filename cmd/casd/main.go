@@ -34,8 +34,12 @@ func main() {
 	if err != nil {
 		log.Fatalf("listen error: %v", err)
 	}
-	s := grpc.NewServer()
-	sc1 := signal.Catch(signal.IgnoreSignals, func() {})
+	opts, err := cfg.TLS.ServerOptions()
+	if err != nil {
+		log.Fatalf("TLS error: %v", err)
+	}
+	s := grpc.NewServer(opts...)
+	sc1 := signal.Catch(signal.ReloadSignals, srv.Reload)
 	defer sc1.Close()
 	sc2 := signal.Catch(signal.ShutdownSignals, s.Stop)
 	defer sc2.Close()
@@ -0,0 +1,100 @@
+// Command cassim replays a cache trace against each eviction policy and
+// reports the hit rates, to help choose cascached's --policy and --limit.
+//
+// Traces are text files with one hex block address per line, as written by
+// cascached --trace.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/cloud9-tools/go-cas/common"
+	"github.com/cloud9-tools/go-cas/server/eviction"
+)
+
+func main() {
+	log.SetPrefix("cassim: ")
+
+	var policiesFlag, limitsFlag string
+	flag.Var(common.VersionFlag{}, "version", "show version information")
+	flag.StringVar(&policiesFlag, "policies", strings.Join(eviction.Names(), ","),
+		"comma-separated list of eviction policies to simulate")
+	flag.StringVar(&limitsFlag, "limits", "1024",
+		"comma-separated list of cache sizes to simulate, in blocks")
+	flag.Parse()
+
+	policies := strings.Split(policiesFlag, ",")
+	for _, name := range policies {
+		if err := eviction.Valid(name); err != nil {
+			log.Fatalf("invalid flag --policies=%q: %v", policiesFlag, err)
+		}
+	}
+	var limits []int
+	for _, str := range strings.Split(limitsFlag, ",") {
+		n, err := strconv.Atoi(str)
+		if err != nil || n < 1 {
+			log.Fatalf("invalid flag --limits=%q: %q is not a positive integer", limitsFlag, str)
+		}
+		limits = append(limits, n)
+	}
+
+	var trace []common.Addr
+	if flag.NArg() == 0 {
+		trace = readTrace(trace, "-", os.Stdin)
+	}
+	for _, path := range flag.Args() {
+		f, err := os.Open(path)
+		if err != nil {
+			log.Fatalf("error: %v", err)
+		}
+		trace = readTrace(trace, path, f)
+		f.Close()
+	}
+	if len(trace) == 0 {
+		log.Fatalf("error: trace is empty")
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "policy\tlimit\thits\tmisses\thit rate\t")
+	for _, limit := range limits {
+		for _, name := range policies {
+			p, err := eviction.New(name, limit)
+			if err != nil {
+				log.Fatalf("error: %v", err)
+			}
+			hits, misses := eviction.Simulate(p, trace)
+			rate := 100.0 * float64(hits) / float64(len(trace))
+			fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%.2f%%\t\n", name, limit, hits, misses, rate)
+		}
+	}
+	w.Flush()
+}
+
+func readTrace(trace []common.Addr, path string, r io.Reader) []common.Addr {
+	scanner := bufio.NewScanner(r)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		var addr common.Addr
+		if err := addr.Parse(line); err != nil {
+			log.Fatalf("error: %s:%d: %v", path, lineno, err)
+		}
+		trace = append(trace, addr)
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatalf("error: %s: %v", path, err)
+	}
+	return trace
+}
@@ -3,10 +3,13 @@ package main
 
 import (
 	"flag"
+	"io/ioutil"
 	"log"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/cloud9-tools/go-cas/client"
 	"github.com/cloud9-tools/go-cas/client/libcasutil"
 	"github.com/cloud9-tools/go-cas/common"
 )
@@ -21,8 +24,12 @@ func main() {
 	log.SetPrefix("casutil: ")
 	log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
 
-	var backendFlag, sourceFlag string
-	var timeoutFlag time.Duration
+	var backendFlag, sourceFlag, tokenFileFlag, capabilityFileFlag string
+	var timeoutFlag, hedgeDelayFlag time.Duration
+	var hedgeFlag string
+	var retriesFlag int
+	var balanceFlag client.Balance
+	var tlsFlags common.TLSConfig
 	flag.Var(common.VersionFlag{}, "version", "show version information")
 	flag.StringVar(&backendFlag, "backend", "", "default CAS backend for commands to operate on")
 	flag.StringVar(&backendFlag, "B", "", "shorthand for --backend")
@@ -30,8 +37,40 @@ func main() {
 	flag.StringVar(&sourceFlag, "S", "", "shorthand for --source")
 	flag.DurationVar(&timeoutFlag, "timeout", defaultTimeout, "timeout for CAS operations")
 	flag.DurationVar(&timeoutFlag, "t", defaultTimeout, "shorthand for --timeout")
+	flag.StringVar(&tokenFileFlag, "token_file", os.Getenv("CAS_TOKEN_FILE"), "file containing a bearer token to identify with (default $CAS_TOKEN_FILE)")
+	flag.StringVar(&capabilityFileFlag, "capability_file", os.Getenv("CAS_CAPABILITY_FILE"), "file containing a capability to present (default $CAS_CAPABILITY_FILE)")
+	flag.IntVar(&retriesFlag, "retries", 2, "how many times to retry operations that fail transiently")
+	flag.StringVar(&hedgeFlag, "hedge", "", "second CAS backend to send slow 'get' requests to")
+	flag.DurationVar(&hedgeDelayFlag, "hedge_delay", 100*time.Millisecond, "how long to wait for --backend before also asking --hedge")
+	flag.Var(&balanceFlag, "balance", "how to spread requests over a backend with several endpoints: \"round-robin\" or \"least-loaded\"")
+	tlsFlags.AddFlags(flag.CommandLine)
 	flag.Parse()
 
+	if err := tlsFlags.Validate(); err != nil {
+		log.Fatalf("flag error: %v", err)
+	}
+	if retriesFlag < 0 {
+		log.Fatalf("flag error: --retries must not be negative, got %d", retriesFlag)
+	}
+	retry := client.DefaultRetryPolicy()
+	retry.MaxAttempts = retriesFlag + 1
+	var token string
+	if tokenFileFlag != "" {
+		raw, err := ioutil.ReadFile(tokenFileFlag)
+		if err != nil {
+			log.Fatalf("flag error: --token_file: %v", err)
+		}
+		token = strings.TrimSpace(string(raw))
+	}
+	var capability string
+	if capabilityFileFlag != "" {
+		raw, err := ioutil.ReadFile(capabilityFileFlag)
+		if err != nil {
+			log.Fatalf("flag error: --capability_file: %v", err)
+		}
+		capability = strings.TrimSpace(string(raw))
+	}
+
 	if sourceFlag == "" {
 		sourceFlag = backendFlag
 	}
@@ -40,5 +79,12 @@ func main() {
 	d.Backend = backendFlag
 	d.Source = sourceFlag
 	d.Timeout = timeoutFlag
+	d.TLS = tlsFlags
+	d.Token = token
+	d.Capability = capability
+	d.Retry = retry
+	d.Hedge = hedgeFlag
+	d.HedgeDelay = hedgeDelayFlag
+	d.Balance = balanceFlag
 	os.Exit(d.Dispatch(flag.Args()))
 }
@@ -0,0 +1,7 @@
+package common
+
+// RateLimitExceeded begins the description of every ResourceExhausted error
+// that asks the client to slow down.  Other ResourceExhausted errors, such as
+// an exceeded quota or a full disk, won't go away by themselves, so clients
+// use this to tell which ones are worth retrying.
+const RateLimitExceeded = "rate limit exceeded"
@@ -0,0 +1,72 @@
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// RefDecoder recognizes blocks that refer to other blocks, such as the index
+// blocks of a large object that was split into many CAS blocks.
+type RefDecoder interface {
+	// DecodeRefs returns the addresses that block refers to, or nil if
+	// block is not a reference block.
+	DecodeRefs(block *Block) []Addr
+}
+
+// HexListDecoder recognizes blocks that contain nothing but a list of hex
+// addresses, one per line, in the same format that "casutil ls" prints.
+type HexListDecoder struct{}
+
+func (HexListDecoder) DecodeRefs(block *Block) []Addr {
+	raw := bytes.TrimRight(block.Trim(), "\n")
+	if len(raw) == 0 {
+		return nil
+	}
+	// Each line is exactly 40 hex digits, so bail out early if the
+	// length is wrong rather than parsing the whole block.
+	if (len(raw)+1)%41 != 0 {
+		return nil
+	}
+	refs := make([]Addr, 0, (len(raw)+1)/41)
+	for len(raw) > 0 {
+		if len(raw) > 40 && raw[40] != '\n' {
+			return nil
+		}
+		var addr Addr
+		if addr.Parse(string(raw[:40])) != nil {
+			return nil
+		}
+		refs = append(refs, addr)
+		if len(raw) == 40 {
+			break
+		}
+		raw = raw[41:]
+	}
+	return refs
+}
+
+var refDecoders = map[string]RefDecoder{
+	"hexlist": HexListDecoder{},
+}
+
+// RefDecoderNames returns the names accepted by RefDecoderByName, sorted.
+func RefDecoderNames() []string {
+	names := make([]string, 0, len(refDecoders))
+	for name := range refDecoders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RefDecoderByName returns the named RefDecoder.
+func RefDecoderByName(name string) (RefDecoder, error) {
+	decoder, found := refDecoders[name]
+	if !found {
+		return nil, fmt.Errorf("go-cas: unknown reference decoder %q: expected one of %s",
+			name, strings.Join(RefDecoderNames(), ", "))
+	}
+	return decoder, nil
+}
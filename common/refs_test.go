@@ -0,0 +1,39 @@
+package common
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHexListDecoder(t *testing.T) {
+	const a = "da39a3ee5e6b4b0d3255bfef95601890afd80709"
+	const b = "0000000000000000000000000000000000000001"
+	type testrow struct {
+		In       string
+		Expected []string
+	}
+	for i, row := range []testrow{
+		testrow{"", nil},
+		testrow{"hello, world\n", nil},
+		testrow{a, []string{a}},
+		testrow{a + "\n", []string{a}},
+		testrow{a + "\n" + b + "\n", []string{a, b}},
+		testrow{a + "\n" + b + "x\n", nil},
+		testrow{a + " " + b + "\n", nil},
+		testrow{strings.ToUpper(a) + "\n", []string{a}},
+		testrow{a + "\n\n" + b + "\n", nil},
+	} {
+		var block Block
+		block.Pad([]byte(row.In))
+		refs := HexListDecoder{}.DecodeRefs(&block)
+		if len(refs) != len(row.Expected) {
+			t.Errorf("[%2d] expected %d refs, got %v", i, len(row.Expected), refs)
+			continue
+		}
+		for j, ref := range refs {
+			if ref.String() != row.Expected[j] {
+				t.Errorf("[%2d] ref %d: expected %s, got %s", i, j, row.Expected[j], ref)
+			}
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package common
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// TLSConfig holds the flags that configure TLS for a CAS server or client.
+//
+// A server with a certificate serves TLS; if it also has a CA, it requires
+// clients to present certificates signed by that CA.  A client with a CA
+// verifies the server against it; if it also has a certificate, it presents
+// that to the server.
+type TLSConfig struct {
+	CertFile   string
+	KeyFile    string
+	CAFile     string
+	ServerName string
+}
+
+func (cfg *TLSConfig) AddFlags(fs *flag.FlagSet) {
+	fs.StringVar(&cfg.CertFile, "tls_cert", "",
+		"PEM file containing this side's TLS certificate")
+	fs.StringVar(&cfg.KeyFile, "tls_key", "",
+		"PEM file containing the private key for --tls_cert")
+	fs.StringVar(&cfg.CAFile, "tls_ca", "",
+		"PEM file containing the CA certificates that the other side's"+
+			" certificate must be signed by")
+	fs.StringVar(&cfg.ServerName, "tls_server_name", "",
+		"name to expect in the server's certificate, if not the host being dialed")
+}
+
+// Enabled returns true iff any TLS flags are set.
+func (cfg *TLSConfig) Enabled() bool {
+	return cfg.CertFile != "" || cfg.CAFile != ""
+}
+
+func (cfg *TLSConfig) Validate() error {
+	if cfg.CertFile != "" && cfg.KeyFile == "" {
+		return fmt.Errorf("missing required flag: --tls_key")
+	}
+	if cfg.CertFile == "" && cfg.KeyFile != "" {
+		return fmt.Errorf("missing required flag: --tls_cert")
+	}
+	return nil
+}
+
+// ValidateServer is Validate for servers, which can't use a CA without a
+// certificate of their own.
+func (cfg *TLSConfig) ValidateServer() error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	if cfg.CAFile != "" && cfg.CertFile == "" {
+		return fmt.Errorf("missing required flag: --tls_cert")
+	}
+	return nil
+}
+
+// ServerOptions returns the options for a gRPC server, or nil if TLS is
+// not enabled.
+func (cfg *TLSConfig) ServerOptions() ([]grpc.ServerOption, error) {
+	if !cfg.Enabled() {
+		return nil, nil
+	}
+	if cfg.CertFile == "" {
+		return nil, fmt.Errorf("missing required flag: --tls_cert")
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	tc := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if cfg.CAFile != "" {
+		pool, err := loadCertPool(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tc.ClientCAs = pool
+		tc.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return []grpc.ServerOption{grpc.Creds(credentials.NewTLS(tc))}, nil
+}
+
+// DialOptions returns the options for dialing target, or nil if TLS is not
+// enabled.  Without --tls_ca, the system's CAs are trusted.
+func (cfg *TLSConfig) DialOptions(target string) ([]grpc.DialOption, error) {
+	if !cfg.Enabled() {
+		return nil, nil
+	}
+	tc := &tls.Config{ServerName: cfg.ServerName}
+	if tc.ServerName == "" {
+		network, address, err := ParseDialSpec(target)
+		if err != nil {
+			return nil, err
+		}
+		tc.ServerName = "localhost"
+		if network != "unix" {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return nil, err
+			}
+			tc.ServerName = host
+		}
+	}
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+	if cfg.CAFile != "" {
+		pool, err := loadCertPool(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tc.RootCAs = pool
+	}
+	return []grpc.DialOption{grpc.WithTransportCredentials(credentials.NewTLS(tc))}, nil
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(raw) {
+		return nil, fmt.Errorf("%q: no PEM certificates found", path)
+	}
+	return pool, nil
+}
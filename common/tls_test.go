@@ -0,0 +1,51 @@
+package common
+
+import (
+	"testing"
+)
+
+func TestTLSConfig_ValidateServer(t *testing.T) {
+	type testrow struct {
+		Cfg TLSConfig
+		Err string
+	}
+	for i, row := range []testrow{
+		testrow{TLSConfig{},
+			""},
+		testrow{TLSConfig{CertFile: "a.crt", KeyFile: "a.key"},
+			""},
+		testrow{TLSConfig{CertFile: "a.crt", KeyFile: "a.key", CAFile: "ca.crt"},
+			""},
+		testrow{TLSConfig{CertFile: "a.crt"},
+			"missing required flag: --tls_key"},
+		testrow{TLSConfig{KeyFile: "a.key"},
+			"missing required flag: --tls_cert"},
+		testrow{TLSConfig{CAFile: "ca.crt"},
+			"missing required flag: --tls_cert"},
+	} {
+		err := row.Cfg.ValidateServer()
+		var errstr string
+		if err != nil {
+			errstr = err.Error()
+		}
+		if errstr != row.Err {
+			t.Errorf("[%d] expected error %q, got %q", i, row.Err, errstr)
+		}
+	}
+}
+
+func TestTLSConfig_DialOptions(t *testing.T) {
+	var cfg TLSConfig
+	opts, err := cfg.DialOptions("tcp:localhost:80")
+	if err != nil || opts != nil {
+		t.Errorf("disabled: expected nil options, got %v, %v", opts, err)
+	}
+
+	cfg.CAFile = "/nonexistent/ca.crt"
+	if _, err := cfg.DialOptions("bogus:foo"); err != ErrBadDialSpec {
+		t.Errorf("bad spec: expected %v, got %v", ErrBadDialSpec, err)
+	}
+	if _, err := cfg.DialOptions("tcp:localhost:80"); err == nil {
+		t.Errorf("missing CA file: expected error, got nil")
+	}
+}
@@ -0,0 +1,74 @@
+// Package bloom implements a Bloom filter over CAS addresses.
+package bloom
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/cloud9-tools/go-cas/common"
+)
+
+// Filter is a Bloom filter: a set that can answer "definitely not present"
+// or "possibly present".  It is not safe for concurrent use.
+type Filter struct {
+	bits []uint64
+	m    uint64
+	k    uint64
+	n    int
+}
+
+// New returns a Filter sized to hold n addresses with a false positive rate
+// of about p.
+func New(n int, p float64) *Filter {
+	if n < 1 {
+		n = 1
+	}
+	m := uint64(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	if m < 64 {
+		m = 64
+	}
+	k := uint64(math.Ceil(float64(m) / float64(n) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return &Filter{
+		bits: make([]uint64, (m+63)/64),
+		m:    m,
+		k:    k,
+	}
+}
+
+// Len returns the number of calls to Add.
+func (f *Filter) Len() int {
+	return f.n
+}
+
+// Add inserts addr into the set.
+func (f *Filter) Add(addr common.Addr) {
+	h1, h2 := hashes(addr)
+	for i := uint64(0); i < f.k; i++ {
+		bit := (h1 + i*h2) % f.m
+		f.bits[bit/64] |= 1 << (bit % 64)
+	}
+	f.n++
+}
+
+// MayContain returns false if addr is definitely not in the set.
+func (f *Filter) MayContain(addr common.Addr) bool {
+	h1, h2 := hashes(addr)
+	for i := uint64(0); i < f.k; i++ {
+		bit := (h1 + i*h2) % f.m
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// hashes derives two independent hashes from addr, which is already a
+// uniformly distributed hash; they are combined by double hashing.
+func hashes(addr common.Addr) (uint64, uint64) {
+	h1 := binary.BigEndian.Uint64(addr[0:8])
+	h2 := binary.BigEndian.Uint64(addr[8:16]) | 1
+	return h1, h2
+}
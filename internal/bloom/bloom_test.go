@@ -0,0 +1,45 @@
+package bloom
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"testing"
+
+	"github.com/cloud9-tools/go-cas/common"
+)
+
+func addr(i int) common.Addr {
+	return common.Addr(sha1.Sum([]byte(fmt.Sprintf("block %d", i))))
+}
+
+func TestFilter(t *testing.T) {
+	type testrow struct {
+		n int
+		p float64
+	}
+	for idx, row := range []testrow{
+		{100, 0.01},
+		{10000, 0.01},
+		{10000, 0.001},
+	} {
+		f := New(row.n, row.p)
+		for i := 0; i < row.n; i++ {
+			f.Add(addr(i))
+		}
+		for i := 0; i < row.n; i++ {
+			if !f.MayContain(addr(i)) {
+				t.Fatalf("[%2d] false negative for item %d", idx, i)
+			}
+		}
+		const trials = 100000
+		fp := 0
+		for i := row.n; i < row.n+trials; i++ {
+			if f.MayContain(addr(i)) {
+				fp++
+			}
+		}
+		if rate := float64(fp) / trials; rate > 2*row.p {
+			t.Errorf("[%2d] expected false positive rate ~%g, got %g", idx, row.p, rate)
+		}
+	}
+}
@@ -9,6 +9,7 @@ It is generated from these files:
 	cas.proto
 
 It has these top-level messages:
+	CacheHints
 	GetRequest
 	GetReply
 	PutRequest
@@ -19,6 +20,9 @@ It has these top-level messages:
 	StatReply
 	WalkRequest
 	WalkReply
+	CacheStatsRequest
+	ShardStats
+	CacheStatsReply
 */
 package proto
 
@@ -36,15 +40,59 @@ var _ grpc.ClientConn
 // Reference imports to suppress errors if they are not otherwise used.
 var _ = proto1.Marshal
 
+type CacheHints_Priority int32
+
+const (
+	CacheHints_NORMAL CacheHints_Priority = 0
+	CacheHints_LOW    CacheHints_Priority = 1
+	CacheHints_HIGH   CacheHints_Priority = 2
+)
+
+var CacheHints_Priority_name = map[int32]string{
+	0: "NORMAL",
+	1: "LOW",
+	2: "HIGH",
+}
+var CacheHints_Priority_value = map[string]int32{
+	"NORMAL": 0,
+	"LOW":    1,
+	"HIGH":   2,
+}
+
+func (x CacheHints_Priority) String() string {
+	return proto1.EnumName(CacheHints_Priority_name, int32(x))
+}
+
+// CacheHints tell a caching server how to treat the blocks of one request.
+// They are ignored by servers that don't cache.
+type CacheHints struct {
+	Bypass     bool                `protobuf:"varint,1,opt,name=bypass" json:"bypass,omitempty"`
+	NoAdmit    bool                `protobuf:"varint,2,opt,name=no_admit" json:"no_admit,omitempty"`
+	PinSeconds int64               `protobuf:"varint,3,opt,name=pin_seconds" json:"pin_seconds,omitempty"`
+	Priority   CacheHints_Priority `protobuf:"varint,4,opt,name=priority,enum=chronos.cas.CacheHints_Priority" json:"priority,omitempty"`
+}
+
+func (m *CacheHints) Reset()         { *m = CacheHints{} }
+func (m *CacheHints) String() string { return proto1.CompactTextString(m) }
+func (*CacheHints) ProtoMessage()    {}
+
 type GetRequest struct {
-	Addr    string `protobuf:"bytes,1,opt,name=addr" json:"addr,omitempty"`
-	NoBlock bool   `protobuf:"varint,2,opt,name=no_block" json:"no_block,omitempty"`
+	Addr    string      `protobuf:"bytes,1,opt,name=addr" json:"addr,omitempty"`
+	NoBlock bool        `protobuf:"varint,2,opt,name=no_block" json:"no_block,omitempty"`
+	Hints   *CacheHints `protobuf:"bytes,3,opt,name=hints" json:"hints,omitempty"`
 }
 
 func (m *GetRequest) Reset()         { *m = GetRequest{} }
 func (m *GetRequest) String() string { return proto1.CompactTextString(m) }
 func (*GetRequest) ProtoMessage()    {}
 
+func (m *GetRequest) GetHints() *CacheHints {
+	if m != nil {
+		return m.Hints
+	}
+	return nil
+}
+
 type GetReply struct {
 	Block []byte `protobuf:"bytes,1,opt,name=block,proto3" json:"block,omitempty"`
 	Found bool   `protobuf:"varint,2,opt,name=found" json:"found,omitempty"`
@@ -55,14 +103,22 @@ func (m *GetReply) String() string { return proto1.CompactTextString(m) }
 func (*GetReply) ProtoMessage()    {}
 
 type PutRequest struct {
-	Addr  string `protobuf:"bytes,1,opt,name=addr" json:"addr,omitempty"`
-	Block []byte `protobuf:"bytes,2,opt,name=block,proto3" json:"block,omitempty"`
+	Addr  string      `protobuf:"bytes,1,opt,name=addr" json:"addr,omitempty"`
+	Block []byte      `protobuf:"bytes,2,opt,name=block,proto3" json:"block,omitempty"`
+	Hints *CacheHints `protobuf:"bytes,3,opt,name=hints" json:"hints,omitempty"`
 }
 
 func (m *PutRequest) Reset()         { *m = PutRequest{} }
 func (m *PutRequest) String() string { return proto1.CompactTextString(m) }
 func (*PutRequest) ProtoMessage()    {}
 
+func (m *PutRequest) GetHints() *CacheHints {
+	if m != nil {
+		return m.Hints
+	}
+	return nil
+}
+
 type PutReply struct {
 	Addr     string `protobuf:"bytes,1,opt,name=addr" json:"addr,omitempty"`
 	Inserted bool   `protobuf:"varint,2,opt,name=inserted" json:"inserted,omitempty"`
@@ -97,8 +153,9 @@ func (m *StatRequest) String() string { return proto1.CompactTextString(m) }
 func (*StatRequest) ProtoMessage()    {}
 
 type StatReply struct {
-	BlocksUsed int64 `protobuf:"varint,1,opt,name=blocks_used" json:"blocks_used,omitempty"`
-	BlocksFree int64 `protobuf:"varint,2,opt,name=blocks_free" json:"blocks_free,omitempty"`
+	BlocksUsed    int64  `protobuf:"varint,1,opt,name=blocks_used" json:"blocks_used,omitempty"`
+	BlocksFree    int64  `protobuf:"varint,2,opt,name=blocks_free" json:"blocks_free,omitempty"`
+	ActiveBackend string `protobuf:"bytes,3,opt,name=active_backend" json:"active_backend,omitempty"`
 }
 
 func (m *StatReply) Reset()         { *m = StatReply{} }
@@ -108,12 +165,21 @@ func (*StatReply) ProtoMessage()    {}
 type WalkRequest struct {
 	WantBlocks bool   `protobuf:"varint,1,opt,name=want_blocks" json:"want_blocks,omitempty"`
 	Regexp     string `protobuf:"bytes,2,opt,name=regexp" json:"regexp,omitempty"`
+	// A walk only caches blocks if hints are given that allow it.
+	Hints *CacheHints `protobuf:"bytes,3,opt,name=hints" json:"hints,omitempty"`
 }
 
 func (m *WalkRequest) Reset()         { *m = WalkRequest{} }
 func (m *WalkRequest) String() string { return proto1.CompactTextString(m) }
 func (*WalkRequest) ProtoMessage()    {}
 
+func (m *WalkRequest) GetHints() *CacheHints {
+	if m != nil {
+		return m.Hints
+	}
+	return nil
+}
+
 type WalkReply struct {
 	Addr  string `protobuf:"bytes,1,opt,name=addr" json:"addr,omitempty"`
 	Block []byte `protobuf:"bytes,2,opt,name=block,proto3" json:"block,omitempty"`
@@ -123,7 +189,66 @@ func (m *WalkReply) Reset()         { *m = WalkReply{} }
 func (m *WalkReply) String() string { return proto1.CompactTextString(m) }
 func (*WalkReply) ProtoMessage()    {}
 
+type CacheStatsRequest struct {
+}
+
+func (m *CacheStatsRequest) Reset()         { *m = CacheStatsRequest{} }
+func (m *CacheStatsRequest) String() string { return proto1.CompactTextString(m) }
+func (*CacheStatsRequest) ProtoMessage()    {}
+
+type ShardStats struct {
+	Entries         int64 `protobuf:"varint,1,opt,name=entries" json:"entries,omitempty"`
+	Hits            int64 `protobuf:"varint,2,opt,name=hits" json:"hits,omitempty"`
+	Misses          int64 `protobuf:"varint,3,opt,name=misses" json:"misses,omitempty"`
+	Evictions       int64 `protobuf:"varint,4,opt,name=evictions" json:"evictions,omitempty"`
+	Busy            int64 `protobuf:"varint,5,opt,name=busy" json:"busy,omitempty"`
+	BytesUsed       int64 `protobuf:"varint,6,opt,name=bytes_used" json:"bytes_used,omitempty"`
+	NegativeEntries int64 `protobuf:"varint,7,opt,name=negative_entries" json:"negative_entries,omitempty"`
+	NegativeHits    int64 `protobuf:"varint,8,opt,name=negative_hits" json:"negative_hits,omitempty"`
+	Prefetched      int64 `protobuf:"varint,9,opt,name=prefetched" json:"prefetched,omitempty"`
+	PrefetchHits    int64 `protobuf:"varint,10,opt,name=prefetch_hits" json:"prefetch_hits,omitempty"`
+	PrefetchWasted  int64 `protobuf:"varint,11,opt,name=prefetch_wasted" json:"prefetch_wasted,omitempty"`
+	Pinned          int64 `protobuf:"varint,12,opt,name=pinned" json:"pinned,omitempty"`
+}
+
+func (m *ShardStats) Reset()         { *m = ShardStats{} }
+func (m *ShardStats) String() string { return proto1.CompactTextString(m) }
+func (*ShardStats) ProtoMessage()    {}
+
+type CacheStatsReply struct {
+	Policy            string        `protobuf:"bytes,1,opt,name=policy" json:"policy,omitempty"`
+	Limit             int64         `protobuf:"varint,2,opt,name=limit" json:"limit,omitempty"`
+	Shards            []*ShardStats `protobuf:"bytes,3,rep,name=shards" json:"shards,omitempty"`
+	L2Entries         int64         `protobuf:"varint,4,opt,name=l2_entries" json:"l2_entries,omitempty"`
+	L2Limit           int64         `protobuf:"varint,5,opt,name=l2_limit" json:"l2_limit,omitempty"`
+	L2Hits            int64         `protobuf:"varint,6,opt,name=l2_hits" json:"l2_hits,omitempty"`
+	L2Misses          int64         `protobuf:"varint,7,opt,name=l2_misses" json:"l2_misses,omitempty"`
+	L2Demoted         int64         `protobuf:"varint,8,opt,name=l2_demoted" json:"l2_demoted,omitempty"`
+	L2Dropped         int64         `protobuf:"varint,9,opt,name=l2_dropped" json:"l2_dropped,omitempty"`
+	L2Failures        int64         `protobuf:"varint,10,opt,name=l2_failures" json:"l2_failures,omitempty"`
+	WriteBackDepth    int64         `protobuf:"varint,11,opt,name=write_back_depth" json:"write_back_depth,omitempty"`
+	WriteBackLagMs    int64         `protobuf:"varint,12,opt,name=write_back_lag_ms" json:"write_back_lag_ms,omitempty"`
+	WriteBackDrained  int64         `protobuf:"varint,13,opt,name=write_back_drained" json:"write_back_drained,omitempty"`
+	WriteBackFailures int64         `protobuf:"varint,14,opt,name=write_back_failures" json:"write_back_failures,omitempty"`
+	PrefetchIssued    int64         `protobuf:"varint,15,opt,name=prefetch_issued" json:"prefetch_issued,omitempty"`
+	PrefetchDropped   int64         `protobuf:"varint,16,opt,name=prefetch_dropped" json:"prefetch_dropped,omitempty"`
+	PrefetchFailed    int64         `protobuf:"varint,17,opt,name=prefetch_failed" json:"prefetch_failed,omitempty"`
+	VerifyFailures    int64         `protobuf:"varint,18,opt,name=verify_failures" json:"verify_failures,omitempty"`
+}
+
+func (m *CacheStatsReply) Reset()         { *m = CacheStatsReply{} }
+func (m *CacheStatsReply) String() string { return proto1.CompactTextString(m) }
+func (*CacheStatsReply) ProtoMessage()    {}
+
+func (m *CacheStatsReply) GetShards() []*ShardStats {
+	if m != nil {
+		return m.Shards
+	}
+	return nil
+}
+
 func init() {
+	proto1.RegisterEnum("chronos.cas.CacheHints_Priority", CacheHints_Priority_name, CacheHints_Priority_value)
 }
 
 // Client API for CAS service
@@ -134,6 +259,7 @@ type CASClient interface {
 	Remove(ctx context.Context, in *RemoveRequest, opts ...grpc.CallOption) (*RemoveReply, error)
 	Stat(ctx context.Context, in *StatRequest, opts ...grpc.CallOption) (*StatReply, error)
 	Walk(ctx context.Context, in *WalkRequest, opts ...grpc.CallOption) (CAS_WalkClient, error)
+	CacheStats(ctx context.Context, in *CacheStatsRequest, opts ...grpc.CallOption) (*CacheStatsReply, error)
 }
 
 type cASClient struct {
@@ -212,6 +338,15 @@ func (x *cASWalkClient) Recv() (*WalkReply, error) {
 	return m, nil
 }
 
+func (c *cASClient) CacheStats(ctx context.Context, in *CacheStatsRequest, opts ...grpc.CallOption) (*CacheStatsReply, error) {
+	out := new(CacheStatsReply)
+	err := grpc.Invoke(ctx, "/chronos.cas.CAS/CacheStats", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // Server API for CAS service
 
 type CASServer interface {
@@ -220,6 +355,7 @@ type CASServer interface {
 	Remove(context.Context, *RemoveRequest) (*RemoveReply, error)
 	Stat(context.Context, *StatRequest) (*StatReply, error)
 	Walk(*WalkRequest, CAS_WalkServer) error
+	CacheStats(context.Context, *CacheStatsRequest) (*CacheStatsReply, error)
 }
 
 func RegisterCASServer(s *grpc.Server, srv CASServer) {
@@ -295,6 +431,18 @@ func (x *cASWalkServer) Send(m *WalkReply) error {
 	return x.ServerStream.SendMsg(m)
 }
 
+func _CAS_CacheStats_Handler(srv interface{}, ctx context.Context, codec grpc.Codec, buf []byte) (interface{}, error) {
+	in := new(CacheStatsRequest)
+	if err := codec.Unmarshal(buf, in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(CASServer).CacheStats(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 var _CAS_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "chronos.cas.CAS",
 	HandlerType: (*CASServer)(nil),
@@ -315,6 +463,10 @@ var _CAS_serviceDesc = grpc.ServiceDesc{
 			MethodName: "Stat",
 			Handler:    _CAS_Stat_Handler,
 		},
+		{
+			MethodName: "CacheStats",
+			Handler:    _CAS_CacheStats_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
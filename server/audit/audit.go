@@ -0,0 +1,214 @@
+// Package audit records the CAS operations performed on a server, and by
+// whom, as JSON lines in an append-only file that is rotated by size.
+package audit
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+
+	"github.com/cloud9-tools/go-cas/server/auth"
+)
+
+// Record describes one RPC.  Result is the name of the gRPC status code that
+// the RPC returned, e.g. "OK" or "PermissionDenied".
+type Record struct {
+	Time      time.Time `json:"time"`
+	Role      string    `json:"role"`
+	Operation string    `json:"op"`
+	Addr      string    `json:"addr,omitempty"`
+	Result    string    `json:"result"`
+	Bytes     int64     `json:"bytes,omitempty"`
+	Peer      string    `json:"peer,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// JSON returns rec as a line of the audit log, without the newline.
+func (rec *Record) JSON() string {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		panic(err)
+	}
+	return string(line)
+}
+
+// Begin starts a Record of id performing op on addr, which may be empty.
+func Begin(ctx context.Context, id auth.Identity, op auth.Operation, addr string) *Record {
+	rec := &Record{
+		Time:      time.Now().UTC(),
+		Role:      string(id.Role),
+		Operation: strings.ToLower(op.String()),
+		Addr:      addr,
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		rec.Peer = p.Addr.String()
+	}
+	return rec
+}
+
+type Config struct {
+	Path    string
+	MaxSize int64
+	Keep    int
+}
+
+func (cfg *Config) AddFlags(fs *flag.FlagSet) {
+	fs.StringVar(&cfg.Path, "audit_log", "",
+		"file to append a record of every CAS RPC to")
+	fs.Int64Var(&cfg.MaxSize, "audit_log_max_size", 100<<20,
+		"size in bytes at which --audit_log is rotated")
+	fs.IntVar(&cfg.Keep, "audit_log_keep", 10,
+		"number of rotated audit logs to keep, as --audit_log.1 (newest)"+
+			" through --audit_log.N (oldest)")
+}
+
+func (cfg *Config) Validate() error {
+	if cfg.Path == "" {
+		return nil
+	}
+	if cfg.MaxSize <= 0 {
+		return fmt.Errorf("invalid flag --audit_log_max_size=%d: must be positive", cfg.MaxSize)
+	}
+	if cfg.Keep < 0 {
+		return fmt.Errorf("invalid flag --audit_log_keep=%d: must not be negative", cfg.Keep)
+	}
+	return nil
+}
+
+// Open opens the audit log, or returns nil if there isn't one.
+func (cfg *Config) Open() (*Log, error) {
+	if cfg.Path == "" {
+		return nil, nil
+	}
+	l := &Log{path: cfg.Path, maxSize: cfg.MaxSize, keep: cfg.Keep}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Log is an open audit log.  A nil *Log discards everything.
+type Log struct {
+	mutex   sync.Mutex
+	path    string
+	maxSize int64
+	keep    int
+	f       *os.File
+	size    int64
+	closed  bool
+}
+
+func (l *Log) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f = f
+	l.size = fi.Size()
+	return nil
+}
+
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.closed = true
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
+
+// Finish completes rec with the outcome of the RPC, and writes it.
+func (l *Log) Finish(rec *Record, err error) {
+	if l == nil {
+		return
+	}
+	rec.Result = grpc.Code(err).String()
+	if err != nil {
+		rec.Error = grpc.ErrorDesc(err)
+	}
+	l.Write(rec)
+}
+
+// Write appends rec to the log, rotating it first if it is full.  Failures
+// are logged, but not returned: the RPC has already happened.  Records
+// written after Close are dropped.
+func (l *Log) Write(rec *Record) {
+	if l == nil {
+		return
+	}
+	line := []byte(rec.JSON() + "\n")
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.closed {
+		return
+	}
+	if l.f != nil && l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			log.Printf("error: failed to rotate audit log %q: %v", l.path, err)
+		}
+	}
+	if l.f == nil {
+		// A rotate failed part way, after closing the old file.
+		if err := l.open(); err != nil {
+			log.Printf("error: failed to open audit log %q: %v", l.path, err)
+			return
+		}
+	}
+	n, err := l.f.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		log.Printf("error: failed to write audit log %q: %v", l.path, err)
+	}
+}
+
+// rotate renames path.N-1 to path.N, and so on down to path to path.1, and
+// then starts a new file.  l.mutex must be held.
+func (l *Log) rotate() error {
+	if err := l.f.Close(); err != nil {
+		return err
+	}
+	l.f = nil
+	if l.keep == 0 {
+		if err := os.Remove(l.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	} else {
+		for i := l.keep - 1; i >= 1; i-- {
+			err := os.Rename(RotatedName(l.path, i), RotatedName(l.path, i+1))
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		if err := os.Rename(l.path, RotatedName(l.path, 1)); err != nil {
+			return err
+		}
+	}
+	return l.open()
+}
+
+// RotatedName returns the name of the nth most recently rotated log.
+func RotatedName(path string, n int) string {
+	return path + "." + strconv.Itoa(n)
+}
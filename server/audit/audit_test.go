@@ -0,0 +1,179 @@
+package audit
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+func TestLog_Rotate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	cfg := Config{Path: path, MaxSize: 400, Keep: 2}
+	l, err := cfg.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2015, 12, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 20; i++ {
+		rec := &Record{
+			Time:      start.Add(time.Duration(i) * time.Minute),
+			Role:      "alice",
+			Operation: "get",
+		}
+		if i%2 == 1 {
+			rec.Role = "bob"
+		}
+		var err error
+		if i%5 == 4 {
+			err = grpc.Errorf(codes.PermissionDenied, "access denied")
+		}
+		l.Finish(rec, err)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := Files(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{path + ".2", path + ".1", path}
+	if strings.Join(files, " ") != strings.Join(expected, " ") {
+		t.Fatalf("expected files %q, got %q", expected, files)
+	}
+
+	var all []*Record
+	for _, name := range files {
+		fi, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Size() > cfg.MaxSize {
+			t.Errorf("%s: size %d exceeds max size %d", name, fi.Size(), cfg.MaxSize)
+		}
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = Read(bytes.NewReader(data), Filter{}, func(rec *Record) error {
+			all = append(all, rec)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+	if len(all) == 0 || len(all) >= 20 {
+		t.Fatalf("expected the oldest records to be rotated away, got %d records", len(all))
+	}
+	for i := 1; i < len(all); i++ {
+		if !all[i-1].Time.Before(all[i].Time) {
+			t.Errorf("records out of order: %v then %v", all[i-1].Time, all[i].Time)
+		}
+	}
+	last := all[len(all)-1]
+	if !last.Time.Equal(start.Add(19*time.Minute)) || last.Role != "bob" || last.Result != "PermissionDenied" || last.Error != "access denied" {
+		t.Errorf("wrong last record: %#v", last)
+	}
+}
+
+func TestRead_Filter(t *testing.T) {
+	start := time.Date(2015, 12, 1, 0, 0, 0, 0, time.UTC)
+	var buf bytes.Buffer
+	for i, role := range []string{"alice", "bob", "alice", "carol"} {
+		rec := &Record{
+			Time:      start.Add(time.Duration(i) * time.Hour),
+			Role:      role,
+			Operation: "put",
+			Addr:      strings.Repeat("0", 63) + string('0'+byte(i)),
+			Result:    "OK",
+		}
+		buf.WriteString(rec.JSON() + "\n")
+	}
+	buf.WriteString(`{"time":"2015-12-01T09:00:00Z","role":"ali`)
+
+	type testrow struct {
+		Filter Filter
+		Count  int
+	}
+	for i, row := range []testrow{
+		testrow{Filter{}, 4},
+		testrow{Filter{Role: "alice"}, 2},
+		testrow{Filter{Role: "alice", Since: start.Add(time.Hour)}, 1},
+		testrow{Filter{Until: start.Add(2 * time.Hour)}, 2},
+		testrow{Filter{Addr: strings.Repeat("0", 63) + "3"}, 1},
+		testrow{Filter{Operation: "get"}, 0},
+	} {
+		n := 0
+		err := Read(bytes.NewReader(buf.Bytes()), row.Filter, func(*Record) error {
+			n++
+			return nil
+		})
+		if err != nil {
+			t.Errorf("[%d] unexpected error: %v", i, err)
+		}
+		if n != row.Count {
+			t.Errorf("[%d] expected %d records, got %d", i, row.Count, n)
+		}
+	}
+}
+
+func TestLog_Nil(t *testing.T) {
+	var l *Log
+	l.Finish(&Record{}, nil)
+	if err := l.Close(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	cfg := Config{}
+	if l, err := cfg.Open(); l != nil || err != nil {
+		t.Errorf("expected no log, got %v, %v", l, err)
+	}
+}
+
+func TestLog_WriteAfterClose(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	cfg := Config{Path: path, MaxSize: 400, Keep: 2}
+	l, err := cfg.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Finish(&Record{Role: "alice", Operation: "get"}, nil)
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A handler that finishes after shutdown must not reopen the file.
+	l.Finish(&Record{Role: "bob", Operation: "get"}, nil)
+	if l.f != nil {
+		t.Errorf("expected Write after Close not to reopen the log")
+	}
+	if fi2, err := os.Stat(path); err != nil || fi2.Size() != fi.Size() {
+		t.Errorf("expected Write after Close to be dropped")
+	}
+	if err := l.Close(); err != nil {
+		t.Errorf("second Close: unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Filter selects Records.  Empty fields match everything.
+type Filter struct {
+	Role      string
+	Operation string
+	Addr      string
+	Since     time.Time
+	Until     time.Time
+}
+
+func (f Filter) Match(rec *Record) bool {
+	if f.Role != "" && f.Role != rec.Role {
+		return false
+	}
+	if f.Operation != "" && f.Operation != rec.Operation {
+		return false
+	}
+	if f.Addr != "" && f.Addr != rec.Addr {
+		return false
+	}
+	if !f.Since.IsZero() && rec.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !rec.Time.Before(f.Until) {
+		return false
+	}
+	return true
+}
+
+// Files lists the audit log at path and its rotated predecessors that exist,
+// oldest first.
+func Files(path string) ([]string, error) {
+	var rotated []string
+	for n := 1; ; n++ {
+		name := RotatedName(path, n)
+		if _, err := os.Stat(name); os.IsNotExist(err) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		rotated = append(rotated, name)
+	}
+	files := make([]string, 0, len(rotated)+1)
+	for i := len(rotated) - 1; i >= 0; i-- {
+		files = append(files, rotated[i])
+	}
+	if _, err := os.Stat(path); err == nil {
+		files = append(files, path)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	return files, nil
+}
+
+// Read calls fn on each Record in r that matches filter.  If fn returns an
+// error, Read stops and returns it.
+func Read(r io.Reader, filter Filter, fn func(*Record) error) error {
+	br := bufio.NewReader(r)
+	lineno := 0
+	for {
+		line, err := br.ReadBytes('\n')
+		if err == io.EOF {
+			// A record without a newline was cut short by a crash.
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		lineno++
+		var rec Record
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return fmt.Errorf("line %d: %v", lineno, err)
+		}
+		if filter.Match(&rec) {
+			if err := fn(&rec); err != nil {
+				return err
+			}
+		}
+	}
+}
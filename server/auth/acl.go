@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"errors"
 	"flag"
+	"fmt"
 	"strings"
 
 	"google.golang.org/grpc"
@@ -17,13 +18,17 @@ func DenyAll() ACL {
 }
 
 func AllowAll() ACL {
-	return ACL{Rule{Role: Anybody, Result: Allow}}
+	return ACL{Rule{Role: Anybody, Operation: Any, Result: Allow}}
 }
 
 func (acl ACL) String() string {
 	var buf bytes.Buffer
 	for _, rule := range acl {
 		buf.WriteString(string(rule.Role))
+		if rule.Operation != Any {
+			buf.WriteByte(':')
+			buf.WriteString(strings.ToLower(rule.Operation.String()))
+		}
 		buf.WriteByte('=')
 		buf.WriteString(rule.Result.String())
 		buf.WriteByte(',')
@@ -34,29 +39,65 @@ func (acl ACL) String() string {
 	return buf.String()
 }
 
+// Set parses a comma-separated list of rules, each of the form "role=result"
+// or "role:operation=result".  A rule without an operation applies to every
+// operation.  An empty list denies everything.
 func (acl *ACL) Set(in string) error {
 	var tmp ACL
+	if strings.TrimSpace(in) == "" {
+		*acl = tmp
+		return nil
+	}
 	for _, piece := range strings.Split(in, ",") {
-		kv := strings.SplitN(piece, "=", 2)
-		role := Role(strings.TrimSpace(kv[0]))
-		result := Deny
-		err := result.Set(strings.TrimSpace(kv[1]))
+		rule, err := ParseRule(piece)
 		if err != nil {
 			return err
 		}
-		tmp = append(tmp, Rule{role, result})
+		tmp = append(tmp, rule)
 	}
 	*acl = tmp
 	return nil
 }
 
+// ParseRule parses a single rule of the form "role=result" or
+// "role:operation=result".
+func ParseRule(in string) (Rule, error) {
+	kv := strings.SplitN(in, "=", 2)
+	if len(kv) != 2 {
+		return Rule{}, fmt.Errorf("rule %q: expected \"role=result\" or \"role:operation=result\"", in)
+	}
+	key := strings.TrimSpace(kv[0])
+	op := Any
+	if i := strings.LastIndexByte(key, ':'); i >= 0 {
+		var err error
+		op, err = ParseOperation(strings.TrimSpace(key[i+1:]))
+		if err != nil {
+			return Rule{}, fmt.Errorf("rule %q: %v", in, err)
+		}
+		key = strings.TrimSpace(key[:i])
+	}
+	result := Deny
+	if err := result.Set(strings.TrimSpace(kv[1])); err != nil {
+		return Rule{}, fmt.Errorf("rule %q: %v", in, err)
+	}
+	return Rule{Role(key), op, result}, nil
+}
+
 func (acl *ACL) Get() interface{} {
 	return *acl
 }
 
+// Rule applies Result to members of Role performing Operation.  A Rule for
+// Any applies to every operation.
 type Rule struct {
-	Role   Role
-	Result Result
+	Role      Role
+	Operation Operation
+	Result    Result
+}
+
+// Matches returns true iff the rule applies to op.
+func (rule Rule) Matches(op Operation) bool {
+	return rule.Operation == Any || rule.Operation == op
 }
 
 type Role string
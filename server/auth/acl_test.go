@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"testing"
+)
+
+func TestACL_Set(t *testing.T) {
+	type testrow struct {
+		In  string
+		ACL ACL
+		Out string
+		Err string
+	}
+	for i, row := range []testrow{
+		testrow{"",
+			nil, "", ""},
+		testrow{"*=allow",
+			ACL{Rule{Anybody, Any, Allow}}, "*=allow", ""},
+		testrow{"alice:get=allow, alice:Put = deny,*:*=deny",
+			ACL{
+				Rule{"alice", Get, Allow},
+				Rule{"alice", Put, Deny},
+				Rule{Anybody, Any, Deny},
+			}, "alice:get=allow,alice:put=deny,*=deny", ""},
+		testrow{"(anonymous):statfs=allow,ci-bot:remove=deny,ci-bot=allow",
+			ACL{
+				Rule{Anonymous, StatFS, Allow},
+				Rule{"ci-bot", Remove, Deny},
+				Rule{"ci-bot", Any, Allow},
+			}, "(anonymous):statfs=allow,ci-bot:remove=deny,ci-bot=allow", ""},
+		testrow{"alice",
+			nil, "", `rule "alice": expected "role=result" or "role:operation=result"`},
+		testrow{"alice:frob=allow",
+			nil, "", `rule "alice:frob=allow": unknown operation "frob"`},
+		testrow{"alice=maybe",
+			nil, "", `rule "alice=maybe": expected "allow" or "deny"`},
+	} {
+		var acl ACL
+		err := acl.Set(row.In)
+		var errstr string
+		if err != nil {
+			errstr = err.Error()
+		}
+		if errstr != row.Err {
+			t.Errorf("[%d] expected error %q, got %q", i, row.Err, errstr)
+			continue
+		}
+		if len(acl) != len(row.ACL) {
+			t.Errorf("[%d] expected %#v, got %#v", i, row.ACL, acl)
+			continue
+		}
+		for j := range acl {
+			if acl[j] != row.ACL[j] {
+				t.Errorf("[%d] rule %d: expected %#v, got %#v", i, j, row.ACL[j], acl[j])
+			}
+		}
+		if str := acl.String(); str != row.Out {
+			t.Errorf("[%d] expected String() %q, got %q", i, row.Out, str)
+		}
+	}
+}
+
+func TestIdentity_Check(t *testing.T) {
+	var acl ACL
+	if err := acl.Set("alice:remove=deny,alice=allow,bob:get=allow,bob:statfs=allow"); err != nil {
+		t.Fatal(err)
+	}
+	auther := AnonymousAuther()
+	type testrow struct {
+		Role   Role
+		Op     Operation
+		Result Result
+	}
+	for i, row := range []testrow{
+		testrow{"alice", Get, Allow},
+		testrow{"alice", Put, Allow},
+		testrow{"alice", Remove, Deny},
+		testrow{"bob", Get, Allow},
+		testrow{"bob", StatFS, Allow},
+		testrow{"bob", Walk, Deny},
+		testrow{"bob", Put, Deny},
+		testrow{Anonymous, Get, Deny},
+	} {
+		id := Identity{auther, row.Role, nil, nil, nil}
+		if result := id.Check(acl, row.Op); result != row.Result {
+			t.Errorf("[%d] %v %v: expected %v, got %v", i, row.Role, row.Op, row.Result, result)
+		}
+	}
+
+	if result := (Identity{auther, Anonymous, nil, nil, nil}).Check(AllowAll(), Remove); result != Allow {
+		t.Errorf("AllowAll: expected allow, got %v", result)
+	}
+	if result := (Identity{auther, "alice", nil, nil, nil}).Check(DenyAll(), StatFS); result != Deny {
+		t.Errorf("DenyAll: expected deny, got %v", result)
+	}
+}
@@ -5,35 +5,76 @@ import (
 	"log"
 
 	"golang.org/x/net/context"
+
+	"github.com/cloud9-tools/go-cas/common"
 )
 
 type Auther struct {
 	Extractor     Extractor
 	Membershipper Membershipper
+
+	// Policy, if set, supplies the ACL and groups, in place of the ACL
+	// passed to Identity.Check.
+	Policy *PolicyFile
+
+	// Capabilities finds capabilities that callers present alongside, or
+	// instead of, their Role.
+	Capabilities CapabilityExtractor
 }
 
 func (auther Auther) Extract(ctx context.Context) Identity {
-	role, err := auther.Extractor.Extract(ctx)
+	role, scope, err := extractScoped(auther.Extractor, ctx)
 	if err != nil {
 		log.Printf("go-cas/server/auth: failed to identify user: %v", err)
-		role = Anonymous
+		role, scope = Anonymous, nil
 	}
-	return Identity{auther, role}
+	capability, err := auther.Capabilities.ExtractCapability(ctx)
+	if err != nil {
+		log.Printf("go-cas/server/auth: ignoring capability: %v", err)
+		capability = nil
+	}
+	return Identity{auther, role, scope, capability, auther.Policy.Current()}
 }
 
 type Identity struct {
 	Auther Auther
 	Role   Role
+
+	// Scope, if non-empty, lists the only operations that the identity's
+	// credentials may be used for.
+	Scope []Operation
+
+	// Capability, if non-nil, grants Get access to some blocks on top of
+	// whatever the Role is allowed.  See CheckGet.
+	Capability *Capability
+
+	// policy is the Auther's policy when the identity was extracted, so
+	// that a reload can't change the rules halfway through a check.
+	policy *Policy
 }
 
 func (id Identity) String() string {
 	return fmt.Sprintf("%q", string(id.Role))
 }
 
-func (id Identity) Check(acl ACL) Result {
+// Check returns the Result of the first rule in acl that matches both the
+// identity's role and op, or Deny if none do or op is outside the identity's
+// scope.  If the Auther has a policy file, the policy's ACL is used instead
+// of acl.
+func (id Identity) Check(acl ACL, op Operation) Result {
+	if !id.InScope(op) {
+		return Deny
+	}
+	m := id.Auther.Membershipper
+	if id.policy != nil {
+		acl = id.policy.ACL
+		m = policyMemberships{id.policy, m}
+	}
 	for _, rule := range acl {
-		log.Printf("rule=%#v", rule)
-		ismem, err := IsIn(id.Role, rule.Role, id.Auther.Membershipper)
+		if !rule.Matches(op) {
+			continue
+		}
+		ismem, err := IsIn(id.Role, rule.Role, m)
 		if err != nil {
 			log.Printf("go-cas/server/auth: failed to test "+
 				"membership of user %q in group %q: %v",
@@ -48,9 +89,134 @@ func (id Identity) Check(acl ACL) Result {
 	return Deny
 }
 
+// CheckGet returns nil iff the identity may Get addr, either because acl
+// allows its Role to Get any block, or because its capability covers addr.
+// known and get are passed to Capability.Covers.
+func (id Identity) CheckGet(acl ACL, addr common.Addr, known *Reachability, get BlockGetter) error {
+	err := id.Check(acl, Get).Err()
+	if err == nil || id.Capability == nil {
+		return err
+	}
+	return id.Capability.Covers(addr, known, get)
+}
+
+// RateLimiter charges roles for their requests and the bytes they transfer.
+// *quota.Limiter implements it; a nil *quota.Limiter allows everything.
+type RateLimiter interface {
+	Allow(role Role) error
+	Charge(role Role, n int64)
+}
+
+// Authorize returns nil iff acl allows the identity to perform op, and the
+// identity's role is within its rate limits.
+func (id Identity) Authorize(acl ACL, limiter RateLimiter, op Operation) error {
+	if err := id.Check(acl, op).Err(); err != nil {
+		return err
+	}
+	return limiter.Allow(id.Role)
+}
+
+// AuthorizeGet is like Authorize for Get, but also admits an identity whose
+// capability covers addr.  The rate limit is applied first, since checking
+// a capability may read blocks, and each block read is charged to the
+// identity's role.
+func (id Identity) AuthorizeGet(acl ACL, limiter RateLimiter, addr string, known *Reachability, get BlockGetter) error {
+	var a common.Addr
+	if id.Capability == nil || a.Parse(addr) != nil {
+		return id.Authorize(acl, limiter, Get)
+	}
+	if err := limiter.Allow(id.Role); err != nil {
+		return err
+	}
+	return id.CheckGet(acl, a, known, func(addr common.Addr, block *common.Block) (bool, error) {
+		limiter.Charge(id.Role, common.BlockSize)
+		return get(addr, block)
+	})
+}
+
+// InScope returns true iff the identity's credentials may be used for op.
+func (id Identity) InScope(op Operation) bool {
+	if len(id.Scope) == 0 {
+		return true
+	}
+	for _, allowed := range id.Scope {
+		if allowed == Any || allowed == op {
+			return true
+		}
+	}
+	return false
+}
+
 func AnonymousAuther() Auther {
 	return Auther{
 		Extractor:     AnonymousExtractor{},
 		Membershipper: NoMemberships{},
 	}
 }
+
+// ChainAuther identifies callers by the first of extractors that recognizes
+// them, or treats everyone as anonymous if there are no extractors.
+func ChainAuther(extractors ...Extractor) Auther {
+	var x Extractor = AnonymousExtractor{}
+	switch len(extractors) {
+	case 0:
+	case 1:
+		x = extractors[0]
+	default:
+		x = ChainExtractor(extractors)
+	}
+	return Auther{
+		Extractor:     x,
+		Membershipper: NoMemberships{},
+	}
+}
+
+// AutherConfig says how a server identifies its callers.  casd and
+// cascached fill it in from the same flags.
+type AutherConfig struct {
+	// CertField, if set, identifies callers by that field of their TLS
+	// client certificates.  Only set it if clients must present them.
+	CertField string
+
+	// TokenKey, if set, is used to verify bearer tokens and capabilities.
+	TokenKey []byte
+
+	// PeerCred identifies callers on a Unix socket by their Unix user,
+	// and gives them their Unix groups.
+	PeerCred bool
+
+	// Policy, if set, supplies the ACL and groups.
+	Policy *PolicyFile
+}
+
+// NewAuther identifies callers by their TLS client certificates, then by
+// their bearer tokens, and then by their Unix user, as far as cfg enables
+// each of them.  Otherwise it treats everyone as anonymous.  The order
+// matters: the strongest credential a caller presents decides its Role.
+func NewAuther(cfg AutherConfig) Auther {
+	var extractors []Extractor
+	if cfg.CertField != "" {
+		extractors = append(extractors, CertExtractor{Field: cfg.CertField})
+	}
+	if cfg.TokenKey != nil {
+		extractors = append(extractors, TokenExtractor{Key: cfg.TokenKey})
+	}
+	if cfg.PeerCred {
+		extractors = append(extractors, PeerCredExtractor{})
+	}
+	auther := ChainAuther(extractors...)
+	if cfg.PeerCred {
+		auther.Membershipper = NewUnixGroups()
+	}
+	auther.Policy = cfg.Policy
+	auther.Capabilities = CapabilityExtractor{Key: cfg.TokenKey}
+	return auther
+}
+
+// Reload re-reads the policy file, if there is one.  If the new policy is
+// invalid, the old one stays in force, and the error is logged.
+func (auther Auther) Reload() {
+	if err := auther.Policy.Reload(); err != nil {
+		log.Printf("error: failed to reload policy: %v", err)
+	}
+}
@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestNewAuther(t *testing.T) {
+	key := []byte("key")
+	type testrow struct {
+		Cfg        AutherConfig
+		Extractor  Extractor
+		UnixGroups bool
+	}
+	for i, row := range []testrow{
+		testrow{AutherConfig{}, AnonymousExtractor{}, false},
+		testrow{AutherConfig{TokenKey: key}, TokenExtractor{Key: key}, false},
+		testrow{AutherConfig{CertField: "cn", TokenKey: key, PeerCred: true},
+			ChainExtractor{CertExtractor{Field: "cn"}, TokenExtractor{Key: key}, PeerCredExtractor{}}, true},
+	} {
+		auther := NewAuther(row.Cfg)
+		if !reflect.DeepEqual(auther.Extractor, row.Extractor) {
+			t.Errorf("[%d] expected extractor %#v, got %#v", i, row.Extractor, auther.Extractor)
+		}
+		if _, ok := auther.Membershipper.(*UnixGroups); ok != row.UnixGroups {
+			t.Errorf("[%d] expected Unix groups=%v, got %T", i, row.UnixGroups, auther.Membershipper)
+		}
+		if !reflect.DeepEqual(auther.Capabilities, CapabilityExtractor{Key: row.Cfg.TokenKey}) {
+			t.Errorf("[%d] expected capabilities signed with the token key", i)
+		}
+	}
+}
+
+type fakeLimiter struct {
+	err     error
+	allowed int
+	charged int64
+}
+
+func (l *fakeLimiter) Allow(role Role) error {
+	l.allowed++
+	return l.err
+}
+
+func (l *fakeLimiter) Charge(role Role, n int64) {
+	l.charged += n
+}
+
+func TestIdentity_Authorize(t *testing.T) {
+	id := Identity{Auther: AnonymousAuther(), Role: "alice"}
+	acl := ACL{Rule{"alice", Get, Allow}}
+
+	l := &fakeLimiter{}
+	if err := id.Authorize(acl, l, Get); err != nil || l.allowed != 1 {
+		t.Errorf("allowed: expected nil and 1 token, got %v and %d", err, l.allowed)
+	}
+	if err := id.Authorize(acl, l, Put); err == nil || l.allowed != 1 {
+		t.Errorf("denied: expected an error without taking a token, got %v and %d", err, l.allowed)
+	}
+	l.err = errors.New("slow down")
+	if err := id.Authorize(acl, l, Get); err != l.err {
+		t.Errorf("rate limited: expected %v, got %v", l.err, err)
+	}
+}
@@ -0,0 +1,236 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/cloud9-tools/go-cas/common"
+)
+
+// CapabilityMetadataKey is the gRPC metadata key that carries capabilities.
+const CapabilityMetadataKey = "x-cas-capability"
+
+const capabilityPrefix = "cap1."
+
+// MaxCapabilityWalk is the most blocks that will be read while checking
+// whether an address is reachable from a capability's root.  Addresses that
+// are deeper or further along than that can still be reached, once the
+// blocks above them have been fetched and their references remembered; see
+// Reachability.
+const MaxCapabilityWalk = 256
+
+// Capability grants whoever holds it Get access to the block at Root, until
+// it expires, regardless of their Role.  If Refs names a reference decoder
+// (see common.RefDecoderByName), it also grants access to every block that
+// can be reached from Root by following the references that it decodes.
+//
+// Capabilities are signed with the same key as bearer tokens, and, like
+// bearer tokens, anyone who holds one can use it.
+type Capability struct {
+	Root   common.Addr
+	Refs   string
+	Expiry time.Time
+
+	decoder common.RefDecoder
+}
+
+type capabilityPayload struct {
+	Root   string `json:"root"`
+	Refs   string `json:"refs,omitempty"`
+	Expiry int64  `json:"exp"`
+}
+
+// Mint encodes and signs c.
+func (c Capability) Mint(key []byte) (string, error) {
+	if len(key) < MinTokenKeySize {
+		return "", fmt.Errorf("token key is too short: need at least %d bytes", MinTokenKeySize)
+	}
+	if c.Expiry.IsZero() {
+		return "", errors.New("cannot mint a capability without an expiry")
+	}
+	if c.Refs != "" {
+		if _, err := common.RefDecoderByName(c.Refs); err != nil {
+			return "", err
+		}
+	}
+	payload := capabilityPayload{Root: c.Root.String(), Refs: c.Refs, Expiry: c.Expiry.Unix()}
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	body := capabilityPrefix + base64.RawURLEncoding.EncodeToString(raw)
+	return body + "." + base64.RawURLEncoding.EncodeToString(tokenMAC(key, body)), nil
+}
+
+// ParseCapability verifies the signature of in, and returns the Capability
+// it encodes if it has not expired by now.
+func ParseCapability(key []byte, in string, now time.Time) (*Capability, error) {
+	if !strings.HasPrefix(in, capabilityPrefix) {
+		return nil, errBadToken
+	}
+	i := strings.LastIndexByte(in, '.')
+	if i < len(capabilityPrefix) {
+		return nil, errBadToken
+	}
+	body, sig := in[:i], in[i+1:]
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return nil, errBadToken
+	}
+	if !hmac.Equal(mac, tokenMAC(key, body)) {
+		return nil, errors.New("capability has an invalid signature")
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(body[len(capabilityPrefix):])
+	if err != nil {
+		return nil, errBadToken
+	}
+	var payload capabilityPayload
+	if err := json.Unmarshal(raw, &payload); err != nil || payload.Expiry == 0 {
+		return nil, errBadToken
+	}
+	c := &Capability{Refs: payload.Refs, Expiry: time.Unix(payload.Expiry, 0)}
+	if err := c.Root.Parse(payload.Root); err != nil {
+		return nil, errBadToken
+	}
+	if c.Refs != "" {
+		c.decoder, err = common.RefDecoderByName(c.Refs)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !now.Before(c.Expiry) {
+		return nil, fmt.Errorf("capability for %v expired at %v", c.Root, c.Expiry)
+	}
+	return c, nil
+}
+
+// BlockGetter reads a block, reporting whether it was found.
+type BlockGetter func(addr common.Addr, block *common.Block) (bool, error)
+
+// Covers returns nil iff the capability grants access to addr.  If the
+// capability follows references, get is used to read the blocks between
+// Root and addr, and at most MaxCapabilityWalk blocks are read; known, if
+// not nil, remembers every address found along the way, so that later
+// checks under the same root needn't repeat the walk.
+func (c *Capability) Covers(addr common.Addr, known *Reachability, get BlockGetter) error {
+	if c == nil {
+		return grpc.Errorf(codes.PermissionDenied, "no capability")
+	}
+	if addr == c.Root {
+		return nil
+	}
+	if c.decoder == nil {
+		return grpc.Errorf(codes.PermissionDenied, "capability only grants access to %v", c.Root)
+	}
+	if known.Has(c, addr) {
+		return nil
+	}
+	seen := map[common.Addr]struct{}{c.Root: struct{}{}}
+	queue := []common.Addr{c.Root}
+	var block common.Block
+	for n := 0; len(queue) > 0; n++ {
+		if n >= MaxCapabilityWalk {
+			return grpc.Errorf(codes.PermissionDenied,
+				"%v was not found within %d blocks of %v", addr, MaxCapabilityWalk, c.Root)
+		}
+		next := queue[0]
+		queue = queue[1:]
+		found, err := get(next, &block)
+		if err != nil {
+			return err
+		}
+		if !found {
+			continue
+		}
+		refs := c.decoder.DecodeRefs(&block)
+		known.Add(c, refs)
+		for _, ref := range refs {
+			if ref == addr {
+				return nil
+			}
+			if _, dup := seen[ref]; !dup {
+				seen[ref] = struct{}{}
+				queue = append(queue, ref)
+			}
+		}
+	}
+	return grpc.Errorf(codes.PermissionDenied, "%v is not reachable from %v", addr, c.Root)
+}
+
+// Reachability remembers which addresses have been found reachable from
+// which capability roots.  Blocks never change, so neither does what they
+// refer to, and a positive answer never goes stale.  Only positive answers
+// are remembered, since a missing block may yet be stored.  All methods are
+// safe to call on a nil *Reachability, which remembers nothing.
+type Reachability struct {
+	limit int
+
+	mutex sync.Mutex
+	known map[reachKey]struct{}
+}
+
+type reachKey struct {
+	root common.Addr
+	refs string
+	addr common.Addr
+}
+
+// NewReachability returns a Reachability that remembers at most limit
+// addresses, forgetting everything when it fills up.
+func NewReachability(limit int) *Reachability {
+	return &Reachability{limit: limit, known: make(map[reachKey]struct{})}
+}
+
+// Has returns true iff addr is known to be reachable under c.
+func (r *Reachability) Has(c *Capability, addr common.Addr) bool {
+	if r == nil {
+		return false
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	_, found := r.known[reachKey{c.Root, c.Refs, addr}]
+	return found
+}
+
+// Add records that addrs are reachable under c.
+func (r *Reachability) Add(c *Capability, addrs []common.Addr) {
+	if r == nil || len(addrs) == 0 {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if len(r.known)+len(addrs) > r.limit {
+		r.known = make(map[reachKey]struct{})
+	}
+	for _, addr := range addrs {
+		r.known[reachKey{c.Root, c.Refs, addr}] = struct{}{}
+	}
+}
+
+// CapabilityExtractor finds the capability, if any, in a caller's request
+// metadata.  If Key is nil, capabilities are not accepted.
+type CapabilityExtractor struct {
+	Key []byte
+}
+
+func (x CapabilityExtractor) ExtractCapability(ctx context.Context) (*Capability, error) {
+	if x.Key == nil {
+		return nil, nil
+	}
+	md, ok := metadata.FromContext(ctx)
+	if !ok || len(md[CapabilityMetadataKey]) == 0 {
+		return nil, nil
+	}
+	return ParseCapability(x.Key, strings.TrimSpace(md[CapabilityMetadataKey][0]), time.Now())
+}
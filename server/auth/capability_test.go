@@ -0,0 +1,218 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloud9-tools/go-cas/common"
+)
+
+func TestCapability_RoundTrip(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	now := time.Unix(1450000000, 0)
+	var block common.Block
+	block.Pad([]byte("root"))
+	c := Capability{Root: block.Addr(), Refs: "hexlist", Expiry: now.Add(time.Hour)}
+	str, err := c.Mint(key)
+	if err != nil {
+		t.Fatalf("Mint: %v", err)
+	}
+
+	got, err := ParseCapability(key, str, now)
+	if err != nil {
+		t.Fatalf("ParseCapability: %v", err)
+	}
+	if got.Root != c.Root || got.Refs != c.Refs || !got.Expiry.Equal(c.Expiry) {
+		t.Errorf("expected %#v, got %#v", c, got)
+	}
+
+	if _, err := ParseCapability(key, str, now.Add(time.Hour)); err == nil {
+		t.Errorf("expired: expected error, got nil")
+	}
+	if _, err := ParseCapability([]byte("fedcba9876543210fedcba9876543210"), str, now); err == nil {
+		t.Errorf("wrong key: expected error, got nil")
+	}
+	tok, err := (Token{Role: "ci-bot", Expiry: now.Add(time.Hour)}).Mint(key)
+	if err != nil {
+		t.Fatalf("Mint: %v", err)
+	}
+	if _, err := ParseCapability(key, tok, now); err == nil {
+		t.Errorf("token as capability: expected error, got nil")
+	}
+	if _, err := ParseToken(key, str, now); err == nil {
+		t.Errorf("capability as token: expected error, got nil")
+	}
+	if _, err := (Capability{Root: c.Root, Refs: "bogus", Expiry: c.Expiry}).Mint(key); err == nil {
+		t.Errorf("unknown decoder: expected error, got nil")
+	}
+}
+
+func TestCapability_Covers(t *testing.T) {
+	blocks := make(map[common.Addr]*common.Block)
+	put := func(data string) common.Addr {
+		block := &common.Block{}
+		block.Pad([]byte(data))
+		blocks[block.Addr()] = block
+		return block.Addr()
+	}
+	get := func(addr common.Addr, block *common.Block) (bool, error) {
+		if b, found := blocks[addr]; found {
+			*block = *b
+			return true, nil
+		}
+		return false, nil
+	}
+	list := func(addrs ...common.Addr) string {
+		var lines []string
+		for _, addr := range addrs {
+			lines = append(lines, addr.String())
+		}
+		return strings.Join(lines, "\n") + "\n"
+	}
+
+	leaf1 := put("leaf 1")
+	leaf2 := put("leaf 2")
+	other := put("somebody else's")
+	var missing common.Block
+	missing.Pad([]byte("missing"))
+	inner := put(list(leaf2, missing.Addr()))
+	root := put(list(leaf1, inner))
+
+	shallow := &Capability{Root: root}
+	deep, err := ParseCapability([]byte("0123456789abcdef"),
+		mustMint(Capability{Root: root, Refs: "hexlist", Expiry: time.Now().Add(time.Hour)}),
+		time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type testrow struct {
+		C     *Capability
+		Addr  common.Addr
+		Allow bool
+	}
+	for i, row := range []testrow{
+		testrow{shallow, root, true},
+		testrow{shallow, leaf1, false},
+		testrow{deep, root, true},
+		testrow{deep, leaf1, true},
+		testrow{deep, inner, true},
+		testrow{deep, leaf2, true},
+		testrow{deep, missing.Addr(), true},
+		testrow{deep, other, false},
+		testrow{nil, root, false},
+	} {
+		err := row.C.Covers(row.Addr, nil, get)
+		if (err == nil) != row.Allow {
+			t.Errorf("[%d] expected allow=%v, got %v", i, row.Allow, err)
+		}
+	}
+
+	id := Identity{AnonymousAuther(), Anonymous, nil, deep, nil}
+	if err := id.CheckGet(DenyAll(), leaf2, nil, get); err != nil {
+		t.Errorf("CheckGet with capability: unexpected error: %v", err)
+	}
+	if err := id.CheckGet(DenyAll(), other, nil, get); err == nil {
+		t.Errorf("CheckGet outside capability: expected error, got nil")
+	}
+	if err := id.Check(DenyAll(), Get).Err(); err == nil {
+		t.Errorf("Check: capability should not grant Get on its own")
+	}
+}
+
+func TestReachability(t *testing.T) {
+	blocks := make(map[common.Addr]*common.Block)
+	reads := 0
+	put := func(data string) common.Addr {
+		block := &common.Block{}
+		block.Pad([]byte(data))
+		blocks[block.Addr()] = block
+		return block.Addr()
+	}
+	get := func(addr common.Addr, block *common.Block) (bool, error) {
+		reads++
+		if b, found := blocks[addr]; found {
+			*block = *b
+			return true, nil
+		}
+		return false, nil
+	}
+
+	// A wide tree: the root refers to many index blocks, each of which
+	// refers to one leaf.  Without Reachability, reaching the last leaf
+	// reads every index block before it.
+	var index []common.Addr
+	var leaves []common.Addr
+	for i := 0; i < 100; i++ {
+		leaf := put(fmt.Sprintf("leaf %d", i))
+		leaves = append(leaves, leaf)
+		index = append(index, put(leaf.String()+"\n"))
+	}
+	var lines []string
+	for _, addr := range index {
+		lines = append(lines, addr.String())
+	}
+	root := put(strings.Join(lines, "\n") + "\n")
+	c, err := ParseCapability([]byte("0123456789abcdef"),
+		mustMint(Capability{Root: root, Refs: "hexlist", Expiry: time.Now().Add(time.Hour)}),
+		time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	known := NewReachability(1000)
+	for _, addr := range index {
+		if err := c.Covers(addr, known, get); err != nil {
+			t.Fatalf("index %v: %v", addr, err)
+		}
+	}
+	if reads != 1 {
+		t.Errorf("index blocks: expected 1 read of the root, got %d", reads)
+	}
+	reads = 0
+	if err := c.Covers(leaves[99], known, get); err != nil {
+		t.Fatalf("last leaf: %v", err)
+	}
+	for _, addr := range leaves {
+		if !known.Has(c, addr) {
+			t.Errorf("leaf %v: expected to be remembered", addr)
+			break
+		}
+	}
+	reads = 0
+	for _, addr := range leaves {
+		if err := c.Covers(addr, known, get); err != nil {
+			t.Fatalf("leaf %v: %v", addr, err)
+		}
+	}
+	if reads != 0 {
+		t.Errorf("leaves: expected no reads once remembered, got %d", reads)
+	}
+
+	other := &Capability{Root: leaves[0], Refs: "hexlist"}
+	if known.Has(other, leaves[1]) {
+		t.Errorf("reachability should not carry over to another root")
+	}
+
+	small := NewReachability(2)
+	small.Add(c, leaves[:2])
+	small.Add(c, leaves[2:3])
+	if small.Has(c, leaves[0]) || !small.Has(c, leaves[2]) {
+		t.Errorf("expected a full Reachability to start over")
+	}
+	var none *Reachability
+	none.Add(c, leaves)
+	if none.Has(c, leaves[0]) {
+		t.Errorf("a nil Reachability should remember nothing")
+	}
+}
+
+func mustMint(c Capability) string {
+	str, err := c.Mint([]byte("0123456789abcdef"))
+	if err != nil {
+		panic(fmt.Errorf("Mint: %v", err))
+	}
+	return str
+}
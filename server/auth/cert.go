@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// CertFields lists the values accepted by CertExtractor.Field.
+var CertFields = []string{"cn", "dns", "email"}
+
+// CertExtractor identifies the caller by the TLS client certificate that it
+// presented, which the server has already verified against its CA.  Callers
+// without a verified certificate are anonymous.
+type CertExtractor struct {
+	// Field selects the part of the certificate that names the Role:
+	// "cn" for the subject's common name, or "dns" or "email" for the
+	// first subject alternative name of that kind.
+	Field string
+}
+
+func (x CertExtractor) Extract(ctx context.Context) (Role, error) {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.AuthInfo == nil {
+		return Anonymous, nil
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return Anonymous, nil
+	}
+	cert := info.State.VerifiedChains[0][0]
+	var name string
+	switch x.Field {
+	case "", "cn":
+		name = cert.Subject.CommonName
+	case "dns":
+		if len(cert.DNSNames) > 0 {
+			name = cert.DNSNames[0]
+		}
+	case "email":
+		if len(cert.EmailAddresses) > 0 {
+			name = cert.EmailAddresses[0]
+		}
+	default:
+		return Anonymous, fmt.Errorf("unknown certificate field %q", x.Field)
+	}
+	if name == "" {
+		return Anonymous, fmt.Errorf("client certificate %q has no %s", cert.Subject.CommonName, x.Field)
+	}
+	return Role(name), nil
+}
+
+// ValidCertField returns an error if field is not one of CertFields.
+func ValidCertField(field string) error {
+	for _, f := range CertFields {
+		if f == field {
+			return nil
+		}
+	}
+	return fmt.Errorf("must be one of: %s", strings.Join(CertFields, ", "))
+}
+
+// CertAuther identifies callers by their TLS client certificates.
+func CertAuther(field string) Auther {
+	return Auther{
+		Extractor:     CertExtractor{Field: field},
+		Membershipper: NoMemberships{},
+	}
+}
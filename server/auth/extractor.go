@@ -6,8 +6,47 @@ type Extractor interface {
 	Extract(ctx context.Context) (Role, error)
 }
 
+// ScopedExtractor is an Extractor whose credentials may be limited to some
+// operations.  An empty scope means that the credentials are not limited.
+type ScopedExtractor interface {
+	Extractor
+	ExtractScoped(ctx context.Context) (Role, []Operation, error)
+}
+
 type AnonymousExtractor struct{}
 
 func (_ AnonymousExtractor) Extract(ctx context.Context) (Role, error) {
 	return Anonymous, nil
 }
+
+// ChainExtractor tries each Extractor in turn, and identifies the caller by
+// the first one that doesn't find it anonymous.
+type ChainExtractor []Extractor
+
+func (chain ChainExtractor) Extract(ctx context.Context) (Role, error) {
+	role, _, err := chain.ExtractScoped(ctx)
+	return role, err
+}
+
+func (chain ChainExtractor) ExtractScoped(ctx context.Context) (Role, []Operation, error) {
+	for _, x := range chain {
+		role, scope, err := extractScoped(x, ctx)
+		if err != nil {
+			return Anonymous, nil, err
+		}
+		if role != Anonymous {
+			return role, scope, nil
+		}
+	}
+	return Anonymous, nil, nil
+}
+
+func extractScoped(x Extractor, ctx context.Context) (Role, []Operation, error) {
+	if sx, ok := x.(ScopedExtractor); ok {
+		return sx.ExtractScoped(ctx)
+	}
+	role, err := x.Extract(ctx)
+	return role, nil, err
+}
+
+var _ ScopedExtractor = ChainExtractor(nil)
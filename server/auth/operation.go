@@ -1,5 +1,10 @@
 package auth
 
+import (
+	"fmt"
+	"strings"
+)
+
 //go:generate stringer -type=Operation
 
 type Operation uint8
@@ -12,3 +17,17 @@ const (
 	Put
 	Remove
 )
+
+// ParseOperation parses the lowercase name of an Operation, as written in an
+// ACL rule.  "*" is accepted as a synonym for "any".
+func ParseOperation(in string) (Operation, error) {
+	if in == "*" {
+		return Any, nil
+	}
+	for op := Any; op <= Remove; op++ {
+		if strings.EqualFold(in, op.String()) {
+			return op, nil
+		}
+	}
+	return Any, fmt.Errorf("unknown operation %q", in)
+}
@@ -0,0 +1,180 @@
+package auth
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/peer"
+)
+
+// PeerCred holds the credentials of the process at the other end of a Unix
+// socket, as reported by the kernel when the connection was accepted.
+type PeerCred struct {
+	PID int32
+	UID uint32
+	GID uint32
+}
+
+// PeerCredAddr is the remote address of a connection accepted by a
+// PeerCredListener.  gRPC reports it as the address of the caller, which is
+// how PeerCredExtractor gets at the credentials.
+type PeerCredAddr struct {
+	net.Addr
+	Cred PeerCred
+}
+
+// String identifies the peer process, since the addresses of Unix socket
+// clients are usually blank.
+func (addr *PeerCredAddr) String() string {
+	return fmt.Sprintf("%s(pid=%d,uid=%d,gid=%d)", addr.Addr.String(),
+		addr.Cred.PID, addr.Cred.UID, addr.Cred.GID)
+}
+
+// PeerCredListener wraps a Unix socket listener, and records the peer
+// credentials of each connection it accepts.
+func PeerCredListener(l net.Listener) net.Listener {
+	return peerCredListener{l}
+}
+
+type peerCredListener struct {
+	net.Listener
+}
+
+func (l peerCredListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return conn, nil
+	}
+	cred, err := getPeerCred(uc)
+	if err != nil {
+		log.Printf("warn: go-cas/server/auth: failed to get peer credentials: %v", err)
+		return conn, nil
+	}
+	return peerCredConn{conn, &PeerCredAddr{conn.RemoteAddr(), cred}}, nil
+}
+
+type peerCredConn struct {
+	net.Conn
+	addr *PeerCredAddr
+}
+
+func (conn peerCredConn) RemoteAddr() net.Addr {
+	return conn.addr
+}
+
+// PeerCredExtractor identifies callers on Unix sockets by the user that
+// they run as.  The Role is the user's name, or "#<uid>" if the user has no
+// name.  Callers on other kinds of socket are anonymous.
+type PeerCredExtractor struct{}
+
+func (_ PeerCredExtractor) Extract(ctx context.Context) (Role, error) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return Anonymous, nil
+	}
+	addr, ok := p.Addr.(*PeerCredAddr)
+	if !ok {
+		return Anonymous, nil
+	}
+	uid := strconv.FormatUint(uint64(addr.Cred.UID), 10)
+	u, err := user.LookupId(uid)
+	if err != nil {
+		return Role("#" + uid), nil
+	}
+	return Role(u.Username), nil
+}
+
+// GroupPrefix marks a Role as the name of a Unix group, e.g. "@builders".
+const GroupPrefix = "@"
+
+// unixGroupsTTL is how long UnixGroups remembers a user's groups.
+const unixGroupsTTL = 1 * time.Minute
+
+// UnixGroups is a Membershipper that treats a Role of the form "@group" as
+// the Unix group of that name, and every other Role as a Unix user.
+// Lookups are cached briefly, since they may involve NSS.
+type UnixGroups struct {
+	mutex  sync.Mutex
+	groups map[Role]unixGroupsEntry
+}
+
+type unixGroupsEntry struct {
+	gids    []string
+	expires time.Time
+}
+
+func NewUnixGroups() *UnixGroups {
+	return &UnixGroups{groups: make(map[Role]unixGroupsEntry)}
+}
+
+func (ug *UnixGroups) IsMember(u, g Role) (bool, error) {
+	if u == Anonymous || !strings.HasPrefix(string(g), GroupPrefix) {
+		return false, nil
+	}
+	group, err := user.LookupGroup(strings.TrimPrefix(string(g), GroupPrefix))
+	if _, ok := err.(user.UnknownGroupError); ok {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	gids, err := ug.lookup(u)
+	if err != nil {
+		return false, err
+	}
+	for _, gid := range gids {
+		if gid == group.Gid {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (ug *UnixGroups) lookup(u Role) ([]string, error) {
+	now := time.Now()
+	ug.mutex.Lock()
+	entry, found := ug.groups[u]
+	ug.mutex.Unlock()
+	if found && now.Before(entry.expires) {
+		return entry.gids, nil
+	}
+
+	var usr *user.User
+	var err error
+	if strings.HasPrefix(string(u), "#") {
+		usr, err = user.LookupId(string(u[1:]))
+	} else {
+		usr, err = user.Lookup(string(u))
+	}
+	if _, ok := err.(user.UnknownUserError); ok {
+		return nil, nil
+	}
+	if _, ok := err.(user.UnknownUserIdError); ok {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	gids, err := usr.GroupIds()
+	if err != nil {
+		return nil, fmt.Errorf("groups of %q: %v", usr.Username, err)
+	}
+
+	ug.mutex.Lock()
+	ug.groups[u] = unixGroupsEntry{gids, now.Add(unixGroupsTTL)}
+	ug.mutex.Unlock()
+	return gids, nil
+}
+
+var _ Membershipper = (*UnixGroups)(nil)
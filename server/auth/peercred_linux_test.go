@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestPeerCredListener(t *testing.T) {
+	dir, err := ioutil.TempDir("", "peercred")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	l, err := net.Listen("unix", filepath.Join(dir, "sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l = PeerCredListener(l)
+	defer l.Close()
+
+	go func() {
+		conn, err := net.Dial("unix", l.Addr().String())
+		if err == nil {
+			defer conn.Close()
+			conn.Read(make([]byte, 1))
+		}
+	}()
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	addr, ok := conn.RemoteAddr().(*PeerCredAddr)
+	if !ok {
+		t.Fatalf("expected *PeerCredAddr, got %#v", conn.RemoteAddr())
+	}
+	if addr.Cred.UID != uint32(os.Getuid()) || addr.Cred.GID != uint32(os.Getgid()) || addr.Cred.PID != int32(os.Getpid()) {
+		t.Errorf("expected uid=%d gid=%d pid=%d, got %+v", os.Getuid(), os.Getgid(), os.Getpid(), addr.Cred)
+	}
+}
+
+func TestUnixGroups(t *testing.T) {
+	u, err := user.LookupId(strconv.Itoa(os.Getuid()))
+	if err != nil {
+		t.Skipf("current user has no name: %v", err)
+	}
+	g, err := user.LookupGroupId(u.Gid)
+	if err != nil {
+		t.Skipf("primary group has no name: %v", err)
+	}
+
+	ug := NewUnixGroups()
+	type testrow struct {
+		User   Role
+		Group  Role
+		Member bool
+	}
+	for i, row := range []testrow{
+		testrow{Role(u.Username), Role(GroupPrefix + g.Name), true},
+		testrow{Role("#" + u.Uid), Role(GroupPrefix + g.Name), true},
+		testrow{Role(u.Username), Role(g.Name), false},
+		testrow{Anonymous, Role(GroupPrefix + g.Name), false},
+		testrow{"no-such-user-go-cas", Role(GroupPrefix + g.Name), false},
+		testrow{Role(u.Username), "@no-such-group-go-cas", false},
+	} {
+		member, err := ug.IsMember(row.User, row.Group)
+		if err != nil {
+			t.Errorf("[%d] unexpected error: %v", i, err)
+			continue
+		}
+		if member != row.Member {
+			t.Errorf("[%d] IsMember(%q, %q): expected %t, got %t", i, row.User, row.Group, row.Member, member)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package auth
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+)
+
+func getPeerCred(conn *net.UnixConn) (PeerCred, error) {
+	return PeerCred{}, fmt.Errorf("peer credentials are not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,214 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+	"sync/atomic"
+)
+
+// Policy is an ACL together with the groups that its rules may name.
+//
+// A policy file is read line by line.  Blank lines and text after '#' are
+// ignored.  Each other line is either a group definition,
+//
+//	group @builders alice bob @release
+//
+// which makes alice, bob, and the members of @release members of @builders,
+// or an ACL rule, as accepted by ParseRule,
+//
+//	@builders:put = allow
+//
+// Rules are checked in the order given, and the first matching rule wins.
+// Groups may be nested, but not in a cycle.  A group that the file doesn't
+// define is left to the server's own Membershipper, e.g. as a Unix group.
+type Policy struct {
+	ACL    ACL
+	Groups map[Role][]Role
+}
+
+// ParsePolicy parses and validates the contents of a policy file.  name is
+// used in error messages.
+func ParsePolicy(name string, data []byte) (*Policy, error) {
+	p := &Policy{Groups: make(map[Role][]Role)}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		fields := strings.Fields(line)
+		if fields[0] == "group" {
+			if err := p.addGroup(fields[1:]); err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", name, lineno, err)
+			}
+			continue
+		}
+		rule, err := ParseRule(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", name, lineno, err)
+		}
+		if rule.Role == Nobody {
+			return nil, fmt.Errorf("%s:%d: rule %q has no role", name, lineno, line)
+		}
+		p.ACL = append(p.ACL, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	if err := p.checkCycles(); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return p, nil
+}
+
+func (p *Policy) addGroup(fields []string) error {
+	if len(fields) == 0 {
+		return fmt.Errorf("expected \"group @name member...\"")
+	}
+	group := Role(fields[0])
+	if !isGroup(group) {
+		return fmt.Errorf("group name %q must start with %q", fields[0], GroupPrefix)
+	}
+	if _, found := p.Groups[group]; found {
+		return fmt.Errorf("group %q is defined twice", fields[0])
+	}
+	members := make([]Role, 0, len(fields)-1)
+	for _, member := range fields[1:] {
+		if Role(member) == Anybody {
+			return fmt.Errorf("group %q: %q cannot be a member", fields[0], member)
+		}
+		members = append(members, Role(member))
+	}
+	p.Groups[group] = members
+	return nil
+}
+
+func (p *Policy) checkCycles() error {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[Role]int, len(p.Groups))
+	var visit func(g Role, path []Role) error
+	visit = func(g Role, path []Role) error {
+		switch state[g] {
+		case visiting:
+			var names []string
+			for _, r := range append(path, g) {
+				names = append(names, string(r))
+			}
+			return fmt.Errorf("groups form a cycle: %s", strings.Join(names, " -> "))
+		case visited:
+			return nil
+		}
+		state[g] = visiting
+		for _, member := range p.Groups[g] {
+			if _, found := p.Groups[member]; found {
+				if err := visit(member, append(path, g)); err != nil {
+					return err
+				}
+			}
+		}
+		state[g] = visited
+		return nil
+	}
+	for g := range p.Groups {
+		if err := visit(g, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isGroup(r Role) bool {
+	return len(r) > len(GroupPrefix) && strings.HasPrefix(string(r), GroupPrefix)
+}
+
+// IsMember returns true iff u is a member of g, directly or through nested
+// groups.  Groups that the policy doesn't define are looked up in fallback.
+func (p *Policy) IsMember(u, g Role, fallback Membershipper) (bool, error) {
+	members, found := p.Groups[g]
+	if !found {
+		return fallback.IsMember(u, g)
+	}
+	for _, member := range members {
+		if member == u {
+			return true, nil
+		}
+		if !isGroup(member) {
+			continue
+		}
+		ismem, err := p.IsMember(u, member, fallback)
+		if err != nil {
+			return false, err
+		}
+		if ismem {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// policyMemberships adapts a Policy to Membershipper.
+type policyMemberships struct {
+	policy   *Policy
+	fallback Membershipper
+}
+
+func (m policyMemberships) IsMember(u, g Role) (bool, error) {
+	return m.policy.IsMember(u, g, m.fallback)
+}
+
+// PolicyFile holds the Policy loaded from a file, and replaces it atomically
+// when the file is reloaded.
+type PolicyFile struct {
+	Path    string
+	current atomic.Value
+}
+
+// LoadPolicyFile loads and validates the policy in path.
+func LoadPolicyFile(path string) (*PolicyFile, error) {
+	pf := &PolicyFile{Path: path}
+	if err := pf.Reload(); err != nil {
+		return nil, err
+	}
+	return pf, nil
+}
+
+// Current returns the policy in force, or nil if pf is nil.
+func (pf *PolicyFile) Current() *Policy {
+	if pf == nil {
+		return nil
+	}
+	return pf.current.Load().(*Policy)
+}
+
+// Reload re-reads the policy file.  If the file can't be read or isn't
+// valid, the policy in force is kept.
+func (pf *PolicyFile) Reload() error {
+	if pf == nil {
+		return nil
+	}
+	data, err := ioutil.ReadFile(pf.Path)
+	if err != nil {
+		return err
+	}
+	p, err := ParsePolicy(pf.Path, data)
+	if err != nil {
+		return err
+	}
+	pf.current.Store(p)
+	log.Printf("info: loaded policy %q: %d rules, %d groups", pf.Path, len(p.ACL), len(p.Groups))
+	return nil
+}
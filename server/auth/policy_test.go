@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+const testPolicy = `
+# release engineers may also build
+group @release carol
+group @builders alice bob @release   # nested
+
+@builders:put = allow
+@builders:get = allow
+*:statfs = allow
+* = deny
+`
+
+func TestParsePolicy(t *testing.T) {
+	p, err := ParsePolicy("test", []byte(testPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.ACL) != 4 || len(p.Groups) != 2 {
+		t.Fatalf("expected 4 rules and 2 groups, got %#v", p)
+	}
+	if str := p.ACL.String(); str != "@builders:put=allow,@builders:get=allow,*:statfs=allow,*=deny" {
+		t.Errorf("wrong ACL: %s", str)
+	}
+
+	auther := AnonymousAuther()
+	type testrow struct {
+		Role   Role
+		Op     Operation
+		Result Result
+	}
+	for i, row := range []testrow{
+		testrow{"alice", Put, Allow},
+		testrow{"carol", Get, Allow},
+		testrow{"carol", Remove, Deny},
+		testrow{"dave", Put, Deny},
+		testrow{"dave", StatFS, Allow},
+	} {
+		id := Identity{auther, row.Role, nil, nil, p}
+		if result := id.Check(AllowAll(), row.Op); result != row.Result {
+			t.Errorf("[%d] %v %v: expected %v, got %v", i, row.Role, row.Op, row.Result, result)
+		}
+	}
+}
+
+func TestParsePolicy_Errors(t *testing.T) {
+	type testrow struct {
+		In  string
+		Err string
+	}
+	for i, row := range []testrow{
+		testrow{"group builders alice",
+			`test:1: group name "builders" must start with "@"`},
+		testrow{"group @a x\ngroup @a y",
+			`test:2: group "@a" is defined twice`},
+		testrow{"group @a *",
+			`test:1: group "@a": "*" cannot be a member`},
+		testrow{"group",
+			`test:1: expected "group @name member..."`},
+		testrow{"\n\nalice:frob = allow",
+			`test:3: rule "alice:frob = allow": unknown operation "frob"`},
+		testrow{"= allow",
+			`test:1: rule "= allow" has no role`},
+		testrow{"group @a @b\ngroup @b @a",
+			"test: groups form a cycle: "},
+	} {
+		_, err := ParsePolicy("test", []byte(row.In))
+		if err == nil {
+			t.Errorf("[%d] expected error %q, got nil", i, row.Err)
+			continue
+		}
+		if str := err.Error(); len(str) < len(row.Err) || str[:len(row.Err)] != row.Err {
+			t.Errorf("[%d] expected error %q, got %q", i, row.Err, str)
+		}
+	}
+}
+
+func TestPolicyFile_Reload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "policy")
+
+	write := func(data string) {
+		if err := ioutil.WriteFile(path, []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("alice = allow\n")
+	pf, err := LoadPolicyFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auther := AnonymousAuther()
+	auther.Policy = pf
+
+	old := auther.Extract(context.Background())
+	old.Role = "alice"
+
+	write("alice = deny\n")
+	if err := pf.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if result := old.Check(nil, Get); result != Allow {
+		t.Errorf("identity extracted before reload: expected allow, got %v", result)
+	}
+	id := auther.Extract(context.Background())
+	id.Role = "alice"
+	if result := id.Check(nil, Get); result != Deny {
+		t.Errorf("after reload: expected deny, got %v", result)
+	}
+
+	write("alice = maybe\n")
+	if err := pf.Reload(); err == nil {
+		t.Errorf("invalid policy: expected error, got nil")
+	}
+	if str := pf.Current().ACL.String(); str != "alice=deny" {
+		t.Errorf("invalid policy: expected old policy to stay, got %q", str)
+	}
+}
@@ -0,0 +1,175 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/metadata"
+)
+
+// TokenMetadataKey is the gRPC metadata key that carries bearer tokens, in
+// the form "Bearer <token>".
+const TokenMetadataKey = "authorization"
+
+const tokenPrefix = "cas1."
+
+// MinTokenKeySize is the minimum length, in bytes, of a token signing key.
+const MinTokenKeySize = 16
+
+var errBadToken = errors.New("malformed token")
+
+// Token is a bearer token that grants a Role, until it expires.  If
+// Operations is non-empty, the token can only be used for those operations,
+// whatever the ACL grants the role.
+//
+// Tokens are signed with HMAC-SHA256 using a key shared between the servers
+// that accept them and whoever mints them.  Anyone who holds a token can use
+// it, so tokens should only be sent over TLS or a local socket.
+type Token struct {
+	Role       Role
+	Expiry     time.Time
+	Operations []Operation
+}
+
+type tokenPayload struct {
+	Role   string   `json:"role"`
+	Expiry int64    `json:"exp"`
+	Ops    []string `json:"ops,omitempty"`
+}
+
+// Mint encodes and signs tok.
+func (tok Token) Mint(key []byte) (string, error) {
+	if len(key) < MinTokenKeySize {
+		return "", fmt.Errorf("token key is too short: need at least %d bytes", MinTokenKeySize)
+	}
+	if tok.Role == Nobody || tok.Role == Anybody || tok.Role == Anonymous {
+		return "", fmt.Errorf("cannot mint a token for role %q", string(tok.Role))
+	}
+	if tok.Expiry.IsZero() {
+		return "", errors.New("cannot mint a token without an expiry")
+	}
+	payload := tokenPayload{Role: string(tok.Role), Expiry: tok.Expiry.Unix()}
+	for _, op := range tok.Operations {
+		if op == Any {
+			payload.Ops = nil
+			break
+		}
+		payload.Ops = append(payload.Ops, strings.ToLower(op.String()))
+	}
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	body := tokenPrefix + base64.RawURLEncoding.EncodeToString(raw)
+	return body + "." + base64.RawURLEncoding.EncodeToString(tokenMAC(key, body)), nil
+}
+
+// ParseToken verifies the signature of in, and returns the Token it encodes
+// if it has not expired by now.
+func ParseToken(key []byte, in string, now time.Time) (Token, error) {
+	if !strings.HasPrefix(in, tokenPrefix) {
+		return Token{}, errBadToken
+	}
+	i := strings.LastIndexByte(in, '.')
+	if i < len(tokenPrefix) {
+		return Token{}, errBadToken
+	}
+	body, sig := in[:i], in[i+1:]
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return Token{}, errBadToken
+	}
+	if !hmac.Equal(mac, tokenMAC(key, body)) {
+		return Token{}, errors.New("token has an invalid signature")
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(body[len(tokenPrefix):])
+	if err != nil {
+		return Token{}, errBadToken
+	}
+	var payload tokenPayload
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return Token{}, errBadToken
+	}
+	tok := Token{Role: Role(payload.Role), Expiry: time.Unix(payload.Expiry, 0)}
+	if tok.Role == Nobody || payload.Expiry == 0 {
+		return Token{}, errBadToken
+	}
+	if !now.Before(tok.Expiry) {
+		return Token{}, fmt.Errorf("token for %q expired at %v", payload.Role, tok.Expiry)
+	}
+	for _, name := range payload.Ops {
+		op, err := ParseOperation(name)
+		if err != nil {
+			return Token{}, err
+		}
+		tok.Operations = append(tok.Operations, op)
+	}
+	return tok, nil
+}
+
+func tokenMAC(key []byte, body string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(body))
+	return h.Sum(nil)
+}
+
+// LoadTokenKey reads a token signing key from a file.  Trailing whitespace
+// is ignored, so that the key can be edited as text.
+func LoadTokenKey(path string) ([]byte, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key := bytes.TrimRight(raw, " \t\r\n")
+	if len(key) < MinTokenKeySize {
+		return nil, fmt.Errorf("%q: key is too short: need at least %d bytes", path, MinTokenKeySize)
+	}
+	return key, nil
+}
+
+// TokenExtractor identifies the caller by the bearer token in its request
+// metadata.  Callers without a token are anonymous.
+type TokenExtractor struct {
+	Key []byte
+}
+
+func (x TokenExtractor) Extract(ctx context.Context) (Role, error) {
+	role, _, err := x.ExtractScoped(ctx)
+	return role, err
+}
+
+func (x TokenExtractor) ExtractScoped(ctx context.Context) (Role, []Operation, error) {
+	md, ok := metadata.FromContext(ctx)
+	if !ok || len(md[TokenMetadataKey]) == 0 {
+		return Anonymous, nil, nil
+	}
+	value := md[TokenMetadataKey][0]
+	const bearer = "Bearer "
+	if len(value) < len(bearer) || !strings.EqualFold(value[:len(bearer)], bearer) {
+		return Anonymous, nil, fmt.Errorf("unsupported authorization scheme")
+	}
+	tok, err := ParseToken(x.Key, strings.TrimSpace(value[len(bearer):]), time.Now())
+	if err != nil {
+		return Anonymous, nil, err
+	}
+	return tok.Role, tok.Operations, nil
+}
+
+// TokenAuther identifies callers by their bearer tokens.
+func TokenAuther(key []byte) Auther {
+	return Auther{
+		Extractor:     TokenExtractor{Key: key},
+		Membershipper: NoMemberships{},
+	}
+}
+
+var _ ScopedExtractor = TokenExtractor{}
@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestToken_RoundTrip(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	now := time.Unix(1450000000, 0)
+	tok := Token{Role: "ci-bot", Expiry: now.Add(time.Hour), Operations: []Operation{Get, StatFS}}
+	str, err := tok.Mint(key)
+	if err != nil {
+		t.Fatalf("Mint: %v", err)
+	}
+
+	got, err := ParseToken(key, str, now)
+	if err != nil {
+		t.Fatalf("ParseToken: %v", err)
+	}
+	if got.Role != tok.Role || !got.Expiry.Equal(tok.Expiry) || len(got.Operations) != 2 ||
+		got.Operations[0] != Get || got.Operations[1] != StatFS {
+		t.Errorf("expected %#v, got %#v", tok, got)
+	}
+
+	if _, err := ParseToken(key, str, now.Add(time.Hour)); err == nil {
+		t.Errorf("expired: expected error, got nil")
+	}
+	if _, err := ParseToken([]byte("fedcba9876543210fedcba9876543210"), str, now); err == nil {
+		t.Errorf("wrong key: expected error, got nil")
+	}
+	i := strings.LastIndexByte(str, '.')
+	forged := str[:i-2] + "xx" + str[i:]
+	if _, err := ParseToken(key, forged, now); err == nil {
+		t.Errorf("forged payload: expected error, got nil")
+	}
+	for _, bad := range []string{"", "cas1.", "cas1.e30", "bogus.e30.AAAA"} {
+		if _, err := ParseToken(key, bad, now); err == nil {
+			t.Errorf("%q: expected error, got nil", bad)
+		}
+	}
+}
+
+func TestToken_Mint(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	exp := time.Unix(1450000000, 0)
+	for i, tok := range []Token{
+		Token{Role: Anybody, Expiry: exp},
+		Token{Role: Anonymous, Expiry: exp},
+		Token{Role: Nobody, Expiry: exp},
+		Token{Role: "ci-bot"},
+	} {
+		if _, err := tok.Mint(key); err == nil {
+			t.Errorf("[%d] expected error, got nil", i)
+		}
+	}
+	if _, err := (Token{Role: "ci-bot", Expiry: exp}).Mint(key[:8]); err == nil {
+		t.Errorf("short key: expected error, got nil")
+	}
+}
+
+func TestIdentity_Scope(t *testing.T) {
+	id := Identity{AnonymousAuther(), "ci-bot", []Operation{Get, StatFS}, nil, nil}
+	acl := AllowAll()
+	for _, op := range []Operation{Get, StatFS} {
+		if result := id.Check(acl, op); result != Allow {
+			t.Errorf("%v: expected allow, got %v", op, result)
+		}
+	}
+	for _, op := range []Operation{Walk, Put, Remove} {
+		if result := id.Check(acl, op); result != Deny {
+			t.Errorf("%v: expected deny, got %v", op, result)
+		}
+	}
+}
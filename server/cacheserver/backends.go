@@ -0,0 +1,359 @@
+package cacheserver
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/cloud9-tools/go-cas/client"
+	"github.com/cloud9-tools/go-cas/proto"
+	"github.com/cloud9-tools/go-multierror"
+)
+
+const minRedialDelay = 1 * time.Second
+const maxRedialDelay = 1 * time.Minute
+
+// redialAfter is the number of consecutive failed probes after which a
+// backend's connection is torn down and dialed again from scratch.
+const redialAfter = 3
+
+// backendPool is a client.Client that spreads over several backends, listed
+// in priority order.  RPCs go to the first healthy backend; reads that fail
+// because the backend is unreachable are retried on the next one.  Writes
+// are not retried, since a write that timed out may still have happened, but
+// the failure still marks the backend unhealthy so that the next write goes
+// elsewhere.
+//
+// If retryCorrupt is set, a read that fails with DataLoss is also retried on
+// the next backend, in the hope that only one copy of the block is damaged.
+//
+// A probe loop checks every backend with Stat, restores backends that have
+// recovered, and redials backends that could not be dialed (or that have
+// failed several probes in a row) with exponential backoff.
+type backendPool struct {
+	mutex    sync.Mutex
+	backends []*backend
+	dial     func(spec string) (client.Client, error)
+	interval time.Duration
+
+	retryCorrupt bool
+
+	closech chan struct{}
+	donech  chan struct{}
+}
+
+type backend struct {
+	spec string
+
+	// cc is nil until the backend has been dialed successfully.
+	cc        client.Client
+	healthy   bool
+	failures  int
+	nextDial  time.Time
+	dialDelay time.Duration
+}
+
+func newBackendPool(specs []string, dial func(string) (client.Client, error), interval time.Duration, retryCorrupt bool) *backendPool {
+	p := &backendPool{
+		dial:         dial,
+		interval:     interval,
+		retryCorrupt: retryCorrupt,
+		closech:      make(chan struct{}),
+		donech:       make(chan struct{}),
+	}
+	for _, spec := range specs {
+		p.backends = append(p.backends, &backend{spec: spec, dialDelay: minRedialDelay})
+	}
+	now := time.Now()
+	for _, b := range p.backends {
+		p.redial(b, now)
+	}
+	go p.loop()
+	return p
+}
+
+func (p *backendPool) Close() error {
+	close(p.closech)
+	<-p.donech
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	var errs []error
+	for _, b := range p.backends {
+		if b.cc != nil {
+			errs = append(errs, b.cc.Close())
+			b.cc = nil
+		}
+	}
+	return multierror.New(errs)
+}
+
+// Active returns the backend that RPCs are currently sent to, or "" if none
+// of them can be reached.
+func (p *backendPool) Active() string {
+	list := p.candidates()
+	if len(list) == 0 {
+		return ""
+	}
+	return list[0].spec
+}
+
+type candidate struct {
+	b    *backend
+	spec string
+	cc   client.Client
+}
+
+// candidates lists the backends to try, in order: the healthy ones by
+// priority, or if none are healthy, every backend that is connected.
+func (p *backendPool) candidates() []candidate {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	var healthy, connected []candidate
+	for _, b := range p.backends {
+		if b.cc == nil {
+			continue
+		}
+		c := candidate{b, b.spec, b.cc}
+		connected = append(connected, c)
+		if b.healthy {
+			healthy = append(healthy, c)
+		}
+	}
+	if len(healthy) > 0 {
+		return healthy
+	}
+	return connected
+}
+
+// isBackendFailure returns true iff err means that the backend could not be
+// reached, as opposed to the backend answering with an error.
+func isBackendFailure(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if err == grpc.ErrClientConnClosing {
+		return true
+	}
+	switch grpc.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Internal:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *backendPool) markFailed(c candidate, err error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if c.b.healthy {
+		log.Printf("warn: backend %q failed: %v", c.spec, err)
+	}
+	c.b.healthy = false
+}
+
+func noBackends() error {
+	return grpc.Errorf(codes.Unavailable, "go-cas/server/cacheserver: no backend is available")
+}
+
+// read calls fn on each candidate in turn until one answers.
+func (p *backendPool) read(ctx context.Context, fn func(cc client.Client) error) error {
+	err := noBackends()
+	for _, c := range p.candidates() {
+		err = fn(c.cc)
+		if err == nil {
+			return nil
+		}
+		if p.retryCorrupt && grpc.Code(err) == codes.DataLoss && ctx.Err() == nil {
+			log.Printf("warn: backend %q returned a corrupt block: %v", c.spec, err)
+			continue
+		}
+		if !isBackendFailure(ctx, err) {
+			return err
+		}
+		p.markFailed(c, err)
+	}
+	return err
+}
+
+// write calls fn on the first candidate only.
+func (p *backendPool) write(ctx context.Context, fn func(cc client.Client) error) error {
+	list := p.candidates()
+	if len(list) == 0 {
+		return noBackends()
+	}
+	err := fn(list[0].cc)
+	if err != nil && isBackendFailure(ctx, err) {
+		p.markFailed(list[0], err)
+	}
+	return err
+}
+
+func (p *backendPool) Get(ctx context.Context, in *proto.GetRequest, opts ...grpc.CallOption) (out *proto.GetReply, err error) {
+	err = p.read(ctx, func(cc client.Client) error {
+		out, err = cc.Get(ctx, in, opts...)
+		return err
+	})
+	return
+}
+
+func (p *backendPool) Put(ctx context.Context, in *proto.PutRequest, opts ...grpc.CallOption) (out *proto.PutReply, err error) {
+	err = p.write(ctx, func(cc client.Client) error {
+		out, err = cc.Put(ctx, in, opts...)
+		return err
+	})
+	return
+}
+
+func (p *backendPool) Remove(ctx context.Context, in *proto.RemoveRequest, opts ...grpc.CallOption) (out *proto.RemoveReply, err error) {
+	err = p.write(ctx, func(cc client.Client) error {
+		out, err = cc.Remove(ctx, in, opts...)
+		return err
+	})
+	return
+}
+
+func (p *backendPool) Stat(ctx context.Context, in *proto.StatRequest, opts ...grpc.CallOption) (out *proto.StatReply, err error) {
+	err = p.read(ctx, func(cc client.Client) error {
+		out, err = cc.Stat(ctx, in, opts...)
+		return err
+	})
+	return
+}
+
+// Walk fails over only when the stream is opened; a stream that breaks
+// partway through is reported to the caller as an error.
+func (p *backendPool) Walk(ctx context.Context, in *proto.WalkRequest, opts ...grpc.CallOption) (out proto.CAS_WalkClient, err error) {
+	err = p.read(ctx, func(cc client.Client) error {
+		out, err = cc.Walk(ctx, in, opts...)
+		return err
+	})
+	return
+}
+
+func (p *backendPool) CacheStats(ctx context.Context, in *proto.CacheStatsRequest, opts ...grpc.CallOption) (out *proto.CacheStatsReply, err error) {
+	err = p.read(ctx, func(cc client.Client) error {
+		out, err = cc.CacheStats(ctx, in, opts...)
+		return err
+	})
+	return
+}
+
+func (p *backendPool) loop() {
+	defer close(p.donech)
+	for {
+		p.probe()
+		select {
+		case <-p.closech:
+			return
+		case <-time.After(p.interval):
+		}
+	}
+}
+
+// probe checks every backend in parallel, then updates their health.
+func (p *backendPool) probe() {
+	p.mutex.Lock()
+	backends := make([]*backend, len(p.backends))
+	clients := make([]client.Client, len(p.backends))
+	for i, b := range p.backends {
+		backends[i] = b
+		clients[i] = b.cc
+	}
+	p.mutex.Unlock()
+
+	errs := make([]error, len(backends))
+	var wg sync.WaitGroup
+	for i, cc := range clients {
+		if cc == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, cc client.Client) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), p.interval)
+			defer cancel()
+			_, errs[i] = cc.Stat(ctx, &proto.StatRequest{})
+		}(i, cc)
+	}
+	wg.Wait()
+
+	now := time.Now()
+	for i, b := range backends {
+		if clients[i] == nil {
+			p.redial(b, now)
+			continue
+		}
+		p.mutex.Lock()
+		if b.cc != clients[i] {
+			p.mutex.Unlock()
+			continue
+		}
+		if errs[i] == nil {
+			if !b.healthy {
+				log.Printf("info: backend %q is healthy", b.spec)
+			}
+			b.healthy = true
+			b.failures = 0
+			b.dialDelay = minRedialDelay
+			p.mutex.Unlock()
+			continue
+		}
+		if b.healthy {
+			log.Printf("warn: backend %q failed health check: %v", b.spec, errs[i])
+		}
+		b.healthy = false
+		b.failures++
+		var stale client.Client
+		if b.failures >= redialAfter {
+			log.Printf("warn: reconnecting to backend %q in %v", b.spec, b.dialDelay)
+			stale = b.cc
+			b.cc = nil
+			b.failures = 0
+			b.backOff(now)
+		}
+		p.mutex.Unlock()
+		if stale != nil {
+			stale.Close()
+		}
+	}
+}
+
+// backOff delays the next dial of b, doubling the delay each time.
+func (b *backend) backOff(now time.Time) {
+	b.nextDial = now.Add(b.dialDelay)
+	b.dialDelay *= 2
+	if b.dialDelay > maxRedialDelay {
+		b.dialDelay = maxRedialDelay
+	}
+}
+
+// redial connects to b if it is not connected and its backoff has expired.
+// A backend that has just been dialed is not trusted until a probe succeeds,
+// unless it is the first dial at startup.
+func (p *backendPool) redial(b *backend, now time.Time) {
+	p.mutex.Lock()
+	ready := b.cc == nil && !now.Before(b.nextDial)
+	spec := b.spec
+	p.mutex.Unlock()
+	if !ready {
+		return
+	}
+
+	cc, err := p.dial(spec)
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if err != nil {
+		log.Printf("warn: failed to dial backend %q, retrying in %v: %v", spec, b.dialDelay, err)
+		b.backOff(now)
+		return
+	}
+	b.cc = cc
+	b.healthy = b.nextDial.IsZero()
+}
+
+var _ client.Client = (*backendPool)(nil)
@@ -0,0 +1,326 @@
+package cacheserver
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/cloud9-tools/go-cas/client"
+	"github.com/cloud9-tools/go-cas/proto"
+)
+
+// fakeBackend is a client.Client whose answers are set by the test.
+type fakeBackend struct {
+	mutex   sync.Mutex
+	getErr  error
+	putErr  error
+	statErr error
+	reply   *proto.GetReply
+	gets    int
+	puts    int
+	stats   int
+	closed  bool
+
+	// keep, if set, makes Put store blocks for later Gets.
+	keep   bool
+	blocks map[string][]byte
+
+	// onGet, onStat and onPut, if set, are called during each Get, Stat
+	// or Put.
+	onGet  func()
+	onStat func()
+	onPut  func()
+}
+
+func (f *fakeBackend) set(fn func()) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	fn()
+}
+
+func (f *fakeBackend) counts() (gets, puts, stats int) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.gets, f.puts, f.stats
+}
+
+func (f *fakeBackend) Close() error {
+	f.set(func() { f.closed = true })
+	return nil
+}
+
+func (f *fakeBackend) Get(ctx context.Context, in *proto.GetRequest, opts ...grpc.CallOption) (*proto.GetReply, error) {
+	f.mutex.Lock()
+	onGet := f.onGet
+	f.mutex.Unlock()
+	if onGet != nil {
+		onGet()
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.gets++
+	if f.getErr != nil {
+		return nil, f.getErr
+	}
+	if raw, found := f.blocks[in.Addr]; found {
+		return &proto.GetReply{Found: true, Block: raw}, nil
+	}
+	if f.reply != nil {
+		return f.reply, nil
+	}
+	return &proto.GetReply{Found: false}, nil
+}
+
+func (f *fakeBackend) Put(ctx context.Context, in *proto.PutRequest, opts ...grpc.CallOption) (*proto.PutReply, error) {
+	f.mutex.Lock()
+	f.puts++
+	err, onPut := f.putErr, f.onPut
+	if f.keep && err == nil {
+		if f.blocks == nil {
+			f.blocks = make(map[string][]byte)
+		}
+		f.blocks[in.Addr] = in.Block
+	}
+	f.mutex.Unlock()
+	if onPut != nil {
+		onPut()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &proto.PutReply{Addr: in.Addr, Inserted: true}, nil
+}
+
+func (f *fakeBackend) Remove(ctx context.Context, in *proto.RemoveRequest, opts ...grpc.CallOption) (*proto.RemoveReply, error) {
+	return &proto.RemoveReply{}, nil
+}
+
+func (f *fakeBackend) Stat(ctx context.Context, in *proto.StatRequest, opts ...grpc.CallOption) (*proto.StatReply, error) {
+	f.mutex.Lock()
+	f.stats++
+	err, onStat := f.statErr, f.onStat
+	f.mutex.Unlock()
+	if onStat != nil {
+		onStat()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &proto.StatReply{}, nil
+}
+
+func (f *fakeBackend) Walk(ctx context.Context, in *proto.WalkRequest, opts ...grpc.CallOption) (proto.CAS_WalkClient, error) {
+	return nil, grpc.Errorf(codes.Unimplemented, "not implemented")
+}
+
+func (f *fakeBackend) CacheStats(ctx context.Context, in *proto.CacheStatsRequest, opts ...grpc.CallOption) (*proto.CacheStatsReply, error) {
+	return nil, grpc.Errorf(codes.Unimplemented, "not implemented")
+}
+
+var _ client.Client = (*fakeBackend)(nil)
+
+// newTestPool returns a backendPool over the given fakes, named "a", "b",
+// ... in priority order.  A nil fake can't be dialed; the test can make it
+// dialable by adding it to the returned map.  The pool's probe loop is
+// stopped after its first probe, so that the test can call probe itself.
+func newTestPool(retryCorrupt bool, fakes ...*fakeBackend) (*backendPool, map[string]*fakeBackend) {
+	bySpec := make(map[string]*fakeBackend)
+	specs := make([]string, len(fakes))
+	for i, f := range fakes {
+		specs[i] = string('a' + rune(i))
+		bySpec[specs[i]] = f
+	}
+	dial := func(spec string) (client.Client, error) {
+		if f := bySpec[spec]; f != nil {
+			return f, nil
+		}
+		return nil, errors.New("connection refused")
+	}
+	p := newBackendPool(specs, dial, time.Hour, retryCorrupt)
+	close(p.closech)
+	<-p.donech
+	p.closech = make(chan struct{})
+	return p, bySpec
+}
+
+func unavailable() error {
+	return grpc.Errorf(codes.Unavailable, "connection refused")
+}
+
+func TestBackendPool_readFailover(t *testing.T) {
+	a, b := &fakeBackend{}, &fakeBackend{}
+	p, _ := newTestPool(false, a, b)
+	defer p.Close()
+	ctx := context.Background()
+
+	a.set(func() { a.getErr = unavailable() })
+	if _, err := p.Get(ctx, &proto.GetRequest{}); err != nil {
+		t.Errorf("Get: expected failover to b, got %v", err)
+	}
+	if gets, _, _ := b.counts(); gets != 1 {
+		t.Errorf("expected 1 get from b, got %d", gets)
+	}
+	if active := p.Active(); active != "b" {
+		t.Errorf("expected b to be active, got %q", active)
+	}
+
+	// Errors that the backend answers with are not failed over.
+	b.set(func() { b.getErr = grpc.Errorf(codes.PermissionDenied, "access denied") })
+	if _, err := p.Get(ctx, &proto.GetRequest{}); grpc.Code(err) != codes.PermissionDenied {
+		t.Errorf("Get: expected PermissionDenied, got %v", err)
+	}
+	if active := p.Active(); active != "b" {
+		t.Errorf("expected b to stay active, got %q", active)
+	}
+
+	// With no healthy backend, every connected one is tried.
+	b.set(func() { b.getErr = unavailable() })
+	if _, err := p.Get(ctx, &proto.GetRequest{}); grpc.Code(err) != codes.Unavailable {
+		t.Errorf("Get: expected Unavailable, got %v", err)
+	}
+	a.set(func() { a.getErr = nil })
+	if _, err := p.Get(ctx, &proto.GetRequest{}); err != nil {
+		t.Errorf("Get: expected a to answer, got %v", err)
+	}
+}
+
+func TestBackendPool_writesNotRetried(t *testing.T) {
+	a, b := &fakeBackend{}, &fakeBackend{}
+	p, _ := newTestPool(false, a, b)
+	defer p.Close()
+	ctx := context.Background()
+
+	a.set(func() { a.putErr = unavailable() })
+	if _, err := p.Put(ctx, &proto.PutRequest{}); grpc.Code(err) != codes.Unavailable {
+		t.Errorf("Put: expected Unavailable, got %v", err)
+	}
+	if _, puts, _ := b.counts(); puts != 0 {
+		t.Errorf("expected the failed Put not to be retried on b, got %d puts", puts)
+	}
+	if _, err := p.Put(ctx, &proto.PutRequest{}); err != nil {
+		t.Errorf("Put: expected the next write to go to b, got %v", err)
+	}
+	if _, puts, _ := b.counts(); puts != 1 {
+		t.Errorf("expected 1 put to b, got %d", puts)
+	}
+}
+
+func TestBackendPool_probeRecovery(t *testing.T) {
+	a, b := &fakeBackend{}, &fakeBackend{}
+	p, _ := newTestPool(false, a, b)
+	defer p.Close()
+	ctx := context.Background()
+
+	a.set(func() { a.getErr = unavailable() })
+	p.Get(ctx, &proto.GetRequest{})
+	if active := p.Active(); active != "b" {
+		t.Fatalf("expected b to be active, got %q", active)
+	}
+	a.set(func() { a.getErr = nil })
+	p.probe()
+	if active := p.Active(); active != "a" {
+		t.Errorf("expected a to be restored by a probe, got %q", active)
+	}
+
+	// After redialAfter failed probes, the connection is torn down.
+	a.set(func() { a.statErr = unavailable() })
+	for i := 0; i < redialAfter; i++ {
+		p.probe()
+	}
+	a.mutex.Lock()
+	closed := a.closed
+	a.mutex.Unlock()
+	if !closed {
+		t.Errorf("expected a's connection to be closed")
+	}
+	p.mutex.Lock()
+	cc := p.backends[0].cc
+	p.mutex.Unlock()
+	if cc != nil {
+		t.Errorf("expected a to be disconnected")
+	}
+}
+
+func TestBackendPool_redialBackoff(t *testing.T) {
+	b := &fakeBackend{}
+	p, bySpec := newTestPool(false, nil, b)
+	defer p.Close()
+	ca := p.backends[0]
+
+	p.mutex.Lock()
+	delay := ca.dialDelay
+	p.mutex.Unlock()
+	if delay != 2*minRedialDelay {
+		t.Errorf("after a failed dial: expected the delay to double to %v, got %v", 2*minRedialDelay, delay)
+	}
+
+	// Not yet due: no dial.
+	p.probe()
+	p.mutex.Lock()
+	if ca.cc != nil || ca.dialDelay != delay {
+		t.Errorf("expected no redial before the backoff expires")
+	}
+	ca.nextDial = time.Now().Add(-time.Second)
+	p.mutex.Unlock()
+
+	// Due, but still failing: the delay doubles again.
+	p.probe()
+	p.mutex.Lock()
+	if ca.dialDelay != 4*minRedialDelay {
+		t.Errorf("after a second failed dial: expected %v, got %v", 4*minRedialDelay, ca.dialDelay)
+	}
+	ca.nextDial = time.Now().Add(-time.Second)
+	p.mutex.Unlock()
+
+	// Due, and now reachable: connected, but not trusted until probed.
+	a := &fakeBackend{}
+	bySpec["a"] = a
+	p.probe()
+	p.mutex.Lock()
+	if ca.cc != a || ca.healthy {
+		t.Errorf("after a redial: expected a connected but unhealthy backend")
+	}
+	p.mutex.Unlock()
+	if active := p.Active(); active != "b" {
+		t.Errorf("expected b to stay active until a passes a probe, got %q", active)
+	}
+	p.probe()
+	if active := p.Active(); active != "a" {
+		t.Errorf("expected a to be active after a probe, got %q", active)
+	}
+	p.mutex.Lock()
+	if ca.dialDelay != minRedialDelay {
+		t.Errorf("after a good probe: expected the delay to reset to %v, got %v", minRedialDelay, ca.dialDelay)
+	}
+	p.mutex.Unlock()
+}
+
+func TestBackendPool_staleProbe(t *testing.T) {
+	old, replacement := &fakeBackend{}, &fakeBackend{}
+	p, _ := newTestPool(false, old)
+	defer p.Close()
+	ca := p.backends[0]
+
+	// The connection is replaced while the probe of the old one is in
+	// flight, and the old one fails.  The failure must not count against
+	// the new connection.
+	old.set(func() {
+		old.statErr = unavailable()
+		old.onStat = func() {
+			p.mutex.Lock()
+			ca.cc = replacement
+			p.mutex.Unlock()
+		}
+	})
+	p.probe()
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if ca.cc != replacement || !ca.healthy || ca.failures != 0 {
+		t.Errorf("expected the stale probe to be ignored, got cc=%p healthy=%v failures=%d", ca.cc, ca.healthy, ca.failures)
+	}
+}
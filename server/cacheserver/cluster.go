@@ -0,0 +1,161 @@
+package cacheserver
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"sort"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/cloud9-tools/go-cas/client"
+	"github.com/cloud9-tools/go-cas/common"
+	"github.com/cloud9-tools/go-cas/proto"
+	"github.com/cloud9-tools/go-cas/server/auth"
+	"github.com/cloud9-tools/go-multierror"
+)
+
+// peerMetadataKey marks an RPC as coming from another member of the cluster.
+// Such RPCs are never forwarded again, so a request crosses at most one hop.
+// Any client can set it, so it is only believed from callers that have
+// authenticated as one of the peer roles; see Trust.
+const peerMetadataKey = "cas-peer"
+
+// trustedPeerKey is the context key under which Trust records that the
+// caller is a peer.
+type trustedPeerKey struct{}
+
+// ringReplicas is the number of points each peer gets on the hash ring.
+const ringReplicas = 64
+
+// cluster is a static group of cascached peers that share the work of
+// caching.  Every address has one owner, chosen by consistent hashing; on a
+// local miss, a peer asks the owner before going to the backend, so that each
+// block is cached in one place no matter which peer the client talks to.
+type cluster struct {
+	self         string
+	ring         []ringPoint
+	peers        map[string]client.Client
+	roles        map[auth.Role]bool
+	hotThreshold uint32
+}
+
+type ringPoint struct {
+	hash uint32
+	peer string
+}
+
+type ringPoints []ringPoint
+
+func (x ringPoints) Len() int           { return len(x) }
+func (x ringPoints) Less(i, j int) bool { return x[i].hash < x[j].hash }
+func (x ringPoints) Swap(i, j int)      { x[i], x[j] = x[j], x[i] }
+
+func newCluster(self string, specs []string, roles []auth.Role, hotThreshold uint, dial func(string) (client.Client, error)) (*cluster, error) {
+	c := &cluster{
+		self:         self,
+		peers:        make(map[string]client.Client, len(specs)),
+		roles:        make(map[auth.Role]bool, len(roles)),
+		hotThreshold: uint32(hotThreshold),
+	}
+	for _, role := range roles {
+		c.roles[role] = true
+	}
+	for _, spec := range specs {
+		for i := 0; i < ringReplicas; i++ {
+			sum := sha1.Sum([]byte(fmt.Sprintf("%s#%d", spec, i)))
+			c.ring = append(c.ring, ringPoint{binary.BigEndian.Uint32(sum[:4]), spec})
+		}
+		if spec == self {
+			continue
+		}
+		cc, err := dial(spec)
+		if err != nil {
+			c.Close()
+			return nil, fmt.Errorf("%q: %v", spec, err)
+		}
+		c.peers[spec] = cc
+	}
+	sort.Sort(ringPoints(c.ring))
+	return c, nil
+}
+
+func (c *cluster) Close() error {
+	if c == nil {
+		return nil
+	}
+	var errs []error
+	for _, cc := range c.peers {
+		errs = append(errs, cc.Close())
+	}
+	return multierror.New(errs)
+}
+
+// Owner returns the dial spec of the peer that owns addr.
+func (c *cluster) Owner(addr common.Addr) string {
+	// Bytes 0-3 pick the shard; use different ones for the ring.
+	h := binary.BigEndian.Uint32(addr[8:12])
+	i := sort.Search(len(c.ring), func(i int) bool { return c.ring[i].hash >= h })
+	if i == len(c.ring) {
+		i = 0
+	}
+	return c.ring[i].peer
+}
+
+// OwnerClient returns a client for the peer that owns addr, or nil if the
+// request should be handled here: because there is no cluster, because this
+// peer is the owner, or because the request was already forwarded once.
+func (c *cluster) OwnerClient(ctx context.Context, addr common.Addr) client.Client {
+	if c == nil || fromPeer(ctx) {
+		return nil
+	}
+	return c.peers[c.Owner(addr)]
+}
+
+// IsHot returns true iff a block fetched from its owner this many times
+// should also be cached here.
+func (c *cluster) IsHot(remoteHits uint32) bool {
+	return c.hotThreshold > 0 && remoteHits >= c.hotThreshold
+}
+
+// Invalidate tells every other peer to forget addr.  Errors are logged, not
+// returned: a peer that misses the message can only serve a stale copy of a
+// removed block, which is no worse than a Remove racing a Get.
+func (c *cluster) Invalidate(ctx context.Context, addr common.Addr) {
+	if c == nil || fromPeer(ctx) {
+		return
+	}
+	ctx = c.peerContext(ctx)
+	for spec, cc := range c.peers {
+		_, err := cc.Remove(ctx, &proto.RemoveRequest{Addr: addr.String()})
+		if err != nil {
+			log.Printf("warn: failed to invalidate %v on peer %q: %v", addr, spec, err)
+		}
+	}
+}
+
+func (c *cluster) peerContext(ctx context.Context) context.Context {
+	return metadata.NewContext(ctx, metadata.Pairs(peerMetadataKey, c.self))
+}
+
+// Trust returns ctx marked as coming from a peer, if the caller both sent
+// the peer marker and authenticated as one of the peer roles.  Otherwise the
+// marker is ignored, and ctx is returned unchanged.
+func (c *cluster) Trust(ctx context.Context, id auth.Identity) context.Context {
+	if c == nil || !c.roles[id.Role] {
+		return ctx
+	}
+	md, ok := metadata.FromContext(ctx)
+	if !ok || len(md[peerMetadataKey]) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, trustedPeerKey{}, true)
+}
+
+// fromPeer returns true iff Trust has marked ctx as coming from a peer.
+func fromPeer(ctx context.Context) bool {
+	trusted, _ := ctx.Value(trustedPeerKey{}).(bool)
+	return trusted
+}
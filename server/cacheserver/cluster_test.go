@@ -0,0 +1,133 @@
+package cacheserver
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/cloud9-tools/go-cas/client"
+	"github.com/cloud9-tools/go-cas/proto"
+	"github.com/cloud9-tools/go-cas/server/auth"
+)
+
+// peerClient is a client.Client that calls another Server directly.
+type peerClient struct {
+	srv *Server
+}
+
+func (p peerClient) Close() error { return nil }
+
+func (p peerClient) Get(ctx context.Context, in *proto.GetRequest, opts ...grpc.CallOption) (*proto.GetReply, error) {
+	return p.srv.Get(ctx, in)
+}
+
+func (p peerClient) Put(ctx context.Context, in *proto.PutRequest, opts ...grpc.CallOption) (*proto.PutReply, error) {
+	return p.srv.Put(ctx, in)
+}
+
+func (p peerClient) Remove(ctx context.Context, in *proto.RemoveRequest, opts ...grpc.CallOption) (*proto.RemoveReply, error) {
+	return p.srv.Remove(ctx, in)
+}
+
+func (p peerClient) Stat(ctx context.Context, in *proto.StatRequest, opts ...grpc.CallOption) (*proto.StatReply, error) {
+	return nil, grpc.Errorf(codes.Unimplemented, "not implemented")
+}
+
+func (p peerClient) Walk(ctx context.Context, in *proto.WalkRequest, opts ...grpc.CallOption) (proto.CAS_WalkClient, error) {
+	return nil, grpc.Errorf(codes.Unimplemented, "not implemented")
+}
+
+func (p peerClient) CacheStats(ctx context.Context, in *proto.CacheStatsRequest, opts ...grpc.CallOption) (*proto.CacheStatsReply, error) {
+	return nil, grpc.Errorf(codes.Unimplemented, "not implemented")
+}
+
+var _ client.Client = peerClient{}
+
+func TestCluster_Trust(t *testing.T) {
+	dial := func(string) (client.Client, error) { return nil, nil }
+	c, err := newCluster("tcp:a:1", []string{"tcp:a:1"}, []auth.Role{"cascached"}, 0, dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	marked := metadata.NewContext(context.Background(), metadata.Pairs(peerMetadataKey, "tcp:b:1"))
+
+	type testrow struct {
+		Ctx  context.Context
+		Role auth.Role
+		Peer bool
+	}
+	for i, row := range []testrow{
+		testrow{marked, "cascached", true},
+		testrow{marked, "alice", false},
+		testrow{marked, auth.Anonymous, false},
+		testrow{context.Background(), "cascached", false},
+	} {
+		id := auth.Identity{Auther: auth.AnonymousAuther(), Role: row.Role}
+		if peer := fromPeer(c.Trust(row.Ctx, id)); peer != row.Peer {
+			t.Errorf("[%d] expected peer=%v, got %v", i, row.Peer, peer)
+		}
+	}
+	if fromPeer(marked) {
+		t.Errorf("the marker alone should not make a caller a peer")
+	}
+	if ctx := (*cluster)(nil).Trust(marked, auth.Identity{Role: "cascached"}); fromPeer(ctx) {
+		t.Errorf("without a cluster, nobody is a peer")
+	}
+}
+
+func TestCluster_putThenGet(t *testing.T) {
+	backend := &fakeBackend{keep: true}
+	pa, _ := newTestPool(false, backend)
+	defer pa.Close()
+	pb, _ := newTestPool(false, backend)
+	defer pb.Close()
+	a, b := newTestServer(pa), newTestServer(pb)
+	specs := []string{"tcp:a:1", "tcp:b:1"}
+	byName := map[string]*Server{"tcp:a:1": a, "tcp:b:1": b}
+	dial := func(spec string) (client.Client, error) { return peerClient{byName[spec]}, nil }
+	var err error
+	if a.cluster, err = newCluster("tcp:a:1", specs, nil, 0, dial); err != nil {
+		t.Fatal(err)
+	}
+	if b.cluster, err = newCluster("tcp:b:1", specs, nil, 0, dial); err != nil {
+		t.Fatal(err)
+	}
+
+	// Pick a block that b owns.
+	var e *entry
+	for i := 0; e == nil; i++ {
+		if x := testEntry(i, proto.CacheHints_NORMAL); a.cluster.Owner(x.addr) == "tcp:b:1" {
+			e = x
+		}
+	}
+	ctx := context.Background()
+	get := &proto.GetRequest{Addr: e.addr.String()}
+
+	// A miss through a leaves b with a negative cache entry.
+	if out, err := a.Get(ctx, get); err != nil || out.Found {
+		t.Fatalf("before Put: expected a miss, got %v", err)
+	}
+	if s := b.shardFor(e.addr); len(s.missing) != 1 {
+		t.Fatalf("expected the owner to remember the miss, got %d misses", len(s.missing))
+	}
+
+	// A Put through a, the non-owner, that a doesn't cache.
+	put := &proto.PutRequest{
+		Addr:  e.addr.String(),
+		Block: e.block.Trim(),
+		Hints: &proto.CacheHints{NoAdmit: true},
+	}
+	if _, err := a.Put(ctx, put); err != nil {
+		t.Fatal(err)
+	}
+	out, err := a.Get(ctx, get)
+	if err != nil || !out.Found {
+		t.Fatalf("after Put: expected a hit despite the owner's stale miss, got %v", err)
+	}
+	if string(out.Block) != string(e.block[:]) {
+		t.Errorf("after Put: got the wrong block")
+	}
+}
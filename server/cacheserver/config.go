@@ -4,45 +4,175 @@ import (
 	"flag"
 	"fmt"
 	"net"
+	"strings"
+	"time"
 
 	"github.com/cloud9-tools/go-cas/client"
 	"github.com/cloud9-tools/go-cas/common"
+	"github.com/cloud9-tools/go-cas/server/audit"
 	"github.com/cloud9-tools/go-cas/server/auth"
+	"github.com/cloud9-tools/go-cas/server/eviction"
+	"github.com/cloud9-tools/go-cas/server/quota"
 )
 
 type Config struct {
-	Bind      string
-	Connect   string
-	Limit     uint
-	NumShards uint
-	ACL       auth.ACL
+	Bind          string
+	Connect       string
+	ProbeInterval time.Duration
+	RetryCorrupt  bool
+	Limit         uint
+	NumShards     uint
+	Policy        string
+	Trace         string
+	L2Dir         string
+	L2Limit       uint
+	ACL           auth.ACL
+
+	WriteBackDir string
+
+	Peers        string
+	Self         string
+	PeerRoles    string
+	HotThreshold uint
+
+	Prefetch            string
+	PrefetchConcurrency uint
+	PrefetchBudget      uint
+
+	Snapshot          string
+	SnapshotInterval  time.Duration
+	SnapshotBlocks    bool
+	WarmBeforeServing bool
+	WarmConcurrency   uint
+
+	MaxPin time.Duration
+
+	TLS          common.TLSConfig
+	TLSIdentity  string
+	TokenKeyFile string
+	PeerCred     bool
+	PolicyFile   string
+	Audit        audit.Config
+	Limits       quota.Table
+
+	tokenKey []byte
+	policy   *auth.PolicyFile
+
+	NegativeTTL  time.Duration
+	PresenceSync time.Duration
 }
 
 func (cfg *Config) AddFlags(fs *flag.FlagSet) {
 	const l = 0
 	const n = 16
+	const p = "lru"
+	const nttl = 10 * time.Second
+	const pc = 4
+	const pb = 64
+	const si = 5 * time.Minute
+	const wc = 16
+	const pi = 5 * time.Second
+	const mp = 1 * time.Hour
 
 	if cfg.ACL == nil {
 		cfg.ACL = auth.AllowAll()
 	}
 
 	fs.Var(&cfg.ACL, "acl",
-		"access control list to apply to CAS RPCs")
+		"access control list to apply to CAS RPCs: comma-separated rules of"+
+			" the form role=allow|deny or role:op=allow|deny, where op is one"+
+			" of statfs, walk, get, put, remove; the first matching rule wins")
 	fs.StringVar(&cfg.Bind, "bind", "",
 		"address to listen on")
 	fs.StringVar(&cfg.Connect, "connect", "",
-		"CAS backend to connect to for cache misses")
+		"CAS backend to connect to for cache misses; may be a comma-separated"+
+			" list of backends in order of preference")
+	fs.DurationVar(&cfg.ProbeInterval, "probe_interval", pi,
+		"how often to check the health of each --connect backend")
+	fs.BoolVar(&cfg.RetryCorrupt, "retry_corrupt", true,
+		"if a --connect backend returns a block that doesn't match its address,"+
+			" try the next backend before giving up")
 	fs.UintVar(&cfg.Limit, "limit", l,
 		"maximum number of "+common.BlockSizeHuman+
 			" blocks to cache in RAM")
 	fs.UintVar(&cfg.NumShards, "num_shards", n,
 		"shard data N ways for parallelism")
+	fs.StringVar(&cfg.Policy, "policy", p,
+		"cache eviction policy; one of: "+strings.Join(eviction.Names(), ", "))
+	fs.StringVar(&cfg.Trace, "trace", "",
+		"append the address of every Get to this file, for use with cassim")
+	fs.StringVar(&cfg.L2Dir, "l2_dir", "",
+		"directory on local disk for a second-tier cache of blocks evicted from RAM")
+	fs.UintVar(&cfg.L2Limit, "l2_limit", 0,
+		"maximum number of "+common.BlockSizeHuman+
+			" blocks to cache in --l2_dir")
+	fs.StringVar(&cfg.WriteBackDir, "write_back_dir", "",
+		"acknowledge Puts once they are journaled in this directory,"+
+			" and forward them to the backend asynchronously;"+
+			" blocks the backend rejects are moved to its \"dead\" subdirectory")
+	fs.StringVar(&cfg.Peers, "peers", "",
+		"comma-separated list of all cascached peers in the cluster, including this one")
+	fs.StringVar(&cfg.Self, "self", "",
+		"the entry in --peers that refers to this cascached")
+	fs.StringVar(&cfg.PeerRoles, "peer_roles", "",
+		"comma-separated roles that the other --peers authenticate as, by TLS"+
+			" client certificate or token; only callers with one of these roles"+
+			" are treated as peers")
+	fs.UintVar(&cfg.HotThreshold, "hot_threshold", 0,
+		"also cache a block owned by another peer after fetching it from"+
+			" that peer this many times; 0 to disable")
+	fs.StringVar(&cfg.Prefetch, "prefetch", "",
+		"prefetch the blocks referred to by blocks in this format; one of: "+
+			strings.Join(common.RefDecoderNames(), ", "))
+	fs.UintVar(&cfg.PrefetchConcurrency, "prefetch_concurrency", pc,
+		"maximum number of prefetches in flight")
+	fs.UintVar(&cfg.PrefetchBudget, "prefetch_budget", pb,
+		"maximum number of references to prefetch from any one block")
+	fs.StringVar(&cfg.Snapshot, "snapshot", "",
+		"periodically save the addresses of the hottest cached blocks to this file,"+
+			" and warm the cache from it on start")
+	fs.DurationVar(&cfg.SnapshotInterval, "snapshot_interval", si,
+		"how often to save --snapshot; 0 to save only on exit")
+	fs.BoolVar(&cfg.SnapshotBlocks, "snapshot_blocks", false,
+		"save block data in --snapshot too, so that warming up doesn't hit the backend")
+	fs.BoolVar(&cfg.WarmBeforeServing, "warm_before_serving", false,
+		"finish warming the cache from --snapshot before accepting RPCs")
+	fs.UintVar(&cfg.WarmConcurrency, "warm_concurrency", wc,
+		"maximum number of backend fetches in flight while warming the cache")
+	fs.DurationVar(&cfg.MaxPin, "max_pin", mp,
+		"longest time that a client may pin a block in the cache; 0 to ignore pins")
+	cfg.TLS.AddFlags(fs)
+	fs.StringVar(&cfg.TLSIdentity, "tls_identity", "cn",
+		"part of a client's TLS certificate that names its role in --acl; one of: "+
+			strings.Join(auth.CertFields, ", "))
+	fs.StringVar(&cfg.TokenKeyFile, "token_key", "",
+		"file containing the secret key that bearer tokens are signed with;"+
+			" if set, clients may identify themselves with tokens minted by"+
+			" \"casutil mint-token\", and may read individual object trees with"+
+			" capabilities minted by \"casutil mint-capability\"")
+	fs.StringVar(&cfg.PolicyFile, "policy_file", "",
+		"file of groups and ACL rules to use in place of --acl;"+
+			" reloaded on SIGHUP")
+	cfg.Audit.AddFlags(fs)
+	fs.Var(&cfg.Limits, "limits", "per-role limits: comma-separated role:kind=N,"+
+		" where kind is requests (per second), bytes (per second), or"+
+		" blocks (pinned blocks owned); *:kind=N applies to each"+
+		" role not listed")
+	fs.BoolVar(&cfg.PeerCred, "peercred", false,
+		"identify clients on a unix: --bind socket by the user they run as;"+
+			" --acl may then name Unix groups as @group")
+	fs.DurationVar(&cfg.NegativeTTL, "negative_ttl", nttl,
+		"remember that a block was not found for this long; 0 to disable")
+	fs.DurationVar(&cfg.PresenceSync, "presence_sync", 0,
+		"rebuild a Bloom filter of the backend's blocks at this interval,"+
+			" and answer Gets for blocks not in it locally; 0 to disable")
 
 	fs.Var(&cfg.ACL, "A", "alias for --acl")
 	fs.StringVar(&cfg.Bind, "B", "", "alias for --bind")
 	fs.StringVar(&cfg.Connect, "C", "", "alias for --connect")
 	fs.UintVar(&cfg.Limit, "l", l, "alias for --limit")
 	fs.UintVar(&cfg.NumShards, "n", n, "alias for --num_shards")
+	fs.StringVar(&cfg.Policy, "p", p, "alias for --policy")
 }
 
 func (cfg *Config) Validate() error {
@@ -58,21 +188,155 @@ func (cfg *Config) Validate() error {
 	if _, _, err := common.ParseDialSpec(cfg.Bind); err != nil {
 		return fmt.Errorf("invalid flag --bind=%q: %v", cfg.Bind, err)
 	}
-	if _, _, err := common.ParseDialSpec(cfg.Connect); err != nil {
-		return fmt.Errorf("invalid flag --connect=%q: %v", cfg.Connect, err)
+	for _, backend := range strings.Split(cfg.Connect, ",") {
+		if _, _, err := common.ParseDialSpec(backend); err != nil {
+			return fmt.Errorf("invalid flag --connect=%q: %v", cfg.Connect, err)
+		}
 	}
-	if n := cfg.NumShards; n > 0 && (n&(n-1)) != 0 {
+	if cfg.ProbeInterval <= 0 {
+		return fmt.Errorf("invalid flag --probe_interval=%v: must be positive", cfg.ProbeInterval)
+	}
+	if n := cfg.NumShards; n == 0 || (n&(n-1)) != 0 {
 		return fmt.Errorf("invalid flag --num_shards=%d: must be a power of 2", cfg.NumShards)
 	}
-	if n := cfg.Limit / cfg.NumShards; n*cfg.NumShards == cfg.Limit {
+	if n := cfg.Limit / cfg.NumShards; n*cfg.NumShards != cfg.Limit {
 		return fmt.Errorf("invalid flag --limit=%d: must be a multiple of --num_shards", cfg.Limit)
 	}
 	if n := cfg.Limit / cfg.NumShards; n != uint(uint32(n)) {
 		return fmt.Errorf("invalid flag --limit=%d: per-shard limit must fit in 32 bits", cfg.Limit)
 	}
+	if err := eviction.Valid(cfg.Policy); err != nil {
+		return fmt.Errorf("invalid flag --policy=%q: %v", cfg.Policy, err)
+	}
+	if cfg.L2Dir != "" && cfg.L2Limit == 0 {
+		return fmt.Errorf("missing required flag: --l2_limit")
+	}
+	if cfg.L2Dir == "" && cfg.L2Limit != 0 {
+		return fmt.Errorf("missing required flag: --l2_dir")
+	}
+	if n := cfg.L2Limit; n != uint(uint32(n)) {
+		return fmt.Errorf("invalid flag --l2_limit=%d: must fit in 32 bits", cfg.L2Limit)
+	}
+	if cfg.Peers != "" {
+		if cfg.Self == "" {
+			return fmt.Errorf("missing required flag: --self")
+		}
+		if cfg.PeerRoles == "" {
+			return fmt.Errorf("missing required flag: --peer_roles")
+		}
+		foundSelf := false
+		for _, peer := range strings.Split(cfg.Peers, ",") {
+			if _, _, err := common.ParseDialSpec(peer); err != nil {
+				return fmt.Errorf("invalid flag --peers=%q: %v", cfg.Peers, err)
+			}
+			if peer == cfg.Self {
+				foundSelf = true
+			}
+		}
+		if !foundSelf {
+			return fmt.Errorf("invalid flag --self=%q: not in --peers", cfg.Self)
+		}
+	}
+	if cfg.Prefetch != "" {
+		if _, err := common.RefDecoderByName(cfg.Prefetch); err != nil {
+			return fmt.Errorf("invalid flag --prefetch=%q: %v", cfg.Prefetch, err)
+		}
+		if cfg.PrefetchConcurrency == 0 {
+			return fmt.Errorf("invalid flag --prefetch_concurrency=0: must be at least 1")
+		}
+		if cfg.PrefetchBudget == 0 {
+			return fmt.Errorf("invalid flag --prefetch_budget=0: must be at least 1")
+		}
+	}
+	if cfg.Snapshot == "" && cfg.SnapshotBlocks {
+		return fmt.Errorf("missing required flag: --snapshot")
+	}
+	if cfg.Snapshot == "" && cfg.WarmBeforeServing {
+		return fmt.Errorf("missing required flag: --snapshot")
+	}
+	if cfg.Snapshot != "" {
+		if cfg.SnapshotInterval < 0 {
+			return fmt.Errorf("invalid flag --snapshot_interval=%v: must not be negative", cfg.SnapshotInterval)
+		}
+		if cfg.WarmConcurrency == 0 {
+			return fmt.Errorf("invalid flag --warm_concurrency=0: must be at least 1")
+		}
+	}
+	if err := cfg.TLS.ValidateServer(); err != nil {
+		return err
+	}
+	if err := auth.ValidCertField(cfg.TLSIdentity); err != nil {
+		return fmt.Errorf("invalid flag --tls_identity=%q: %v", cfg.TLSIdentity, err)
+	}
+	if cfg.TokenKeyFile != "" {
+		key, err := auth.LoadTokenKey(cfg.TokenKeyFile)
+		if err != nil {
+			return fmt.Errorf("invalid flag --token_key=%q: %v", cfg.TokenKeyFile, err)
+		}
+		cfg.tokenKey = key
+	}
+	if err := cfg.Audit.Validate(); err != nil {
+		return err
+	}
+	if cfg.PolicyFile != "" {
+		policy, err := auth.LoadPolicyFile(cfg.PolicyFile)
+		if err != nil {
+			return fmt.Errorf("invalid flag --policy_file=%q: %v", cfg.PolicyFile, err)
+		}
+		cfg.policy = policy
+	}
+	if cfg.PeerCred {
+		if network, _, _ := common.ParseDialSpec(cfg.Bind); network != "unix" {
+			return fmt.Errorf("invalid flag --peercred: --bind=%q is not a unix: socket", cfg.Bind)
+		}
+	}
+	if cfg.MaxPin < 0 {
+		return fmt.Errorf("invalid flag --max_pin=%v: must not be negative", cfg.MaxPin)
+	}
+	if cfg.NegativeTTL < 0 {
+		return fmt.Errorf("invalid flag --negative_ttl=%v: must not be negative", cfg.NegativeTTL)
+	}
+	if cfg.PresenceSync < 0 {
+		return fmt.Errorf("invalid flag --presence_sync=%v: must not be negative", cfg.PresenceSync)
+	}
 	return nil
 }
 
+// Auther identifies callers as the flags direct; see auth.NewAuther.
+// Validate must have been called first.
+func (cfg *Config) Auther() auth.Auther {
+	var certField string
+	if cfg.TLS.CAFile != "" {
+		certField = cfg.TLSIdentity
+	}
+	return auth.NewAuther(auth.AutherConfig{
+		CertField: certField,
+		TokenKey:  cfg.tokenKey,
+		PeerCred:  cfg.PeerCred,
+		Policy:    cfg.policy,
+	})
+}
+
+// peerRoles returns the roles listed in --peer_roles.
+func (cfg *Config) peerRoles() []auth.Role {
+	var roles []auth.Role
+	for _, role := range strings.Split(cfg.PeerRoles, ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			roles = append(roles, auth.Role(role))
+		}
+	}
+	return roles
+}
+
+// Dial connects to a backend or peer, over TLS if the flags ask for it.
+func (cfg *Config) Dial(spec string) (client.Client, error) {
+	opts, err := cfg.TLS.DialOptions(spec)
+	if err != nil {
+		return nil, err
+	}
+	return client.DialClient(spec, opts...)
+}
+
 func (cfg *Config) Listen() (net.Listener, error) {
 	network, address, err := common.ParseDialSpec(cfg.Bind)
 	if err != nil {
@@ -82,9 +346,8 @@ func (cfg *Config) Listen() (net.Listener, error) {
 	if err != nil {
 		return nil, fmt.Errorf("%q, %q: %v", network, address, err)
 	}
+	if cfg.PeerCred {
+		listen = auth.PeerCredListener(listen)
+	}
 	return listen, nil
 }
-
-func (cfg *Config) Dial() (client.Client, error) {
-	return client.DialClient(cfg.Connect)
-}
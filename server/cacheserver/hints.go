@@ -0,0 +1,99 @@
+package cacheserver
+
+import (
+	"log"
+	"time"
+
+	"github.com/cloud9-tools/go-cas/common"
+	"github.com/cloud9-tools/go-cas/proto"
+	"github.com/cloud9-tools/go-cas/server/auth"
+)
+
+// cacheHints is the server's reading of a client's proto.CacheHints.
+type cacheHints struct {
+	bypass   bool
+	noAdmit  bool
+	pin      time.Duration
+	priority proto.CacheHints_Priority
+
+	// role is charged for the blocks that the hints pin.
+	role auth.Role
+}
+
+// parseHints interprets in, which may be nil, on behalf of role.  Pins are
+// capped at srv.maxPin, so that clients can't fill the cache with pins
+// forever.
+func (srv *Server) parseHints(in *proto.CacheHints, role auth.Role) cacheHints {
+	if in == nil {
+		return cacheHints{}
+	}
+	h := cacheHints{
+		bypass:   in.Bypass,
+		noAdmit:  in.NoAdmit,
+		priority: in.Priority,
+		role:     role,
+	}
+	if in.PinSeconds > 0 {
+		h.pin = srv.maxPin
+		if in.PinSeconds < int64(srv.maxPin/time.Second) {
+			h.pin = time.Duration(in.PinSeconds) * time.Second
+		}
+	}
+	return h
+}
+
+// caches returns true iff the hints allow new blocks to be cached.
+func (h cacheHints) caches() bool {
+	return !h.bypass && !h.noAdmit
+}
+
+// checkPin returns an error if the hints ask for a pin on addr that would
+// exceed the role's quota.  RPCs call it before doing anything else, so that
+// a request that can't be pinned fails without side effects.
+func (h cacheHints) checkPin(s *shard, addr common.Addr) error {
+	if h.pin <= 0 || !h.caches() {
+		return nil
+	}
+	return s.pins.Check(addr, h.role)
+}
+
+// hit applies the hints to e, which was already cached.  s.mutex must be
+// held.
+func (h cacheHints) hit(s *shard, e *entry, now time.Time) {
+	if !h.caches() {
+		return
+	}
+	if h.priority == proto.CacheHints_HIGH {
+		e.priority = h.priority
+	}
+	h.applyPin(s, e, now)
+}
+
+// admit caches e as far as the hints allow, and returns the entries that
+// were evicted to make room.  s.mutex must be held.
+func (h cacheHints) admit(s *shard, e *entry, now time.Time) []*entry {
+	if !h.caches() {
+		return nil
+	}
+	e.priority = h.priority
+	evicted := s.TryInsert(e)
+	h.applyPin(s, e, now)
+	return evicted
+}
+
+// applyPin pins e if the hints ask for it, charging the pin to h.role unless
+// e is already pinned.  If the role has run out of quota since checkPin, the
+// pin is skipped: by now the RPC has done its work, and its result is worth
+// more than the pin.  s.mutex must be held.
+func (h cacheHints) applyPin(s *shard, e *entry, now time.Time) {
+	if h.pin <= 0 {
+		return
+	}
+	if err := s.pins.Reserve(e.addr, h.role); err != nil {
+		log.Printf("warn: not pinning %v: %v", e.addr, err)
+		return
+	}
+	if !s.Pin(e, now.Add(h.pin)) {
+		s.pins.Release(e.addr)
+	}
+}
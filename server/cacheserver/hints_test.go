@@ -0,0 +1,117 @@
+package cacheserver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cloud9-tools/go-cas/proto"
+	"github.com/cloud9-tools/go-cas/server/auth"
+	"github.com/cloud9-tools/go-cas/server/quota"
+)
+
+func TestParseHints(t *testing.T) {
+	srv := &Server{maxPin: time.Minute}
+	type testrow struct {
+		In  *proto.CacheHints
+		Out cacheHints
+	}
+	for i, row := range []testrow{
+		testrow{nil, cacheHints{}},
+		testrow{&proto.CacheHints{}, cacheHints{role: "alice"}},
+		testrow{&proto.CacheHints{Bypass: true}, cacheHints{bypass: true, role: "alice"}},
+		testrow{&proto.CacheHints{NoAdmit: true}, cacheHints{noAdmit: true, role: "alice"}},
+		testrow{&proto.CacheHints{Priority: proto.CacheHints_HIGH}, cacheHints{priority: proto.CacheHints_HIGH, role: "alice"}},
+		testrow{&proto.CacheHints{PinSeconds: 30}, cacheHints{pin: 30 * time.Second, role: "alice"}},
+		testrow{&proto.CacheHints{PinSeconds: 60}, cacheHints{pin: time.Minute, role: "alice"}},
+		testrow{&proto.CacheHints{PinSeconds: 3600}, cacheHints{pin: time.Minute, role: "alice"}},
+		testrow{&proto.CacheHints{PinSeconds: -5}, cacheHints{role: "alice"}},
+	} {
+		if out := srv.parseHints(row.In, "alice"); out != row.Out {
+			t.Errorf("[%d] expected %+v, got %+v", i, row.Out, out)
+		}
+	}
+}
+
+func TestCacheHints_pinQuota(t *testing.T) {
+	s := newTestShard(4, 4)
+	s.pins = quota.NewLedger(quota.Table{"alice": quota.Limits{Blocks: 1}})
+	h := cacheHints{pin: time.Minute, role: "alice"}
+	now := time.Now()
+	a := testEntry(1, proto.CacheHints_NORMAL)
+	b := testEntry(2, proto.CacheHints_NORMAL)
+
+	if err := h.checkPin(s, a.addr); err != nil {
+		t.Fatalf("first pin: unexpected error: %v", err)
+	}
+	h.admit(s, a, now)
+	if _, found := s.pinned[a.addr]; !found {
+		t.Errorf("first pin: expected entry 1 to be pinned")
+	}
+	// Pinning the same block again costs nothing.
+	if err := h.checkPin(s, a.addr); err != nil {
+		t.Errorf("repeated pin: unexpected error: %v", err)
+	}
+	h.hit(s, a, now)
+	if err := h.checkPin(s, b.addr); err == nil {
+		t.Errorf("second pin: expected a quota error")
+	}
+	if err := (cacheHints{noAdmit: true, pin: time.Minute, role: "alice"}).checkPin(s, b.addr); err != nil {
+		t.Errorf("pin with NoAdmit: expected no error, since nothing is pinned, got %v", err)
+	}
+
+	// If the quota runs out after checkPin, the block is still cached,
+	// just not pinned.
+	h.admit(s, b, now)
+	if s.byAddr[b.addr] != b {
+		t.Errorf("second pin: expected entry 2 to be cached anyway")
+	}
+	if _, found := s.pinned[b.addr]; found {
+		t.Errorf("second pin: expected entry 2 not to be pinned")
+	}
+	if n := s.pins.Usage("alice"); n != 1 {
+		t.Errorf("expected alice to own 1 pin, got %d", n)
+	}
+	s.Remove(a.addr)
+	if n := s.pins.Usage("alice"); n != 0 {
+		t.Errorf("after Remove: expected alice to own no pins, got %d", n)
+	}
+}
+
+func TestAdmitWalked(t *testing.T) {
+	s := newTestShard(4, 1)
+	srv := &Server{shards: []*shard{s}}
+	good := testEntry(1, proto.CacheHints_NORMAL)
+	other := testEntry(2, proto.CacheHints_NORMAL)
+	busy := testEntry(3, proto.CacheHints_NORMAL)
+	noAdmit := testEntry(4, proto.CacheHints_NORMAL)
+	s.MarkBusy(busy.addr)
+	normal := cacheHints{role: auth.Anonymous}
+
+	srv.admitWalked(normal, &proto.WalkReply{Addr: "bogus", Block: good.block.Trim()})
+	srv.admitWalked(normal, &proto.WalkReply{Addr: good.addr.String(), Block: other.block.Trim()})
+	if len(s.byAddr) != 0 {
+		t.Errorf("expected bad addresses and corrupt blocks not to be cached")
+	}
+	if srv.verifyFailures != 1 {
+		t.Errorf("expected 1 verify failure, got %d", srv.verifyFailures)
+	}
+
+	srv.admitWalked(normal, &proto.WalkReply{Addr: good.addr.String(), Block: good.block.Trim()})
+	if e := s.byAddr[good.addr]; e == nil || *e.block != *good.block {
+		t.Errorf("expected a good block to be cached")
+	}
+	high := cacheHints{priority: proto.CacheHints_HIGH, role: auth.Anonymous}
+	srv.admitWalked(high, &proto.WalkReply{Addr: good.addr.String(), Block: good.block.Trim()})
+	if e := s.byAddr[good.addr]; e == nil || e.priority != proto.CacheHints_HIGH {
+		t.Errorf("expected the hints to apply to a block that was already cached")
+	}
+
+	srv.admitWalked(normal, &proto.WalkReply{Addr: busy.addr.String(), Block: busy.block.Trim()})
+	if s.byAddr[busy.addr] != nil {
+		t.Errorf("expected a block that is being fetched not to be cached")
+	}
+	srv.admitWalked(cacheHints{noAdmit: true}, &proto.WalkReply{Addr: noAdmit.addr.String(), Block: noAdmit.block.Trim()})
+	if s.byAddr[noAdmit.addr] != nil {
+		t.Errorf("expected NoAdmit to keep the block out of the cache")
+	}
+}
@@ -0,0 +1,316 @@
+package cacheserver
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/cloud9-tools/go-cas/common"
+	"github.com/cloud9-tools/go-cas/server/eviction"
+	"github.com/cloud9-tools/go-cas/server/fs"
+	"github.com/cloud9-tools/go-multierror"
+)
+
+const l2IndexMagic = 0x63417343 // "cAsC"
+const l2IndexVersion = 0x01
+const l2QueueDepth = 256
+const l2FlushInterval = 10 * time.Second
+
+// l2 is the optional second cache tier, which keeps blocks evicted from RAM
+// in a data file on local disk.  Every slot of the data file holds one full
+// block; the index file records which block is in which slot.
+//
+// The index is only written periodically, so after a crash it may be stale.
+// This is harmless: every block read back from disk is checked against its
+// address, and a mismatch is treated as a miss.
+type l2 struct {
+	mutex    sync.Mutex
+	policy   eviction.Policy
+	bySlot   []common.Addr
+	byAddr   map[common.Addr]uint32
+	free     []uint32
+	dirty    bool
+	hits     uint64
+	misses   uint64
+	demoted  uint64
+	dropped  uint64
+	failures uint64
+
+	indexFile fs.File
+	dataFile  fs.BlockFile
+	demotech  chan *entry
+	closech   chan struct{}
+	donech    chan struct{}
+}
+
+func openL2(dir string, limit uint, policyName string) (*l2, error) {
+	policy, err := eviction.New(policyName, int(limit))
+	if err != nil {
+		return nil, err
+	}
+	nfs := fs.NativeFileSystem{RootDir: dir}
+	indexFile, err := nfs.OpenMetadata(fs.ReadWrite)
+	if err != nil {
+		return nil, err
+	}
+	dataFile, err := nfs.OpenData(fs.ReadWrite)
+	if err != nil {
+		indexFile.Close()
+		return nil, err
+	}
+
+	c := &l2{
+		policy:    policy,
+		bySlot:    make([]common.Addr, limit),
+		byAddr:    make(map[common.Addr]uint32, limit),
+		indexFile: indexFile,
+		dataFile:  dataFile,
+		demotech:  make(chan *entry, l2QueueDepth),
+		closech:   make(chan struct{}),
+		donech:    make(chan struct{}),
+	}
+	if err := c.readIndex(); err != nil {
+		log.Printf("warn: discarding L2 cache index %q: %v", indexFile.Name(), err)
+		for i := range c.bySlot {
+			c.bySlot[i].Clear()
+		}
+	}
+	for slot := len(c.bySlot) - 1; slot >= 0; slot-- {
+		addr := c.bySlot[slot]
+		if addr.IsZero() {
+			c.free = append(c.free, uint32(slot))
+			continue
+		}
+		c.byAddr[addr] = uint32(slot)
+		c.policy.Insert(addr)
+	}
+	log.Printf("info: L2 cache %q: %d of %d slots in use", dataFile.Name(), len(c.byAddr), limit)
+	go c.loop()
+	return c, nil
+}
+
+// Close stops demotion, saves the index, and closes the files.  demotech is
+// never closed, since RPCs may still be calling Demote.
+func (c *l2) Close() error {
+	if c == nil {
+		return nil
+	}
+	close(c.closech)
+	<-c.donech
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return multierror.Of(
+		c.writeIndex(),
+		c.dataFile.Close(),
+		c.indexFile.Close())
+}
+
+// Get returns the cache entry for addr, or nil if the L2 tier doesn't have
+// it.  The block is read from disk without holding the lock.
+func (c *l2) Get(addr common.Addr) *entry {
+	if c == nil {
+		return nil
+	}
+	c.mutex.Lock()
+	slot, found := c.byAddr[addr]
+	if !found {
+		c.misses++
+	}
+	c.mutex.Unlock()
+	if !found {
+		return nil
+	}
+
+	block := &common.Block{}
+	err := c.dataFile.ReadBlock(slot, block)
+	if err == nil {
+		err = common.Verify(addr, block.Addr())
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err != nil {
+		log.Printf("warn: L2 cache slot %d: %v", slot, err)
+		c.failures++
+		c.misses++
+		if cur, found := c.byAddr[addr]; found && cur == slot {
+			c.forget(addr, slot)
+		}
+		return nil
+	}
+	c.hits++
+	if _, found := c.byAddr[addr]; found {
+		c.policy.Hit(addr)
+	}
+	return &entry{addr: addr, block: block}
+}
+
+// Demote queues entries that were evicted from RAM to be written to disk.
+// If the queue is full, the entries are dropped rather than stalling the RPC.
+func (c *l2) Demote(entries []*entry) {
+	if c == nil {
+		return
+	}
+	for _, e := range entries {
+		select {
+		case <-c.closech:
+			return
+		case c.demotech <- e:
+		default:
+			c.mutex.Lock()
+			c.dropped++
+			c.mutex.Unlock()
+		}
+	}
+}
+
+// Remove forgets addr, so that it will not be promoted later.
+func (c *l2) Remove(addr common.Addr) {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if slot, found := c.byAddr[addr]; found {
+		c.forget(addr, slot)
+	} else {
+		// It might be in the middle of being demoted.
+		c.policy.Remove(addr)
+	}
+}
+
+func (c *l2) forget(addr common.Addr, slot uint32) {
+	delete(c.byAddr, addr)
+	c.bySlot[slot].Clear()
+	c.free = append(c.free, slot)
+	c.policy.Remove(addr)
+	c.dirty = true
+}
+
+func (c *l2) loop() {
+	defer close(c.donech)
+	ticker := time.NewTicker(l2FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.closech:
+			// Finish what was queued before Close.
+			for {
+				select {
+				case e := <-c.demotech:
+					c.put(e)
+				default:
+					return
+				}
+			}
+		case e := <-c.demotech:
+			c.put(e)
+		case <-ticker.C:
+			c.mutex.Lock()
+			if c.dirty {
+				if err := c.writeIndex(); err != nil {
+					log.Printf("warn: failed to save L2 cache index: %v", err)
+				}
+			}
+			c.mutex.Unlock()
+		}
+	}
+}
+
+// put writes e to a free slot, evicting as the policy directs.  Only the
+// demotion goroutine calls put, so the slot can be written without holding
+// the lock; the new block is only published in the index afterward.
+func (c *l2) put(e *entry) {
+	c.mutex.Lock()
+	if _, found := c.byAddr[e.addr]; found {
+		c.policy.Hit(e.addr)
+		c.mutex.Unlock()
+		return
+	}
+	admitted, evicted := c.policy.Insert(e.addr)
+	for _, victim := range evicted {
+		slot := c.byAddr[victim]
+		delete(c.byAddr, victim)
+		c.bySlot[slot].Clear()
+		c.free = append(c.free, slot)
+		c.dirty = true
+	}
+	if !admitted || len(c.free) == 0 {
+		c.policy.Remove(e.addr)
+		c.mutex.Unlock()
+		return
+	}
+	slot := c.free[len(c.free)-1]
+	c.free = c.free[:len(c.free)-1]
+	c.mutex.Unlock()
+
+	err := c.dataFile.WriteBlock(slot, e.block)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err != nil {
+		log.Printf("warn: failed to write L2 cache slot %d: %v", slot, err)
+		c.failures++
+		c.free = append(c.free, slot)
+		c.policy.Remove(e.addr)
+		return
+	}
+	c.demoted++
+	if !c.policy.Contains(e.addr) {
+		// Removed while we were writing.
+		c.free = append(c.free, slot)
+		return
+	}
+	c.byAddr[e.addr] = slot
+	c.bySlot[slot] = e.addr
+	c.dirty = true
+}
+
+func (c *l2) readIndex() error {
+	raw, err := c.indexFile.ReadContents()
+	if err != nil {
+		return err
+	}
+	if len(raw) == 0 {
+		return nil
+	}
+	if len(raw) < 12 {
+		return fmt.Errorf("file is too short: expected >= 12 bytes, got %d bytes", len(raw))
+	}
+	if magic := binary.BigEndian.Uint32(raw[0:4]); magic != l2IndexMagic {
+		return fmt.Errorf("file has incorrect magic: expected %08x, got %08x", l2IndexMagic, magic)
+	}
+	if raw[4] != l2IndexVersion || raw[5] != 0 || raw[6] != 0 || raw[7] != 0 {
+		return fmt.Errorf("file has unsupported version %d", raw[4])
+	}
+	n := binary.BigEndian.Uint32(raw[8:12])
+	if len(raw) != 12+int(n)*common.AddrSize {
+		return fmt.Errorf("file has wrong length for %d slots: %d bytes", n, len(raw))
+	}
+	if int(n) > len(c.bySlot) {
+		log.Printf("warn: L2 cache shrank from %d to %d slots", n, len(c.bySlot))
+		n = uint32(len(c.bySlot))
+	}
+	for slot := uint32(0); slot < n; slot++ {
+		i := 12 + slot*common.AddrSize
+		copy(c.bySlot[slot][:], raw[i:i+common.AddrSize])
+	}
+	return nil
+}
+
+func (c *l2) writeIndex() error {
+	raw := make([]byte, 12, 12+len(c.bySlot)*common.AddrSize)
+	binary.BigEndian.PutUint32(raw[0:4], l2IndexMagic)
+	raw[4] = l2IndexVersion
+	binary.BigEndian.PutUint32(raw[8:12], uint32(len(c.bySlot)))
+	for _, addr := range c.bySlot {
+		raw = append(raw, addr[:]...)
+	}
+	if err := c.indexFile.WriteContents(raw); err != nil {
+		return err
+	}
+	c.dirty = false
+	return nil
+}
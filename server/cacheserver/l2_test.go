@@ -0,0 +1,203 @@
+package cacheserver
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/cloud9-tools/go-cas/common"
+	"github.com/cloud9-tools/go-cas/proto"
+	"github.com/cloud9-tools/go-cas/server/fs"
+)
+
+func tempL2(t *testing.T, limit uint) (*l2, string) {
+	dir, err := ioutil.TempDir("", "l2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := openL2(dir, limit, "lru")
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return c, dir
+}
+
+// holds returns true iff c has a slot for addr.
+func (c *l2) holds(addr common.Addr) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	_, found := c.byAddr[addr]
+	return found
+}
+
+func (c *l2) counters() (hits, misses, demoted, failures uint64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.hits, c.misses, c.demoted, c.failures
+}
+
+func TestL2_demoteAndPromote(t *testing.T) {
+	c, dir := tempL2(t, 4)
+	defer os.RemoveAll(dir)
+	defer c.Close()
+
+	backend := &fakeBackend{}
+	p, _ := newTestPool(false, backend)
+	defer p.Close()
+	srv := newTestServer(p)
+	srv.shards = []*shard{newTestShard(1, 1)}
+	srv.l2 = c
+	ctx := context.Background()
+
+	a := testEntry(1, proto.CacheHints_NORMAL)
+	b := testEntry(2, proto.CacheHints_NORMAL)
+	for _, e := range []*entry{a, b} {
+		if _, err := srv.Put(ctx, &proto.PutRequest{Addr: e.addr.String(), Block: e.block.Trim()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := srv.shards[0]
+	if s.byAddr[a.addr] != nil {
+		t.Fatalf("expected a to be evicted from RAM")
+	}
+	waitFor(t, "a to be demoted", func() bool { return c.holds(a.addr) })
+	if c.holds(b.addr) {
+		t.Errorf("expected b, still in RAM, not to be demoted")
+	}
+
+	// A hit in L2 is served without the backend, and promoted to RAM.
+	backend.set(func() { backend.getErr = unavailable() })
+	out, err := srv.Get(ctx, &proto.GetRequest{Addr: a.addr.String()})
+	if err != nil || !out.Found || string(out.Block) != string(a.block[:]) {
+		t.Fatalf("expected a from L2, got %v", err)
+	}
+	if gets, _, _ := backend.counts(); gets != 0 {
+		t.Errorf("expected no backend gets, got %d", gets)
+	}
+	if s.byAddr[a.addr] == nil {
+		t.Errorf("expected a to be promoted to RAM")
+	}
+	if hits, _, _, _ := c.counters(); hits != 1 {
+		t.Errorf("expected 1 L2 hit, got %d", hits)
+	}
+	waitFor(t, "b to be demoted in turn", func() bool { return c.holds(b.addr) })
+}
+
+func TestL2_capacity(t *testing.T) {
+	c, dir := tempL2(t, 2)
+	defer os.RemoveAll(dir)
+	defer c.Close()
+
+	var entries []*entry
+	for i := 0; i < 3; i++ {
+		e := testEntry(i, proto.CacheHints_NORMAL)
+		entries = append(entries, e)
+		c.Demote([]*entry{e})
+		waitFor(t, "the block to be demoted", func() bool { return c.holds(e.addr) })
+	}
+	if c.holds(entries[0].addr) {
+		t.Errorf("expected the least recently used block to be evicted")
+	}
+	if e := c.Get(entries[0].addr); e != nil {
+		t.Errorf("expected a miss for the evicted block")
+	}
+	for _, e := range entries[1:] {
+		if got := c.Get(e.addr); got == nil || *got.block != *e.block {
+			t.Errorf("expected %v to be in L2", e.addr)
+		}
+	}
+	c.mutex.Lock()
+	used, free := len(c.byAddr), len(c.free)
+	c.mutex.Unlock()
+	if used != 2 || free != 0 {
+		t.Errorf("expected 2 slots used and none free, got %d and %d", used, free)
+	}
+	if hits, misses, demoted, _ := c.counters(); hits != 2 || misses != 1 || demoted != 3 {
+		t.Errorf("expected 2 hits, 1 miss, 3 demoted, got %d, %d, %d", hits, misses, demoted)
+	}
+}
+
+func TestL2_reopen(t *testing.T) {
+	c, dir := tempL2(t, 4)
+	defer os.RemoveAll(dir)
+
+	a := testEntry(1, proto.CacheHints_NORMAL)
+	b := testEntry(2, proto.CacheHints_NORMAL)
+	c.Demote([]*entry{a, b})
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Demotions queued before Close survive it.
+	c, err := openL2(dir, 4, "lru")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range []*entry{a, b} {
+		if got := c.Get(e.addr); got == nil || *got.block != *e.block {
+			t.Errorf("after reopen: expected %v to be in L2", e.addr)
+		}
+	}
+	c.mutex.Lock()
+	slot := c.byAddr[a.addr]
+	c.mutex.Unlock()
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// After a crash, the index may name a block that is no longer in its
+	// slot.  That slot is a miss, and is freed.
+	nfs := fs.NativeFileSystem{RootDir: dir}
+	data, err := nfs.OpenData(fs.ReadWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := testEntry(3, proto.CacheHints_NORMAL)
+	if err := data.WriteBlock(slot, other.block); err != nil {
+		t.Fatal(err)
+	}
+	data.Close()
+	c, err = openL2(dir, 4, "lru")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := c.Get(a.addr); got != nil {
+		t.Errorf("stale index: expected a miss")
+	}
+	if c.holds(a.addr) {
+		t.Errorf("stale index: expected the slot to be forgotten")
+	}
+	if _, _, _, failures := c.counters(); failures != 1 {
+		t.Errorf("stale index: expected 1 failure, got %d", failures)
+	}
+	if got := c.Get(b.addr); got == nil || *got.block != *b.block {
+		t.Errorf("stale index: expected b to be unaffected")
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// An unreadable index is discarded, leaving an empty cache.
+	index, err := nfs.OpenMetadata(fs.ReadWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := index.WriteContents([]byte("garbage index")); err != nil {
+		t.Fatal(err)
+	}
+	index.Close()
+	c, err = openL2(dir, 4, "lru")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if c.holds(b.addr) {
+		t.Errorf("bad index: expected an empty cache")
+	}
+	if n := len(c.free); n != 4 {
+		t.Errorf("bad index: expected 4 free slots, got %d", n)
+	}
+}
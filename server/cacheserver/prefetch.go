@@ -0,0 +1,93 @@
+package cacheserver
+
+import (
+	"log"
+	"sync"
+
+	"github.com/cloud9-tools/go-cas/common"
+	"github.com/cloud9-tools/go-cas/internal"
+)
+
+// prefetcher follows references: when Get serves a block that the decoder
+// recognizes as a reference block, the blocks it refers to are fetched into
+// the cache in the background, on the theory that the client will ask for
+// them next.
+//
+// Work is bounded twice over: at most budget references are taken from any
+// one block, and a fixed pool of workers drains a fixed-size queue.  When the
+// queue is full, further references are dropped rather than delaying Get.
+//
+// The queue is never closed, since Get may still be calling Notify while the
+// server shuts down; closech tells the workers to stop instead.
+type prefetcher struct {
+	srv     *Server
+	decoder common.RefDecoder
+	budget  int
+	queue   chan common.Addr
+	closech chan struct{}
+	wg      sync.WaitGroup
+
+	mutex   sync.Mutex
+	issued  uint64
+	dropped uint64
+	failed  uint64
+}
+
+func newPrefetcher(srv *Server, decoder common.RefDecoder, concurrency, budget int) *prefetcher {
+	p := &prefetcher{
+		srv:     srv,
+		decoder: decoder,
+		budget:  budget,
+		queue:   make(chan common.Addr, concurrency*budget),
+		closech: make(chan struct{}),
+	}
+	p.wg.Add(concurrency)
+	for i := 0; i < concurrency; i++ {
+		go p.worker()
+	}
+	return p
+}
+
+func (p *prefetcher) Close() {
+	if p != nil {
+		close(p.closech)
+		p.wg.Wait()
+	}
+}
+
+// Notify queues the references in block, if it has any.
+func (p *prefetcher) Notify(block *common.Block) {
+	if p == nil {
+		return
+	}
+	refs := p.decoder.DecodeRefs(block)
+	if len(refs) > p.budget {
+		refs = refs[:p.budget]
+	}
+	for _, addr := range refs {
+		select {
+		case <-p.closech:
+			return
+		case p.queue <- addr:
+			internal.Locked(&p.mutex, func() { p.issued++ })
+		default:
+			internal.Locked(&p.mutex, func() { p.dropped++ })
+		}
+	}
+}
+
+func (p *prefetcher) worker() {
+	defer p.wg.Done()
+	for {
+		var addr common.Addr
+		select {
+		case <-p.closech:
+			return
+		case addr = <-p.queue:
+		}
+		if err := p.srv.load(addr, nil, true); err != nil {
+			log.Printf("warn: failed to prefetch %v: %v", addr, err)
+			internal.Locked(&p.mutex, func() { p.failed++ })
+		}
+	}
+}
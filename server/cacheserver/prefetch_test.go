@@ -0,0 +1,148 @@
+package cacheserver
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/cloud9-tools/go-cas/common"
+	"github.com/cloud9-tools/go-cas/internal"
+	"github.com/cloud9-tools/go-cas/proto"
+)
+
+// refTable is a common.RefDecoder that looks blocks up by address.
+type refTable map[common.Addr][]common.Addr
+
+func (t refTable) DecodeRefs(block *common.Block) []common.Addr {
+	return t[block.Addr()]
+}
+
+func (p *prefetcher) counters() (issued, dropped, failed uint64) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.issued, p.dropped, p.failed
+}
+
+func (s *shard) prefetchCounters() (prefetched, hits, wasted uint64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.prefetched, s.prefetchHits, s.prefetchWasted
+}
+
+func TestPrefetcher_budgetAndQueue(t *testing.T) {
+	parent := testEntry(0, proto.CacheHints_NORMAL)
+	var refs []common.Addr
+	for i := 1; i <= 5; i++ {
+		refs = append(refs, testEntry(i, proto.CacheHints_NORMAL).addr)
+	}
+	// No workers, so that nothing drains the queue.
+	p := &prefetcher{
+		decoder: refTable{parent.addr: refs},
+		budget:  2,
+		queue:   make(chan common.Addr, 3),
+		closech: make(chan struct{}),
+	}
+	defer p.Close()
+
+	p.Notify(parent.block)
+	if n := len(p.queue); n != 2 {
+		t.Errorf("expected the budget to cap the refs at 2, got %d queued", n)
+	}
+	if issued, dropped, _ := p.counters(); issued != 2 || dropped != 0 {
+		t.Errorf("expected 2 issued and 0 dropped, got %d and %d", issued, dropped)
+	}
+	p.Notify(parent.block)
+	if n := len(p.queue); n != 3 {
+		t.Errorf("expected a full queue, got %d queued", n)
+	}
+	if issued, dropped, _ := p.counters(); issued != 3 || dropped != 1 {
+		t.Errorf("with a full queue: expected 3 issued and 1 dropped, got %d and %d", issued, dropped)
+	}
+	p.Notify(testEntry(6, proto.CacheHints_NORMAL).block)
+	if issued, dropped, _ := p.counters(); issued != 3 || dropped != 1 {
+		t.Errorf("a block without refs: expected no change, got %d issued and %d dropped", issued, dropped)
+	}
+}
+
+func TestPrefetcher_failed(t *testing.T) {
+	parent := testEntry(0, proto.CacheHints_NORMAL)
+	ref := testEntry(1, proto.CacheHints_NORMAL)
+	backend := &fakeBackend{getErr: grpc.Errorf(codes.PermissionDenied, "access denied")}
+	pool, _ := newTestPool(false, backend)
+	defer pool.Close()
+	srv := newTestServer(pool)
+	p := newPrefetcher(srv, refTable{parent.addr: {ref.addr}}, 1, 4)
+	defer p.Close()
+
+	p.Notify(parent.block)
+	waitFor(t, "the prefetch to fail", func() bool {
+		_, _, failed := p.counters()
+		return failed == 1
+	})
+	if issued, dropped, _ := p.counters(); issued != 1 || dropped != 0 {
+		t.Errorf("expected 1 issued and 0 dropped, got %d and %d", issued, dropped)
+	}
+	if prefetched, _, _ := srv.shards[0].prefetchCounters(); prefetched != 0 {
+		t.Errorf("expected nothing prefetched, got %d", prefetched)
+	}
+}
+
+func TestPrefetcher_shardCounters(t *testing.T) {
+	parent := testEntry(0, proto.CacheHints_NORMAL)
+	used := testEntry(1, proto.CacheHints_NORMAL)
+	wasted := testEntry(2, proto.CacheHints_NORMAL)
+	backend := &fakeBackend{blocks: map[string][]byte{
+		parent.addr.String(): parent.block.Trim(),
+		used.addr.String():   used.block.Trim(),
+		wasted.addr.String(): wasted.block.Trim(),
+	}}
+	pool, _ := newTestPool(false, backend)
+	defer pool.Close()
+	srv := newTestServer(pool)
+	s := newTestShard(3, 1)
+	srv.shards = []*shard{s}
+	srv.prefetch = newPrefetcher(srv, refTable{parent.addr: {used.addr, wasted.addr}}, 1, 4)
+	defer srv.prefetch.Close()
+	ctx := context.Background()
+
+	// Getting the parent prefetches both of its refs.
+	if _, err := srv.Get(ctx, &proto.GetRequest{Addr: parent.addr.String()}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "both refs to be prefetched", func() bool {
+		prefetched, _, _ := s.prefetchCounters()
+		return prefetched == 2
+	})
+	if gets, _, _ := backend.counts(); gets != 3 {
+		t.Errorf("expected 3 backend gets, got %d", gets)
+	}
+
+	// Using a prefetched block counts once, however often it is used.
+	for i := 0; i < 2; i++ {
+		if _, err := srv.Get(ctx, &proto.GetRequest{Addr: used.addr.String()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, hits, _ := s.prefetchCounters(); hits != 1 {
+		t.Errorf("expected 1 prefetch hit, got %d", hits)
+	}
+
+	// Evicting a prefetched block that was never used wastes it.
+	var evicted []*entry
+	internal.Locked(&s.mutex, func() {
+		for i := 10; i < 12; i++ {
+			evicted = append(evicted, s.TryInsert(testEntry(i, proto.CacheHints_NORMAL))...)
+		}
+	})
+	if s.byAddr[wasted.addr] != nil {
+		t.Fatalf("expected the unused prefetched block to be evicted, got %d evictions", len(evicted))
+	}
+	if prefetched, hits, wasted := s.prefetchCounters(); prefetched != 2 || hits != 1 || wasted != 1 {
+		t.Errorf("expected 2 prefetched, 1 hit, 1 wasted, got %d, %d, %d", prefetched, hits, wasted)
+	}
+	if issued, dropped, failed := srv.prefetch.counters(); issued != 2 || dropped != 0 || failed != 0 {
+		t.Errorf("expected 2 issued, 0 dropped, 0 failed, got %d, %d, %d", issued, dropped, failed)
+	}
+}
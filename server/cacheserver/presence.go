@@ -0,0 +1,133 @@
+package cacheserver
+
+import (
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/cloud9-tools/go-cas/common"
+	"github.com/cloud9-tools/go-cas/internal/bloom"
+	"github.com/cloud9-tools/go-cas/proto"
+)
+
+const presenceFalsePositiveRate = 0.01
+
+// presence is a Bloom filter of the addresses that the backend holds, which
+// lets Get answer "not found" without asking the backend.  It is rebuilt
+// periodically from a Walk of the backend, and updated by Puts in between.
+// Removes cannot be reflected in a Bloom filter, but that only costs a trip
+// to the backend.
+//
+// Blocks written to the backend by other clients are invisible until the
+// next sync, so the sync interval bounds how stale "not found" can be.
+type presence struct {
+	mutex   sync.RWMutex
+	filter  *bloom.Filter
+	syncing bool
+	pending []common.Addr
+	closech chan struct{}
+}
+
+func newPresence() *presence {
+	return &presence{closech: make(chan struct{})}
+}
+
+// DefinitelyAbsent returns true iff the filter is ready and says that the
+// backend does not hold addr.
+func (p *presence) DefinitelyAbsent(addr common.Addr) bool {
+	if p == nil {
+		return false
+	}
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return p.filter != nil && !p.filter.MayContain(addr)
+}
+
+// Add records that the backend holds addr.
+func (p *presence) Add(addr common.Addr) {
+	if p == nil {
+		return
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.filter != nil {
+		p.filter.Add(addr)
+	}
+	if p.syncing {
+		p.pending = append(p.pending, addr)
+	}
+}
+
+func (p *presence) Close() {
+	if p != nil {
+		close(p.closech)
+	}
+}
+
+func (p *presence) loop(srv *Server, interval time.Duration) {
+	for {
+		if err := p.sync(srv); err != nil {
+			log.Printf("warn: failed to sync presence filter: %v", err)
+		}
+		select {
+		case <-p.closech:
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// sync rebuilds the filter from a Walk of the backend.  Puts that happen
+// while the Walk is in progress are added to the new filter before it
+// replaces the old one, since the Walk may already have gone past them.
+func (p *presence) sync(srv *Server) error {
+	ctx := context.Background()
+	stat, err := srv.fallback.Stat(ctx, &proto.StatRequest{})
+	if err != nil {
+		return err
+	}
+	n := int(stat.BlocksUsed)
+	filter := bloom.New(n+n/2+1024, presenceFalsePositiveRate)
+
+	p.mutex.Lock()
+	p.syncing = true
+	p.pending = nil
+	p.mutex.Unlock()
+	defer func() {
+		p.mutex.Lock()
+		p.syncing = false
+		p.pending = nil
+		p.mutex.Unlock()
+	}()
+
+	stream, err := srv.fallback.Walk(ctx, &proto.WalkRequest{})
+	if err != nil {
+		return err
+	}
+	for {
+		item, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		var addr common.Addr
+		if err := addr.Parse(item.Addr); err != nil {
+			return err
+		}
+		filter.Add(addr)
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for _, addr := range p.pending {
+		filter.Add(addr)
+	}
+	p.filter = filter
+	log.Printf("info: synced presence filter: %d blocks", filter.Len())
+	return nil
+}
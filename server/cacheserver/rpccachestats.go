@@ -0,0 +1,78 @@
+package cacheserver
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/cloud9-tools/go-cas/common"
+	"github.com/cloud9-tools/go-cas/internal"
+	"github.com/cloud9-tools/go-cas/proto"
+	"github.com/cloud9-tools/go-cas/server/audit"
+	"github.com/cloud9-tools/go-cas/server/auth"
+)
+
+func (srv *Server) CacheStats(ctx context.Context, in *proto.CacheStatsRequest) (out *proto.CacheStatsReply, err error) {
+	id := srv.Auther.Extract(ctx)
+	rec := audit.Begin(ctx, id, auth.StatFS, "")
+	defer func() { srv.finish(rec, err) }()
+	if err := srv.authorize(id, auth.StatFS); err != nil {
+		return nil, err
+	}
+
+	out = &proto.CacheStatsReply{
+		Policy: srv.policy,
+		Limit:  int64(srv.limit),
+	}
+	for _, s := range srv.shards {
+		var stats proto.ShardStats
+		internal.Locked(&s.mutex, func() {
+			stats = proto.ShardStats{
+				Entries:         int64(len(s.byAddr)),
+				Hits:            int64(s.hits),
+				Misses:          int64(s.misses),
+				Evictions:       int64(s.evictions),
+				Busy:            int64(len(s.busy)),
+				BytesUsed:       int64(len(s.byAddr)) * common.BlockSize,
+				NegativeEntries: int64(len(s.missing)),
+				NegativeHits:    int64(s.negativeHits),
+				Prefetched:      int64(s.prefetched),
+				PrefetchHits:    int64(s.prefetchHits),
+				PrefetchWasted:  int64(s.prefetchWasted),
+				Pinned:          int64(len(s.pinned)),
+			}
+		})
+		out.Shards = append(out.Shards, &stats)
+	}
+	if c := srv.l2; c != nil {
+		internal.Locked(&c.mutex, func() {
+			out.L2Entries = int64(len(c.byAddr))
+			out.L2Limit = int64(len(c.bySlot))
+			out.L2Hits = int64(c.hits)
+			out.L2Misses = int64(c.misses)
+			out.L2Demoted = int64(c.demoted)
+			out.L2Dropped = int64(c.dropped)
+			out.L2Failures = int64(c.failures)
+		})
+	}
+	if w := srv.writeBack; w != nil {
+		depth, lag := w.Stats()
+		out.WriteBackDepth = int64(depth)
+		out.WriteBackLagMs = int64(lag / time.Millisecond)
+		internal.Locked(&w.mutex, func() {
+			out.WriteBackDrained = int64(w.drained)
+			out.WriteBackFailures = int64(w.failures)
+		})
+	}
+	if p := srv.prefetch; p != nil {
+		internal.Locked(&p.mutex, func() {
+			out.PrefetchIssued = int64(p.issued)
+			out.PrefetchDropped = int64(p.dropped)
+			out.PrefetchFailed = int64(p.failed)
+		})
+	}
+	internal.Locked(&srv.mutex, func() {
+		out.VerifyFailures = int64(srv.verifyFailures)
+	})
+	return out, nil
+}
@@ -0,0 +1,87 @@
+package cacheserver
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/cloud9-tools/go-cas/common"
+	"github.com/cloud9-tools/go-cas/proto"
+)
+
+func TestCacheStats(t *testing.T) {
+	backend := &fakeBackend{keep: true}
+	pool, _ := newTestPool(false, backend)
+	defer pool.Close()
+	srv := newTestServer(pool)
+	srv.shards = []*shard{newTestShard(1, 1), newTestShard(1, 1)}
+	srv.policy = "lru"
+	srv.limit = 2
+	ctx := context.Background()
+
+	// Two blocks for shard 0, and a block and an absent address for shard 1.
+	var byShard [2][]*entry
+	for i := 0; len(byShard[0]) < 2 || len(byShard[1]) < 2; i++ {
+		e := testEntry(i, proto.CacheHints_NORMAL)
+		n := 0
+		if srv.shardFor(e.addr) == srv.shards[1] {
+			n = 1
+		}
+		byShard[n] = append(byShard[n], e)
+	}
+	a0, a1 := byShard[0][0], byShard[0][1]
+	b0, absent := byShard[1][0], byShard[1][1]
+
+	put := func(e *entry) {
+		if _, err := srv.Put(ctx, &proto.PutRequest{Addr: e.addr.String(), Block: e.block.Trim()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	get := func(e *entry, expected bool) {
+		out, err := srv.Get(ctx, &proto.GetRequest{Addr: e.addr.String()})
+		if err != nil || out.Found != expected {
+			t.Fatalf("Get %v: expected found=%v, got %v", e.addr, expected, err)
+		}
+	}
+	put(a0)
+	put(a1)            // shard 0: evicts a0
+	put(b0)            // shard 1
+	get(a1, true)      // shard 0: hit
+	get(a0, true)      // shard 0: miss, fetched, evicts a1
+	get(b0, true)      // shard 1: hit
+	get(absent, false) // shard 1: miss, remembered
+	get(absent, false) // shard 1: miss, negative hit
+
+	out, err := srv.CacheStats(ctx, &proto.CacheStatsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Policy != "lru" || out.Limit != 2 {
+		t.Errorf("expected policy=lru limit=2, got policy=%s limit=%d", out.Policy, out.Limit)
+	}
+	expected := []proto.ShardStats{
+		proto.ShardStats{Entries: 1, Hits: 1, Misses: 1, Evictions: 2, BytesUsed: common.BlockSize},
+		proto.ShardStats{Entries: 1, Hits: 1, Misses: 2, BytesUsed: common.BlockSize, NegativeEntries: 1, NegativeHits: 1},
+	}
+	if len(out.Shards) != len(expected) {
+		t.Fatalf("expected %d shards, got %d", len(expected), len(out.Shards))
+	}
+	var total proto.ShardStats
+	for i, s := range out.Shards {
+		if *s != expected[i] {
+			t.Errorf("shard %d: expected %+v, got %+v", i, expected[i], *s)
+		}
+		total.Entries += s.Entries
+		total.Hits += s.Hits
+		total.Misses += s.Misses
+		total.Evictions += s.Evictions
+		total.BytesUsed += s.BytesUsed
+	}
+	if total.Entries != 2 || total.Hits != 2 || total.Misses != 3 || total.Evictions != 2 ||
+		total.BytesUsed != 2*common.BlockSize {
+		t.Errorf("totals: expected 2 entries, 2 hits, 3 misses, 2 evictions, got %+v", total)
+	}
+	if out.L2Limit != 0 || out.WriteBackDepth != 0 || out.PrefetchIssued != 0 || out.VerifyFailures != 0 {
+		t.Errorf("expected no L2, write-back, prefetch or verify stats, got %+v", out)
+	}
+}
@@ -1,6 +1,9 @@
 package cacheserver
 
 import (
+	"log"
+	"time"
+
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
@@ -9,19 +12,37 @@ import (
 	"github.com/cloud9-tools/go-cas/common"
 	"github.com/cloud9-tools/go-cas/internal"
 	"github.com/cloud9-tools/go-cas/proto"
+	"github.com/cloud9-tools/go-cas/server/audit"
+	"github.com/cloud9-tools/go-cas/server/auth"
 )
 
 func (srv *Server) Get(ctx context.Context, in *proto.GetRequest) (out *proto.GetReply, err error) {
 	id := srv.Auther.Extract(ctx)
-	if err := id.Check(srv.ACL).Err(); err != nil {
+	rec := audit.Begin(ctx, id, auth.Get, in.Addr)
+	defer func() {
+		if out != nil {
+			rec.Bytes = int64(len(out.Block))
+		}
+		srv.finish(rec, err)
+	}()
+	if err := srv.authorizeGet(ctx, id, in.Addr); err != nil {
 		return nil, err
 	}
+	ctx = srv.cluster.Trust(ctx, id)
 
 	var addr common.Addr
 	if err := addr.Parse(in.Addr); err != nil {
 		return nil, err
 	}
+	srv.trace.Record(addr)
+	h := srv.parseHints(in.Hints, id.Role)
+	if h.bypass {
+		return srv.bypassGet(ctx, addr, in.NoBlock)
+	}
 	s := srv.shardFor(addr)
+	if err := h.checkPin(s, addr); err != nil {
+		return nil, err
+	}
 
 	unmarkBusy := false
 	defer func() {
@@ -33,50 +54,144 @@ func (srv *Server) Get(ctx context.Context, in *proto.GetRequest) (out *proto.Ge
 	}()
 
 	e := (*entry)(nil)
+	missing := false
 	internal.Locked(&s.mutex, func() {
 		s.Await(addr)
-		e = s.byAddr[addr]
+		e = s.Lookup(addr, !h.noAdmit)
 		if e != nil {
-			s.Bump(e)
+			h.hit(s, e, time.Now())
+			return
+		}
+		missing = s.IsMissing(addr, time.Now())
+		if missing {
 			return
 		}
 		s.MarkBusy(addr)
 		unmarkBusy = true
 	})
-	if e == nil {
-		e, err = doBypassGet(srv.fallback, ctx, addr)
+	if e == nil && !missing {
+		e = srv.writeBack.Get(addr)
+	}
+	// A miss from the presence filter is not remembered: the filter is
+	// cheap to ask again, and is refreshed on its own schedule.
+	filtered := false
+	if e == nil && !missing {
+		filtered = srv.presence.DefinitelyAbsent(addr)
+		missing = filtered
+	}
+	if e == nil && !missing {
+		e = srv.l2.Get(addr)
+	}
+	found := e != nil
+	cache := found
+	if !found && !missing {
+		var err error
+		e, found, cache, err = srv.fetch(ctx, s, addr, in.NoBlock)
 		if err != nil {
 			return nil, err
 		}
+	}
+	if unmarkBusy {
+		var evicted []*entry
+		now := time.Now()
 		internal.Locked(&s.mutex, func() {
-			s.TryInsert(e)
+			if e != nil && cache {
+				evicted = h.admit(s, e, now)
+			} else if !found && !filtered {
+				s.RememberMissing(addr, now, now.Add(srv.negativeTTL))
+			}
 			s.UnmarkBusy(addr)
 			unmarkBusy = false
 		})
+		srv.l2.Demote(evicted)
+	}
+
+	out = &proto.GetReply{Found: found}
+	if e != nil && !in.NoBlock {
+		out.Block = e.block[:]
+		if h.caches() {
+			srv.prefetch.Notify(e.block)
+		}
 	}
-	if e != nil {
-		out.Found = true
-		if !in.NoBlock {
-			out.Block = e.block[:]
+	return out, nil
+}
+
+// bypassGet serves a Get from the backend without touching the cache.  Only
+// the write-back journal is consulted, since the backend may not have its
+// blocks yet.
+func (srv *Server) bypassGet(ctx context.Context, addr common.Addr, noBlock bool) (*proto.GetReply, error) {
+	e := srv.writeBack.Get(addr)
+	found := e != nil
+	if !found {
+		var err error
+		e, found, err = srv.fetchBackend(ctx, addr, noBlock)
+		if err != nil {
+			return nil, err
+		}
+	}
+	out := &proto.GetReply{Found: found}
+	if e != nil && !noBlock {
+		out.Block = e.block[:]
+	}
+	return out, nil
+}
+
+// fetch gets addr from the peer that owns it, or else from the backend.  If
+// noBlock is set, only existence is checked and the returned entry is nil.
+// cache reports whether the entry should be cached here: blocks owned by
+// other peers are only cached once they get hot.
+//
+// A miss at the owner is not final: its negative cache and presence filter
+// don't see blocks that were Put through other peers, so only the backend
+// can say that a block is missing.
+func (srv *Server) fetch(ctx context.Context, s *shard, addr common.Addr, noBlock bool) (e *entry, found bool, cache bool, err error) {
+	if owner := srv.cluster.OwnerClient(ctx, addr); owner != nil {
+		e, found, err = srv.doGet(owner, srv.cluster.peerContext(ctx), addr, noBlock)
+		if err == nil && found {
+			if e != nil {
+				internal.Locked(&s.mutex, func() {
+					cache = srv.cluster.IsHot(s.CountRemoteHit(addr))
+				})
+			}
+			return e, found, cache, nil
+		}
+		if err != nil {
+			log.Printf("warn: peer %q failed, falling back to backend: %v", srv.cluster.Owner(addr), err)
 		}
 	}
-	return out, err
+	e, found, err = srv.fetchBackend(ctx, addr, noBlock)
+	return e, found, true, err
+}
+
+// fetchBackend gets addr from the backend, trying each backend in turn.
+func (srv *Server) fetchBackend(ctx context.Context, addr common.Addr, noBlock bool) (e *entry, found bool, err error) {
+	err = srv.fallback.read(ctx, func(cc client.Client) error {
+		e, found, err = srv.doGet(cc, ctx, addr, noBlock)
+		return err
+	})
+	return e, found, err
 }
 
-func doBypassGet(fallback client.Client, ctx context.Context, addr common.Addr) (*entry, error) {
-	out, err := fallback.Get(ctx, &proto.GetRequest{
+// doGet asks cc for addr, and checks that the block it returns really has
+// that address, so that a faulty peer or backend can't poison the cache.
+func (srv *Server) doGet(cc client.Client, ctx context.Context, addr common.Addr, noBlock bool) (*entry, bool, error) {
+	out, err := cc.Get(ctx, &proto.GetRequest{
 		Addr:    addr.String(),
-		NoBlock: false,
+		NoBlock: noBlock,
 	})
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
-	if !out.Found {
-		return nil, nil
+	if !out.Found || noBlock {
+		return nil, out.Found, nil
 	}
 	block := &common.Block{}
 	if err := block.Pad(out.Block); err != nil {
-		return nil, grpc.Errorf(codes.Internal, "go-cas/server/cacheserver: problem with remote server response: %v", err)
+		return nil, false, grpc.Errorf(codes.Internal, "go-cas/server/cacheserver: problem with remote server response: %v", err)
+	}
+	if err := common.Verify(addr, block.Addr()); err != nil {
+		internal.Locked(&srv.mutex, func() { srv.verifyFailures++ })
+		return nil, false, grpc.Errorf(codes.DataLoss, "go-cas/server/cacheserver: problem with remote server response: %v", err)
 	}
-	return &entry{block: block, addr: addr}, nil
+	return &entry{block: block, addr: addr}, true, nil
 }
@@ -0,0 +1,104 @@
+package cacheserver
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/cloud9-tools/go-cas/internal/bloom"
+	"github.com/cloud9-tools/go-cas/proto"
+	"github.com/cloud9-tools/go-cas/server/auth"
+)
+
+func newTestServer(pool *backendPool) *Server {
+	return &Server{
+		ACL:         auth.AllowAll(),
+		Auther:      auth.AnonymousAuther(),
+		shards:      []*shard{newTestShard(4, 1)},
+		fallback:    pool,
+		negativeTTL: time.Minute,
+	}
+}
+
+func TestGet_corruptBackend(t *testing.T) {
+	want := testEntry(1, proto.CacheHints_NORMAL)
+	other := testEntry(2, proto.CacheHints_NORMAL)
+	corrupt := &proto.GetReply{Found: true, Block: other.block.Trim()}
+	good := &proto.GetReply{Found: true, Block: want.block.Trim()}
+	in := &proto.GetRequest{Addr: want.addr.String()}
+	ctx := context.Background()
+
+	a, b := &fakeBackend{reply: corrupt}, &fakeBackend{reply: good}
+	p, _ := newTestPool(false, a, b)
+	defer p.Close()
+	srv := newTestServer(p)
+	if _, err := srv.Get(ctx, in); grpc.Code(err) != codes.DataLoss {
+		t.Errorf("expected DataLoss, got %v", err)
+	}
+	if srv.verifyFailures != 1 {
+		t.Errorf("expected 1 verify failure, got %d", srv.verifyFailures)
+	}
+	s := srv.shardFor(want.addr)
+	if len(s.byAddr) != 0 || len(s.missing) != 0 {
+		t.Errorf("expected the corrupt reply not to be cached, got %d entries and %d misses", len(s.byAddr), len(s.missing))
+	}
+	if gets, _, _ := b.counts(); gets != 0 {
+		t.Errorf("without --retry_corrupt: expected no gets from b, got %d", gets)
+	}
+
+	a, b = &fakeBackend{reply: corrupt}, &fakeBackend{reply: good}
+	p, _ = newTestPool(true, a, b)
+	defer p.Close()
+	srv = newTestServer(p)
+	out, err := srv.Get(ctx, in)
+	if err != nil || !out.Found {
+		t.Fatalf("with --retry_corrupt: expected b to answer, got %v", err)
+	}
+	if string(out.Block) != string(want.block[:]) {
+		t.Errorf("with --retry_corrupt: got the wrong block")
+	}
+	if srv.verifyFailures != 1 {
+		t.Errorf("with --retry_corrupt: expected 1 verify failure, got %d", srv.verifyFailures)
+	}
+	if e := srv.shardFor(want.addr).byAddr[want.addr]; e == nil || *e.block != *want.block {
+		t.Errorf("with --retry_corrupt: expected the good block to be cached")
+	}
+	if active := p.Active(); active != "a" {
+		t.Errorf("with --retry_corrupt: expected a corrupt block not to count as a backend failure, got %q active", active)
+	}
+}
+
+func TestGet_presenceMiss(t *testing.T) {
+	e := testEntry(1, proto.CacheHints_NORMAL)
+	in := &proto.GetRequest{Addr: e.addr.String()}
+	ctx := context.Background()
+
+	backend := &fakeBackend{}
+	p, _ := newTestPool(false, backend)
+	defer p.Close()
+	srv := newTestServer(p)
+	srv.presence = newPresence()
+	srv.presence.filter = bloom.New(1024, presenceFalsePositiveRate)
+
+	out, err := srv.Get(ctx, in)
+	if err != nil || out.Found {
+		t.Fatalf("expected a miss, got found=%v err=%v", out != nil && out.Found, err)
+	}
+	if gets, _, _ := backend.counts(); gets != 0 {
+		t.Errorf("expected the presence filter to answer, got %d backend gets", gets)
+	}
+	if s := srv.shardFor(e.addr); len(s.missing) != 0 {
+		t.Errorf("expected a presence filter miss not to be remembered, got %d misses", len(s.missing))
+	}
+
+	// Once the filter learns of the block, the next Get asks the backend.
+	srv.presence.Add(e.addr)
+	backend.set(func() { backend.reply = &proto.GetReply{Found: true, Block: e.block.Trim()} })
+	out, err = srv.Get(ctx, in)
+	if err != nil || !out.Found {
+		t.Errorf("after Add: expected a hit, got found=%v err=%v", out != nil && out.Found, err)
+	}
+}
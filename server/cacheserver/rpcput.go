@@ -1,16 +1,30 @@
 package cacheserver
 
 import (
+	"time"
+
 	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 
 	"github.com/cloud9-tools/go-cas/common"
 	"github.com/cloud9-tools/go-cas/internal"
 	"github.com/cloud9-tools/go-cas/proto"
+	"github.com/cloud9-tools/go-cas/server/audit"
+	"github.com/cloud9-tools/go-cas/server/auth"
 )
 
 func (srv *Server) Put(ctx context.Context, in *proto.PutRequest) (out *proto.PutReply, err error) {
 	id := srv.Auther.Extract(ctx)
-	if err := id.Check(srv.ACL).Err(); err != nil {
+	rec := audit.Begin(ctx, id, auth.Put, in.Addr)
+	rec.Bytes = int64(len(in.Block))
+	defer func() {
+		if out != nil {
+			rec.Addr = out.Addr
+		}
+		srv.finish(rec, err)
+	}()
+	if err := srv.authorize(id, auth.Put); err != nil {
 		return nil, err
 	}
 
@@ -19,7 +33,22 @@ func (srv *Server) Put(ctx context.Context, in *proto.PutRequest) (out *proto.Pu
 		return nil, err
 	}
 	addr := block.Addr()
+	if in.Addr != "" {
+		// Check here, rather than leaving it to the backend, so that a
+		// mismatched block is never journaled or cached.
+		var expected common.Addr
+		if err := expected.Parse(in.Addr); err != nil {
+			return nil, grpc.Errorf(codes.InvalidArgument, "%v", err)
+		}
+		if err := common.Verify(expected, addr); err != nil {
+			return nil, grpc.Errorf(codes.DataLoss, "%v", err)
+		}
+	}
+	h := srv.parseHints(in.Hints, id.Role)
 	s := srv.shardFor(addr)
+	if err := h.checkPin(s, addr); err != nil {
+		return nil, err
+	}
 
 	unmarkBusy := false
 	defer func() {
@@ -36,21 +65,30 @@ func (srv *Server) Put(ctx context.Context, in *proto.PutRequest) (out *proto.Pu
 		unmarkBusy = true
 	})
 
-	out, err = srv.fallback.Put(ctx, in)
-	if err != nil {
-		return nil, err
+	if srv.writeBack != nil {
+		// The backend hasn't seen the block yet, so Inserted can only
+		// report whether it was new to the journal.
+		inserted, err := srv.writeBack.Append(addr, &block)
+		if err != nil {
+			return nil, grpc.Errorf(codes.Unavailable, "go-cas/server/cacheserver: failed to journal block: %v", err)
+		}
+		out = &proto.PutReply{Addr: addr.String(), Inserted: inserted}
+	} else {
+		out, err = srv.fallback.Put(ctx, in)
+		if err != nil {
+			return nil, err
+		}
 	}
 
+	var evicted []*entry
+	now := time.Now()
 	internal.Locked(&s.mutex, func() {
-		e := s.byAddr[addr]
-		if e != nil {
-			s.Bump(e)
-			return
-		}
-		e = &entry{addr: addr, block: &block}
-		s.TryInsert(e)
+		s.ForgetMissing(addr)
+		evicted = h.admit(s, &entry{addr: addr, block: &block}, now)
 		s.UnmarkBusy(addr)
 		unmarkBusy = false
 	})
+	srv.l2.Demote(evicted)
+	srv.presence.Add(addr)
 	return out, err
 }
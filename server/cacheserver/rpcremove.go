@@ -1,18 +1,25 @@
 package cacheserver
 
 import (
+	"time"
+
 	"golang.org/x/net/context"
 
 	"github.com/cloud9-tools/go-cas/common"
 	"github.com/cloud9-tools/go-cas/internal"
 	"github.com/cloud9-tools/go-cas/proto"
+	"github.com/cloud9-tools/go-cas/server/audit"
+	"github.com/cloud9-tools/go-cas/server/auth"
 )
 
 func (srv *Server) Remove(ctx context.Context, in *proto.RemoveRequest) (out *proto.RemoveReply, err error) {
 	id := srv.Auther.Extract(ctx)
-	if err := id.Check(srv.ACL).Err(); err != nil {
+	rec := audit.Begin(ctx, id, auth.Remove, in.Addr)
+	defer func() { srv.finish(rec, err) }()
+	if err := srv.authorize(id, auth.Remove); err != nil {
 		return nil, err
 	}
+	ctx = srv.cluster.Trust(ctx, id)
 
 	var addr common.Addr
 	if err := addr.Parse(in.Addr); err != nil {
@@ -35,10 +42,21 @@ func (srv *Server) Remove(ctx context.Context, in *proto.RemoveRequest) (out *pr
 		s.MarkBusy(addr)
 		unmarkBusy = true
 	})
+	srv.l2.Remove(addr)
+	srv.writeBack.Cancel(addr)
+	if fromPeer(ctx) {
+		// Just an invalidation; the peer will tell the backend itself.
+		return &proto.RemoveReply{}, nil
+	}
 
 	out, err = srv.fallback.Remove(ctx, in)
 	if err != nil {
 		return nil, err
 	}
+	srv.cluster.Invalidate(ctx, addr)
+	now := time.Now()
+	internal.Locked(&s.mutex, func() {
+		s.RememberMissing(addr, now, now.Add(srv.negativeTTL))
+	})
 	return out, err
 }
@@ -4,13 +4,22 @@ import (
 	"golang.org/x/net/context"
 
 	"github.com/cloud9-tools/go-cas/proto"
+	"github.com/cloud9-tools/go-cas/server/audit"
+	"github.com/cloud9-tools/go-cas/server/auth"
 )
 
-func (srv *Server) Stat(ctx context.Context, in *proto.StatRequest) (*proto.StatReply, error) {
+func (srv *Server) Stat(ctx context.Context, in *proto.StatRequest) (out *proto.StatReply, err error) {
 	id := srv.Auther.Extract(ctx)
-	if err := id.Check(srv.ACL).Err(); err != nil {
+	rec := audit.Begin(ctx, id, auth.StatFS, "")
+	defer func() { srv.finish(rec, err) }()
+	if err := srv.authorize(id, auth.StatFS); err != nil {
 		return nil, err
 	}
 
-	return srv.fallback.Stat(ctx, in)
+	out, err = srv.fallback.Stat(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	out.ActiveBackend = srv.fallback.Active()
+	return out, nil
 }
@@ -2,16 +2,26 @@ package cacheserver
 
 import (
 	"io"
+	"time"
 
+	"github.com/cloud9-tools/go-cas/common"
+	"github.com/cloud9-tools/go-cas/internal"
 	"github.com/cloud9-tools/go-cas/proto"
+	"github.com/cloud9-tools/go-cas/server/audit"
+	"github.com/cloud9-tools/go-cas/server/auth"
 )
 
-func (srv *Server) Walk(in *proto.WalkRequest, serverstream proto.CAS_WalkServer) error {
+func (srv *Server) Walk(in *proto.WalkRequest, serverstream proto.CAS_WalkServer) (err error) {
 	id := srv.Auther.Extract(serverstream.Context())
-	if err := id.Check(srv.ACL).Err(); err != nil {
+	rec := audit.Begin(serverstream.Context(), id, auth.Walk, "")
+	defer func() { srv.finish(rec, err) }()
+	if err := srv.authorize(id, auth.Walk); err != nil {
 		return err
 	}
 
+	h := srv.parseHints(in.Hints, id.Role)
+	admit := in.Hints != nil && in.WantBlocks && h.caches()
+
 	clientstream, err := srv.fallback.Walk(serverstream.Context(), in)
 	if err != nil {
 		return err
@@ -24,7 +34,42 @@ func (srv *Server) Walk(in *proto.WalkRequest, serverstream proto.CAS_WalkServer
 		if err != nil {
 			return err
 		}
+		if admit {
+			srv.admitWalked(h, item)
+		}
 		serverstream.Send(item)
+		rec.Bytes += int64(len(item.Block))
 	}
 	return nil
 }
+
+// admitWalked caches a block streamed by the backend during a Walk, if it
+// checks out and isn't being worked on by another RPC.
+func (srv *Server) admitWalked(h cacheHints, item *proto.WalkReply) {
+	var addr common.Addr
+	if err := addr.Parse(item.Addr); err != nil {
+		return
+	}
+	block := &common.Block{}
+	if err := block.Pad(item.Block); err != nil {
+		return
+	}
+	if common.Verify(addr, block.Addr()) != nil {
+		internal.Locked(&srv.mutex, func() { srv.verifyFailures++ })
+		return
+	}
+	s := srv.shardFor(addr)
+	var evicted []*entry
+	now := time.Now()
+	internal.Locked(&s.mutex, func() {
+		if _, busy := s.busy[addr]; busy {
+			return
+		}
+		if e := s.byAddr[addr]; e != nil {
+			h.hit(s, e, now)
+			return
+		}
+		evicted = h.admit(s, &entry{addr: addr, block: block}, now)
+	})
+	srv.l2.Demote(evicted)
+}
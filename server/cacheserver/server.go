@@ -3,90 +3,282 @@ package cacheserver
 import (
 	"encoding/binary"
 	"log"
-	"math/rand"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/cloud9-tools/go-cas/client"
+	"golang.org/x/net/context"
+
 	"github.com/cloud9-tools/go-cas/common"
+	"github.com/cloud9-tools/go-cas/internal"
+	"github.com/cloud9-tools/go-cas/server/audit"
 	"github.com/cloud9-tools/go-cas/server/auth"
+	"github.com/cloud9-tools/go-cas/server/eviction"
+	"github.com/cloud9-tools/go-cas/server/quota"
+	"github.com/cloud9-tools/go-multierror"
 )
 
-// ModelFunc is a function that takes the size of a cache and the index of one
-// element (0 = most hits, size-1 == least hits), and returns the expected
-// number of hits as a ratio to the max number of hits.
-//	∀x: ∀y: 0.0 ≤ f(x, y) ≤ 1.0
-//	    ∀y:       f(0, y) = 1.0
-type ModelFunc func(index, size int) float64
-
-func UniformModel(index, size int) float64 {
-	return 1.0
-}
-
-func ZipfModel(index, size int) float64 {
-	return 1.0 / (float64(index) + 1.0)
-}
+// pinnedShare limits pins: at most 1/pinnedShare of each shard may be pinned.
+const pinnedShare = 4
 
 type Server struct {
 	ACL      auth.ACL
 	Auther   auth.Auther
+	Audit    *audit.Log
+	Limiter  *quota.Limiter
+	policy   string
+	limit    uint
 	shards   []*shard
-	fallback client.Client
-	model    ModelFunc
-	rng      *rand.Rand
-	closech  chan struct{}
+	fallback *backendPool
+	trace    *tracer
+	l2       *l2
+	presence *presence
+	cluster  *cluster
+	prefetch *prefetcher
+	snapshot *snapshotter
+	reach    *auth.Reachability
+
+	// writeBack is non-nil iff Puts are acknowledged before they reach
+	// the backend.
+	writeBack *writeBack
+
+	negativeTTL time.Duration
+	maxPin      time.Duration
+
+	// mutex protects verifyFailures, the number of blocks fetched from
+	// peers or backends that did not match their addresses.
+	mutex          sync.Mutex
+	verifyFailures uint64
 }
 
+// reachLimit is how many addresses are remembered as reachable from
+// capability roots.
+const reachLimit = 1 << 16
+
 func NewServer(cfg Config) *Server {
 	if err := cfg.Validate(); err != nil {
 		panic(err)
 	}
 	shards := make([]*shard, 0, cfg.NumShards)
-	perShardMax := uint32(cfg.Limit / cfg.NumShards)
+	perShardMax := int(cfg.Limit / cfg.NumShards)
 	for i := uint(0); i < cfg.NumShards; i++ {
-		shards = append(shards, NewShard(perShardMax))
+		policy, err := eviction.New(cfg.Policy, perShardMax)
+		if err != nil {
+			panic(err)
+		}
+		maxMissing := 0
+		if cfg.NegativeTTL > 0 {
+			maxMissing = perShardMax
+		}
+		maxPinned := perShardMax / pinnedShare
+		shards = append(shards, NewShard(policy, perShardMax, maxMissing, maxPinned, 2*perShardMax))
+	}
+	pins := quota.NewLedger(cfg.Limits)
+	for _, s := range shards {
+		s.pins = pins
+	}
+	fallback := newBackendPool(strings.Split(cfg.Connect, ","), cfg.Dial, cfg.ProbeInterval, cfg.RetryCorrupt)
+	var err error
+	var trace *tracer
+	if cfg.Trace != "" {
+		trace, err = openTracer(cfg.Trace)
+		if err != nil {
+			log.Fatalf("trace error: %v", err)
+		}
 	}
-	fallback, err := cfg.Dial()
+	var tier2 *l2
+	if cfg.L2Dir != "" {
+		tier2, err = openL2(cfg.L2Dir, cfg.L2Limit, cfg.Policy)
+		if err != nil {
+			log.Fatalf("L2 cache error: %q: %v", cfg.L2Dir, err)
+		}
+	}
+
+	var wb *writeBack
+	if cfg.WriteBackDir != "" {
+		wb, err = openWriteBack(cfg.WriteBackDir, fallback)
+		if err != nil {
+			log.Fatalf("write-back error: %q: %v", cfg.WriteBackDir, err)
+		}
+	}
+
+	auditLog, err := cfg.Audit.Open()
 	if err != nil {
-		log.Fatalf("dial error: %q: %v", cfg.Connect, err)
+		log.Fatalf("audit log error: %v", err)
+	}
+
+	var peers *cluster
+	if cfg.Peers != "" {
+		peers, err = newCluster(cfg.Self, strings.Split(cfg.Peers, ","), cfg.peerRoles(), cfg.HotThreshold, cfg.Dial)
+		if err != nil {
+			log.Fatalf("dial error: peer %v", err)
+		}
 	}
-	model := ModelFunc(ZipfModel)
-	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
 
 	srv := &Server{
-		ACL:      cfg.ACL,
-		Auther:   auth.AnonymousAuther(),
-		shards:   shards,
-		fallback: fallback,
-		model:    model,
-		rng:      rng,
-		closech:  make(chan struct{}),
-	}
-	go srv.maintenance()
+		ACL:         cfg.ACL,
+		Auther:      cfg.Auther(),
+		Audit:       auditLog,
+		Limiter:     quota.NewLimiter(cfg.Limits),
+		policy:      cfg.Policy,
+		limit:       cfg.Limit,
+		shards:      shards,
+		fallback:    fallback,
+		trace:       trace,
+		l2:          tier2,
+		writeBack:   wb,
+		cluster:     peers,
+		negativeTTL: cfg.NegativeTTL,
+		maxPin:      cfg.MaxPin,
+		reach:       auth.NewReachability(reachLimit),
+	}
+	if cfg.Prefetch != "" {
+		decoder, err := common.RefDecoderByName(cfg.Prefetch)
+		if err != nil {
+			panic(err)
+		}
+		srv.prefetch = newPrefetcher(srv, decoder, int(cfg.PrefetchConcurrency), int(cfg.PrefetchBudget))
+	}
+	if cfg.PresenceSync > 0 {
+		srv.presence = newPresence()
+		go srv.presence.loop(srv, cfg.PresenceSync)
+	}
+	if cfg.Snapshot != "" {
+		srv.snapshot = newSnapshotter(srv, cfg.Snapshot, cfg.SnapshotBlocks, int(cfg.WarmConcurrency))
+		go srv.snapshot.loop(cfg.SnapshotInterval)
+	}
 	return srv
 }
 
+// Warm fills the cache from the snapshot saved by a previous run, if any.  It
+// may be called before serving RPCs, or concurrently with them.
+func (srv *Server) Warm() {
+	srv.snapshot.Warm()
+}
+
 func (srv *Server) Close() error {
-	close(srv.closech)
-	return srv.fallback.Close()
+	if srv.trace != nil {
+		srv.trace.Close()
+	}
+	srv.presence.Close()
+	srv.prefetch.Close()
+	return multierror.Of(
+		srv.snapshot.Close(),
+		srv.writeBack.Close(),
+		srv.l2.Close(),
+		srv.cluster.Close(),
+		srv.fallback.Close(),
+		srv.Audit.Close())
+}
+
+func (srv *Server) authorize(id auth.Identity, op auth.Operation) error {
+	return id.Authorize(srv.ACL, srv.Limiter, op)
+}
+
+func (srv *Server) authorizeGet(ctx context.Context, id auth.Identity, addr string) error {
+	return id.AuthorizeGet(srv.ACL, srv.Limiter, addr, srv.reach, func(addr common.Addr, block *common.Block) (bool, error) {
+		return srv.peek(ctx, addr, block)
+	})
+}
+
+// peek reads addr from the cache if it's there, or else from the backend,
+// without admitting it to the cache.
+func (srv *Server) peek(ctx context.Context, addr common.Addr, block *common.Block) (bool, error) {
+	s := srv.shardFor(addr)
+	var e *entry
+	internal.Locked(&s.mutex, func() { e = s.byAddr[addr] })
+	if e == nil {
+		e = srv.writeBack.Get(addr)
+	}
+	if e == nil {
+		e = srv.l2.Get(addr)
+	}
+	if e == nil {
+		var err error
+		e, _, err = srv.fetchBackend(ctx, addr, false)
+		if err != nil {
+			return false, err
+		}
+	}
+	if e == nil {
+		return false, nil
+	}
+	*block = *e.block
+	return true, nil
+}
+
+// finish accounts for a completed RPC.
+func (srv *Server) finish(rec *audit.Record, err error) {
+	srv.Limiter.Charge(auth.Role(rec.Role), rec.Bytes)
+	srv.Audit.Finish(rec, err)
+}
+
+// Reload re-reads the policy file, if there is one.
+func (srv *Server) Reload() {
+	srv.Auther.Reload()
 }
 
 func (srv *Server) shardFor(addr common.Addr) *shard {
 	i := binary.BigEndian.Uint32(addr[:]) % uint32(len(srv.shards))
-	log.Printf("addr=%q, shard=%d", addr, i)
 	return srv.shards[i]
 }
 
-func (srv *Server) maintenance() {
-	ticker := time.NewTicker(2 * time.Second)
-	defer ticker.Stop()
-	for {
-		select {
-		case <-srv.closech:
-			return
-		case <-ticker.C:
-		}
-		for _, s := range srv.shards {
-			s.maintain(srv.model, srv.rng)
+const loadTimeout = 30 * time.Second
+
+// load brings addr into the cache on the server's own initiative, rather than
+// a client's, for the prefetcher and for warming up.  If block is non-nil, it
+// is used instead of fetching addr.  Nothing happens if addr is already
+// cached, already being fetched, or recently found to be missing.
+func (srv *Server) load(addr common.Addr, block *common.Block, prefetched bool) error {
+	s := srv.shardFor(addr)
+	skip := false
+	internal.Locked(&s.mutex, func() {
+		_, cached := s.byAddr[addr]
+		_, busy := s.busy[addr]
+		skip = cached || busy || s.IsMissing(addr, time.Now())
+		if !skip {
+			s.MarkBusy(addr)
 		}
+	})
+	if skip {
+		return nil
 	}
+
+	var e *entry
+	if block != nil {
+		e = &entry{addr: addr, block: block}
+	}
+	if e == nil {
+		e = srv.writeBack.Get(addr)
+	}
+	if e == nil {
+		e = srv.l2.Get(addr)
+	}
+	found := e != nil
+	cache := found
+	var err error
+	if !found {
+		// Without a peer marker, fetch asks the owning peer, which
+		// warms that peer's cache even if the block isn't cached here.
+		ctx, cancel := context.WithTimeout(context.Background(), loadTimeout)
+		defer cancel()
+		e, found, cache, err = srv.fetch(ctx, s, addr, false)
+	}
+
+	var evicted []*entry
+	now := time.Now()
+	internal.Locked(&s.mutex, func() {
+		if e != nil && cache {
+			e.prefetched = prefetched
+			evicted = s.TryInsert(e)
+			if prefetched && s.byAddr[addr] == e {
+				s.prefetched++
+			}
+		} else if err == nil && !found {
+			s.RememberMissing(addr, now, now.Add(srv.negativeTTL))
+		}
+		s.UnmarkBusy(addr)
+	})
+	srv.l2.Demote(evicted)
+	return err
 }
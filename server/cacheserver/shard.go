@@ -1,38 +1,65 @@
 package cacheserver
 
 import (
-	"math/rand"
-	"sort"
 	"sync"
+	"time"
 
 	"github.com/cloud9-tools/go-cas/common"
-	"github.com/cloud9-tools/go-cas/internal"
+	"github.com/cloud9-tools/go-cas/proto"
+	"github.com/cloud9-tools/go-cas/server/eviction"
+	"github.com/cloud9-tools/go-cas/server/quota"
 )
 
-const maxuint32 = ^uint32(0)
-const toobig = maxuint32 / 2
+// pinSweepInterval is the least time between checks for expired pins.
+const pinSweepInterval = time.Second
 
 // shard is a single cache shard.  The cache is sharded in order to reduce
 // mutex contention and improve parallelism: addresses in a shard can only
 // block each other, not the rest of the server.  There is always at least one
 // cache shard, but more likely hundreds or thousands.
 type shard struct {
-	// max is the maximum number of cache entries at any time.
-	max uint32
-
 	// mutex must be held for all field accesses and method calls.
 	mutex sync.Mutex
 
-	hit  uint32
-	miss uint32
+	hits         uint64
+	misses       uint64
+	evictions    uint64
+	negativeHits uint64
+
+	// prefetched counts entries inserted by the prefetcher; of those,
+	// prefetchHits were later used, and prefetchWasted were evicted
+	// without ever being used.
+	prefetched     uint64
+	prefetchHits   uint64
+	prefetchWasted uint64
 
-	// entries is the list of cache entries.
-	entries []*entry
+	// policy decides which entries stay cached.  capacity is its size.
+	policy   eviction.Policy
+	capacity int
 
-	// byAddr is a map that allows looking up an entry by its .Addr field.
-	// Each item in Entries should have a corresponding row in this map.
+	// byAddr holds the cached entries.  Each key is resident in policy,
+	// unless it is pinned and policy has already tried to evict it.
 	byAddr map[common.Addr]*entry
 
+	// pinned maps pinned addresses to the time when their pins expire.
+	pinned       map[common.Addr]time.Time
+	maxPinned    int
+	nextPinSweep time.Time
+
+	// pins records which role owns each pin, for quotas.  It is shared by
+	// all shards, and may be nil.
+	pins *quota.Ledger
+
+	// missing remembers addresses that the backend recently reported as
+	// not found, mapped to the time when that answer expires.
+	missing    map[common.Addr]time.Time
+	maxMissing int
+
+	// remoteHits counts fetches of blocks owned by other peers, to spot
+	// blocks hot enough to replicate here.  It is cleared when it fills up.
+	remoteHits    map[common.Addr]uint32
+	maxRemoteHits int
+
 	// busy is a map that keeps track of outstanding RPCs to the backend.
 	// If an RPC is in flight, then a Cond will be present and any
 	// operations should Cond.Wait() until the row is deleted.
@@ -40,55 +67,30 @@ type shard struct {
 }
 
 type entry struct {
-	bumped uint32
-	block  *common.Block
-	addr   common.Addr
-}
+	block *common.Block
+	addr  common.Addr
 
-func NewShard(perShardMax uint32) *shard {
-	return &shard{
-		max:     perShardMax,
-		entries: make([]*entry, 0, perShardMax),
-		byAddr:  make(map[common.Addr]*entry, perShardMax),
-		busy:    make(map[common.Addr]*sync.Cond, 2),
-	}
-}
+	// prefetched is true iff the prefetcher inserted this entry and no
+	// client has asked for it yet.
+	prefetched bool
 
-func (s *shard) Len() int {
-	return len(s.entries)
-}
-func (s *shard) Less(i, j int) bool {
-	return s.entries[i].bumped > s.entries[j].bumped
-}
-func (s *shard) Swap(i, j int) {
-	s.entries[i], s.entries[j] = s.entries[j], s.entries[i]
+	// priority is the priority that the client asked for.  A HIGH entry
+	// is demoted to NORMAL instead of being evicted the first time.
+	priority proto.CacheHints_Priority
 }
 
-func (s *shard) Push(e *entry) {
-	s.entries = append(s.entries, e)
-}
-func (s *shard) Pop() *entry {
-	n := len(s.entries) - 1
-	e := s.entries[n]
-	s.entries = s.entries[0:n]
-	return e
-}
-func (s *shard) Peek() *entry {
-	if len(s.entries) == 0 {
-		return nil
-	}
-	n := len(s.entries) - 1
-	return s.entries[n]
-}
-func (s *shard) RemoveAtIndex(i int) {
-	j := len(s.entries) - 1
-	for i < j {
-		s.Swap(i, i+1)
-		i++
-	}
-	s.Pop()
-	if !sort.IsSorted(s) {
-		panic("not sorted")
+func NewShard(policy eviction.Policy, capacity, maxMissing, maxPinned, maxRemoteHits int) *shard {
+	return &shard{
+		policy:        policy,
+		capacity:      capacity,
+		byAddr:        make(map[common.Addr]*entry),
+		pinned:        make(map[common.Addr]time.Time),
+		maxPinned:     maxPinned,
+		missing:       make(map[common.Addr]time.Time),
+		maxMissing:    maxMissing,
+		remoteHits:    make(map[common.Addr]uint32),
+		maxRemoteHits: maxRemoteHits,
+		busy:          make(map[common.Addr]*sync.Cond, 2),
 	}
 }
 
@@ -113,66 +115,179 @@ func (s *shard) UnmarkBusy(addr common.Addr) {
 	cond.Broadcast()
 }
 
-// TryInsert caches e if there's room (or if it can make room).
-func (s *shard) TryInsert(e *entry) {
-	for uint32(len(s.entries)) > s.max {
-		s.Pop()
+// Lookup returns the cache entry for addr, or nil on a miss.  If touch is
+// false, the eviction policy isn't told about the hit.
+func (s *shard) Lookup(addr common.Addr, touch bool) *entry {
+	e := s.byAddr[addr]
+	if e == nil {
+		s.misses++
+		return nil
+	}
+	s.hits++
+	if e.prefetched {
+		e.prefetched = false
+		s.prefetchHits++
+	}
+	if touch && s.policy.Contains(addr) {
+		s.policy.Hit(addr)
+	}
+	return e
+}
+
+// TryInsert caches e if the eviction policy admits it.  A LOW priority entry
+// is only admitted if there is room for it without evicting anything.  It
+// returns the entries that were evicted to make room.
+func (s *shard) TryInsert(e *entry) []*entry {
+	if cur := s.byAddr[e.addr]; cur != nil {
+		if s.policy.Contains(e.addr) {
+			s.policy.Hit(e.addr)
+		}
+		if e.priority == proto.CacheHints_HIGH {
+			cur.priority = e.priority
+		}
+		return nil
+	}
+	out := s.releasePins(time.Now())
+	if e.priority == proto.CacheHints_LOW && s.policy.Len() >= s.capacity {
+		return out
+	}
+	admitted, evicted := s.policy.Insert(e.addr)
+	if admitted {
+		s.byAddr[e.addr] = e
+	}
+	for i := 0; i < len(evicted); i++ {
+		addr := evicted[i]
+		victim := s.byAddr[addr]
+		if victim == nil {
+			continue
+		}
+		if _, found := s.pinned[addr]; found {
+			// Hold it outside the policy until the pin expires.
+			continue
+		}
+		if victim.priority == proto.CacheHints_HIGH {
+			victim.priority = proto.CacheHints_NORMAL
+			again, more := s.policy.Insert(addr)
+			evicted = append(evicted, more...)
+			if again {
+				continue
+			}
+		}
+		out = append(out, s.evict(addr))
+	}
+	return out
+}
+
+// Pin keeps e cached until the given time, even if the policy would rather
+// evict it or never admitted it.  Pinning an entry that is already pinned
+// can only extend the pin.  It returns false if too many entries are pinned.
+func (s *shard) Pin(e *entry, until time.Time) bool {
+	if expiry, found := s.pinned[e.addr]; found {
+		if until.After(expiry) {
+			s.pinned[e.addr] = until
+		}
+		return true
+	}
+	if len(s.pinned) >= s.maxPinned {
+		return false
 	}
-	if uint32(len(s.entries)) == s.max {
-		e2 := s.Peek()
-		if e2.bumped > s.miss {
-			s.miss++
-			return
+	if s.byAddr[e.addr] == nil {
+		s.byAddr[e.addr] = e
+	}
+	s.pinned[e.addr] = until
+	return true
+}
+
+// releasePins forgets pins that have expired, at most once per
+// pinSweepInterval.  Entries that were only held because of their pins are
+// evicted and returned.
+func (s *shard) releasePins(now time.Time) []*entry {
+	if len(s.pinned) == 0 || now.Before(s.nextPinSweep) {
+		return nil
+	}
+	s.nextPinSweep = now.Add(pinSweepInterval)
+	var out []*entry
+	for addr, expiry := range s.pinned {
+		if now.Before(expiry) {
+			continue
+		}
+		delete(s.pinned, addr)
+		s.pins.Release(addr)
+		if !s.policy.Contains(addr) {
+			out = append(out, s.evict(addr))
 		}
-		s.Pop()
 	}
-	s.Push(e)
-	s.byAddr[e.addr] = e
+	return out
+}
+
+func (s *shard) evict(addr common.Addr) *entry {
+	victim := s.byAddr[addr]
+	if victim.prefetched {
+		s.prefetchWasted++
+	}
+	delete(s.byAddr, addr)
+	s.evictions++
+	return victim
 }
 
 // Remove forgets the cache entry associated with addr.
 func (s *shard) Remove(addr common.Addr) {
 	delete(s.byAddr, addr)
-	for i, e := range s.entries {
-		if e.addr == addr {
-			s.RemoveAtIndex(i)
-			break
-		}
+	if _, found := s.pinned[addr]; found {
+		delete(s.pinned, addr)
+		s.pins.Release(addr)
 	}
+	delete(s.remoteHits, addr)
+	s.policy.Remove(addr)
 }
 
-// Bump increases the usage counter on e, keeping it cached longer.
-func (s *shard) Bump(e *entry) {
-	s.hit++
-	e.bumped++
-	sort.Stable(s)
+// IsMissing returns true iff the backend recently reported addr as not found.
+func (s *shard) IsMissing(addr common.Addr, now time.Time) bool {
+	expiry, found := s.missing[addr]
+	if !found {
+		return false
+	}
+	if now.After(expiry) {
+		delete(s.missing, addr)
+		return false
+	}
+	s.negativeHits++
+	return true
 }
 
-func (s *shard) maintain(fn ModelFunc, rng *rand.Rand) {
-	internal.Locked(&s.mutex, func() {
-		if uint32(len(s.entries)) >= s.max {
-			for uint32(len(s.entries)) > s.max {
-				s.Pop()
-			}
-			n := 1.0 / float64(s.entries[0].bumped)
-			for i, e := range s.entries {
-				xbar := fn(i, len(s.entries))
-				x := float64(e.bumped) * n
-				// Overperform 2x: -1.0
-				// As expected: 0.0
-				// Underperform 10%: +0.1
-				// Underperform 50%: +0.5
-				delta := xbar - x
-				// As expected or better: 0% eviction
-				// 10% underperform: 10% eviction
-				// etc.
-				if delta > 0 && rng.Float64() < delta {
-					s.Pop()
-				}
+// RememberMissing records that the backend reported addr as not found, and
+// that the answer can be trusted until expiry.
+func (s *shard) RememberMissing(addr common.Addr, now, expiry time.Time) {
+	if s.maxMissing <= 0 {
+		return
+	}
+	if len(s.missing) >= s.maxMissing {
+		for addr, expiry := range s.missing {
+			if now.After(expiry) {
+				delete(s.missing, addr)
 			}
 		}
-		for _, e := range s.entries {
-			e.bumped = 0
+	}
+	for addr := range s.missing {
+		if len(s.missing) < s.maxMissing {
+			break
 		}
-	})
+		delete(s.missing, addr)
+	}
+	s.missing[addr] = expiry
+}
+
+// ForgetMissing discards any negative answer for addr.
+func (s *shard) ForgetMissing(addr common.Addr) {
+	delete(s.missing, addr)
+}
+
+// CountRemoteHit records a fetch of addr from the peer that owns it, and
+// returns the number of such fetches seen recently.
+func (s *shard) CountRemoteHit(addr common.Addr) uint32 {
+	if len(s.remoteHits) >= s.maxRemoteHits {
+		s.remoteHits = make(map[common.Addr]uint32)
+	}
+	s.remoteHits[addr]++
+	return s.remoteHits[addr]
 }
@@ -0,0 +1,255 @@
+package cacheserver
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/cloud9-tools/go-cas/common"
+	"github.com/cloud9-tools/go-cas/proto"
+	"github.com/cloud9-tools/go-cas/server/eviction"
+)
+
+func testEntry(i int, priority proto.CacheHints_Priority) *entry {
+	block := &common.Block{}
+	block.Pad([]byte(fmt.Sprintf("block %d", i)))
+	return &entry{addr: block.Addr(), block: block, priority: priority}
+}
+
+func newTestShard(capacity, maxPinned int) *shard {
+	return NewShard(eviction.NewLRU(capacity), capacity, capacity, maxPinned, 2*capacity)
+}
+
+func TestShard_TryInsert_low(t *testing.T) {
+	s := newTestShard(2, 1)
+	a := testEntry(1, proto.CacheHints_NORMAL)
+	b := testEntry(2, proto.CacheHints_NORMAL)
+	c := testEntry(3, proto.CacheHints_LOW)
+	s.TryInsert(a)
+	s.TryInsert(b)
+	if evicted := s.TryInsert(c); len(evicted) != 0 {
+		t.Errorf("LOW into a full shard: expected no evictions, got %d", len(evicted))
+	}
+	if s.byAddr[c.addr] != nil || s.policy.Contains(c.addr) {
+		t.Errorf("LOW into a full shard: expected it to be skipped")
+	}
+	if s.byAddr[a.addr] == nil || s.byAddr[b.addr] == nil {
+		t.Errorf("LOW into a full shard: expected the other entries to stay")
+	}
+
+	s.Remove(a.addr)
+	if evicted := s.TryInsert(c); len(evicted) != 0 || s.byAddr[c.addr] == nil {
+		t.Errorf("LOW with room: expected it to be cached, got %d evictions", len(evicted))
+	}
+}
+
+func TestShard_TryInsert_high(t *testing.T) {
+	s := newTestShard(2, 1)
+	h := testEntry(1, proto.CacheHints_HIGH)
+	a := testEntry(2, proto.CacheHints_NORMAL)
+	b := testEntry(3, proto.CacheHints_NORMAL)
+	s.TryInsert(h)
+	s.TryInsert(a)
+
+	// h is the LRU victim, but it is demoted to NORMAL and reinserted, so
+	// a goes instead.
+	evicted := s.TryInsert(b)
+	if len(evicted) != 1 || evicted[0] != a {
+		t.Fatalf("expected to evict only entry 2, got %v", evicted)
+	}
+	if s.byAddr[h.addr] != h || !s.policy.Contains(h.addr) {
+		t.Errorf("expected the HIGH entry to survive")
+	}
+	if h.priority != proto.CacheHints_NORMAL {
+		t.Errorf("expected the HIGH entry to be demoted to NORMAL, got %v", h.priority)
+	}
+	if s.evictions != 1 {
+		t.Errorf("expected 1 eviction, got %d", s.evictions)
+	}
+
+	// The second time around, it is evicted like anything else.
+	s.policy.Hit(b.addr)
+	evicted = s.TryInsert(testEntry(4, proto.CacheHints_NORMAL))
+	if len(evicted) != 1 || evicted[0] != h {
+		t.Errorf("expected to evict the demoted entry, got %v", evicted)
+	}
+}
+
+func TestShard_TryInsert_pinned(t *testing.T) {
+	s := newTestShard(1, 1)
+	now := time.Now()
+	a := testEntry(1, proto.CacheHints_NORMAL)
+	b := testEntry(2, proto.CacheHints_NORMAL)
+	s.TryInsert(a)
+	if !s.Pin(a, now.Add(time.Hour)) {
+		t.Fatalf("Pin: expected success")
+	}
+	if evicted := s.TryInsert(b); len(evicted) != 0 {
+		t.Errorf("expected the pinned victim to be held, got %d evictions", len(evicted))
+	}
+	if s.byAddr[a.addr] != a || s.policy.Contains(a.addr) {
+		t.Errorf("expected the pinned victim to be held outside the policy")
+	}
+	if s.byAddr[b.addr] != b || !s.policy.Contains(b.addr) {
+		t.Errorf("expected the new entry to be cached")
+	}
+	if e := s.Lookup(a.addr, true); e != a {
+		t.Errorf("expected the pinned victim to still be served, got %v", e)
+	}
+}
+
+func TestShard_Pin(t *testing.T) {
+	s := newTestShard(4, 1)
+	now := time.Now()
+	a := testEntry(1, proto.CacheHints_NORMAL)
+	b := testEntry(2, proto.CacheHints_NORMAL)
+
+	// Pinning an entry that the policy never admitted still caches it.
+	if !s.Pin(a, now.Add(time.Minute)) {
+		t.Fatalf("Pin: expected success")
+	}
+	if s.byAddr[a.addr] != a || s.policy.Contains(a.addr) {
+		t.Errorf("expected the pinned entry to be cached outside the policy")
+	}
+	if s.Pin(b, now.Add(time.Minute)) {
+		t.Errorf("Pin past maxPinned: expected failure")
+	}
+	if s.byAddr[b.addr] != nil {
+		t.Errorf("Pin past maxPinned: expected the entry not to be cached")
+	}
+
+	// Pins can be extended, but never shortened.
+	s.Pin(a, now.Add(time.Hour))
+	s.Pin(a, now.Add(time.Second))
+	if expiry := s.pinned[a.addr]; !expiry.Equal(now.Add(time.Hour)) {
+		t.Errorf("expected the pin to last an hour, got %v", expiry.Sub(now))
+	}
+}
+
+func TestShard_releasePins(t *testing.T) {
+	s := newTestShard(4, 2)
+	now := time.Now()
+	held := testEntry(1, proto.CacheHints_NORMAL)
+	cached := testEntry(2, proto.CacheHints_NORMAL)
+	s.Pin(held, now.Add(time.Minute))
+	s.TryInsert(cached)
+	s.Pin(cached, now.Add(time.Minute))
+
+	if out := s.releasePins(now); len(out) != 0 {
+		t.Errorf("before expiry: expected nothing released, got %d", len(out))
+	}
+	// Sweeps happen at most once per pinSweepInterval.
+	later := now.Add(time.Minute)
+	if out := s.releasePins(now.Add(pinSweepInterval / 2)); len(out) != 0 {
+		t.Errorf("within the sweep interval: expected nothing released, got %d", len(out))
+	}
+	out := s.releasePins(later)
+	if len(out) != 1 || out[0] != held {
+		t.Fatalf("after expiry: expected only the entry held by its pin to be evicted, got %v", out)
+	}
+	if len(s.pinned) != 0 {
+		t.Errorf("after expiry: expected no pins, got %d", len(s.pinned))
+	}
+	if s.byAddr[held.addr] != nil {
+		t.Errorf("after expiry: expected the held entry to be gone")
+	}
+	if s.byAddr[cached.addr] != cached {
+		t.Errorf("after expiry: expected the entry in the policy to stay")
+	}
+}
+
+func TestShard_IsMissing_expiry(t *testing.T) {
+	s := newTestShard(4, 1)
+	a := testEntry(1, proto.CacheHints_NORMAL)
+	now := time.Now()
+	ttl := time.Minute
+
+	if s.IsMissing(a.addr, now) {
+		t.Errorf("expected no negative answer before RememberMissing")
+	}
+	s.RememberMissing(a.addr, now, now.Add(ttl))
+	if !s.IsMissing(a.addr, now.Add(ttl/2)) {
+		t.Errorf("expected a negative answer within the TTL")
+	}
+	if !s.IsMissing(a.addr, now.Add(ttl)) {
+		t.Errorf("expected a negative answer at the expiry time")
+	}
+	if s.negativeHits != 2 {
+		t.Errorf("expected 2 negative hits, got %d", s.negativeHits)
+	}
+	if s.IsMissing(a.addr, now.Add(ttl+time.Second)) {
+		t.Errorf("expected no negative answer after the TTL")
+	}
+	if _, found := s.missing[a.addr]; found {
+		t.Errorf("expected an expired negative answer to be discarded")
+	}
+	if s.negativeHits != 2 {
+		t.Errorf("expected expired answers not to count as hits, got %d", s.negativeHits)
+	}
+}
+
+func TestShard_RememberMissing_cap(t *testing.T) {
+	s := NewShard(eviction.NewLRU(4), 4, 2, 1, 8)
+	now := time.Now()
+	a := testEntry(1, proto.CacheHints_NORMAL)
+	b := testEntry(2, proto.CacheHints_NORMAL)
+	c := testEntry(3, proto.CacheHints_NORMAL)
+
+	// Expired answers are the first to go.
+	s.RememberMissing(a.addr, now, now.Add(time.Second))
+	s.RememberMissing(b.addr, now, now.Add(time.Hour))
+	later := now.Add(time.Minute)
+	s.RememberMissing(c.addr, later, later.Add(time.Hour))
+	if len(s.missing) != 2 {
+		t.Fatalf("expected at most 2 negative answers, got %d", len(s.missing))
+	}
+	if _, found := s.missing[a.addr]; found {
+		t.Errorf("expected the expired answer to be dropped")
+	}
+	if !s.IsMissing(b.addr, later) || !s.IsMissing(c.addr, later) {
+		t.Errorf("expected the live answers to be kept")
+	}
+
+	// With none expired, some live answer makes room.
+	d := testEntry(4, proto.CacheHints_NORMAL)
+	s.RememberMissing(d.addr, later, later.Add(time.Hour))
+	if len(s.missing) != 2 || !s.IsMissing(d.addr, later) {
+		t.Errorf("expected the newest answer to replace an old one, got %d answers", len(s.missing))
+	}
+
+	off := NewShard(eviction.NewLRU(4), 4, 0, 1, 8)
+	off.RememberMissing(a.addr, now, now.Add(time.Hour))
+	if off.IsMissing(a.addr, now) {
+		t.Errorf("maxMissing=0: expected nothing to be remembered")
+	}
+}
+
+func TestShard_ForgetMissing_put(t *testing.T) {
+	backend := &fakeBackend{}
+	p, _ := newTestPool(false, backend)
+	defer p.Close()
+	srv := newTestServer(p)
+	a := testEntry(1, proto.CacheHints_NORMAL)
+	ctx := context.Background()
+	s := srv.shardFor(a.addr)
+
+	if out, err := srv.Get(ctx, &proto.GetRequest{Addr: a.addr.String()}); err != nil || out.Found {
+		t.Fatalf("expected a miss, got %v", err)
+	}
+	if !s.IsMissing(a.addr, time.Now()) {
+		t.Fatalf("expected the miss to be remembered")
+	}
+	put := &proto.PutRequest{
+		Addr:  a.addr.String(),
+		Block: a.block.Trim(),
+		Hints: &proto.CacheHints{NoAdmit: true},
+	}
+	if _, err := srv.Put(ctx, put); err != nil {
+		t.Fatal(err)
+	}
+	if s.IsMissing(a.addr, time.Now()) {
+		t.Errorf("expected Put to forget the negative answer")
+	}
+}
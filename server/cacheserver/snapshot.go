@@ -0,0 +1,298 @@
+package cacheserver
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/cloud9-tools/go-cas/common"
+	"github.com/cloud9-tools/go-cas/internal"
+)
+
+const snapshotMagic = 0x63417357 // "cAsW"
+const snapshotVersion = 0x01
+const snapshotFlagBlocks = 0x01
+
+// snapshotter periodically saves the addresses of the cached blocks, hottest
+// first, so that a restarted cascached can warm its cache from the backend
+// instead of starting cold.  If blocks is set, the block data is saved too,
+// and warming up doesn't need the backend at all.
+//
+// The snapshot is written to a temporary file and renamed into place, so a
+// crash mid-save leaves the previous snapshot intact.
+type snapshotter struct {
+	srv         *Server
+	path        string
+	blocks      bool
+	concurrency int
+
+	// partial is true while the cache is warming up, and stays true if
+	// warming up is interrupted.
+	mutex   sync.Mutex
+	partial bool
+
+	warmwg  sync.WaitGroup
+	closech chan struct{}
+	donech  chan struct{}
+}
+
+type snapshotEntry struct {
+	addr  common.Addr
+	block *common.Block
+}
+
+func newSnapshotter(srv *Server, path string, blocks bool, concurrency int) *snapshotter {
+	return &snapshotter{
+		srv:         srv,
+		path:        path,
+		blocks:      blocks,
+		concurrency: concurrency,
+		closech:     make(chan struct{}),
+		donech:      make(chan struct{}),
+	}
+}
+
+// Close stops any warm-up in progress, and saves a final snapshot.
+func (sn *snapshotter) Close() error {
+	if sn == nil {
+		return nil
+	}
+	close(sn.closech)
+	<-sn.donech
+	sn.warmwg.Wait()
+	return sn.Save()
+}
+
+func (sn *snapshotter) loop(interval time.Duration) {
+	defer close(sn.donech)
+	if interval == 0 {
+		<-sn.closech
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-sn.closech:
+			return
+		case <-ticker.C:
+			if err := sn.Save(); err != nil {
+				log.Printf("warn: failed to save cache snapshot: %v", err)
+			}
+		}
+	}
+}
+
+// Save writes a snapshot of the cache.  Until the cache has finished warming
+// up, it does nothing: the cache is still mostly empty, and the previous
+// snapshot is a better record of what was hot.
+func (sn *snapshotter) Save() error {
+	var partial bool
+	internal.Locked(&sn.mutex, func() { partial = sn.partial })
+	if partial {
+		return nil
+	}
+
+	entries := sn.capture()
+	tmp := sn.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	err = sn.write(f, entries)
+	if err == nil {
+		err = f.Sync()
+	}
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err == nil {
+		err = os.Rename(tmp, sn.path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// capture lists the cached entries, hottest first.  Each shard is ordered by
+// its own eviction policy; the shards are interleaved so that the hottest
+// entries of every shard come before the coldest entries of any.
+func (sn *snapshotter) capture() []*entry {
+	perShard := make([][]*entry, len(sn.srv.shards))
+	total := 0
+	for i, s := range sn.srv.shards {
+		internal.Locked(&s.mutex, func() {
+			for _, addr := range s.policy.Keys() {
+				if e := s.byAddr[addr]; e != nil {
+					perShard[i] = append(perShard[i], e)
+				}
+			}
+		})
+		total += len(perShard[i])
+	}
+	out := make([]*entry, 0, total)
+	for rank := 0; len(out) < total; rank++ {
+		for _, list := range perShard {
+			if rank < len(list) {
+				out = append(out, list[rank])
+			}
+		}
+	}
+	return out
+}
+
+func (sn *snapshotter) write(w io.Writer, entries []*entry) error {
+	bw := bufio.NewWriter(w)
+	var header [12]byte
+	binary.BigEndian.PutUint32(header[0:4], snapshotMagic)
+	header[4] = snapshotVersion
+	if sn.blocks {
+		header[5] = snapshotFlagBlocks
+	}
+	binary.BigEndian.PutUint32(header[8:12], uint32(len(entries)))
+	bw.Write(header[:])
+	for _, e := range entries {
+		bw.Write(e.addr[:])
+		if sn.blocks {
+			data := e.block.Trim()
+			var size [4]byte
+			binary.BigEndian.PutUint32(size[:], uint32(len(data)))
+			bw.Write(size[:])
+			bw.Write(data)
+		}
+	}
+	return bw.Flush()
+}
+
+// read loads at most limit entries from the snapshot.  Saved blocks that
+// don't match their addresses are discarded, leaving the address to be
+// fetched from the backend.
+func (sn *snapshotter) read(limit int) ([]snapshotEntry, error) {
+	f, err := os.Open(sn.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+
+	var header [12]byte
+	if _, err := io.ReadFull(br, header[:]); err != nil {
+		return nil, fmt.Errorf("file is too short: %v", err)
+	}
+	if magic := binary.BigEndian.Uint32(header[0:4]); magic != snapshotMagic {
+		return nil, fmt.Errorf("file has incorrect magic: expected %08x, got %08x", snapshotMagic, magic)
+	}
+	if header[4] != snapshotVersion || header[5]&^snapshotFlagBlocks != 0 || header[6] != 0 || header[7] != 0 {
+		return nil, fmt.Errorf("file has unsupported version %d", header[4])
+	}
+	hasBlocks := header[5]&snapshotFlagBlocks != 0
+	n := int(binary.BigEndian.Uint32(header[8:12]))
+	if n > limit {
+		n = limit
+	}
+
+	entries := make([]snapshotEntry, 0, n)
+	data := make([]byte, common.BlockSize)
+	for i := 0; i < n; i++ {
+		var se snapshotEntry
+		if _, err := io.ReadFull(br, se.addr[:]); err != nil {
+			return nil, fmt.Errorf("entry %d: %v", i, err)
+		}
+		if hasBlocks {
+			var size [4]byte
+			if _, err := io.ReadFull(br, size[:]); err != nil {
+				return nil, fmt.Errorf("entry %d: %v", i, err)
+			}
+			length := binary.BigEndian.Uint32(size[:])
+			if length > common.BlockSize {
+				return nil, fmt.Errorf("entry %d: block is too long: %d bytes", i, length)
+			}
+			if _, err := io.ReadFull(br, data[:length]); err != nil {
+				return nil, fmt.Errorf("entry %d: %v", i, err)
+			}
+			block := &common.Block{}
+			block.Pad(data[:length])
+			if err := common.Verify(se.addr, block.Addr()); err != nil {
+				log.Printf("warn: cache snapshot entry %d: %v", i, err)
+			} else {
+				se.block = block
+			}
+		}
+		entries = append(entries, se)
+	}
+	return entries, nil
+}
+
+// Warm fills the cache from the snapshot, if there is one.  Blocks not saved
+// in the snapshot are fetched from the backend, several at a time.  Entries
+// are loaded coldest first, so that the hottest end up most recently used.
+func (sn *snapshotter) Warm() {
+	if sn == nil {
+		return
+	}
+	select {
+	case <-sn.closech:
+		return
+	default:
+	}
+	sn.warmwg.Add(1)
+	defer sn.warmwg.Done()
+	internal.Locked(&sn.mutex, func() { sn.partial = true })
+
+	start := time.Now()
+	entries, err := sn.read(int(sn.srv.limit))
+	if os.IsNotExist(err) {
+		log.Printf("info: no cache snapshot %q; starting cold", sn.path)
+		internal.Locked(&sn.mutex, func() { sn.partial = false })
+		return
+	}
+	if err != nil {
+		log.Printf("warn: discarding cache snapshot %q: %v", sn.path, err)
+		internal.Locked(&sn.mutex, func() { sn.partial = false })
+		return
+	}
+
+	queue := make(chan snapshotEntry)
+	var wg sync.WaitGroup
+	var failed uint64
+	var failedMutex sync.Mutex
+	wg.Add(sn.concurrency)
+	for i := 0; i < sn.concurrency; i++ {
+		go func() {
+			defer wg.Done()
+			for se := range queue {
+				if err := sn.srv.load(se.addr, se.block, false); err != nil {
+					log.Printf("warn: failed to warm %v: %v", se.addr, err)
+					internal.Locked(&failedMutex, func() { failed++ })
+				}
+			}
+		}()
+	}
+	loaded := 0
+feed:
+	for i := len(entries) - 1; i >= 0; i-- {
+		select {
+		case queue <- entries[i]:
+			loaded++
+		case <-sn.closech:
+			break feed
+		}
+	}
+	close(queue)
+	wg.Wait()
+	if loaded < len(entries) {
+		log.Printf("info: interrupted warming cache from snapshot %q after %d of %d blocks",
+			sn.path, loaded, len(entries))
+		return
+	}
+	internal.Locked(&sn.mutex, func() { sn.partial = false })
+	log.Printf("info: warmed cache from snapshot %q: %d of %d blocks (%d failed) in %v",
+		sn.path, uint64(loaded)-failed, len(entries), failed, time.Since(start))
+}
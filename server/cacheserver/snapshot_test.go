@@ -0,0 +1,193 @@
+package cacheserver
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cloud9-tools/go-cas/proto"
+)
+
+func tempSnapshot(t *testing.T) (dir, path string) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, filepath.Join(dir, "snapshot")
+}
+
+// newSnapshotServer returns a test server holding entries, oldest first.
+func newSnapshotServer(backend *fakeBackend, entries ...*entry) (*Server, *backendPool) {
+	p, _ := newTestPool(false, backend)
+	srv := newTestServer(p)
+	srv.limit = 100
+	for _, e := range entries {
+		srv.shardFor(e.addr).TryInsert(e)
+	}
+	return srv, p
+}
+
+func TestSnapshot_roundTrip(t *testing.T) {
+	dir, path := tempSnapshot(t)
+	defer os.RemoveAll(dir)
+
+	var entries []*entry
+	for i := 0; i < 3; i++ {
+		entries = append(entries, testEntry(i, proto.CacheHints_NORMAL))
+	}
+	for _, blocks := range []bool{false, true} {
+		srv, p := newSnapshotServer(&fakeBackend{}, entries...)
+		sn := newSnapshotter(srv, path, blocks, 1)
+		if err := sn.Save(); err != nil {
+			t.Fatalf("blocks=%v: Save: %v", blocks, err)
+		}
+		got, err := sn.read(100)
+		if err != nil {
+			t.Fatalf("blocks=%v: read: %v", blocks, err)
+		}
+		if len(got) != len(entries) {
+			t.Fatalf("blocks=%v: expected %d entries, got %d", blocks, len(entries), len(got))
+		}
+		// Hottest first, i.e. the reverse of insertion order.
+		for i, se := range got {
+			e := entries[len(entries)-1-i]
+			if se.addr != e.addr {
+				t.Errorf("blocks=%v: [%d] expected %v, got %v", blocks, i, e.addr, se.addr)
+			}
+			if blocks && (se.block == nil || *se.block != *e.block) {
+				t.Errorf("blocks=%v: [%d] expected the saved block", blocks, i)
+			}
+			if !blocks && se.block != nil {
+				t.Errorf("blocks=%v: [%d] expected no block", blocks, i)
+			}
+		}
+		if got, err := sn.read(2); err != nil || len(got) != 2 {
+			t.Errorf("blocks=%v: read(2): expected 2 entries, got %d, %v", blocks, len(got), err)
+		}
+		p.Close()
+	}
+}
+
+func TestSnapshot_corruptEntry(t *testing.T) {
+	dir, path := tempSnapshot(t)
+	defer os.RemoveAll(dir)
+
+	e := testEntry(1, proto.CacheHints_NORMAL)
+	srv, p := newSnapshotServer(&fakeBackend{}, e)
+	sn := newSnapshotter(srv, path, true, 1)
+	if err := sn.Save(); err != nil {
+		t.Fatal(err)
+	}
+	p.Close()
+
+	// Flip the last byte of the saved block.
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw[len(raw)-1] ^= 0xff
+	if err := ioutil.WriteFile(path, raw, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	backend := &fakeBackend{keep: true}
+	backend.blocks = map[string][]byte{e.addr.String(): e.block.Trim()}
+	srv, p = newSnapshotServer(backend)
+	defer p.Close()
+	sn = newSnapshotter(srv, path, true, 1)
+	got, err := sn.read(100)
+	if err != nil || len(got) != 1 {
+		t.Fatalf("read: expected 1 entry, got %d, %v", len(got), err)
+	}
+	if got[0].addr != e.addr || got[0].block != nil {
+		t.Errorf("read: expected the corrupt block to be dropped, keeping its address")
+	}
+	sn.Warm()
+	if gets, _, _ := backend.counts(); gets != 1 {
+		t.Errorf("Warm: expected the block to be refetched, got %d gets", gets)
+	}
+	if c := srv.shardFor(e.addr).byAddr[e.addr]; c == nil || *c.block != *e.block {
+		t.Errorf("Warm: expected the refetched block to be cached")
+	}
+}
+
+func TestSnapshot_partial(t *testing.T) {
+	dir, path := tempSnapshot(t)
+	defer os.RemoveAll(dir)
+
+	srv, p := newSnapshotServer(&fakeBackend{}, testEntry(1, proto.CacheHints_NORMAL))
+	defer p.Close()
+	sn := newSnapshotter(srv, path, false, 1)
+	sn.partial = true
+	if err := sn.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected Save to do nothing while partial, got %v", err)
+	}
+}
+
+func TestSnapshot_warmInterrupted(t *testing.T) {
+	dir, path := tempSnapshot(t)
+	defer os.RemoveAll(dir)
+
+	var entries []*entry
+	for i := 0; i < 3; i++ {
+		entries = append(entries, testEntry(i, proto.CacheHints_NORMAL))
+	}
+	srv, p := newSnapshotServer(&fakeBackend{}, entries...)
+	if err := newSnapshotter(srv, path, false, 1).Save(); err != nil {
+		t.Fatal(err)
+	}
+	p.Close()
+	before, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan struct{}, len(entries))
+	release := make(chan struct{})
+	backend := &fakeBackend{onGet: func() {
+		started <- struct{}{}
+		<-release
+	}}
+	srv, p = newSnapshotServer(backend)
+	defer p.Close()
+	sn := newSnapshotter(srv, path, false, 1)
+	go sn.loop(0)
+	warmed := make(chan struct{})
+	go func() {
+		sn.Warm()
+		close(warmed)
+	}()
+	<-started
+
+	closed := make(chan error)
+	go func() { closed <- sn.Close() }()
+	waitFor(t, "Close to interrupt Warm", func() bool {
+		select {
+		case <-sn.closech:
+			return true
+		default:
+			return false
+		}
+	})
+	close(release)
+	<-warmed
+	if err := <-closed; err != nil {
+		t.Fatal(err)
+	}
+
+	if gets, _, _ := backend.counts(); gets >= len(entries) {
+		t.Errorf("expected Warm to stop early, got %d of %d gets", gets, len(entries))
+	}
+	after, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Errorf("expected the previous snapshot to survive an interrupted Warm")
+	}
+}
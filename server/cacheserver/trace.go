@@ -0,0 +1,39 @@
+package cacheserver
+
+import (
+	"log"
+	"os"
+	"sync"
+
+	"github.com/cloud9-tools/go-cas/common"
+)
+
+// tracer records the address of every Get, one hex address per line, so that
+// the workload can be replayed later by cassim.
+type tracer struct {
+	mutex sync.Mutex
+	f     *os.File
+}
+
+func openTracer(path string) (*tracer, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, err
+	}
+	return &tracer{f: f}, nil
+}
+
+func (t *tracer) Record(addr common.Addr) {
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if _, err := t.f.WriteString(addr.String() + "\n"); err != nil {
+		log.Printf("warn: failed to write trace: %v", err)
+	}
+}
+
+func (t *tracer) Close() error {
+	return t.f.Close()
+}
@@ -0,0 +1,292 @@
+package cacheserver
+
+import (
+	"log"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/cloud9-tools/go-cas/client"
+	"github.com/cloud9-tools/go-cas/common"
+	"github.com/cloud9-tools/go-cas/proto"
+	"github.com/cloud9-tools/go-cas/server/fs"
+	"github.com/cloud9-tools/go-multierror"
+)
+
+const writeBackTimeout = 30 * time.Second
+const writeBackMinDelay = 100 * time.Millisecond
+const writeBackMaxDelay = time.Minute
+
+// writeBack is the outbound queue for write-back mode.  Put acknowledges a
+// block as soon as it is durable in the journal, a directory-per-object
+// layout on local disk, and a single goroutine drains the journal to the
+// backend in arrival order, retrying transient failures with exponential
+// backoff.  Blocks that the backend rejects outright are moved to a
+// dead-letter journal in the "dead" subdirectory, so that they don't hold up
+// the rest of the queue.  Blocks left in the journal at shutdown are drained
+// on the next start.
+type writeBack struct {
+	mutex    sync.Mutex
+	cond     *sync.Cond
+	objects  fs.ObjectSet
+	dead     fs.ObjectSet
+	fallback client.Client
+	queue    []common.Addr
+	queued   map[common.Addr]time.Time
+	inflight common.Addr
+	busy     bool
+	closed   bool
+	drained  uint64
+	failures uint64
+	closech  chan struct{}
+	donech   chan struct{}
+}
+
+func openWriteBack(dir string, fallback client.Client) (*writeBack, error) {
+	objects, err := fs.NativeFileSystem{RootDir: dir}.OpenObjects(fs.ReadWrite)
+	if err != nil {
+		return nil, err
+	}
+	dead, err := fs.NativeFileSystem{RootDir: filepath.Join(dir, "dead")}.OpenObjects(fs.ReadWrite)
+	if err != nil {
+		objects.Close()
+		return nil, err
+	}
+	list, err := objects.ListObjects()
+	if err != nil {
+		dead.Close()
+		objects.Close()
+		return nil, err
+	}
+	w := &writeBack{
+		objects:  objects,
+		dead:     dead,
+		fallback: fallback,
+		queue:    list,
+		queued:   make(map[common.Addr]time.Time, len(list)),
+		closech:  make(chan struct{}),
+		donech:   make(chan struct{}),
+	}
+	w.cond = sync.NewCond(&w.mutex)
+	now := time.Now()
+	for _, addr := range list {
+		w.queued[addr] = now
+	}
+	if len(list) > 0 {
+		log.Printf("info: write-back journal %q: %d blocks pending from previous run", objects.Name(), len(list))
+	}
+	go w.loop()
+	return w, nil
+}
+
+// Close stops draining.  Pending blocks stay in the journal.
+func (w *writeBack) Close() error {
+	if w == nil {
+		return nil
+	}
+	w.mutex.Lock()
+	w.closed = true
+	w.cond.Broadcast()
+	w.mutex.Unlock()
+	close(w.closech)
+	<-w.donech
+	return multierror.Of(w.objects.Close(), w.dead.Close())
+}
+
+// Append makes block durable in the journal and queues it for the backend.
+// It returns false if addr was already queued.
+func (w *writeBack) Append(addr common.Addr, block *common.Block) (bool, error) {
+	w.mutex.Lock()
+	_, found := w.queued[addr]
+	w.mutex.Unlock()
+	if found {
+		return false, nil
+	}
+	if err := w.objects.WriteObject(addr, block); err != nil {
+		return false, err
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.queued[addr] = time.Now()
+	w.queue = append(w.queue, addr)
+	w.cond.Broadcast()
+	return true, nil
+}
+
+// Get returns the cache entry for a block that has not reached the backend
+// yet, or nil.
+func (w *writeBack) Get(addr common.Addr) *entry {
+	if w == nil {
+		return nil
+	}
+	w.mutex.Lock()
+	_, found := w.queued[addr]
+	w.mutex.Unlock()
+	if !found {
+		return nil
+	}
+	block := &common.Block{}
+	if err := w.objects.ReadObject(addr, block); err != nil {
+		// Most likely it was drained in the meantime.
+		return nil
+	}
+	if common.Verify(addr, block.Addr()) != nil {
+		return nil
+	}
+	return &entry{addr: addr, block: block}
+}
+
+// Cancel drops addr from the queue, so that a Remove is not undone by a
+// later drain.  If addr is being drained right now, Cancel waits for that to
+// finish first.
+func (w *writeBack) Cancel(addr common.Addr) {
+	if w == nil {
+		return
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	for w.busy && w.inflight == addr {
+		w.cond.Wait()
+	}
+	if _, found := w.queued[addr]; !found {
+		return
+	}
+	delete(w.queued, addr)
+	for i, x := range w.queue {
+		if x == addr {
+			w.queue = append(w.queue[:i], w.queue[i+1:]...)
+			break
+		}
+	}
+	if err := w.objects.EraseObject(addr, false); err != nil {
+		log.Printf("warn: failed to erase %v from write-back journal: %v", addr, err)
+	}
+}
+
+// Stats returns the number of blocks waiting for the backend, and how long
+// the oldest of them has been waiting.
+func (w *writeBack) Stats() (depth int, lag time.Duration) {
+	if w == nil {
+		return 0, 0
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if len(w.queue) > 0 {
+		lag = time.Since(w.queued[w.queue[0]])
+	}
+	return len(w.queue), lag
+}
+
+func (w *writeBack) loop() {
+	defer close(w.donech)
+	delay := writeBackMinDelay
+	for {
+		w.mutex.Lock()
+		for len(w.queue) == 0 && !w.closed {
+			w.cond.Wait()
+		}
+		if w.closed {
+			w.mutex.Unlock()
+			return
+		}
+		addr := w.queue[0]
+		w.inflight = addr
+		w.busy = true
+		w.mutex.Unlock()
+
+		err := w.push(addr)
+		permanent := err != nil && isPermanent(err)
+		if err == nil {
+			if err := w.objects.EraseObject(addr, false); err != nil && err != fs.ErrNotFound {
+				log.Printf("warn: failed to erase %v from write-back journal: %v", addr, err)
+			}
+		} else if permanent {
+			log.Printf("error: write-back of %v failed for good, moving it to the dead-letter journal: %v", addr, err)
+			if err := w.bury(addr); err != nil {
+				log.Printf("error: failed to move %v to the dead-letter journal: %v", addr, err)
+			}
+		}
+
+		w.mutex.Lock()
+		w.busy = false
+		if err == nil || permanent {
+			w.queue = w.queue[1:]
+			delete(w.queued, addr)
+		}
+		if err == nil {
+			w.drained++
+		} else {
+			w.failures++
+		}
+		depth := len(w.queue)
+		w.cond.Broadcast()
+		w.mutex.Unlock()
+
+		if err == nil || permanent {
+			delay = writeBackMinDelay
+			continue
+		}
+		log.Printf("warn: write-back of %v failed, retrying in %v (%d blocks pending): %v", addr, delay, depth, err)
+		select {
+		case <-w.closech:
+		case <-time.After(delay):
+		}
+		delay *= 2
+		if delay > writeBackMaxDelay {
+			delay = writeBackMaxDelay
+		}
+	}
+}
+
+// push sends addr from the journal to the backend.  A block that has
+// vanished from the journal or no longer matches its address is reported as
+// DataLoss, so that it is counted as a failure and buried, not drained.
+func (w *writeBack) push(addr common.Addr) error {
+	var block common.Block
+	if err := w.objects.ReadObject(addr, &block); err != nil {
+		if err == fs.ErrNotFound {
+			return grpc.Errorf(codes.DataLoss, "go-cas/server/cacheserver: block %v vanished from write-back journal", addr)
+		}
+		return err
+	}
+	if err := common.Verify(addr, block.Addr()); err != nil {
+		return grpc.Errorf(codes.DataLoss, "go-cas/server/cacheserver: corrupt block in write-back journal: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), writeBackTimeout)
+	defer cancel()
+	_, err := w.fallback.Put(ctx, &proto.PutRequest{
+		Addr:  addr.String(),
+		Block: block.Trim(),
+	})
+	return err
+}
+
+// bury moves addr from the journal to the dead-letter journal.
+func (w *writeBack) bury(addr common.Addr) error {
+	var block common.Block
+	if err := w.objects.ReadObject(addr, &block); err != nil {
+		return err
+	}
+	if err := w.dead.WriteObject(addr, &block); err != nil {
+		return err
+	}
+	return w.objects.EraseObject(addr, false)
+}
+
+// isPermanent returns true iff err, returned by push, means that the same
+// push would fail again however long we waited.  I/O errors from the journal
+// itself are not permanent, but a lost or corrupt journal entry is.
+func isPermanent(err error) bool {
+	switch grpc.Code(err) {
+	case codes.InvalidArgument, codes.PermissionDenied, codes.Unauthenticated,
+		codes.FailedPrecondition, codes.DataLoss, codes.Unimplemented:
+		return true
+	case codes.ResourceExhausted:
+		return !client.IsRateLimited(err)
+	}
+	return false
+}
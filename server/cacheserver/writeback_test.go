@@ -0,0 +1,261 @@
+package cacheserver
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/cloud9-tools/go-cas/proto"
+	"github.com/cloud9-tools/go-cas/server/fs"
+)
+
+func tempJournal(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "writeback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// waitFor polls fn until it returns true, or fails the test after a while.
+func waitFor(t *testing.T, what string, fn func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !fn() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func journaled(t *testing.T, objects fs.ObjectSet) int {
+	list, err := objects.ListObjects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(list)
+}
+
+func (w *writeBack) counters() (drained, failures uint64) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.drained, w.failures
+}
+
+func TestWriteBack_replay(t *testing.T) {
+	dir := tempJournal(t)
+	defer os.RemoveAll(dir)
+
+	down := &fakeBackend{putErr: grpc.Errorf(codes.Unavailable, "down")}
+	w, err := openWriteBack(dir, down)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		e := testEntry(i, proto.CacheHints_NORMAL)
+		if inserted, err := w.Append(e.addr, e.block); err != nil || !inserted {
+			t.Fatalf("[%d] Append: inserted=%v err=%v", i, inserted, err)
+		}
+	}
+	e := testEntry(0, proto.CacheHints_NORMAL)
+	if inserted, _ := w.Append(e.addr, e.block); inserted {
+		t.Errorf("Append of a queued block: expected inserted=false")
+	}
+	if got := w.Get(e.addr); got == nil || *got.block != *e.block {
+		t.Errorf("Get: expected the queued block")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	up := &fakeBackend{}
+	w, err = openWriteBack(dir, up)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	waitFor(t, "the replayed journal to drain", func() bool {
+		depth, _ := w.Stats()
+		return depth == 0
+	})
+	if _, puts, _ := up.counts(); puts != 3 {
+		t.Errorf("expected 3 puts after replay, got %d", puts)
+	}
+	if drained, _ := w.counters(); drained != 3 {
+		t.Errorf("expected 3 blocks drained, got %d", drained)
+	}
+	if n := journaled(t, w.objects); n != 0 {
+		t.Errorf("expected an empty journal, got %d blocks", n)
+	}
+}
+
+func TestWriteBack_statsAndBackoff(t *testing.T) {
+	dir := tempJournal(t)
+	defer os.RemoveAll(dir)
+
+	down := &fakeBackend{putErr: grpc.Errorf(codes.Unavailable, "down")}
+	w, err := openWriteBack(dir, down)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	e := testEntry(1, proto.CacheHints_NORMAL)
+	w.Append(e.addr, e.block)
+
+	// Retries come after 100ms, then 200ms, then 400ms, ...
+	time.Sleep(4 * writeBackMinDelay)
+	depth, lag := w.Stats()
+	if depth != 1 {
+		t.Errorf("expected 1 block pending, got %d", depth)
+	}
+	if lag < 4*writeBackMinDelay {
+		t.Errorf("expected a lag of at least %v, got %v", 4*writeBackMinDelay, lag)
+	}
+	_, puts, _ := down.counts()
+	if puts < 2 || puts > 4 {
+		t.Errorf("expected 2 to 4 attempts with backoff, got %d", puts)
+	}
+	if _, failures := w.counters(); failures != uint64(puts) && failures+1 != uint64(puts) {
+		t.Errorf("expected a failure per attempt, got %d failures for %d attempts", failures, puts)
+	}
+	if n := journaled(t, w.objects); n != 1 {
+		t.Errorf("expected the block to stay journaled, got %d blocks", n)
+	}
+}
+
+func TestWriteBack_permanentFailure(t *testing.T) {
+	dir := tempJournal(t)
+	defer os.RemoveAll(dir)
+
+	denied := &fakeBackend{putErr: grpc.Errorf(codes.PermissionDenied, "access denied")}
+	w, err := openWriteBack(dir, denied)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	for i := 0; i < 2; i++ {
+		e := testEntry(i, proto.CacheHints_NORMAL)
+		w.Append(e.addr, e.block)
+	}
+	waitFor(t, "rejected blocks to leave the queue", func() bool {
+		depth, _ := w.Stats()
+		return depth == 0
+	})
+	if _, puts, _ := denied.counts(); puts != 2 {
+		t.Errorf("expected each rejected block to be tried once, got %d puts", puts)
+	}
+	if n := journaled(t, w.objects); n != 0 {
+		t.Errorf("expected rejected blocks to leave the journal, got %d", n)
+	}
+	if n := journaled(t, w.dead); n != 2 {
+		t.Errorf("expected 2 blocks in the dead-letter journal, got %d", n)
+	}
+}
+
+func TestWriteBack_cancel(t *testing.T) {
+	dir := tempJournal(t)
+	defer os.RemoveAll(dir)
+
+	release := make(chan struct{})
+	slow := &fakeBackend{onPut: func() { <-release }}
+	w, err := openWriteBack(dir, slow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	inflight := testEntry(1, proto.CacheHints_NORMAL)
+	queued := testEntry(2, proto.CacheHints_NORMAL)
+	w.Append(inflight.addr, inflight.block)
+	waitFor(t, "the first block to be in flight", func() bool {
+		_, puts, _ := slow.counts()
+		return puts == 1
+	})
+	w.Append(queued.addr, queued.block)
+
+	// A block that is only queued is cancelled at once, and never sent.
+	w.Cancel(queued.addr)
+	if depth, _ := w.Stats(); depth != 1 {
+		t.Errorf("expected 1 block pending after cancelling the queued one, got %d", depth)
+	}
+
+	// A block in flight can't be recalled, so Cancel waits for it.
+	done := make(chan struct{})
+	go func() {
+		w.Cancel(inflight.addr)
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatalf("Cancel returned while the block was still in flight")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	<-done
+
+	if depth, _ := w.Stats(); depth != 0 {
+		t.Errorf("expected an empty queue, got %d", depth)
+	}
+	if _, puts, _ := slow.counts(); puts != 1 {
+		t.Errorf("expected only the in-flight block to be sent, got %d puts", puts)
+	}
+	if n := journaled(t, w.objects); n != 0 {
+		t.Errorf("expected an empty journal, got %d blocks", n)
+	}
+	if w.Get(inflight.addr) != nil || w.Get(queued.addr) != nil {
+		t.Errorf("expected Get to miss after Cancel")
+	}
+}
+
+func TestWriteBack_corruptJournal(t *testing.T) {
+	dir := tempJournal(t)
+	defer os.RemoveAll(dir)
+
+	down := &fakeBackend{putErr: grpc.Errorf(codes.Unavailable, "down")}
+	w, err := openWriteBack(dir, down)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := testEntry(1, proto.CacheHints_NORMAL)
+	other := testEntry(2, proto.CacheHints_NORMAL)
+	w.Append(e.addr, e.block)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Overwrite the journaled block with some other block's data.
+	objects, err := fs.NativeFileSystem{RootDir: dir}.OpenObjects(fs.ReadWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := objects.WriteObject(e.addr, other.block); err != nil {
+		t.Fatal(err)
+	}
+	objects.Close()
+
+	up := &fakeBackend{}
+	w, err = openWriteBack(dir, up)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	waitFor(t, "the corrupt block to leave the queue", func() bool {
+		depth, _ := w.Stats()
+		return depth == 0
+	})
+	if _, puts, _ := up.counts(); puts != 0 {
+		t.Errorf("expected the corrupt block not to be sent, got %d puts", puts)
+	}
+	if drained, failures := w.counters(); drained != 0 || failures != 1 {
+		t.Errorf("expected 0 drained and 1 failure, got %d and %d", drained, failures)
+	}
+	if n := journaled(t, w.objects); n != 0 {
+		t.Errorf("expected the corrupt block to leave the journal, got %d blocks", n)
+	}
+	if n := journaled(t, w.dead); n != 1 {
+		t.Errorf("expected the corrupt block in the dead-letter journal, got %d", n)
+	}
+}
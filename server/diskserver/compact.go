@@ -0,0 +1,152 @@
+package diskserver
+
+import (
+	"log"
+
+	"github.com/cloud9-tools/go-cas/common"
+	"github.com/cloud9-tools/go-cas/server/fs"
+	"github.com/cloud9-tools/go-multierror"
+)
+
+// Compact consolidates partially-filled slabs of the same size class by
+// moving blocks out of the emptiest slab and into the fullest one.  Slots
+// that end up empty are returned to the free list.
+func (store *SlabStore) Compact() error {
+	store.Metadata.Mutex.Lock()
+	defer store.Metadata.Mutex.Unlock()
+
+	var errors []error
+	for class := SizeClass(0); class < ClassFull; class++ {
+		for {
+			src, dst, ok := store.Metadata.compactionPair(class)
+			if !ok {
+				break
+			}
+			if err := store.evacuate(src, dst); err != nil {
+				errors = append(errors, err)
+				break
+			}
+		}
+	}
+	return multierror.New(errors)
+}
+
+// compactionPair picks the least-full and the most-full of the non-full
+// slabs of class.  Each evacuation either empties src or fills dst, so
+// repeated calls always terminate.
+func (md *Metadata) compactionPair(class SizeClass) (src, dst uint32, ok bool) {
+	var srcSlab, dstSlab *Slab
+	for n, s := range md.Slabs {
+		if s.Class != class || s.IsFull() {
+			continue
+		}
+		if srcSlab == nil || s.Count() < srcSlab.Count() ||
+			(s.Count() == srcSlab.Count() && n > src) {
+			src, srcSlab = n, s
+		}
+	}
+	if srcSlab == nil {
+		return
+	}
+	for n, s := range md.Slabs {
+		if n == src || s.Class != class || s.IsFull() {
+			continue
+		}
+		if dstSlab == nil || s.Count() > dstSlab.Count() ||
+			(s.Count() == dstSlab.Count() && n < dst) {
+			dst, dstSlab = n, s
+		}
+	}
+	ok = (dstSlab != nil)
+	return
+}
+
+// evacuate moves as many blocks as will fit from the slab at src into the
+// slab at dst.  The new copies are written and the metadata is saved before
+// the old copies are wiped, so a crash part-way through loses nothing.  If
+// either write fails, the in-memory metadata is rolled back, so that a later
+// WriteMetadata can't save locations that were never written.
+func (store *SlabStore) evacuate(src, dst uint32) error {
+	md := &store.Metadata
+	var srcSlot, dstSlot common.Block
+	if err := store.DataFile.ReadBlock(src, &srcSlot); err != nil {
+		return err
+	}
+	if err := store.DataFile.ReadBlock(dst, &dstSlot); err != nil {
+		return err
+	}
+
+	undo := md.saveMoves(src, dst)
+	var moved []UsedBlock
+	released := false
+	for slot := range md.Used {
+		if released || md.Slabs[dst].IsFull() {
+			break
+		}
+		if md.Used[slot].BlockNumber != src {
+			continue
+		}
+		from, to, r, ok := md.Move(slot, dst)
+		if !ok {
+			break
+		}
+		undo.slots = append(undo.slots, slot)
+		undo.from = append(undo.from, from)
+		chunk := ClassFor(from.Length).ChunkSize()
+		copy(dstSlot[to.Offset:to.Offset+chunk], srcSlot[from.Offset:from.Offset+chunk])
+		copy(srcSlot[from.Offset:from.Offset+chunk], fs.Empty[:])
+		moved = append(moved, from)
+		released = r
+	}
+	if len(moved) == 0 {
+		return nil
+	}
+
+	if err := store.DataFile.WriteBlock(dst, &dstSlot); err != nil {
+		md.undoMoves(undo)
+		return err
+	}
+	if err := WriteMetadata(store.MetadataFile, store.BackupFile, md); err != nil {
+		md.undoMoves(undo)
+		return err
+	}
+	log.Printf("info: Compact: moved %d blocks from slot %d to slot %d", len(moved), src, dst)
+	if released {
+		return store.DataFile.EraseBlock(src, false)
+	}
+	return store.DataFile.WriteBlock(src, &srcSlot)
+}
+
+// moveUndo records what a series of Moves from src to dst changed.
+type moveUndo struct {
+	src, dst  uint32
+	srcSlab   Slab
+	dstSlab   Slab
+	free      FreeBlockList
+	minUnused uint32
+	slots     []int
+	from      []UsedBlock
+}
+
+func (md *Metadata) saveMoves(src, dst uint32) *moveUndo {
+	return &moveUndo{
+		src:       src,
+		dst:       dst,
+		srcSlab:   *md.Slabs[src],
+		dstSlab:   *md.Slabs[dst],
+		free:      append(FreeBlockList(nil), md.Free...),
+		minUnused: md.MinUnused,
+	}
+}
+
+// undoMoves puts md back the way it was before the Moves recorded in u.
+func (md *Metadata) undoMoves(u *moveUndo) {
+	for i, slot := range u.slots {
+		md.Used[slot] = u.from[i]
+	}
+	srcSlab, dstSlab := u.srcSlab, u.dstSlab
+	md.Slabs[u.src] = &srcSlab
+	*md.Slabs[u.dst] = dstSlab
+	md.Free = u.free
+	md.MinUnused = u.minUnused
+}
@@ -0,0 +1,159 @@
+package diskserver
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/cloud9-tools/go-cas/common"
+)
+
+// memBlockFile is an in-memory fs.BlockFile.  Writes to the slots in
+// failWrite return an error.
+type memBlockFile struct {
+	blocks    map[uint32]common.Block
+	failWrite map[uint32]bool
+}
+
+func newMemBlockFile() *memBlockFile {
+	return &memBlockFile{
+		blocks:    make(map[uint32]common.Block),
+		failWrite: make(map[uint32]bool),
+	}
+}
+
+func (f *memBlockFile) Name() string { return "data" }
+func (f *memBlockFile) Close() error { return nil }
+
+func (f *memBlockFile) ReadBlock(blknum uint32, block *common.Block) error {
+	*block = f.blocks[blknum]
+	return nil
+}
+
+func (f *memBlockFile) WriteBlock(blknum uint32, block *common.Block) error {
+	if f.failWrite[blknum] {
+		return errors.New("write failed")
+	}
+	f.blocks[blknum] = *block
+	return nil
+}
+
+func (f *memBlockFile) EraseBlock(blknum uint32, shred bool) error {
+	delete(f.blocks, blknum)
+	return nil
+}
+
+func newMemSlabStore() *SlabStore {
+	return &SlabStore{
+		MetadataFile: &memFile{name: "metadata"},
+		BackupFile:   &memFile{name: "metadata.backup"},
+		DataFile:     newMemBlockFile(),
+	}
+}
+
+// fragment fills one 64K slab with blocks[0:4] and starts a second with
+// blocks[4], then removes blocks[1], leaving two partial slabs.
+func fragment(t *testing.T, store *SlabStore) []common.Block {
+	blocks := make([]common.Block, 5)
+	for i := range blocks {
+		blocks[i].Pad(bytes.Repeat([]byte{byte('a' + i)}, 20000))
+		if inserted, err := store.Put(blocks[i].Addr(), &blocks[i]); err != nil || !inserted {
+			t.Fatalf("[%d] Put: inserted=%v err=%v", i, inserted, err)
+		}
+	}
+	if deleted, err := store.Remove(blocks[1].Addr(), false); err != nil || !deleted {
+		t.Fatalf("Remove: deleted=%v err=%v", deleted, err)
+	}
+	if n := len(store.Metadata.Slabs); n != 2 {
+		t.Fatalf("expected 2 slabs before Compact, got %d", n)
+	}
+	return blocks
+}
+
+// checkBlocks checks that every block but blocks[1] can be read back.
+func checkBlocks(t *testing.T, what string, store *SlabStore, blocks []common.Block) {
+	for i := range blocks {
+		var block common.Block
+		found, err := store.Get(blocks[i].Addr(), &block)
+		if i == 1 {
+			if found {
+				t.Errorf("%s: [%d] expected the removed block to be gone", what, i)
+			}
+			continue
+		}
+		if err != nil || !found || block != blocks[i] {
+			t.Errorf("%s: [%d] Get: found=%v err=%v match=%v", what, i, found, err, block == blocks[i])
+		}
+	}
+}
+
+func TestSlabStore_Compact(t *testing.T) {
+	store := newMemSlabStore()
+	blocks := fragment(t, store)
+
+	if err := store.Compact(); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	if n := len(store.Metadata.Slabs); n != 1 {
+		t.Errorf("expected 1 slab after Compact, got %d", n)
+	}
+	if store.Metadata.MinUnused != 1 {
+		t.Errorf("expected the emptied slot to be released, got MinUnused=%d", store.Metadata.MinUnused)
+	}
+	checkBlocks(t, "after Compact", store, blocks)
+
+	// The saved metadata agrees with the data file.
+	reopened := &SlabStore{
+		MetadataFile: store.MetadataFile,
+		BackupFile:   store.BackupFile,
+		DataFile:     store.DataFile,
+	}
+	if err := ReadMetadata(reopened.MetadataFile, reopened.BackupFile, &reopened.Metadata); err != nil {
+		t.Fatal(err)
+	}
+	checkBlocks(t, "after reload", reopened, blocks)
+}
+
+func TestSlabStore_CompactFailure(t *testing.T) {
+	store := newMemSlabStore()
+	blocks := fragment(t, store)
+	before := append(UsedBlockList(nil), store.Metadata.Used...)
+
+	data := store.DataFile.(*memBlockFile)
+	data.failWrite[0] = true
+	if err := store.Compact(); err == nil {
+		t.Fatalf("Compact: expected an error")
+	}
+	for i := range before {
+		if store.Metadata.Used[i] != before[i] {
+			t.Errorf("[%d] expected %+v after a failed Compact, got %+v", i, before[i], store.Metadata.Used[i])
+		}
+	}
+	if n := len(store.Metadata.Slabs); n != 2 {
+		t.Errorf("expected 2 slabs after a failed Compact, got %d", n)
+	}
+	checkBlocks(t, "after failed Compact", store, blocks)
+
+	// A later Put saves the metadata; it must not refer to the chunks that
+	// Compact failed to write.
+	var other common.Block
+	other.Pad([]byte("other"))
+	if _, err := store.Put(other.Addr(), &other); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	reopened := &SlabStore{
+		MetadataFile: store.MetadataFile,
+		BackupFile:   store.BackupFile,
+		DataFile:     store.DataFile,
+	}
+	if err := ReadMetadata(reopened.MetadataFile, reopened.BackupFile, &reopened.Metadata); err != nil {
+		t.Fatal(err)
+	}
+	checkBlocks(t, "after reload", reopened, blocks)
+
+	data.failWrite[0] = false
+	if err := store.Compact(); err != nil {
+		t.Fatalf("Compact retry: %v", err)
+	}
+	checkBlocks(t, "after Compact retry", store, blocks)
+}
@@ -4,38 +4,116 @@ import (
 	"flag"
 	"fmt"
 	"net"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/cloud9-tools/go-cas/common"
+	"github.com/cloud9-tools/go-cas/server/audit"
 	"github.com/cloud9-tools/go-cas/server/auth"
+	"github.com/cloud9-tools/go-cas/server/fs"
+	"github.com/cloud9-tools/go-cas/server/quota"
+	"github.com/cloud9-tools/go-cas/server/s3"
 )
 
 type Config struct {
-	Bind  string
-	Dir   string
-	Limit uint64
-	ACL   auth.ACL
+	Bind    string
+	Dir     string
+	Storage string
+	Limit   uint64
+	ACL     auth.ACL
+	S3      S3Config
+
+	TLS          common.TLSConfig
+	TLSIdentity  string
+	TokenKeyFile string
+	PeerCred     bool
+	PolicyFile   string
+	Audit        audit.Config
+	Limits       quota.Table
+
+	tokenKey []byte
+	policy   *auth.PolicyFile
+}
+
+type S3Config struct {
+	Endpoint        string
+	Bucket          string
+	Region          string
+	Prefix          string
+	AccessKeyID     string
+	SecretAccessKey string
+	Rebuild         bool
+	Retries         int
+	RetryDelay      time.Duration
 }
 
 func (cfg *Config) AddFlags(fs *flag.FlagSet) {
 	const l = 0
+	const s = "native"
 
 	if cfg.ACL == nil {
 		cfg.ACL = auth.AllowAll()
 	}
 
 	fs.Var(&cfg.ACL, "acl",
-		"access control list to apply to CAS RPCs")
+		"access control list to apply to CAS RPCs: comma-separated rules of"+
+			" the form role=allow|deny or role:op=allow|deny, where op is one"+
+			" of statfs, walk, get, put, remove; the first matching rule wins")
 	fs.StringVar(&cfg.Bind, "bind", "",
 		"address to listen on")
 	fs.StringVar(&cfg.Dir, "dir", "",
 		"directory in which to store CAS blocks")
+	fs.StringVar(&cfg.Storage, "storage", s,
+		"storage engine: \"native\" (one data file plus metadata), "+
+			"\"objects\" (one file per block), "+
+			"or \"s3\" (S3-compatible bucket, indexed in --dir)")
+	fs.StringVar(&cfg.S3.Endpoint, "s3_endpoint", "https://s3.amazonaws.com",
+		"base URL of the S3-compatible service")
+	fs.StringVar(&cfg.S3.Bucket, "s3_bucket", "",
+		"S3 bucket in which to store CAS blocks")
+	fs.StringVar(&cfg.S3.Region, "s3_region", "us-east-1",
+		"S3 region, for request signing")
+	fs.StringVar(&cfg.S3.Prefix, "s3_prefix", "",
+		"prefix to prepend to each S3 object key")
+	fs.StringVar(&cfg.S3.AccessKeyID, "s3_access_key_id", os.Getenv("AWS_ACCESS_KEY_ID"),
+		"S3 access key ID (default $AWS_ACCESS_KEY_ID)")
+	fs.StringVar(&cfg.S3.SecretAccessKey, "s3_secret_access_key", os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		"S3 secret access key (default $AWS_SECRET_ACCESS_KEY)")
+	fs.BoolVar(&cfg.S3.Rebuild, "s3_rebuild", false,
+		"rebuild the local index by listing the S3 bucket")
+	fs.IntVar(&cfg.S3.Retries, "s3_retries", 5,
+		"number of times to retry failed or not-yet-consistent S3 requests")
+	fs.DurationVar(&cfg.S3.RetryDelay, "s3_retry_delay", 100*time.Millisecond,
+		"delay before the first S3 retry; doubles with each retry")
 	fs.Uint64Var(&cfg.Limit, "limit", l,
 		"maximum number of blocks to store on diskserver "+
 			"("+common.BlockSizeHuman+" each)")
+	cfg.TLS.AddFlags(fs)
+	fs.StringVar(&cfg.TLSIdentity, "tls_identity", "cn",
+		"part of a client's TLS certificate that names its role in --acl; one of: "+
+			strings.Join(auth.CertFields, ", "))
+	fs.StringVar(&cfg.TokenKeyFile, "token_key", "",
+		"file containing the secret key that bearer tokens are signed with;"+
+			" if set, clients may identify themselves with tokens minted by"+
+			" \"casutil mint-token\", and may read individual object trees with"+
+			" capabilities minted by \"casutil mint-capability\"")
+	fs.StringVar(&cfg.PolicyFile, "policy_file", "",
+		"file of groups and ACL rules to use in place of --acl;"+
+			" reloaded on SIGHUP")
+	cfg.Audit.AddFlags(fs)
+	fs.Var(&cfg.Limits, "limits", "per-role limits: comma-separated role:kind=N,"+
+		" where kind is requests (per second), bytes (per second), or"+
+		" blocks (stored blocks owned); *:kind=N applies to each"+
+		" role not listed")
+	fs.BoolVar(&cfg.PeerCred, "peercred", false,
+		"identify clients on a unix: --bind socket by the user they run as;"+
+			" --acl may then name Unix groups as @group")
 
 	fs.Var(&cfg.ACL, "A", "alias for --acl")
 	fs.StringVar(&cfg.Bind, "B", "", "alias for --bind")
 	fs.StringVar(&cfg.Dir, "D", "", "alias for --dir")
+	fs.StringVar(&cfg.Storage, "S", s, "alias for --storage")
 	fs.Uint64Var(&cfg.Limit, "l", l, "alias for --limit")
 }
 
@@ -49,9 +127,91 @@ func (cfg *Config) Validate() error {
 	if _, _, err := common.ParseDialSpec(cfg.Bind); err != nil {
 		return fmt.Errorf("invalid flag --bind=%q: %v", cfg.Bind, err)
 	}
+	switch cfg.Storage {
+	case "", "native", "objects":
+	case "s3":
+		if cfg.S3.Bucket == "" {
+			return fmt.Errorf("missing required flag: --s3_bucket")
+		}
+		if cfg.S3.Endpoint == "" {
+			return fmt.Errorf("missing required flag: --s3_endpoint")
+		}
+	default:
+		return fmt.Errorf("invalid flag --storage=%q: must be \"native\", \"objects\", or \"s3\"", cfg.Storage)
+	}
+	if err := cfg.TLS.ValidateServer(); err != nil {
+		return err
+	}
+	if err := auth.ValidCertField(cfg.TLSIdentity); err != nil {
+		return fmt.Errorf("invalid flag --tls_identity=%q: %v", cfg.TLSIdentity, err)
+	}
+	if cfg.TokenKeyFile != "" {
+		key, err := auth.LoadTokenKey(cfg.TokenKeyFile)
+		if err != nil {
+			return fmt.Errorf("invalid flag --token_key=%q: %v", cfg.TokenKeyFile, err)
+		}
+		cfg.tokenKey = key
+	}
+	if err := cfg.Audit.Validate(); err != nil {
+		return err
+	}
+	if cfg.PolicyFile != "" {
+		policy, err := auth.LoadPolicyFile(cfg.PolicyFile)
+		if err != nil {
+			return fmt.Errorf("invalid flag --policy_file=%q: %v", cfg.PolicyFile, err)
+		}
+		cfg.policy = policy
+	}
+	if cfg.PeerCred {
+		if network, _, _ := common.ParseDialSpec(cfg.Bind); network != "unix" {
+			return fmt.Errorf("invalid flag --peercred: --bind=%q is not a unix: socket", cfg.Bind)
+		}
+	}
 	return nil
 }
 
+func (cfg *Config) NewStore() Store {
+	nfs := fs.NativeFileSystem{RootDir: cfg.Dir}
+	switch cfg.Storage {
+	case "objects":
+		return &ObjectStore{FS: nfs}
+	case "s3":
+		return &S3Store{
+			Client: &s3.Client{
+				Endpoint:        cfg.S3.Endpoint,
+				Bucket:          cfg.S3.Bucket,
+				Region:          cfg.S3.Region,
+				AccessKeyID:     cfg.S3.AccessKeyID,
+				SecretAccessKey: cfg.S3.SecretAccessKey,
+				Retries:         cfg.S3.Retries,
+				RetryDelay:      cfg.S3.RetryDelay,
+			},
+			Prefix:       cfg.S3.Prefix,
+			FS:           nfs,
+			Rebuild:      cfg.S3.Rebuild,
+			StaleRetries: cfg.S3.Retries,
+			StaleDelay:   cfg.S3.RetryDelay,
+		}
+	default:
+		return &SlabStore{FS: nfs}
+	}
+}
+
+// Auther identifies callers as the flags direct; see auth.NewAuther.
+// Validate must have been called first.
+func (cfg *Config) Auther() auth.Auther {
+	var certField string
+	if cfg.TLS.CAFile != "" {
+		certField = cfg.TLSIdentity
+	}
+	return auth.NewAuther(auth.AutherConfig{
+		CertField: certField,
+		TokenKey:  cfg.tokenKey,
+		PeerCred:  cfg.PeerCred,
+		Policy:    cfg.policy,
+	})
+}
+
 func (cfg *Config) Listen() (net.Listener, error) {
 	network, address, err := common.ParseDialSpec(cfg.Bind)
 	if err != nil {
@@ -61,6 +221,9 @@ func (cfg *Config) Listen() (net.Listener, error) {
 	if err != nil {
 		return nil, fmt.Errorf("%q, %q: %v", network, address, err)
 	}
+	if cfg.PeerCred {
+		listen = auth.PeerCredListener(listen)
+	}
 	return listen, nil
 
 }
@@ -12,7 +12,7 @@ import (
 )
 
 const metadataMagic = 0x63417344 // "cAsD"
-const metadataVersion = 0x01
+const metadataVersion = 0x02
 const maxuint32 = ^uint32(0)
 
 type Metadata struct {
@@ -20,12 +20,15 @@ type Metadata struct {
 	MinUnused  uint32
 	Used       UsedBlockList
 	Free       FreeBlockList
+	Slabs      map[uint32]*Slab
 	BackupData []byte
 }
 type UsedBlockList []UsedBlock
 type UsedBlock struct {
 	Addr        common.Addr
 	BlockNumber uint32
+	Offset      uint32
+	Length      uint32
 }
 type FreeBlockList []uint32
 
@@ -37,36 +40,50 @@ func (x FreeBlockList) Len() int           { return len(x) }
 func (x FreeBlockList) Less(i, j int) bool { return x[i] < x[j] }
 func (x FreeBlockList) Swap(i, j int)      { x[i], x[j] = x[j], x[i] }
 
-func (md *Metadata) Search(addr common.Addr) (slot int, blknum uint32, found bool) {
+func (md *Metadata) Search(addr common.Addr) (slot int, used UsedBlock, found bool) {
 	slot = sort.Search(len(md.Used), func(i int) bool {
 		return !md.Used[i].Addr.Less(addr)
 	})
 	if slot < len(md.Used) && md.Used[slot].Addr == addr {
-		blknum = md.Used[slot].BlockNumber
+		used = md.Used[slot]
 		found = true
 	}
 	return
 }
 
-func (md *Metadata) Insert(slot int, addr common.Addr) (blknum uint32, inserted bool) {
+// Insert allocates storage for a block of the given (trimmed) length.  If the
+// returned fresh is true, the slot was newly allocated and holds nothing else
+// worth preserving.
+func (md *Metadata) Insert(slot int, addr common.Addr, length uint32) (used UsedBlock, fresh bool, inserted bool) {
 	if slot < len(md.Used) && md.Used[slot].Addr == addr {
-		blknum = md.Used[slot].BlockNumber
+		used = md.Used[slot]
 		return
 	}
 
-	if len(md.Free) > 0 {
-		blknum = md.Free[0]
-		md.Free = md.Free[1:]
-	} else if md.MinUnused < maxuint32 {
-		blknum = md.MinUnused
-		md.MinUnused++
+	used = UsedBlock{Addr: addr, Length: length}
+	class := ClassFor(length)
+	if class == ClassFull {
+		blknum, ok := md.allocSlot()
+		if !ok {
+			return
+		}
+		used.BlockNumber = blknum
+		fresh = true
 	} else {
-		return
-	}
-
-	used := UsedBlock{
-		Addr:        addr,
-		BlockNumber: blknum,
+		blknum, s := md.findSlab(class)
+		if s == nil {
+			var ok bool
+			blknum, ok = md.allocSlot()
+			if !ok {
+				return
+			}
+			s = &Slab{Class: class}
+			md.Slabs[blknum] = s
+			fresh = true
+		}
+		chunk, _ := s.Alloc()
+		used.BlockNumber = blknum
+		used.Offset = chunk * class.ChunkSize()
 	}
 
 	md.Used = append(md.Used, used)
@@ -81,13 +98,15 @@ func (md *Metadata) Insert(slot int, addr common.Addr) (blknum uint32, inserted
 	return
 }
 
-func (md *Metadata) Remove(slot int, addr common.Addr) (minUnused uint32, deleted bool) {
+// Remove forgets the block at the given slot.  If released is true, the data
+// file slot that held it is now completely unused.
+func (md *Metadata) Remove(slot int, addr common.Addr) (used UsedBlock, released bool, deleted bool) {
 	max := len(md.Used) - 1
 	if slot > max || md.Used[slot].Addr != addr {
-		return maxuint32, false
+		return
 	}
 
-	blknum := md.Used[slot].BlockNumber
+	used = md.Used[slot]
 	for i := slot; i < max; i++ {
 		md.Used.Swap(i, i+1)
 	}
@@ -96,8 +115,73 @@ func (md *Metadata) Remove(slot int, addr common.Addr) (minUnused uint32, delete
 		panic("not sorted")
 	}
 
+	class := ClassFor(used.Length)
+	if class == ClassFull {
+		released = true
+	} else if s := md.Slabs[used.BlockNumber]; s != nil {
+		s.Release(used.Offset / class.ChunkSize())
+		released = s.IsEmpty()
+	} else {
+		released = true
+	}
+	if released {
+		delete(md.Slabs, used.BlockNumber)
+		md.releaseSlot(used.BlockNumber)
+	}
+	deleted = true
+	return
+}
+
+// Move relocates the block at the given slot to a free chunk of the slab at
+// blknum, returning its old and new locations.  The caller is responsible
+// for copying the data.
+func (md *Metadata) Move(slot int, blknum uint32) (from, to UsedBlock, released bool, ok bool) {
+	from = md.Used[slot]
+	class := ClassFor(from.Length)
+	dst := md.Slabs[blknum]
+	if class == ClassFull || dst == nil || dst.Class != class {
+		return
+	}
+	chunk, ok := dst.Alloc()
+	if !ok {
+		return
+	}
+	to = from
+	to.BlockNumber = blknum
+	to.Offset = chunk * class.ChunkSize()
+	md.Used[slot] = to
+
+	src := md.Slabs[from.BlockNumber]
+	src.Release(from.Offset / class.ChunkSize())
+	if src.IsEmpty() {
+		delete(md.Slabs, from.BlockNumber)
+		md.releaseSlot(from.BlockNumber)
+		released = true
+	}
+	return
+}
+
+func (md *Metadata) allocSlot() (blknum uint32, ok bool) {
+	if md.Slabs == nil {
+		md.Slabs = make(map[uint32]*Slab)
+	}
+	if len(md.Free) > 0 {
+		blknum = md.Free[0]
+		md.Free = md.Free[1:]
+		return blknum, true
+	}
+	if md.MinUnused < maxuint32 {
+		blknum = md.MinUnused
+		md.MinUnused++
+		return blknum, true
+	}
+	return 0, false
+}
+
+func (md *Metadata) releaseSlot(blknum uint32) {
 	tmp := append(md.Free, blknum)
-	keep := []uint32(nil)
+	sort.Sort(sort.Reverse(tmp))
+	keep := FreeBlockList(nil)
 	for _, blknum := range tmp {
 		if blknum == md.MinUnused-1 {
 			md.MinUnused--
@@ -105,13 +189,51 @@ func (md *Metadata) Remove(slot int, addr common.Addr) (minUnused uint32, delete
 			keep = append(keep, blknum)
 		}
 	}
+	sort.Sort(keep)
 	md.Free = keep
+}
 
-	return md.MinUnused, true
+// findSlab returns the fullest slab of the given class that still has room,
+// so that partially-filled slabs are topped up before new ones are started.
+func (md *Metadata) findSlab(class SizeClass) (blknum uint32, slab *Slab) {
+	for n, s := range md.Slabs {
+		if s.Class != class || s.IsFull() {
+			continue
+		}
+		if slab == nil || s.Count() > slab.Count() ||
+			(s.Count() == slab.Count() && n < blknum) {
+			blknum, slab = n, s
+		}
+	}
+	return
+}
+
+// rebuildSlabs reconstructs md.Slabs from md.Used.
+func (md *Metadata) rebuildSlabs() {
+	md.Slabs = make(map[uint32]*Slab)
+	for _, used := range md.Used {
+		class := ClassFor(used.Length)
+		if class == ClassFull {
+			continue
+		}
+		s := md.Slabs[used.BlockNumber]
+		if s == nil {
+			s = &Slab{Class: class}
+			md.Slabs[used.BlockNumber] = s
+		}
+		s.Mark(used.Offset / class.ChunkSize())
+	}
 }
 
 const metadataFormatLen = 16
 
+func usedEntryLen(ver uint8) uint32 {
+	if ver == 0x01 {
+		return common.AddrSize + 4
+	}
+	return common.AddrSize + 12
+}
+
 func ReadMetadata(primaryFile, secondaryFile fs.File, metadata *Metadata) (err error) {
 	var md Metadata
 	var raw []byte
@@ -136,7 +258,7 @@ func ReadMetadata(primaryFile, secondaryFile fs.File, metadata *Metadata) (err e
 		goto TryBackup
 	}
 	ver = raw[4]
-	if ver != metadataVersion {
+	if ver != metadataVersion && ver != 0x01 {
 		reason = fmt.Errorf("file has incorrect version: expected %d, got %d", metadataVersion, ver)
 		goto TryBackup
 	}
@@ -147,7 +269,7 @@ func ReadMetadata(primaryFile, secondaryFile fs.File, metadata *Metadata) (err e
 	numUsed = binary.BigEndian.Uint32(raw[8:12])
 	numFree = binary.BigEndian.Uint32(raw[12:16])
 
-	requiredLength = metadataFormatLen + numUsed*(common.AddrSize+4) + numFree*4
+	requiredLength = metadataFormatLen + numUsed*usedEntryLen(ver) + numFree*4
 	if len(raw) < int(requiredLength) {
 		reason = fmt.Errorf("unexpected EOF -- missing %d bytes", int(requiredLength)-len(raw))
 		goto TryBackup
@@ -161,8 +283,21 @@ func ReadMetadata(primaryFile, secondaryFile fs.File, metadata *Metadata) (err e
 		n += common.AddrSize
 		blknum := binary.BigEndian.Uint32(raw[n : n+4])
 		n += 4
+		offset, length := uint32(0), uint32(common.BlockSize)
+		if ver != 0x01 {
+			offset = binary.BigEndian.Uint32(raw[n : n+4])
+			length = binary.BigEndian.Uint32(raw[n+4 : n+8])
+			n += 8
+		}
+		if length > common.BlockSize || offset%ClassFor(length).ChunkSize() != 0 ||
+			offset+ClassFor(length).ChunkSize() > common.BlockSize {
+			reason = fmt.Errorf("bad location for %v: offset=%d length=%d", addr, offset, length)
+			goto TryBackup
+		}
 		md.Used[slot].Addr = addr
 		md.Used[slot].BlockNumber = blknum
+		md.Used[slot].Offset = offset
+		md.Used[slot].Length = length
 		if blknum >= md.MinUnused {
 			md.MinUnused = blknum + 1
 		}
@@ -181,8 +316,13 @@ func ReadMetadata(primaryFile, secondaryFile fs.File, metadata *Metadata) (err e
 		goto TryBackup
 	}
 
-	metadata = &md
-	log.Printf("info: ReadMetadata: %#v", md)
+	metadata.MinUnused = md.MinUnused
+	metadata.Used = md.Used
+	metadata.Free = md.Free
+	metadata.BackupData = md.BackupData
+	metadata.rebuildSlabs()
+	log.Printf("info: ReadMetadata: %d used, %d free, %d slabs",
+		len(metadata.Used), len(metadata.Free), len(metadata.Slabs))
 	return
 
 TryBackup:
@@ -196,8 +336,6 @@ TryBackup:
 		if err2 := ReadMetadata(secondaryFile, nil, metadata); err2 == nil {
 			err = nil
 		}
-	} else if err == nil {
-		log.Printf("info: ReadMetadata: %#v", *metadata)
 	}
 	return
 }
@@ -217,15 +355,19 @@ func WriteMetadata(primaryFile, secondaryFile fs.File, metadata *Metadata) error
 	binary.BigEndian.PutUint32(raw[12:16], uint32(len(metadata.Free)))
 	var tmp [4]byte
 	for _, used := range metadata.Used {
-		binary.BigEndian.PutUint32(tmp[:], used.BlockNumber)
 		raw = append(raw, used.Addr[:]...)
+		binary.BigEndian.PutUint32(tmp[:], used.BlockNumber)
+		raw = append(raw, tmp[:]...)
+		binary.BigEndian.PutUint32(tmp[:], used.Offset)
+		raw = append(raw, tmp[:]...)
+		binary.BigEndian.PutUint32(tmp[:], used.Length)
 		raw = append(raw, tmp[:]...)
 	}
 	for _, blknum := range metadata.Free {
 		binary.BigEndian.PutUint32(tmp[:], blknum)
 		raw = append(raw, tmp[:]...)
 	}
-	log.Printf("WriteMetadata: %#v", metadata)
+	log.Printf("WriteMetadata: %d used, %d free", len(metadata.Used), len(metadata.Free))
 
 	if err := secondaryFile.WriteContents(metadata.BackupData); err != nil {
 		return err
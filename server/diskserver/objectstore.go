@@ -0,0 +1,116 @@
+package diskserver
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/cloud9-tools/go-cas/common"
+	"github.com/cloud9-tools/go-cas/server/fs"
+)
+
+// ObjectStore keeps each block in its own file, with no central metadata.
+// The set of stored addresses is rebuilt in RAM by listing the objects when
+// the store is opened.
+type ObjectStore struct {
+	FS      fs.ObjectFileSystem
+	Objects fs.ObjectSet
+
+	mutex sync.RWMutex
+	index map[common.Addr]struct{}
+}
+
+func (store *ObjectStore) Open() error {
+	objects, err := store.FS.OpenObjects(fs.ReadWrite)
+	if err != nil {
+		return err
+	}
+	list, err := objects.ListObjects()
+	if err != nil {
+		objects.Close()
+		return err
+	}
+	index := make(map[common.Addr]struct{}, len(list))
+	for _, addr := range list {
+		index[addr] = struct{}{}
+	}
+	store.Objects = objects
+	store.index = index
+	return nil
+}
+
+func (store *ObjectStore) Close() error {
+	return store.Objects.Close()
+}
+
+func (store *ObjectStore) Len() int {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	return len(store.index)
+}
+
+func (store *ObjectStore) List() ([]common.Addr, error) {
+	store.mutex.RLock()
+	list := make(AddrList, 0, len(store.index))
+	for addr := range store.index {
+		list = append(list, addr)
+	}
+	store.mutex.RUnlock()
+	sort.Sort(list)
+	return list, nil
+}
+
+func (store *ObjectStore) Has(addr common.Addr) (bool, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	_, found := store.index[addr]
+	return found, nil
+}
+
+func (store *ObjectStore) Get(addr common.Addr, block *common.Block) (bool, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	if _, found := store.index[addr]; !found {
+		return false, nil
+	}
+	err := store.Objects.ReadObject(addr, block)
+	if err == fs.ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (store *ObjectStore) Put(addr common.Addr, block *common.Block) (bool, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if _, found := store.index[addr]; found {
+		return false, nil
+	}
+	if err := store.Objects.WriteObject(addr, block); err != nil {
+		return false, err
+	}
+	store.index[addr] = struct{}{}
+	return true, nil
+}
+
+func (store *ObjectStore) Remove(addr common.Addr, shred bool) (bool, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if _, found := store.index[addr]; !found {
+		return false, nil
+	}
+	err := store.Objects.EraseObject(addr, shred)
+	if err != nil && err != fs.ErrNotFound {
+		return false, err
+	}
+	delete(store.index, addr)
+	return err == nil, nil
+}
+
+// AddrList is a list of addresses, sortable in lexical order.
+type AddrList []common.Addr
+
+func (x AddrList) Len() int           { return len(x) }
+func (x AddrList) Less(i, j int) bool { return x[i].Less(x[j]) }
+func (x AddrList) Swap(i, j int)      { x[i], x[j] = x[j], x[i] }
+
+var _ Store = (*ObjectStore)(nil)
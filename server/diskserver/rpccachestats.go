@@ -0,0 +1,21 @@
+package diskserver
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/cloud9-tools/go-cas/proto"
+	"github.com/cloud9-tools/go-cas/server/audit"
+	"github.com/cloud9-tools/go-cas/server/auth"
+)
+
+func (srv *Server) CacheStats(ctx context.Context, in *proto.CacheStatsRequest) (out *proto.CacheStatsReply, err error) {
+	id := srv.Auther.Extract(ctx)
+	rec := audit.Begin(ctx, id, auth.StatFS, "")
+	defer func() { srv.finish(rec, err) }()
+	if err := srv.authorize(id, auth.StatFS); err != nil {
+		return nil, err
+	}
+	return nil, grpc.Errorf(codes.Unimplemented, "go-cas/server/diskserver: not a cache")
+}
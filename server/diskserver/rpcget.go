@@ -9,11 +9,20 @@ import (
 
 	"github.com/cloud9-tools/go-cas/common"
 	"github.com/cloud9-tools/go-cas/proto"
+	"github.com/cloud9-tools/go-cas/server/audit"
+	"github.com/cloud9-tools/go-cas/server/auth"
 )
 
 func (srv *Server) Get(ctx context.Context, in *proto.GetRequest) (out *proto.GetReply, err error) {
 	id := srv.Auther.Extract(ctx)
-	if err = id.Check(srv.ACL).Err(); err != nil {
+	rec := audit.Begin(ctx, id, auth.Get, in.Addr)
+	defer func() {
+		if out != nil {
+			rec.Bytes = int64(len(out.Block))
+		}
+		srv.finish(rec, err)
+	}()
+	if err = srv.authorizeGet(id, in.Addr); err != nil {
 		return
 	}
 
@@ -36,18 +45,15 @@ func (srv *Server) Get(ctx context.Context, in *proto.GetRequest) (out *proto.Ge
 		return
 	}
 
-	srv.Metadata.Mutex.RLock()
-	defer srv.Metadata.Mutex.RUnlock()
-
-	_, blknum, found := srv.Metadata.Search(addr)
-	if !found {
-		return
-	}
 	var block common.Block
-	if err = srv.DataFile.ReadBlock(blknum, &block); err != nil {
+	found, err := srv.Store.Get(addr, &block)
+	if err != nil {
 		err = grpc.Errorf(codes.Unknown, "%v", err)
 		return
 	}
+	if !found {
+		return
+	}
 	if err = common.Verify(addr, block.Addr()); err != nil {
 		err = grpc.Errorf(codes.DataLoss, "%v", err)
 		return
@@ -9,11 +9,21 @@ import (
 
 	"github.com/cloud9-tools/go-cas/common"
 	"github.com/cloud9-tools/go-cas/proto"
+	"github.com/cloud9-tools/go-cas/server/audit"
+	"github.com/cloud9-tools/go-cas/server/auth"
 )
 
 func (srv *Server) Put(ctx context.Context, in *proto.PutRequest) (out *proto.PutReply, err error) {
 	id := srv.Auther.Extract(ctx)
-	if err = id.Check(srv.ACL).Err(); err != nil {
+	rec := audit.Begin(ctx, id, auth.Put, in.Addr)
+	rec.Bytes = int64(len(in.Block))
+	defer func() {
+		if out != nil {
+			rec.Addr = out.Addr
+		}
+		srv.finish(rec, err)
+	}()
+	if err = srv.authorize(id, auth.Put); err != nil {
 		return
 	}
 
@@ -50,30 +60,36 @@ func (srv *Server) Put(ctx context.Context, in *proto.PutRequest) (out *proto.Pu
 	}
 	out.Addr = addr.String()
 
-	srv.Metadata.Mutex.Lock()
-	defer srv.Metadata.Mutex.Unlock()
+	srv.Mutex.Lock()
+	defer srv.Mutex.Unlock()
 
-	slot, _, found := srv.Metadata.Search(addr)
+	found, err := srv.Store.Has(addr)
+	if err != nil {
+		err = grpc.Errorf(codes.Unknown, "%v", err)
+		return
+	}
 	if found {
 		return
 	}
-	if uint(len(srv.Metadata.Used)) >= uint(srv.BlocksTotal) {
+	if uint(srv.Store.Len()) >= uint(srv.BlocksTotal) {
 		err = grpc.Errorf(codes.ResourceExhausted, "storage exhausted")
 		return
 	}
-	blknum, inserted := srv.Metadata.Insert(slot, addr)
-	if !inserted {
-		err = grpc.Errorf(codes.ResourceExhausted, "storage exhausted")
+	if err = srv.Ledger.Reserve(addr, id.Role); err != nil {
 		return
 	}
-	if err = WriteMetadata(srv.MetadataFile, srv.BackupFile, &srv.Metadata); err != nil {
-		err = grpc.Errorf(codes.Unknown, "%v", err)
+	inserted, err := srv.Store.Put(addr, &block)
+	if err != nil {
+		srv.Ledger.Release(addr)
+	}
+	if err == ErrStorageExhausted {
+		err = grpc.Errorf(codes.ResourceExhausted, "%v", err)
 		return
 	}
-	if err = srv.DataFile.WriteBlock(blknum, &block); err != nil {
+	if err != nil {
 		err = grpc.Errorf(codes.Unknown, "%v", err)
 		return
 	}
-	out.Inserted = true
+	out.Inserted = inserted
 	return
 }
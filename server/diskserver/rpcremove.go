@@ -9,11 +9,15 @@ import (
 
 	"github.com/cloud9-tools/go-cas/common"
 	"github.com/cloud9-tools/go-cas/proto"
+	"github.com/cloud9-tools/go-cas/server/audit"
+	"github.com/cloud9-tools/go-cas/server/auth"
 )
 
 func (srv *Server) Remove(ctx context.Context, in *proto.RemoveRequest) (out *proto.RemoveReply, err error) {
 	id := srv.Auther.Extract(ctx)
-	if err = id.Check(srv.ACL).Err(); err != nil {
+	rec := audit.Begin(ctx, id, auth.Remove, in.Addr)
+	defer func() { srv.finish(rec, err) }()
+	if err = srv.authorize(id, auth.Remove); err != nil {
 		return
 	}
 
@@ -32,22 +36,19 @@ func (srv *Server) Remove(ctx context.Context, in *proto.RemoveRequest) (out *pr
 		return
 	}
 
-	srv.Metadata.Mutex.Lock()
-	defer srv.Metadata.Mutex.Unlock()
+	// Hold srv.Mutex, as Put does, so that a Remove racing a re-Put can't
+	// release the owner that the Put just reserved.
+	srv.Mutex.Lock()
+	defer srv.Mutex.Unlock()
 
-	slot, blknum, found := srv.Metadata.Search(addr)
-	if !found {
-		return
-	}
-	if err = srv.DataFile.EraseBlock(blknum, in.Shred); err != nil {
+	deleted, err := srv.Store.Remove(addr, in.Shred)
+	if err != nil {
 		err = grpc.Errorf(codes.Unknown, "%v", err)
 		return
 	}
-	_, deleted := srv.Metadata.Remove(slot, addr)
-	if !deleted {
-		return
+	if deleted {
+		srv.Ledger.Release(addr)
 	}
-	err = WriteMetadata(srv.MetadataFile, srv.BackupFile, &srv.Metadata)
-	out.Deleted = true
+	out.Deleted = deleted
 	return
 }
@@ -6,11 +6,15 @@ import (
 	"golang.org/x/net/context"
 
 	"github.com/cloud9-tools/go-cas/proto"
+	"github.com/cloud9-tools/go-cas/server/audit"
+	"github.com/cloud9-tools/go-cas/server/auth"
 )
 
 func (srv *Server) Stat(ctx context.Context, in *proto.StatRequest) (out *proto.StatReply, err error) {
 	id := srv.Auther.Extract(ctx)
-	if err = id.Check(srv.ACL).Err(); err != nil {
+	rec := audit.Begin(ctx, id, auth.StatFS, "")
+	defer func() { srv.finish(rec, err) }()
+	if err = srv.authorize(id, auth.StatFS); err != nil {
 		return
 	}
 
@@ -23,10 +27,7 @@ func (srv *Server) Stat(ctx context.Context, in *proto.StatRequest) (out *proto.
 		log.Printf("-- END Stat: out=%#v err=%v", out, err)
 	}()
 
-	srv.Metadata.Mutex.RLock()
-	defer srv.Metadata.Mutex.RUnlock()
-
-	out.BlocksUsed = int64(len(srv.Metadata.Used))
+	out.BlocksUsed = int64(srv.Store.Len())
 	out.BlocksFree = int64(srv.BlocksTotal) - out.BlocksUsed
 	return
 }
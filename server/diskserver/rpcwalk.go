@@ -9,12 +9,16 @@ import (
 
 	"github.com/cloud9-tools/go-cas/common"
 	"github.com/cloud9-tools/go-cas/proto"
+	"github.com/cloud9-tools/go-cas/server/audit"
+	"github.com/cloud9-tools/go-cas/server/auth"
 	"github.com/cloud9-tools/go-multierror"
 )
 
 func (srv *Server) Walk(in *proto.WalkRequest, stream proto.CAS_WalkServer) (err error) {
 	id := srv.Auther.Extract(stream.Context())
-	if err = id.Check(srv.ACL).Err(); err != nil {
+	rec := audit.Begin(stream.Context(), id, auth.Walk, "")
+	defer func() { srv.finish(rec, err) }()
+	if err = srv.authorize(id, auth.Walk); err != nil {
 		return
 	}
 
@@ -32,22 +36,26 @@ func (srv *Server) Walk(in *proto.WalkRequest, stream proto.CAS_WalkServer) (err
 		}
 	}
 
-	srv.Metadata.Mutex.RLock()
-	snapshot := make(UsedBlockList, len(srv.Metadata.Used))
-	copy(snapshot, srv.Metadata.Used)
-	srv.Metadata.Mutex.RUnlock()
+	snapshot, err := srv.Store.List()
+	if err != nil {
+		err = grpc.Errorf(codes.Unknown, "%v", err)
+		return
+	}
 
 	var errors []error
-	for _, used := range snapshot {
+	for _, addr := range snapshot {
 		reply := &proto.WalkReply{}
-		reply.Addr = used.Addr.String()
+		reply.Addr = addr.String()
 		if re != nil || in.WantBlocks {
 			var block common.Block
-			err = srv.DataFile.ReadBlock(used.BlockNumber, &block)
+			found, err := srv.Store.Get(addr, &block)
 			if err != nil {
 				errors = append(errors, err)
 				continue
 			}
+			if !found {
+				continue
+			}
 			if re != nil && !re.Match(block[:]) {
 				continue
 			}
@@ -56,6 +64,7 @@ func (srv *Server) Walk(in *proto.WalkRequest, stream proto.CAS_WalkServer) (err
 			}
 		}
 		stream.Send(reply)
+		rec.Bytes += int64(len(reply.Block))
 		sanitizedReply := *reply
 		if len(sanitizedReply.Block) > 0 {
 			sanitizedReply.Block = []byte{}
@@ -0,0 +1,337 @@
+package diskserver
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloud9-tools/go-cas/common"
+	"github.com/cloud9-tools/go-cas/server/fs"
+	"github.com/cloud9-tools/go-cas/server/s3"
+)
+
+const s3IndexMagic = 0x63417353 // "cAsS"
+const s3IndexVersion = 0x01
+const s3IndexFlushInterval = 5 * time.Second
+
+// s3IndexInUse is set in the flags byte of the index while an S3Store has it
+// open.  Changes since the last flush are lost if the process dies, so an
+// index that was never closed can't be trusted.
+const s3IndexInUse = 0x01
+
+// S3Store keeps blocks in an S3-compatible bucket, one object per block,
+// keyed by Prefix + Addr.  The list of stored addresses is cached in a local
+// metadata file, but the bucket is authoritative: if the cache is missing,
+// damaged, or was not closed cleanly (or if Rebuild is set), it is rebuilt by
+// listing the bucket.
+//
+// Requests to S3 are made without holding the index lock, so that one slow
+// request doesn't stall the others; only operations on the same Addr wait
+// for each other.  Changes to the index are written out every FlushInterval
+// and on Close.
+type S3Store struct {
+	Client *s3.Client
+	Prefix string
+	FS     fs.FileSystem
+
+	// Rebuild forces the local index to be rebuilt on Open.
+	Rebuild bool
+
+	// StaleRetries is how many times to retry a GET that reports a missing
+	// object which the index says should exist, to ride out eventual
+	// consistency.  StaleDelay is the delay before the first such retry;
+	// it doubles on each subsequent retry.
+	StaleRetries int
+	StaleDelay   time.Duration
+
+	// FlushInterval is how often changes to the local index are written
+	// out.  If zero, it defaults to 5 seconds.
+	FlushInterval time.Duration
+
+	IndexFile fs.File
+
+	mutex   sync.RWMutex
+	cond    *sync.Cond
+	index   map[common.Addr]struct{}
+	busy    map[common.Addr]struct{}
+	dirty   bool
+	closech chan struct{}
+	donech  chan struct{}
+}
+
+func (store *S3Store) Open() error {
+	f, err := store.FS.OpenMetadata(fs.ReadWrite)
+	if err != nil {
+		return err
+	}
+	store.IndexFile = f
+	store.cond = sync.NewCond(&store.mutex)
+	store.index = make(map[common.Addr]struct{})
+	store.busy = make(map[common.Addr]struct{})
+	// The index shares its file with SlabStore's metadata, so make sure
+	// that a slab's metadata is never mistaken for a damaged index and
+	// overwritten.
+	raw, err := f.ReadContents()
+	if err == nil && len(raw) >= 4 {
+		if magic := binary.BigEndian.Uint32(raw[0:4]); magic != s3IndexMagic {
+			f.Close()
+			return fmt.Errorf("%q is not an S3 index: expected magic %08x, got %08x", f.Name(), s3IndexMagic, magic)
+		}
+	}
+	loaded := false
+	if !store.Rebuild {
+		if err == nil {
+			err = store.readIndex(raw)
+		}
+		if err == nil {
+			loaded = true
+		} else {
+			log.Printf("warn: failed to load S3 index %q: %v", f.Name(), err)
+		}
+	}
+	if !loaded {
+		if err := store.RebuildIndex(); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := store.writeIndex(s3IndexInUse); err != nil {
+		f.Close()
+		return err
+	}
+	interval := store.FlushInterval
+	if interval == 0 {
+		interval = s3IndexFlushInterval
+	}
+	store.closech = make(chan struct{})
+	store.donech = make(chan struct{})
+	go store.flushLoop(interval)
+	return nil
+}
+
+// Close writes out the index, marked as closed cleanly.
+func (store *S3Store) Close() error {
+	close(store.closech)
+	<-store.donech
+	store.mutex.Lock()
+	err := store.writeIndex(0)
+	store.mutex.Unlock()
+	if err2 := store.IndexFile.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+func (store *S3Store) flushLoop(interval time.Duration) {
+	defer close(store.donech)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-store.closech:
+			return
+		case <-ticker.C:
+			store.Flush()
+		}
+	}
+}
+
+// Flush writes out the index, if it has changed since the last flush.
+func (store *S3Store) Flush() error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if !store.dirty {
+		return nil
+	}
+	if err := store.writeIndex(s3IndexInUse); err != nil {
+		log.Printf("warn: failed to write S3 index %q: %v", store.IndexFile.Name(), err)
+		return err
+	}
+	store.dirty = false
+	return nil
+}
+
+// acquire waits until no other Put or Remove is working on addr, then claims
+// it.  The mutex must be held.
+func (store *S3Store) acquire(addr common.Addr) {
+	for {
+		if _, found := store.busy[addr]; !found {
+			break
+		}
+		store.cond.Wait()
+	}
+	store.busy[addr] = struct{}{}
+}
+
+// release undoes acquire.  The mutex must be held.
+func (store *S3Store) release(addr common.Addr) {
+	delete(store.busy, addr)
+	store.cond.Broadcast()
+}
+
+// RebuildIndex replaces the local index with a fresh listing of the bucket.
+func (store *S3Store) RebuildIndex() error {
+	index := make(map[common.Addr]struct{})
+	err := store.Client.List(store.Prefix, func(key string) error {
+		var addr common.Addr
+		if addr.Parse(strings.TrimPrefix(key, store.Prefix)) != nil {
+			log.Printf("warn: ignoring unexpected key %q in S3 bucket", key)
+			return nil
+		}
+		index[addr] = struct{}{}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.index = index
+	store.dirty = true
+	log.Printf("info: rebuilt S3 index: %d blocks", len(index))
+	return nil
+}
+
+func (store *S3Store) key(addr common.Addr) string {
+	return store.Prefix + addr.String()
+}
+
+func (store *S3Store) Len() int {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	return len(store.index)
+}
+
+func (store *S3Store) List() ([]common.Addr, error) {
+	store.mutex.RLock()
+	list := make(AddrList, 0, len(store.index))
+	for addr := range store.index {
+		list = append(list, addr)
+	}
+	store.mutex.RUnlock()
+	sort.Sort(list)
+	return list, nil
+}
+
+func (store *S3Store) Has(addr common.Addr) (bool, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	_, found := store.index[addr]
+	return found, nil
+}
+
+func (store *S3Store) Get(addr common.Addr, block *common.Block) (bool, error) {
+	if found, _ := store.Has(addr); !found {
+		return false, nil
+	}
+	delay := store.StaleDelay
+	for attempt := 0; ; attempt++ {
+		data, err := store.Client.Get(store.key(addr))
+		if err == nil {
+			return true, block.Pad(data)
+		}
+		if err != s3.ErrNotFound {
+			return false, err
+		}
+		if attempt >= store.StaleRetries {
+			return false, fmt.Errorf("object %q is indexed but missing from S3", store.key(addr))
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+func (store *S3Store) Put(addr common.Addr, block *common.Block) (bool, error) {
+	store.mutex.Lock()
+	store.acquire(addr)
+	_, found := store.index[addr]
+	store.mutex.Unlock()
+	defer func() {
+		store.mutex.Lock()
+		store.release(addr)
+		store.mutex.Unlock()
+	}()
+	if found {
+		return false, nil
+	}
+	if err := store.Client.Put(store.key(addr), block[:]); err != nil {
+		return false, err
+	}
+	store.mutex.Lock()
+	store.index[addr] = struct{}{}
+	store.dirty = true
+	store.mutex.Unlock()
+	return true, nil
+}
+
+func (store *S3Store) Remove(addr common.Addr, shred bool) (bool, error) {
+	store.mutex.Lock()
+	store.acquire(addr)
+	_, found := store.index[addr]
+	store.mutex.Unlock()
+	defer func() {
+		store.mutex.Lock()
+		store.release(addr)
+		store.mutex.Unlock()
+	}()
+	if !found {
+		return false, nil
+	}
+	// There is no way to shred an object in someone else's bucket;
+	// the best we can do is delete it.
+	if err := store.Client.Delete(store.key(addr)); err != nil {
+		return false, err
+	}
+	store.mutex.Lock()
+	delete(store.index, addr)
+	store.dirty = true
+	store.mutex.Unlock()
+	return true, nil
+}
+
+func (store *S3Store) readIndex(raw []byte) error {
+	if len(raw) < 12 {
+		return fmt.Errorf("file is too short: expected >= 12 bytes, got %d bytes", len(raw))
+	}
+	if magic := binary.BigEndian.Uint32(raw[0:4]); magic != s3IndexMagic {
+		return fmt.Errorf("file has incorrect magic: expected %08x, got %08x", s3IndexMagic, magic)
+	}
+	if raw[4] != s3IndexVersion || raw[6] != 0 || raw[7] != 0 {
+		return fmt.Errorf("file has unsupported version %d", raw[4])
+	}
+	if raw[5]&s3IndexInUse != 0 {
+		return fmt.Errorf("file was not closed cleanly")
+	}
+	n := binary.BigEndian.Uint32(raw[8:12])
+	if len(raw) != 12+int(n)*common.AddrSize {
+		return fmt.Errorf("file has wrong length for %d entries: %d bytes", n, len(raw))
+	}
+	index := make(map[common.Addr]struct{}, n)
+	for i := 12; i < len(raw); i += common.AddrSize {
+		var addr common.Addr
+		copy(addr[:], raw[i:i+common.AddrSize])
+		index[addr] = struct{}{}
+	}
+	store.index = index
+	return nil
+}
+
+// writeIndex must be called with the mutex held.
+func (store *S3Store) writeIndex(flags byte) error {
+	raw := make([]byte, 12, 12+len(store.index)*common.AddrSize)
+	binary.BigEndian.PutUint32(raw[0:4], s3IndexMagic)
+	raw[4] = s3IndexVersion
+	raw[5] = flags
+	binary.BigEndian.PutUint32(raw[8:12], uint32(len(store.index)))
+	for addr := range store.index {
+		raw = append(raw, addr[:]...)
+	}
+	return store.IndexFile.WriteContents(raw)
+}
+
+var _ Store = (*S3Store)(nil)
@@ -0,0 +1,134 @@
+package diskserver
+
+import (
+	"testing"
+
+	"github.com/cloud9-tools/go-cas/common"
+	"github.com/cloud9-tools/go-cas/server/fs"
+	"github.com/cloud9-tools/go-cas/server/s3"
+	"github.com/cloud9-tools/go-cas/server/s3/s3test"
+)
+
+type memFS struct {
+	metadata memFile
+}
+
+func (m *memFS) OpenMetadata(fs.WriteType) (fs.File, error)       { return &m.metadata, nil }
+func (m *memFS) OpenMetadataBackup(fs.WriteType) (fs.File, error) { return nil, fs.ErrNotSupported }
+func (m *memFS) OpenData(fs.WriteType) (fs.BlockFile, error)      { return nil, fs.ErrNotSupported }
+
+func TestS3Store(t *testing.T) {
+	fake := s3test.NewServer("cas")
+	defer fake.Close()
+	fake.StaleReads = 2
+	fake.PageSize = 2
+
+	var local memFS
+	newStore := func() *S3Store {
+		return &S3Store{
+			Client: &s3.Client{
+				Endpoint:        fake.URL,
+				Bucket:          "cas",
+				AccessKeyID:     "AKIDEXAMPLE",
+				SecretAccessKey: "secret",
+				Retries:         2,
+				RetryDelay:      1,
+			},
+			Prefix:       "blocks/",
+			FS:           &local,
+			StaleRetries: 3,
+			StaleDelay:   1,
+		}
+	}
+
+	store := newStore()
+	if err := store.Open(); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	var blocks []common.Block
+	for i := 0; i < 5; i++ {
+		var block common.Block
+		block.Pad([]byte{byte(i), 'x'})
+		blocks = append(blocks, block)
+		if i == 3 {
+			fake.FailNext = 1
+		}
+		inserted, err := store.Put(block.Addr(), &block)
+		if err != nil || !inserted {
+			t.Fatalf("[%d] Put: inserted=%t err=%v", i, inserted, err)
+		}
+	}
+	if objects := fake.Objects("cas"); len(objects) != 5 {
+		t.Errorf("expected 5 objects in bucket, got %d", len(objects))
+	}
+
+	var block common.Block
+	found, err := store.Get(blocks[0].Addr(), &block)
+	if err != nil || !found || block != blocks[0] {
+		t.Errorf("Get through stale reads: found=%t err=%v", found, err)
+	}
+	deleted, err := store.Remove(blocks[1].Addr(), false)
+	if err != nil || !deleted {
+		t.Errorf("Remove: deleted=%t err=%v", deleted, err)
+	}
+	store.Close()
+
+	// Reopen from the cached index.
+	store = newStore()
+	if err := store.Open(); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if store.Len() != 4 {
+		t.Errorf("cached index: expected 4 blocks, got %d", store.Len())
+	}
+	if inserted, err := store.Put(blocks[1].Addr(), &blocks[1]); err != nil || !inserted {
+		t.Errorf("Put again: inserted=%t err=%v", inserted, err)
+	}
+	if err := store.Flush(); err != nil {
+		t.Errorf("Flush: %v", err)
+	}
+	crashed := local.metadata.contents
+	if _, err := store.Remove(blocks[1].Addr(), false); err != nil {
+		t.Errorf("Remove again: %v", err)
+	}
+	store.Close()
+
+	// Lose the Remove in a crash: the index that was never closed must
+	// not be trusted.
+	local.metadata.contents = crashed
+	store = newStore()
+	if err := store.Open(); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if store.Len() != 4 {
+		t.Errorf("index after crash: expected 4 blocks, got %d", store.Len())
+	}
+	store.Close()
+
+	// Refuse to overwrite a SlabStore's metadata.
+	saved := local.metadata.contents
+	local.metadata.contents = []byte("cAsD\x01\x00\x00\x00")
+	if err := newStore().Open(); err == nil {
+		t.Errorf("Open over slab metadata: expected error, got nil")
+	}
+	if string(local.metadata.contents) != "cAsD\x01\x00\x00\x00" {
+		t.Errorf("Open over slab metadata: file was overwritten")
+	}
+
+	// Lose the cache, and rebuild it from the bucket.
+	local.metadata.contents = saved[:10]
+	store = newStore()
+	if err := store.Open(); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	list, _ := store.List()
+	if len(list) != 4 {
+		t.Fatalf("rebuilt index: expected 4 blocks, got %d", len(list))
+	}
+	for _, addr := range list {
+		if addr == blocks[1].Addr() {
+			t.Errorf("rebuilt index contains removed block %v", addr)
+		}
+	}
+	store.Close()
+}
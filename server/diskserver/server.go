@@ -1,26 +1,42 @@
 package diskserver
 
 import (
+	"fmt"
+	"path/filepath"
 	"sync"
 
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/cloud9-tools/go-cas/common"
 	"github.com/cloud9-tools/go-cas/proto"
+	"github.com/cloud9-tools/go-cas/server/audit"
 	"github.com/cloud9-tools/go-cas/server/auth"
-	"github.com/cloud9-tools/go-cas/server/fs"
+	"github.com/cloud9-tools/go-cas/server/quota"
 	"github.com/cloud9-tools/go-multierror"
 )
 
 type Server struct {
-	Mutex        sync.Mutex
-	Metadata     Metadata
-	BlocksTotal  uint32
-	ACL          auth.ACL
-	Auther       auth.Auther
-	FS           fs.FileSystem
-	MetadataFile fs.File
-	BackupFile   fs.File
-	DataFile     fs.BlockFile
+	Mutex       sync.Mutex
+	BlocksTotal uint32
+	ACL         auth.ACL
+	Auther      auth.Auther
+	Store       Store
+	Limiter     *quota.Limiter
+
+	// Audit and Ledger are opened by Open.
+	Audit       *audit.Log
+	Ledger      *quota.Ledger
+	auditConfig audit.Config
+	limits      quota.Table
+	dir         string
+	reach       *auth.Reachability
 }
 
+// reachLimit is how many addresses are remembered as reachable from
+// capability roots.
+const reachLimit = 1 << 16
+
 func New(cfg Config) *Server {
 	if err := cfg.Validate(); err != nil {
 		panic(err)
@@ -28,57 +44,67 @@ func New(cfg Config) *Server {
 	return &Server{
 		BlocksTotal: uint32(cfg.Limit),
 		ACL:         cfg.ACL,
-		Auther:      auth.AnonymousAuther(),
-		FS:          fs.NativeFileSystem{RootDir: cfg.Dir},
+		Auther:      cfg.Auther(),
+		Store:       cfg.NewStore(),
+		Limiter:     quota.NewLimiter(cfg.Limits),
+		auditConfig: cfg.Audit,
+		limits:      cfg.Limits,
+		dir:         cfg.Dir,
+		reach:       auth.NewReachability(reachLimit),
 	}
 }
 
-func (srv *Server) Open() (err error) {
-	var mf, bf fs.File
-	var df fs.BlockFile
-	defer func() {
-		if err != nil {
-			if df != nil {
-				df.Close()
-			}
-			if bf != nil {
-				bf.Close()
-			}
-			if mf != nil {
-				mf.Close()
-			}
-		}
-	}()
-	mf, err = srv.FS.OpenMetadata(fs.ReadWrite)
+func (srv *Server) Open() error {
+	l, err := srv.auditConfig.Open()
 	if err != nil {
-		return err
+		return fmt.Errorf("audit log: %v", err)
 	}
-	bf, err = srv.FS.OpenMetadataBackup(fs.ReadWrite)
-	if err != nil {
+	srv.Audit = l
+	if err := srv.Store.Open(); err != nil {
 		return err
 	}
-	df, err = srv.FS.OpenData(fs.ReadWrite)
+	ledger, err := quota.OpenLedger(filepath.Join(srv.dir, "owners"), srv.limits)
 	if err != nil {
-		return err
+		return fmt.Errorf("quota ledger: %v", err)
 	}
-	srv.DataFile = df
-	srv.BackupFile = bf
-	srv.MetadataFile = mf
+	srv.Ledger = ledger
+	return nil
+}
 
-	if err = ReadMetadata(srv.MetadataFile, srv.BackupFile, &srv.Metadata); err != nil {
-		return
+func (srv *Server) Close() error {
+	return multierror.Of(srv.Store.Close(), srv.Ledger.Close(), srv.Audit.Close())
+}
+
+func (srv *Server) authorize(id auth.Identity, op auth.Operation) error {
+	return id.Authorize(srv.ACL, srv.Limiter, op)
+}
+
+func (srv *Server) authorizeGet(id auth.Identity, addr string) error {
+	return id.AuthorizeGet(srv.ACL, srv.Limiter, addr, srv.reach, srv.getVerified)
+}
+
+// getVerified reads addr from the store, for following a capability's
+// references.
+func (srv *Server) getVerified(addr common.Addr, block *common.Block) (bool, error) {
+	found, err := srv.Store.Get(addr, block)
+	if err != nil || !found {
+		return false, err
 	}
-	if err = WriteMetadata(srv.MetadataFile, srv.BackupFile, &srv.Metadata); err != nil {
-		return
+	if err := common.Verify(addr, block.Addr()); err != nil {
+		return false, grpc.Errorf(codes.DataLoss, "%v", err)
 	}
-	return
+	return true, nil
 }
 
-func (srv *Server) Close() error {
-	return multierror.Of(
-		srv.DataFile.Close(),
-		srv.BackupFile.Close(),
-		srv.MetadataFile.Close())
+// finish accounts for a completed RPC.
+func (srv *Server) finish(rec *audit.Record, err error) {
+	srv.Limiter.Charge(auth.Role(rec.Role), rec.Bytes)
+	srv.Audit.Finish(rec, err)
+}
+
+// Reload re-reads the policy file, if there is one.
+func (srv *Server) Reload() {
+	srv.Auther.Reload()
 }
 
 var _ proto.CASServer = (*Server)(nil)
@@ -0,0 +1,144 @@
+package diskserver
+
+import (
+	"github.com/cloud9-tools/go-cas/common"
+	"github.com/cloud9-tools/go-cas/server/fs"
+)
+
+// SizeClass describes how a single slot in the data file is carved up.
+// Blocks whose trimmed contents fit in one of the small classes share a slot
+// (a "slab") with other blocks of the same class; everything else gets a
+// whole slot to itself.
+type SizeClass uint8
+
+const (
+	Class4K SizeClass = iota
+	Class16K
+	Class64K
+	ClassFull
+)
+
+var sizeClassChunkSize = [...]uint32{
+	4 << 10,
+	16 << 10,
+	64 << 10,
+	common.BlockSize,
+}
+
+// ClassFor returns the smallest SizeClass that can hold length bytes.
+func ClassFor(length uint32) SizeClass {
+	for i, size := range sizeClassChunkSize {
+		if length <= size {
+			return SizeClass(i)
+		}
+	}
+	return ClassFull
+}
+
+// ChunkSize returns the number of bytes in each chunk of this class.
+func (c SizeClass) ChunkSize() uint32 {
+	return sizeClassChunkSize[c]
+}
+
+// NumChunks returns the number of chunks of this class that fit in one slot.
+func (c SizeClass) NumChunks() uint32 {
+	return common.BlockSize / c.ChunkSize()
+}
+
+// Slab tracks which chunks of a shared slot are in use.  Slabs are not
+// persisted; they are rebuilt from the UsedBlockList when metadata is loaded.
+type Slab struct {
+	Class SizeClass
+	Used  uint64
+}
+
+// Count returns the number of chunks in use.
+func (s *Slab) Count() uint32 {
+	var n uint32
+	for x := s.Used; x != 0; x &= x - 1 {
+		n++
+	}
+	return n
+}
+
+func (s *Slab) IsFull() bool  { return s.Count() >= s.Class.NumChunks() }
+func (s *Slab) IsEmpty() bool { return s.Used == 0 }
+
+// Alloc claims the lowest-numbered free chunk.
+func (s *Slab) Alloc() (chunk uint32, ok bool) {
+	for chunk = 0; chunk < s.Class.NumChunks(); chunk++ {
+		if s.Used&(1<<chunk) == 0 {
+			s.Used |= 1 << chunk
+			return chunk, true
+		}
+	}
+	return 0, false
+}
+
+// Mark claims a specific chunk.
+func (s *Slab) Mark(chunk uint32) {
+	s.Used |= 1 << chunk
+}
+
+// Release frees a specific chunk.
+func (s *Slab) Release(chunk uint32) {
+	s.Used &^= 1 << chunk
+}
+
+// readStored loads the block described by used from the data file.
+func (store *SlabStore) readStored(used UsedBlock, block *common.Block) error {
+	if ClassFor(used.Length) == ClassFull {
+		return store.DataFile.ReadBlock(used.BlockNumber, block)
+	}
+	var slot common.Block
+	if err := store.DataFile.ReadBlock(used.BlockNumber, &slot); err != nil {
+		return err
+	}
+	return block.Pad(slot[used.Offset : used.Offset+used.Length])
+}
+
+// writeStored saves block to the location described by used.  If fresh is
+// true, the slot was newly allocated and its old contents can be ignored.
+func (store *SlabStore) writeStored(used UsedBlock, block *common.Block, fresh bool) error {
+	if ClassFor(used.Length) == ClassFull {
+		return store.DataFile.WriteBlock(used.BlockNumber, block)
+	}
+	var slot common.Block
+	if !fresh {
+		if err := store.DataFile.ReadBlock(used.BlockNumber, &slot); err != nil {
+			return err
+		}
+	}
+	chunk := slot[used.Offset : used.Offset+ClassFor(used.Length).ChunkSize()]
+	copy(chunk, fs.Empty[:])
+	copy(chunk, block[:used.Length])
+	return store.DataFile.WriteBlock(used.BlockNumber, &slot)
+}
+
+// eraseStored wipes the block described by used.  If it is the last block
+// left in its slab, the whole slot is erased instead.
+func (store *SlabStore) eraseStored(used UsedBlock, shred bool) error {
+	class := ClassFor(used.Length)
+	if class == ClassFull {
+		return store.DataFile.EraseBlock(used.BlockNumber, shred)
+	}
+	if s := store.Metadata.Slabs[used.BlockNumber]; s == nil || s.Count() <= 1 {
+		return store.DataFile.EraseBlock(used.BlockNumber, shred)
+	}
+
+	var slot common.Block
+	if err := store.DataFile.ReadBlock(used.BlockNumber, &slot); err != nil {
+		return err
+	}
+	chunk := slot[used.Offset : used.Offset+class.ChunkSize()]
+	if shred {
+		for _, pattern := range fs.ShredPatterns {
+			copy(chunk, pattern[:])
+			if err := store.DataFile.WriteBlock(used.BlockNumber, &slot); err != nil {
+				return err
+			}
+		}
+	}
+	copy(chunk, fs.Empty[:])
+	return store.DataFile.WriteBlock(used.BlockNumber, &slot)
+}
@@ -0,0 +1,203 @@
+package diskserver
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/cloud9-tools/go-cas/common"
+)
+
+type memFile struct {
+	name     string
+	contents []byte
+}
+
+func (f *memFile) Name() string                  { return f.name }
+func (f *memFile) Close() error                  { return nil }
+func (f *memFile) ReadContents() ([]byte, error) { return f.contents, nil }
+func (f *memFile) WriteContents(raw []byte) error {
+	f.contents = append([]byte(nil), raw...)
+	return nil
+}
+
+func TestClassFor(t *testing.T) {
+	type testrow struct {
+		Length uint32
+		Class  SizeClass
+	}
+	for i, row := range []testrow{
+		testrow{0, Class4K},
+		testrow{10, Class4K},
+		testrow{4 << 10, Class4K},
+		testrow{4<<10 + 1, Class16K},
+		testrow{64 << 10, Class64K},
+		testrow{64<<10 + 1, ClassFull},
+		testrow{common.BlockSize, ClassFull},
+	} {
+		if actual := ClassFor(row.Length); actual != row.Class {
+			t.Errorf("[%2d] ClassFor(%d): expected %d, got %d", i, row.Length, row.Class, actual)
+		}
+	}
+}
+
+func TestMetadata_slabs(t *testing.T) {
+	var md Metadata
+
+	insert := func(b byte, length uint32) UsedBlock {
+		addr := common.Addr{b}
+		slot, _, found := md.Search(addr)
+		if found {
+			t.Fatalf("%v: unexpectedly found", addr)
+		}
+		used, _, inserted := md.Insert(slot, addr, length)
+		if !inserted {
+			t.Fatalf("%v: not inserted", addr)
+		}
+		return used
+	}
+	remove := func(b byte) bool {
+		addr := common.Addr{b}
+		slot, _, found := md.Search(addr)
+		if !found {
+			t.Fatalf("%v: not found", addr)
+		}
+		_, released, deleted := md.Remove(slot, addr)
+		if !deleted {
+			t.Fatalf("%v: not deleted", addr)
+		}
+		return released
+	}
+
+	a := insert(1, 10)
+	b := insert(2, 20)
+	c := insert(3, common.BlockSize)
+	if a.BlockNumber != b.BlockNumber || a.Offset == b.Offset {
+		t.Errorf("small blocks should share a slab: %#v %#v", a, b)
+	}
+	if c.BlockNumber == a.BlockNumber {
+		t.Errorf("full block should not share a slab: %#v %#v", a, c)
+	}
+	if md.MinUnused != 2 {
+		t.Errorf("expected 2 slots in use, got %d", md.MinUnused)
+	}
+
+	if remove(1) {
+		t.Errorf("slab released while still in use")
+	}
+	d := insert(4, 30)
+	if d.BlockNumber != a.BlockNumber || d.Offset != a.Offset {
+		t.Errorf("freed chunk was not reused: %#v %#v", a, d)
+	}
+	if !remove(3) {
+		t.Errorf("full slot not released")
+	}
+
+	var primary, backup memFile
+	if err := WriteMetadata(&primary, &backup, &md); err != nil {
+		t.Fatalf("WriteMetadata: %v", err)
+	}
+	var md2 Metadata
+	if err := ReadMetadata(&primary, &backup, &md2); err != nil {
+		t.Fatalf("ReadMetadata: %v", err)
+	}
+	if len(md2.Used) != len(md.Used) {
+		t.Fatalf("expected %d used, got %d", len(md.Used), len(md2.Used))
+	}
+	for i := range md.Used {
+		if md.Used[i] != md2.Used[i] {
+			t.Errorf("[%2d] expected %#v, got %#v", i, md.Used[i], md2.Used[i])
+		}
+	}
+	if s := md2.Slabs[a.BlockNumber]; s == nil || s.Count() != 2 {
+		t.Errorf("slab not rebuilt: %#v", s)
+	}
+}
+
+func TestMetadata_compactionPair(t *testing.T) {
+	var md Metadata
+	for b := byte(0); b < 3; b++ {
+		slot, _, _ := md.Search(common.Addr{b})
+		md.Insert(slot, common.Addr{b}, 10)
+	}
+	// Force the third block into a slab of its own.
+	slot, _, _ := md.Search(common.Addr{2})
+	used := md.Used[slot]
+	md.Slabs[used.BlockNumber].Release(used.Offset / Class4K.ChunkSize())
+	blknum, _ := md.allocSlot()
+	md.Slabs[blknum] = &Slab{Class: Class4K}
+	md.Slabs[blknum].Mark(0)
+	md.Used[slot].BlockNumber = blknum
+	md.Used[slot].Offset = 0
+
+	src, dst, ok := md.compactionPair(Class4K)
+	if !ok || src != blknum || dst != used.BlockNumber {
+		t.Fatalf("expected (%d, %d, true), got (%d, %d, %t)", blknum, used.BlockNumber, src, dst, ok)
+	}
+	_, _, released, moved := md.Move(slot, dst)
+	if !moved || !released {
+		t.Errorf("expected move to release slot %d", src)
+	}
+	if _, _, ok := md.compactionPair(Class4K); ok {
+		t.Errorf("expected nothing left to compact")
+	}
+}
+
+func TestReadMetadata_v1(t *testing.T) {
+	// A version 1 file has no offsets or lengths: every block fills a slot.
+	raw := make([]byte, metadataFormatLen)
+	binary.BigEndian.PutUint32(raw[0:4], metadataMagic)
+	raw[4] = 0x01
+	binary.BigEndian.PutUint32(raw[8:12], 2)
+	binary.BigEndian.PutUint32(raw[12:16], 1)
+	var tmp [4]byte
+	for i, blknum := range []uint32{0, 2} {
+		addr := common.Addr{byte(i + 1)}
+		raw = append(raw, addr[:]...)
+		binary.BigEndian.PutUint32(tmp[:], blknum)
+		raw = append(raw, tmp[:]...)
+	}
+	binary.BigEndian.PutUint32(tmp[:], 1)
+	raw = append(raw, tmp[:]...)
+
+	var md Metadata
+	primary := &memFile{name: "metadata", contents: raw}
+	backup := &memFile{name: "metadata.backup"}
+	if err := ReadMetadata(primary, backup, &md); err != nil {
+		t.Fatal(err)
+	}
+	expected := UsedBlockList{
+		UsedBlock{Addr: common.Addr{1}, BlockNumber: 0, Offset: 0, Length: common.BlockSize},
+		UsedBlock{Addr: common.Addr{2}, BlockNumber: 2, Offset: 0, Length: common.BlockSize},
+	}
+	if len(md.Used) != len(expected) {
+		t.Fatalf("expected %d used, got %d", len(expected), len(md.Used))
+	}
+	for i := range expected {
+		if md.Used[i] != expected[i] {
+			t.Errorf("[%d] expected %+v, got %+v", i, expected[i], md.Used[i])
+		}
+	}
+	if len(md.Free) != 1 || md.Free[0] != 1 || md.MinUnused != 3 {
+		t.Errorf("expected Free=[1] MinUnused=3, got Free=%v MinUnused=%d", md.Free, md.MinUnused)
+	}
+	if len(md.Slabs) != 0 {
+		t.Errorf("expected no slabs, got %d", len(md.Slabs))
+	}
+
+	// It is written back as version 2, and reads back the same.
+	if err := WriteMetadata(primary, backup, &md); err != nil {
+		t.Fatal(err)
+	}
+	if v := primary.contents[4]; v != metadataVersion {
+		t.Errorf("expected version %d after WriteMetadata, got %d", metadataVersion, v)
+	}
+	var md2 Metadata
+	if err := ReadMetadata(primary, nil, &md2); err != nil {
+		t.Fatal(err)
+	}
+	for i := range expected {
+		if i >= len(md2.Used) || md2.Used[i] != expected[i] {
+			t.Errorf("after rewrite: [%d] expected %+v", i, expected[i])
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package diskserver
+
+import (
+	"github.com/cloud9-tools/go-cas/common"
+	"github.com/cloud9-tools/go-cas/server/fs"
+	"github.com/cloud9-tools/go-multierror"
+)
+
+// SlabStore keeps blocks in a single data file of BlockSize slots, indexed
+// by a central metadata file (with a backup copy).  Small blocks are packed
+// into shared slabs; see SizeClass.
+type SlabStore struct {
+	FS           fs.FileSystem
+	Metadata     Metadata
+	MetadataFile fs.File
+	BackupFile   fs.File
+	DataFile     fs.BlockFile
+}
+
+func (store *SlabStore) Open() (err error) {
+	var mf, bf fs.File
+	var df fs.BlockFile
+	defer func() {
+		if err != nil {
+			if df != nil {
+				df.Close()
+			}
+			if bf != nil {
+				bf.Close()
+			}
+			if mf != nil {
+				mf.Close()
+			}
+		}
+	}()
+	mf, err = store.FS.OpenMetadata(fs.ReadWrite)
+	if err != nil {
+		return err
+	}
+	bf, err = store.FS.OpenMetadataBackup(fs.ReadWrite)
+	if err != nil {
+		return err
+	}
+	df, err = store.FS.OpenData(fs.ReadWrite)
+	if err != nil {
+		return err
+	}
+	store.DataFile = df
+	store.BackupFile = bf
+	store.MetadataFile = mf
+
+	if err = ReadMetadata(store.MetadataFile, store.BackupFile, &store.Metadata); err != nil {
+		return
+	}
+	if err = WriteMetadata(store.MetadataFile, store.BackupFile, &store.Metadata); err != nil {
+		return
+	}
+	if err = store.Compact(); err != nil {
+		return
+	}
+	return
+}
+
+func (store *SlabStore) Close() error {
+	return multierror.Of(
+		store.DataFile.Close(),
+		store.BackupFile.Close(),
+		store.MetadataFile.Close())
+}
+
+func (store *SlabStore) Len() int {
+	store.Metadata.Mutex.RLock()
+	defer store.Metadata.Mutex.RUnlock()
+	return len(store.Metadata.Used)
+}
+
+func (store *SlabStore) List() ([]common.Addr, error) {
+	store.Metadata.Mutex.RLock()
+	defer store.Metadata.Mutex.RUnlock()
+	list := make([]common.Addr, len(store.Metadata.Used))
+	for i, used := range store.Metadata.Used {
+		list[i] = used.Addr
+	}
+	return list, nil
+}
+
+func (store *SlabStore) Has(addr common.Addr) (bool, error) {
+	store.Metadata.Mutex.RLock()
+	defer store.Metadata.Mutex.RUnlock()
+	_, _, found := store.Metadata.Search(addr)
+	return found, nil
+}
+
+func (store *SlabStore) Get(addr common.Addr, block *common.Block) (bool, error) {
+	store.Metadata.Mutex.RLock()
+	defer store.Metadata.Mutex.RUnlock()
+	_, used, found := store.Metadata.Search(addr)
+	if !found {
+		return false, nil
+	}
+	if err := store.readStored(used, block); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (store *SlabStore) Put(addr common.Addr, block *common.Block) (bool, error) {
+	store.Metadata.Mutex.Lock()
+	defer store.Metadata.Mutex.Unlock()
+	slot, _, found := store.Metadata.Search(addr)
+	if found {
+		return false, nil
+	}
+	used, fresh, inserted := store.Metadata.Insert(slot, addr, uint32(len(block.Trim())))
+	if !inserted {
+		return false, ErrStorageExhausted
+	}
+	if err := WriteMetadata(store.MetadataFile, store.BackupFile, &store.Metadata); err != nil {
+		return false, err
+	}
+	if err := store.writeStored(used, block, fresh); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (store *SlabStore) Remove(addr common.Addr, shred bool) (bool, error) {
+	store.Metadata.Mutex.Lock()
+	defer store.Metadata.Mutex.Unlock()
+	slot, used, found := store.Metadata.Search(addr)
+	if !found {
+		return false, nil
+	}
+	if err := store.eraseStored(used, shred); err != nil {
+		return false, err
+	}
+	_, _, deleted := store.Metadata.Remove(slot, addr)
+	if !deleted {
+		return false, nil
+	}
+	return true, WriteMetadata(store.MetadataFile, store.BackupFile, &store.Metadata)
+}
+
+var _ Store = (*SlabStore)(nil)
@@ -0,0 +1,38 @@
+package diskserver
+
+import (
+	"errors"
+
+	"github.com/cloud9-tools/go-cas/common"
+)
+
+var ErrStorageExhausted = errors.New("storage exhausted")
+
+// Store is the storage engine behind a Server.  The Server takes care of
+// authorization, argument checking, hash verification, and the block limit;
+// a Store only has to keep track of which blocks it holds.
+//
+// All methods must be safe to call from multiple goroutines.
+type Store interface {
+	Open() error
+	Close() error
+
+	// Len returns the number of blocks stored.
+	Len() int
+
+	// List returns a sorted snapshot of the stored addresses.
+	List() ([]common.Addr, error)
+
+	// Has returns true iff addr is stored.
+	Has(addr common.Addr) (bool, error)
+
+	// Get loads the block for addr, if it is stored.
+	Get(addr common.Addr, block *common.Block) (found bool, err error)
+
+	// Put stores block, which hashes to addr.  If the block was already
+	// stored, Put returns inserted=false and does nothing.
+	Put(addr common.Addr, block *common.Block) (inserted bool, err error)
+
+	// Remove forgets the block for addr, if it is stored.
+	Remove(addr common.Addr, shred bool) (deleted bool, err error)
+}
@@ -0,0 +1,126 @@
+package diskserver
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/cloud9-tools/go-cas/common"
+	"github.com/cloud9-tools/go-cas/server/fs"
+)
+
+func TestStores(t *testing.T) {
+	type testrow struct {
+		Name     string
+		NewStore func(dir string) Store
+	}
+	for _, row := range []testrow{
+		testrow{"slabs", func(dir string) Store {
+			return &SlabStore{FS: fs.NativeFileSystem{RootDir: dir}}
+		}},
+		testrow{"objects", func(dir string) Store {
+			return &ObjectStore{FS: fs.NativeFileSystem{RootDir: dir}}
+		}},
+	} {
+		dir, err := ioutil.TempDir("", "store")
+		if err != nil {
+			t.Fatal(err)
+		}
+		testStore(t, row.Name, func() Store { return row.NewStore(dir) })
+		os.RemoveAll(dir)
+	}
+}
+
+// testStore runs the same sequence of operations against any Store.
+// newStore must return a Store over the same directory each time.
+func testStore(t *testing.T, name string, newStore func() Store) {
+	// Blocks of assorted sizes, so that a SlabStore packs some of them.
+	var blocks []common.Block
+	for i, size := range []int{1, 100, 4096, 50000, common.BlockSize} {
+		var block common.Block
+		block.Pad(bytes.Repeat([]byte{byte('a' + i)}, size))
+		blocks = append(blocks, block)
+	}
+
+	store := newStore()
+	if err := store.Open(); err != nil {
+		t.Fatalf("%s: Open: %v", name, err)
+	}
+	if n := store.Len(); n != 0 {
+		t.Errorf("%s: empty store: expected Len()=0, got %d", name, n)
+	}
+	for i := range blocks {
+		inserted, err := store.Put(blocks[i].Addr(), &blocks[i])
+		if err != nil || !inserted {
+			t.Errorf("%s: [%d] Put: inserted=%v err=%v", name, i, inserted, err)
+		}
+	}
+	if inserted, err := store.Put(blocks[0].Addr(), &blocks[0]); err != nil || inserted {
+		t.Errorf("%s: Put again: expected inserted=false, got inserted=%v err=%v", name, inserted, err)
+	}
+	for i := range blocks {
+		var block common.Block
+		found, err := store.Get(blocks[i].Addr(), &block)
+		if err != nil || !found || block != blocks[i] {
+			t.Errorf("%s: [%d] Get: found=%v err=%v match=%v", name, i, found, err, block == blocks[i])
+		}
+	}
+
+	var absent common.Block
+	absent.Pad([]byte("absent"))
+	if found, err := store.Has(absent.Addr()); err != nil || found {
+		t.Errorf("%s: Has(absent): found=%v err=%v", name, found, err)
+	}
+	if found, err := store.Get(absent.Addr(), &common.Block{}); err != nil || found {
+		t.Errorf("%s: Get(absent): found=%v err=%v", name, found, err)
+	}
+	if deleted, err := store.Remove(absent.Addr(), false); err != nil || deleted {
+		t.Errorf("%s: Remove(absent): deleted=%v err=%v", name, deleted, err)
+	}
+
+	if deleted, err := store.Remove(blocks[1].Addr(), false); err != nil || !deleted {
+		t.Errorf("%s: Remove: deleted=%v err=%v", name, deleted, err)
+	}
+	if deleted, err := store.Remove(blocks[4].Addr(), true); err != nil || !deleted {
+		t.Errorf("%s: Remove with shred: deleted=%v err=%v", name, deleted, err)
+	}
+	if found, _ := store.Has(blocks[1].Addr()); found {
+		t.Errorf("%s: Has after Remove: expected false", name)
+	}
+	if err := store.Close(); err != nil {
+		t.Errorf("%s: Close: %v", name, err)
+	}
+
+	// Everything but the removed blocks survives a reopen.
+	store = newStore()
+	if err := store.Open(); err != nil {
+		t.Fatalf("%s: reopen: %v", name, err)
+	}
+	defer store.Close()
+	list, err := store.List()
+	if err != nil {
+		t.Fatalf("%s: List: %v", name, err)
+	}
+	expected := AddrList{blocks[0].Addr(), blocks[2].Addr(), blocks[3].Addr()}
+	sort.Sort(expected)
+	if len(list) != len(expected) {
+		t.Fatalf("%s: after reopen: expected %d blocks, got %d", name, len(expected), len(list))
+	}
+	for i := range list {
+		if list[i] != expected[i] {
+			t.Errorf("%s: after reopen: [%d] expected %v, got %v", name, i, expected[i], list[i])
+		}
+	}
+	if n := store.Len(); n != len(expected) {
+		t.Errorf("%s: after reopen: expected Len()=%d, got %d", name, len(expected), n)
+	}
+	var block common.Block
+	if found, err := store.Get(blocks[3].Addr(), &block); err != nil || !found || block != blocks[3] {
+		t.Errorf("%s: Get after reopen: found=%v err=%v", name, found, err)
+	}
+	if found, _ := store.Has(blocks[4].Addr()); found {
+		t.Errorf("%s: shredded block came back after reopen", name)
+	}
+}
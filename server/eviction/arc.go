@@ -0,0 +1,127 @@
+package eviction
+
+import (
+	"github.com/cloud9-tools/go-cas/common"
+)
+
+// ARC is the Adaptive Replacement Cache of Megiddo and Modha.  It splits the
+// cache between keys seen once recently (t1) and keys seen at least twice
+// (t2), and remembers the keys recently evicted from each (b1 and b2).  A
+// miss that hits one of the ghost lists shifts the target size p of t1 in
+// that list's favor, so the split adapts between recency and frequency.
+type ARC struct {
+	capacity int
+	p        int
+	t1, t2   *queue
+	b1, b2   *queue
+}
+
+func NewARC(capacity int) *ARC {
+	return &ARC{
+		capacity: capacity,
+		t1:       newQueue(),
+		t2:       newQueue(),
+		b1:       newQueue(),
+		b2:       newQueue(),
+	}
+}
+
+func (p *ARC) Len() int {
+	return p.t1.Len() + p.t2.Len()
+}
+
+func (p *ARC) Contains(addr common.Addr) bool {
+	return p.t1.Contains(addr) || p.t2.Contains(addr)
+}
+
+func (p *ARC) Hit(addr common.Addr) {
+	if p.t1.Remove(addr) {
+		p.t2.PushFront(addr)
+		return
+	}
+	p.t2.MoveToFront(addr)
+}
+
+func (p *ARC) Insert(addr common.Addr) (bool, []common.Addr) {
+	if p.Contains(addr) {
+		p.Hit(addr)
+		return true, nil
+	}
+	var evicted []common.Addr
+	switch {
+	case p.b1.Contains(addr):
+		p.p = min(p.capacity, p.p+max(1, p.b2.Len()/p.b1.Len()))
+		p.b1.Remove(addr)
+		evicted = p.replace(false)
+		p.t2.PushFront(addr)
+
+	case p.b2.Contains(addr):
+		p.p = max(0, p.p-max(1, p.b1.Len()/p.b2.Len()))
+		p.b2.Remove(addr)
+		evicted = p.replace(true)
+		p.t2.PushFront(addr)
+
+	default:
+		l1 := p.t1.Len() + p.b1.Len()
+		total := l1 + p.t2.Len() + p.b2.Len()
+		if l1 >= p.capacity {
+			if p.t1.Len() < p.capacity {
+				p.b1.PopBack()
+				evicted = p.replace(false)
+			} else {
+				evicted = append(evicted, p.t1.PopBack())
+			}
+		} else if total >= p.capacity {
+			if total >= 2*p.capacity {
+				p.b2.PopBack()
+			}
+			evicted = p.replace(false)
+		}
+		p.t1.PushFront(addr)
+	}
+	return true, evicted
+}
+
+// replace makes room for one more resident key, if the cache is full, by
+// demoting the least recent key of t1 or t2 to the corresponding ghost list.
+func (p *ARC) replace(inB2 bool) []common.Addr {
+	if p.Len() < p.capacity {
+		return nil
+	}
+	if n := p.t1.Len(); n > 0 && (p.t2.Len() == 0 || n > p.p || (inB2 && n == p.p)) {
+		victim := p.t1.PopBack()
+		p.b1.PushFront(victim)
+		return []common.Addr{victim}
+	}
+	victim := p.t2.PopBack()
+	p.b2.PushFront(victim)
+	return []common.Addr{victim}
+}
+
+func (p *ARC) Remove(addr common.Addr) {
+	p.t1.Remove(addr)
+	p.t2.Remove(addr)
+	p.b1.Remove(addr)
+	p.b2.Remove(addr)
+}
+
+func (p *ARC) Keys() []common.Addr {
+	keys := make([]common.Addr, 0, p.Len())
+	return p.t1.AppendTo(p.t2.AppendTo(keys))
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+var _ Policy = (*ARC)(nil)
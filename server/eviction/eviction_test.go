@@ -0,0 +1,124 @@
+package eviction
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/cloud9-tools/go-cas/common"
+)
+
+func key(i int) common.Addr {
+	return common.Addr(sha1.Sum([]byte(fmt.Sprintf("key %d", i))))
+}
+
+func TestPolicies_invariants(t *testing.T) {
+	const capacity = 10
+	for _, name := range Names() {
+		p, err := New(name, capacity)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		rng := rand.New(rand.NewSource(42))
+		resident := make(map[common.Addr]bool)
+		for i := 0; i < 5000; i++ {
+			addr := key(rng.Intn(50))
+			switch {
+			case i%97 == 0:
+				p.Remove(addr)
+				delete(resident, addr)
+			case p.Contains(addr):
+				p.Hit(addr)
+			default:
+				admitted, evicted := p.Insert(addr)
+				for _, victim := range evicted {
+					if victim == addr || !resident[victim] {
+						t.Fatalf("%s: [%d] evicted non-resident key %v", name, i, victim)
+					}
+					delete(resident, victim)
+				}
+				if admitted {
+					resident[addr] = true
+				}
+			}
+			if p.Len() != len(resident) || p.Len() > capacity {
+				t.Fatalf("%s: [%d] expected Len()=%d <= %d, got %d", name, i, len(resident), capacity, p.Len())
+			}
+			if p.Contains(addr) != resident[addr] {
+				t.Fatalf("%s: [%d] Contains(%v) disagrees with Insert/Remove history", name, i, addr)
+			}
+			if keys := p.Keys(); len(keys) != len(resident) {
+				t.Fatalf("%s: [%d] expected %d Keys(), got %d", name, i, len(resident), len(keys))
+			} else {
+				for _, key := range keys {
+					if !resident[key] {
+						t.Fatalf("%s: [%d] Keys() includes non-resident key %v", name, i, key)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestLRU(t *testing.T) {
+	p := NewLRU(2)
+	p.Insert(key(1))
+	p.Insert(key(2))
+	p.Hit(key(1))
+	_, evicted := p.Insert(key(3))
+	if len(evicted) != 1 || evicted[0] != key(2) {
+		t.Errorf("expected to evict key 2, got %v", evicted)
+	}
+}
+
+func TestLFU(t *testing.T) {
+	p := NewLFU(2)
+	p.Insert(key(1))
+	p.Insert(key(2))
+	p.Hit(key(1))
+	p.Hit(key(1))
+	p.Hit(key(2))
+	_, evicted := p.Insert(key(3))
+	if len(evicted) != 1 || evicted[0] != key(2) {
+		t.Errorf("expected to evict key 2, got %v", evicted)
+	}
+	_, evicted = p.Insert(key(4))
+	if len(evicted) != 1 || evicted[0] != key(3) {
+		t.Errorf("expected to evict key 3, got %v", evicted)
+	}
+	if keys := p.Keys(); len(keys) != 2 || keys[0] != key(1) || keys[1] != key(4) {
+		t.Errorf("expected Keys() to be [key 1, key 4], got %v", keys)
+	}
+}
+
+// A small, established hot set interleaved with a long scan defeats LRU,
+// since each hot key's reuse distance exceeds the cache size, but the
+// frequency-aware policies should keep the hot set resident.
+func TestSimulate_scan(t *testing.T) {
+	const capacity = 100
+	const rounds = 5000
+	var trace []common.Addr
+	for i := 0; i < 50; i++ {
+		trace = append(trace, key(i), key(i))
+	}
+	next := 1000
+	for i := 0; i < rounds; i++ {
+		trace = append(trace, key(i%50), key(next), key(next+1))
+		next += 2
+	}
+
+	hits := make(map[string]int)
+	for _, name := range Names() {
+		p, _ := New(name, capacity)
+		hits[name], _ = Simulate(p, trace)
+	}
+	if hits["lru"] > 100 {
+		t.Errorf("lru: expected the scan to flush the hot set, got %d hits", hits["lru"])
+	}
+	for _, name := range []string{"lfu", "arc", "tinylfu"} {
+		if hits[name] < rounds*9/10 {
+			t.Errorf("%s: expected most of %d hot accesses to hit, got %d hits", name, rounds, hits[name])
+		}
+	}
+}
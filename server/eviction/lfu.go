@@ -0,0 +1,111 @@
+package eviction
+
+import (
+	"container/list"
+
+	"github.com/cloud9-tools/go-cas/common"
+)
+
+// LFU evicts the least frequently used key, breaking ties by recency.
+//
+// Every operation is O(1): keys with the same use count share a bucket, and
+// the buckets are kept in a list sorted by count, so the victim is always at
+// the tail of the first bucket.
+type LFU struct {
+	capacity int
+	buckets  *list.List // of *lfuBucket, ascending by count
+	byAddr   map[common.Addr]*lfuItem
+}
+
+type lfuBucket struct {
+	count uint64
+	items *list.List // of common.Addr, most recent first
+}
+
+type lfuItem struct {
+	bucket *list.Element
+	elem   *list.Element
+}
+
+func NewLFU(capacity int) *LFU {
+	return &LFU{
+		capacity: capacity,
+		buckets:  list.New(),
+		byAddr:   make(map[common.Addr]*lfuItem, capacity),
+	}
+}
+
+func (p *LFU) Len() int {
+	return len(p.byAddr)
+}
+
+func (p *LFU) Contains(addr common.Addr) bool {
+	_, found := p.byAddr[addr]
+	return found
+}
+
+func (p *LFU) Hit(addr common.Addr) {
+	item, found := p.byAddr[addr]
+	if !found {
+		return
+	}
+	cur := item.bucket.Value.(*lfuBucket)
+	next := item.bucket.Next()
+	if next == nil || next.Value.(*lfuBucket).count != cur.count+1 {
+		next = p.buckets.InsertAfter(&lfuBucket{count: cur.count + 1, items: list.New()}, item.bucket)
+	}
+	cur.items.Remove(item.elem)
+	if cur.items.Len() == 0 {
+		p.buckets.Remove(item.bucket)
+	}
+	item.bucket = next
+	item.elem = next.Value.(*lfuBucket).items.PushFront(addr)
+}
+
+func (p *LFU) Insert(addr common.Addr) (bool, []common.Addr) {
+	if _, found := p.byAddr[addr]; found {
+		p.Hit(addr)
+		return true, nil
+	}
+	var evicted []common.Addr
+	for len(p.byAddr) >= p.capacity {
+		first := p.buckets.Front().Value.(*lfuBucket)
+		victim := first.items.Back().Value.(common.Addr)
+		p.Remove(victim)
+		evicted = append(evicted, victim)
+	}
+	front := p.buckets.Front()
+	if front == nil || front.Value.(*lfuBucket).count != 1 {
+		front = p.buckets.PushFront(&lfuBucket{count: 1, items: list.New()})
+	}
+	p.byAddr[addr] = &lfuItem{
+		bucket: front,
+		elem:   front.Value.(*lfuBucket).items.PushFront(addr),
+	}
+	return true, evicted
+}
+
+func (p *LFU) Remove(addr common.Addr) {
+	item, found := p.byAddr[addr]
+	if !found {
+		return
+	}
+	b := item.bucket.Value.(*lfuBucket)
+	b.items.Remove(item.elem)
+	if b.items.Len() == 0 {
+		p.buckets.Remove(item.bucket)
+	}
+	delete(p.byAddr, addr)
+}
+
+func (p *LFU) Keys() []common.Addr {
+	keys := make([]common.Addr, 0, len(p.byAddr))
+	for b := p.buckets.Back(); b != nil; b = b.Prev() {
+		for elem := b.Value.(*lfuBucket).items.Front(); elem != nil; elem = elem.Next() {
+			keys = append(keys, elem.Value.(common.Addr))
+		}
+	}
+	return keys
+}
+
+var _ Policy = (*LFU)(nil)
@@ -0,0 +1,50 @@
+package eviction
+
+import (
+	"github.com/cloud9-tools/go-cas/common"
+)
+
+// LRU evicts the least recently used key.
+type LRU struct {
+	capacity int
+	q        *queue
+}
+
+func NewLRU(capacity int) *LRU {
+	return &LRU{capacity: capacity, q: newQueue()}
+}
+
+func (p *LRU) Len() int {
+	return p.q.Len()
+}
+
+func (p *LRU) Contains(addr common.Addr) bool {
+	return p.q.Contains(addr)
+}
+
+func (p *LRU) Hit(addr common.Addr) {
+	p.q.MoveToFront(addr)
+}
+
+func (p *LRU) Insert(addr common.Addr) (bool, []common.Addr) {
+	if p.q.Contains(addr) {
+		p.q.MoveToFront(addr)
+		return true, nil
+	}
+	var evicted []common.Addr
+	for p.q.Len() >= p.capacity {
+		evicted = append(evicted, p.q.PopBack())
+	}
+	p.q.PushFront(addr)
+	return true, evicted
+}
+
+func (p *LRU) Remove(addr common.Addr) {
+	p.q.Remove(addr)
+}
+
+func (p *LRU) Keys() []common.Addr {
+	return p.q.AppendTo(make([]common.Addr, 0, p.q.Len()))
+}
+
+var _ Policy = (*LRU)(nil)
@@ -0,0 +1,89 @@
+// Package eviction provides cache replacement policies for cacheserver.
+//
+// A Policy only tracks keys; the caller owns the cached values and is
+// responsible for dropping the ones that the Policy evicts.  None of the
+// implementations are safe for concurrent use.
+package eviction
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cloud9-tools/go-cas/common"
+)
+
+type Policy interface {
+	// Len returns the number of resident keys.
+	Len() int
+
+	// Contains returns true iff addr is resident.  It does not count as an
+	// access.
+	Contains(addr common.Addr) bool
+
+	// Hit records an access to a resident key.
+	Hit(addr common.Addr)
+
+	// Insert offers a non-resident key to the cache.  It returns true iff
+	// addr was admitted, along with the resident keys (never addr itself)
+	// that were evicted to make room.
+	Insert(addr common.Addr) (admitted bool, evicted []common.Addr)
+
+	// Remove forgets a key, resident or not.
+	Remove(addr common.Addr)
+
+	// Keys returns the resident keys, roughly from most to least valuable.
+	Keys() []common.Addr
+}
+
+var constructors = map[string]func(capacity int) Policy{
+	"lru":     func(n int) Policy { return NewLRU(n) },
+	"lfu":     func(n int) Policy { return NewLFU(n) },
+	"arc":     func(n int) Policy { return NewARC(n) },
+	"tinylfu": func(n int) Policy { return NewTinyLFU(n) },
+}
+
+// Names returns the names accepted by New, sorted.
+func Names() []string {
+	names := make([]string, 0, len(constructors))
+	for name := range constructors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Valid returns an error if New would not recognize name.
+func Valid(name string) error {
+	if _, found := constructors[name]; !found {
+		return fmt.Errorf("unknown eviction policy %q: expected one of %s", name, strings.Join(Names(), ", "))
+	}
+	return nil
+}
+
+// New returns a new Policy of the named type that holds at most capacity keys.
+func New(name string, capacity int) (Policy, error) {
+	if err := Valid(name); err != nil {
+		return nil, err
+	}
+	if capacity < 1 {
+		return nil, fmt.Errorf("invalid capacity %d: must be at least 1", capacity)
+	}
+	return constructors[name](capacity), nil
+}
+
+// Simulate replays trace against p, as a read-through cache would: each
+// resident key is a hit, and each non-resident key is a miss followed by an
+// Insert.
+func Simulate(p Policy, trace []common.Addr) (hits, misses int) {
+	for _, addr := range trace {
+		if p.Contains(addr) {
+			p.Hit(addr)
+			hits++
+		} else {
+			p.Insert(addr)
+			misses++
+		}
+	}
+	return
+}
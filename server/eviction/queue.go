@@ -0,0 +1,73 @@
+package eviction
+
+import (
+	"container/list"
+
+	"github.com/cloud9-tools/go-cas/common"
+)
+
+// queue is a recency-ordered set of keys with O(1) membership tests.
+type queue struct {
+	order  *list.List // of common.Addr, most recent first
+	byAddr map[common.Addr]*list.Element
+}
+
+func newQueue() *queue {
+	return &queue{
+		order:  list.New(),
+		byAddr: make(map[common.Addr]*list.Element),
+	}
+}
+
+func (q *queue) Len() int {
+	return q.order.Len()
+}
+
+func (q *queue) Contains(addr common.Addr) bool {
+	_, found := q.byAddr[addr]
+	return found
+}
+
+// PushFront adds addr as the most recent key.  addr must not already be
+// present.
+func (q *queue) PushFront(addr common.Addr) {
+	q.byAddr[addr] = q.order.PushFront(addr)
+}
+
+// MoveToFront marks addr as the most recent key, if it is present.
+func (q *queue) MoveToFront(addr common.Addr) {
+	if elem, found := q.byAddr[addr]; found {
+		q.order.MoveToFront(elem)
+	}
+}
+
+// Back returns the least recent key.  The queue must not be empty.
+func (q *queue) Back() common.Addr {
+	return q.order.Back().Value.(common.Addr)
+}
+
+// PopBack removes and returns the least recent key.  The queue must not be
+// empty.
+func (q *queue) PopBack() common.Addr {
+	addr := q.order.Remove(q.order.Back()).(common.Addr)
+	delete(q.byAddr, addr)
+	return addr
+}
+
+// Remove deletes addr, returning true iff it was present.
+func (q *queue) Remove(addr common.Addr) bool {
+	elem, found := q.byAddr[addr]
+	if found {
+		q.order.Remove(elem)
+		delete(q.byAddr, addr)
+	}
+	return found
+}
+
+// AppendTo appends the keys to out, most recent first.
+func (q *queue) AppendTo(out []common.Addr) []common.Addr {
+	for elem := q.order.Front(); elem != nil; elem = elem.Next() {
+		out = append(out, elem.Value.(common.Addr))
+	}
+	return out
+}
@@ -0,0 +1,168 @@
+package eviction
+
+import (
+	"encoding/binary"
+
+	"github.com/cloud9-tools/go-cas/common"
+)
+
+const (
+	sketchDepth    = 4
+	sketchMaxCount = 15
+)
+
+// TinyLFU is the W-TinyLFU policy of Einziger, Friedman and Manes.  New keys
+// enter a small LRU window (1% of capacity).  Keys that fall out of the window
+// are admitted to the main segmented LRU only if a frequency sketch says they
+// are used more often than the main cache's next victim, which protects the
+// cache from one-hit wonders and large scans.
+type TinyLFU struct {
+	windowCap    int
+	mainCap      int
+	protectedCap int
+	window       *queue
+	probation    *queue
+	protected    *queue
+	sketch       *sketch
+}
+
+func NewTinyLFU(capacity int) *TinyLFU {
+	windowCap := max(1, capacity/100)
+	mainCap := capacity - windowCap
+	return &TinyLFU{
+		windowCap:    windowCap,
+		mainCap:      mainCap,
+		protectedCap: mainCap * 8 / 10,
+		window:       newQueue(),
+		probation:    newQueue(),
+		protected:    newQueue(),
+		sketch:       newSketch(capacity),
+	}
+}
+
+func (p *TinyLFU) Len() int {
+	return p.window.Len() + p.probation.Len() + p.protected.Len()
+}
+
+func (p *TinyLFU) Contains(addr common.Addr) bool {
+	return p.window.Contains(addr) || p.probation.Contains(addr) || p.protected.Contains(addr)
+}
+
+func (p *TinyLFU) Hit(addr common.Addr) {
+	p.sketch.Add(addr)
+	switch {
+	case p.window.Contains(addr):
+		p.window.MoveToFront(addr)
+	case p.probation.Remove(addr):
+		p.protected.PushFront(addr)
+		if p.protected.Len() > p.protectedCap {
+			p.probation.PushFront(p.protected.PopBack())
+		}
+	default:
+		p.protected.MoveToFront(addr)
+	}
+}
+
+func (p *TinyLFU) Insert(addr common.Addr) (bool, []common.Addr) {
+	if p.Contains(addr) {
+		p.Hit(addr)
+		return true, nil
+	}
+	p.sketch.Add(addr)
+	p.window.PushFront(addr)
+	if p.window.Len() <= p.windowCap {
+		return true, nil
+	}
+
+	candidate := p.window.PopBack()
+	if p.probation.Len()+p.protected.Len() < p.mainCap {
+		p.probation.PushFront(candidate)
+		return true, nil
+	}
+	if p.mainCap == 0 {
+		return true, []common.Addr{candidate}
+	}
+	mainq := p.probation
+	if mainq.Len() == 0 {
+		mainq = p.protected
+	}
+	victim := mainq.Back()
+	if p.sketch.Estimate(candidate) <= p.sketch.Estimate(victim) {
+		return true, []common.Addr{candidate}
+	}
+	mainq.Remove(victim)
+	p.probation.PushFront(candidate)
+	return true, []common.Addr{victim}
+}
+
+func (p *TinyLFU) Remove(addr common.Addr) {
+	p.window.Remove(addr)
+	p.probation.Remove(addr)
+	p.protected.Remove(addr)
+}
+
+func (p *TinyLFU) Keys() []common.Addr {
+	keys := make([]common.Addr, 0, p.Len())
+	return p.probation.AppendTo(p.window.AppendTo(p.protected.AppendTo(keys)))
+}
+
+// sketch is a count-min sketch of small saturating counters.  Once it has
+// seen ten samples per cache slot, every counter is halved, so that the
+// estimates favor recent history.
+type sketch struct {
+	mask    uint64
+	rows    [sketchDepth][]uint8
+	samples int
+	resetAt int
+}
+
+func newSketch(capacity int) *sketch {
+	width := uint64(16)
+	for width < uint64(capacity) {
+		width <<= 1
+	}
+	s := &sketch{mask: width - 1, resetAt: 10 * capacity}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, width)
+	}
+	return s
+}
+
+// index returns the counter index for addr in row i.  Addrs are already
+// uniformly distributed hashes, so slices of them make fine hash functions;
+// the first four bytes are skipped because they select the cache shard.
+func (s *sketch) index(addr common.Addr, i int) uint64 {
+	h1 := binary.BigEndian.Uint64(addr[4:12])
+	h2 := binary.BigEndian.Uint64(addr[12:20])
+	return (h1 + uint64(i)*h2) & s.mask
+}
+
+func (s *sketch) Add(addr common.Addr) {
+	for i := range s.rows {
+		j := s.index(addr, i)
+		if s.rows[i][j] < sketchMaxCount {
+			s.rows[i][j]++
+		}
+	}
+	s.samples++
+	if s.samples >= s.resetAt {
+		for i := range s.rows {
+			for j := range s.rows[i] {
+				s.rows[i][j] >>= 1
+			}
+		}
+		s.samples /= 2
+	}
+}
+
+func (s *sketch) Estimate(addr common.Addr) uint8 {
+	est := uint8(sketchMaxCount)
+	for i := range s.rows {
+		if c := s.rows[i][s.index(addr, i)]; c < est {
+			est = c
+		}
+	}
+	return est
+}
+
+var _ Policy = (*TinyLFU)(nil)
@@ -40,3 +40,16 @@ type BlockFile interface {
 	WriteBlock(blknum uint32, block *common.Block) error
 	EraseBlock(blknum uint32, shred bool) error
 }
+
+type ObjectFileSystem interface {
+	OpenObjects(WriteType) (ObjectSet, error)
+}
+
+type ObjectSet interface {
+	Name() string
+	Close() error
+	ReadObject(addr common.Addr, block *common.Block) error
+	WriteObject(addr common.Addr, block *common.Block) error
+	EraseObject(addr common.Addr, shred bool) error
+	ListObjects() ([]common.Addr, error)
+}
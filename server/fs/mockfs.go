@@ -194,3 +194,117 @@ func (_m *MockBlockFile) EraseBlock(blknum uint32, shred bool) error {
 func (_mr *_MockBlockFileRecorder) EraseBlock(arg0, arg1 interface{}) *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "EraseBlock", arg0, arg1)
 }
+
+// Mock of ObjectFileSystem interface
+type MockObjectFileSystem struct {
+	ctrl     *gomock.Controller
+	recorder *_MockObjectFileSystemRecorder
+}
+
+// Recorder for MockObjectFileSystem (not exported)
+type _MockObjectFileSystemRecorder struct {
+	mock *MockObjectFileSystem
+}
+
+func NewMockObjectFileSystem(ctrl *gomock.Controller) *MockObjectFileSystem {
+	mock := &MockObjectFileSystem{ctrl: ctrl}
+	mock.recorder = &_MockObjectFileSystemRecorder{mock}
+	return mock
+}
+
+func (_m *MockObjectFileSystem) EXPECT() *_MockObjectFileSystemRecorder {
+	return _m.recorder
+}
+
+func (_m *MockObjectFileSystem) OpenObjects(_param0 WriteType) (ObjectSet, error) {
+	ret := _m.ctrl.Call(_m, "OpenObjects", _param0)
+	ret0, _ := ret[0].(ObjectSet)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockObjectFileSystemRecorder) OpenObjects(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "OpenObjects", arg0)
+}
+
+// Mock of ObjectSet interface
+type MockObjectSet struct {
+	ctrl     *gomock.Controller
+	recorder *_MockObjectSetRecorder
+}
+
+// Recorder for MockObjectSet (not exported)
+type _MockObjectSetRecorder struct {
+	mock *MockObjectSet
+}
+
+func NewMockObjectSet(ctrl *gomock.Controller) *MockObjectSet {
+	mock := &MockObjectSet{ctrl: ctrl}
+	mock.recorder = &_MockObjectSetRecorder{mock}
+	return mock
+}
+
+func (_m *MockObjectSet) EXPECT() *_MockObjectSetRecorder {
+	return _m.recorder
+}
+
+func (_m *MockObjectSet) Name() string {
+	ret := _m.ctrl.Call(_m, "Name")
+	ret0, _ := ret[0].(string)
+	return ret0
+}
+
+func (_mr *_MockObjectSetRecorder) Name() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Name")
+}
+
+func (_m *MockObjectSet) Close() error {
+	ret := _m.ctrl.Call(_m, "Close")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockObjectSetRecorder) Close() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Close")
+}
+
+func (_m *MockObjectSet) ReadObject(addr common.Addr, block *common.Block) error {
+	ret := _m.ctrl.Call(_m, "ReadObject", addr, block)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockObjectSetRecorder) ReadObject(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "ReadObject", arg0, arg1)
+}
+
+func (_m *MockObjectSet) WriteObject(addr common.Addr, block *common.Block) error {
+	ret := _m.ctrl.Call(_m, "WriteObject", addr, block)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockObjectSetRecorder) WriteObject(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "WriteObject", arg0, arg1)
+}
+
+func (_m *MockObjectSet) EraseObject(addr common.Addr, shred bool) error {
+	ret := _m.ctrl.Call(_m, "EraseObject", addr, shred)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockObjectSetRecorder) EraseObject(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "EraseObject", arg0, arg1)
+}
+
+func (_m *MockObjectSet) ListObjects() ([]common.Addr, error) {
+	ret := _m.ctrl.Call(_m, "ListObjects")
+	ret0, _ := ret[0].([]common.Addr)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockObjectSetRecorder) ListObjects() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "ListObjects")
+}
@@ -155,12 +155,16 @@ func (f NativeBlockFile) WriteBlock(blknum uint32, block *common.Block) error {
 	return nil
 }
 
-var empty, shred55, shredAA, shredFF common.Block
+// Empty is a block of zeroes, and ShredPatterns are the blocks written over
+// a block, in order, to shred it.  Each is allocated on its own rather than
+// declared as an array, so that it is page-aligned for O_DIRECT writes.
+var Empty = new(common.Block)
+var ShredPatterns = []*common.Block{pattern(0xAA), pattern(0x55), pattern(0xFF)}
 
-func init() {
-	copy(shred55[:], bytes.Repeat([]byte{0x55}, common.BlockSize))
-	copy(shredAA[:], bytes.Repeat([]byte{0xAA}, common.BlockSize))
-	copy(shredFF[:], bytes.Repeat([]byte{0xFF}, common.BlockSize))
+func pattern(b byte) *common.Block {
+	block := new(common.Block)
+	copy(block[:], bytes.Repeat([]byte{b}, common.BlockSize))
+	return block
 }
 
 func (f NativeBlockFile) EraseBlock(blknum uint32, shred bool) error {
@@ -175,20 +179,14 @@ func (f NativeBlockFile) EraseBlock(blknum uint32, shred bool) error {
 			return err
 		}
 
-		if err := f.WriteBlock(blknum, &shredAA); err != nil {
-			return err
-		}
-
-		if err := f.WriteBlock(blknum, &shred55); err != nil {
-			return err
-		}
-
-		if err := f.WriteBlock(blknum, &shredFF); err != nil {
-			return err
+		for _, pattern := range ShredPatterns {
+			if err := f.WriteBlock(blknum, pattern); err != nil {
+				return err
+			}
 		}
 	}
 
-	if err := writeExactlyAt(f.Handle, empty[:], offset); err != nil {
+	if err := writeExactlyAt(f.Handle, Empty[:], offset); err != nil {
 		return err
 	}
 
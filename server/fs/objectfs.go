@@ -0,0 +1,183 @@
+package fs
+
+import (
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/cloud9-tools/go-cas/common"
+)
+
+// OpenObjects opens a directory-per-object layout rooted at RootDir/objects.
+// Each block is stored, with trailing zeroes trimmed, in its own file named
+// after its Addr: the first two hex digits select a fan-out subdirectory and
+// the remaining digits name the file, just like Git's loose objects.
+func (fs NativeFileSystem) OpenObjects(wt WriteType) (ObjectSet, error) {
+	fh, err := fs.open("objects.lock", wt, normalIO)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(fs.RootDir, "objects")
+	if wt == ReadWrite {
+		if err := os.MkdirAll(dir, 0777); err != nil {
+			fh.Close()
+			return nil, err
+		}
+	}
+	return NativeObjectSet{Dir: dir, Lock: fh}, nil
+}
+
+type NativeObjectSet struct {
+	Dir  string
+	Lock *os.File
+}
+
+func (set NativeObjectSet) Name() string {
+	return set.Dir
+}
+
+func (set NativeObjectSet) Close() error {
+	return set.Lock.Close()
+}
+
+func (set NativeObjectSet) path(addr common.Addr) (dir, file string) {
+	str := addr.String()
+	dir = filepath.Join(set.Dir, str[:2])
+	file = filepath.Join(dir, str[2:])
+	return
+}
+
+func (set NativeObjectSet) ReadObject(addr common.Addr, block *common.Block) error {
+	_, file := set.path(addr)
+	raw, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = ErrNotFound
+		}
+		return err
+	}
+	return block.Pad(raw)
+}
+
+func (set NativeObjectSet) WriteObject(addr common.Addr, block *common.Block) error {
+	dir, file := set.path(addr)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(dir, ".tmp")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName)
+	if err := writeExactlyAt(tmp, block.Trim(), 0); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpName, file); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+func (set NativeObjectSet) EraseObject(addr common.Addr, shred bool) error {
+	dir, file := set.path(addr)
+	if shred {
+		if err := shredFile(file); err != nil {
+			if os.IsNotExist(err) {
+				err = ErrNotFound
+			}
+			return err
+		}
+	}
+	if err := os.Remove(file); err != nil {
+		if os.IsNotExist(err) {
+			err = ErrNotFound
+		}
+		return err
+	}
+	return syncDir(dir)
+}
+
+func (set NativeObjectSet) ListObjects() ([]common.Addr, error) {
+	fanout, err := ioutil.ReadDir(set.Dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var list []common.Addr
+	for _, fi := range fanout {
+		if !fi.IsDir() || len(fi.Name()) != 2 {
+			continue
+		}
+		entries, err := ioutil.ReadDir(filepath.Join(set.Dir, fi.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, fi2 := range entries {
+			var addr common.Addr
+			if addr.Parse(fi.Name()+fi2.Name()) != nil {
+				continue
+			}
+			list = append(list, addr)
+		}
+	}
+	sort.Sort(addrList(list))
+	return list, nil
+}
+
+type addrList []common.Addr
+
+func (x addrList) Len() int           { return len(x) }
+func (x addrList) Less(i, j int) bool { return x[i].Less(x[j]) }
+func (x addrList) Swap(i, j int)      { x[i], x[j] = x[j], x[i] }
+
+func shredFile(path string) error {
+	fh, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	fi, err := fh.Stat()
+	if err != nil {
+		return err
+	}
+	size := fi.Size()
+	if size > common.BlockSize {
+		size = common.BlockSize
+	}
+
+	var random common.Block
+	if _, err := rand.Read(random[:]); err != nil {
+		return err
+	}
+	for _, pattern := range append([]*common.Block{&random}, ShredPatterns...) {
+		if err := writeExactlyAt(fh, pattern[:size], 0); err != nil {
+			return err
+		}
+		if err := fh.Sync(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func syncDir(dir string) error {
+	fh, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	return fh.Sync()
+}
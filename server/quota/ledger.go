@@ -0,0 +1,243 @@
+package quota
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/cloud9-tools/go-cas/common"
+	"github.com/cloud9-tools/go-cas/server/auth"
+)
+
+// Ledger records which Role owns each block, i.e. which Role first stored
+// or pinned it, and enforces the block quotas in a Table.  Blocks that were
+// stored before the ledger was started have no owner, and count against no
+// one's quota.
+//
+// A Ledger may be backed by a journal file, so that ownership survives
+// restarts.  The journal is compacted each time it is opened.  A nil
+// *Ledger allows everything.
+type Ledger struct {
+	mutex  sync.Mutex
+	table  Table
+	owners map[common.Addr]auth.Role
+	counts map[auth.Role]int64
+	path   string
+	f      *os.File
+}
+
+// NewLedger returns an in-memory Ledger for table, or nil if table has no
+// quotas.
+func NewLedger(table Table) *Ledger {
+	if !table.HasQuotas() {
+		return nil
+	}
+	return &Ledger{
+		table:  table,
+		owners: make(map[common.Addr]auth.Role),
+		counts: make(map[auth.Role]int64),
+	}
+}
+
+// OpenLedger is NewLedger, but backed by the journal at path.
+func OpenLedger(path string, table Table) (*Ledger, error) {
+	l := NewLedger(table)
+	if l == nil {
+		return nil, nil
+	}
+	l.path = path
+	f, err := os.Open(path)
+	if err == nil {
+		err = l.replay(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%q: %v", path, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err := l.compact(); err != nil {
+		return nil, fmt.Errorf("%q: %v", path, err)
+	}
+	return l, nil
+}
+
+// Journal lines are "+<addr>\t<role>" when a block gains an owner, and
+// "-<addr>" when it loses one.
+func (l *Ledger) replay(r io.Reader) error {
+	br := bufio.NewReader(r)
+	lineno := 0
+	for {
+		line, err := br.ReadString('\n')
+		if err == io.EOF {
+			// An entry without a newline was cut short by a crash.
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		lineno++
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			continue
+		}
+		fields := strings.SplitN(line[1:], "\t", 2)
+		var addr common.Addr
+		if err := addr.Parse(fields[0]); err != nil {
+			return fmt.Errorf("line %d: %v", lineno, err)
+		}
+		switch {
+		case line[0] == '+' && len(fields) == 2:
+			l.add(addr, auth.Role(fields[1]))
+		case line[0] == '-' && len(fields) == 1:
+			l.remove(addr)
+		default:
+			return fmt.Errorf("line %d: malformed entry", lineno)
+		}
+	}
+}
+
+// compact rewrites the journal with one entry per owned block.
+func (l *Ledger) compact() error {
+	tmp := l.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(f)
+	for addr, role := range l.owners {
+		fmt.Fprintf(bw, "+%v\t%s\n", addr, role)
+	}
+	err = bw.Flush()
+	if err == nil {
+		err = f.Sync()
+	}
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err == nil {
+		err = os.Rename(tmp, l.path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	l.f, err = os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND, 0666)
+	return err
+}
+
+func (l *Ledger) Close() error {
+	if l == nil || l.f == nil {
+		return nil
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
+
+func (l *Ledger) add(addr common.Addr, role auth.Role) {
+	if _, found := l.owners[addr]; found {
+		return
+	}
+	l.owners[addr] = role
+	l.counts[role]++
+}
+
+func (l *Ledger) remove(addr common.Addr) bool {
+	role, found := l.owners[addr]
+	if !found {
+		return false
+	}
+	delete(l.owners, addr)
+	l.counts[role]--
+	if l.counts[role] == 0 {
+		delete(l.counts, role)
+	}
+	return true
+}
+
+// journal appends line to the journal, if there is one.  l.mutex must be
+// held.
+func (l *Ledger) journal(line string) {
+	if l.f == nil {
+		return
+	}
+	if _, err := io.WriteString(l.f, line); err != nil {
+		log.Printf("error: failed to write quota ledger %q: %v", l.path, err)
+	}
+}
+
+// Reserve makes role the owner of addr, unless addr already has an owner.
+// It returns ResourceExhausted if role already owns as many blocks as its
+// quota allows.
+func (l *Ledger) Reserve(addr common.Addr, role auth.Role) error {
+	if l == nil {
+		return nil
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if _, found := l.owners[addr]; found {
+		return nil
+	}
+	if err := l.check(role); err != nil {
+		return err
+	}
+	l.add(addr, role)
+	l.journal(fmt.Sprintf("+%v\t%s\n", addr, role))
+	return nil
+}
+
+// Check returns the error that Reserve would return, without reserving
+// anything, so that a caller can refuse a request before acting on it.
+func (l *Ledger) Check(addr common.Addr, role auth.Role) error {
+	if l == nil {
+		return nil
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if _, found := l.owners[addr]; found {
+		return nil
+	}
+	return l.check(role)
+}
+
+// check must be called with the mutex held.
+func (l *Ledger) check(role auth.Role) error {
+	if quota := l.table.For(role).Blocks; quota > 0 && l.counts[role] >= quota {
+		return grpc.Errorf(codes.ResourceExhausted,
+			"quota exceeded: role %q already owns %d blocks, and may own at most %d",
+			string(role), l.counts[role], quota)
+	}
+	return nil
+}
+
+// Release forgets the owner of addr.
+func (l *Ledger) Release(addr common.Addr) {
+	if l == nil {
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.remove(addr) {
+		l.journal(fmt.Sprintf("-%v\n", addr))
+	}
+}
+
+// Usage returns the number of blocks that role owns.
+func (l *Ledger) Usage(role auth.Role) int64 {
+	if l == nil {
+		return 0
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.counts[role]
+}
@@ -0,0 +1,113 @@
+package quota
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/cloud9-tools/go-cas/common"
+	"github.com/cloud9-tools/go-cas/server/auth"
+)
+
+// Limiter enforces the request and byte rates in a Table, with a token
+// bucket per Role for each.  Each bucket holds up to one second's worth of
+// tokens.
+//
+// An RPC takes a request token before it starts.  Since the size of a reply
+// isn't known in advance, bytes are charged once the RPC is done, and may
+// leave the byte bucket in debt; a Role in debt is refused until the bucket
+// refills.  A nil *Limiter allows everything.
+type Limiter struct {
+	mutex   sync.Mutex
+	table   Table
+	buckets map[auth.Role]*roleBuckets
+}
+
+type roleBuckets struct {
+	requests bucket
+	bytes    bucket
+}
+
+type bucket struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter returns a Limiter for table, or nil if table has no rates.
+func NewLimiter(table Table) *Limiter {
+	if !table.HasRates() {
+		return nil
+	}
+	return &Limiter{
+		table:   table,
+		buckets: make(map[auth.Role]*roleBuckets),
+	}
+}
+
+func (b *bucket) refill(now time.Time) {
+	if b.rate <= 0 {
+		return
+	}
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	b.tokens = math.Min(b.tokens, math.Max(b.rate, 1))
+	b.last = now
+}
+
+// bucketsFor returns the buckets for role, refilled up to now.
+// l.mutex must be held.
+func (l *Limiter) bucketsFor(role auth.Role, now time.Time) *roleBuckets {
+	rb := l.buckets[role]
+	if rb == nil {
+		limits := l.table.For(role)
+		rb = &roleBuckets{
+			requests: bucket{rate: limits.Requests, tokens: math.Max(limits.Requests, 1), last: now},
+			bytes:    bucket{rate: limits.Bytes, tokens: limits.Bytes, last: now},
+		}
+		l.buckets[role] = rb
+	}
+	rb.requests.refill(now)
+	rb.bytes.refill(now)
+	return rb
+}
+
+// Allow takes a request token for role, or returns ResourceExhausted if
+// role is over either of its rates.
+func (l *Limiter) Allow(role auth.Role) error {
+	if l == nil {
+		return nil
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	rb := l.bucketsFor(role, time.Now())
+	if rb.requests.rate > 0 {
+		if rb.requests.tokens < 1 {
+			return grpc.Errorf(codes.ResourceExhausted,
+				"%s: role %q may make %g requests per second",
+				common.RateLimitExceeded, string(role), rb.requests.rate)
+		}
+		rb.requests.tokens--
+	}
+	if rb.bytes.rate > 0 && rb.bytes.tokens < 0 {
+		return grpc.Errorf(codes.ResourceExhausted,
+			"%s: role %q may transfer %g bytes per second",
+			common.RateLimitExceeded, string(role), rb.bytes.rate)
+	}
+	return nil
+}
+
+// Charge takes n byte tokens for role.
+func (l *Limiter) Charge(role auth.Role, n int64) {
+	if l == nil || n <= 0 {
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	rb := l.bucketsFor(role, time.Now())
+	if rb.bytes.rate > 0 {
+		rb.bytes.tokens -= float64(n)
+	}
+}
@@ -0,0 +1,220 @@
+package quota
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/cloud9-tools/go-cas/common"
+	"github.com/cloud9-tools/go-cas/server/auth"
+)
+
+func TestTable_Set(t *testing.T) {
+	type testrow struct {
+		In  string
+		Out string
+		Err string
+	}
+	for i, row := range []testrow{
+		testrow{"",
+			"", ""},
+		testrow{"ci-bot:requests=100, ci-bot:bytes=1048576/s,*:blocks=10",
+			"*:blocks=10,ci-bot:requests=100,ci-bot:bytes=1.048576e+06", ""},
+		testrow{"alice:requests=0.5",
+			"alice:requests=0.5", ""},
+		testrow{"alice=5",
+			"", `limit "alice=5": expected "role:kind=value"`},
+		testrow{":blocks=5",
+			"", `limit ":blocks=5": missing role`},
+		testrow{"alice:frobs=5",
+			"", `limit "alice:frobs=5": unknown kind "frobs": expected "requests", "bytes", or "blocks"`},
+		testrow{"alice:blocks=-1",
+			"", `limit "alice:blocks=-1": must not be negative`},
+	} {
+		var table Table
+		err := table.Set(row.In)
+		var errstr string
+		if err != nil {
+			errstr = err.Error()
+		}
+		if errstr != row.Err {
+			t.Errorf("[%d] expected error %q, got %q", i, row.Err, errstr)
+			continue
+		}
+		if err == nil && table.String() != row.Out {
+			t.Errorf("[%d] expected %q, got %q", i, row.Out, table.String())
+		}
+	}
+
+	var table Table
+	table.Set("alice:blocks=3,*:blocks=1")
+	if n := table.For("alice").Blocks; n != 3 {
+		t.Errorf("alice: expected 3 blocks, got %d", n)
+	}
+	if n := table.For("bob").Blocks; n != 1 {
+		t.Errorf("bob: expected the default of 1 block, got %d", n)
+	}
+}
+
+func isExhausted(err error) bool {
+	return grpc.Code(err) == codes.ResourceExhausted
+}
+
+func TestLimiter(t *testing.T) {
+	var table Table
+	if err := table.Set("alice:requests=3,bob:bytes=1000"); err != nil {
+		t.Fatal(err)
+	}
+	if l := NewLimiter(Table{}); l != nil {
+		t.Errorf("expected no limiter without rates, got %v", l)
+	}
+	l := NewLimiter(table)
+
+	for i := 0; i < 3; i++ {
+		if err := l.Allow("alice"); err != nil {
+			t.Fatalf("alice request %d: unexpected error: %v", i, err)
+		}
+	}
+	if err := l.Allow("alice"); !isExhausted(err) {
+		t.Errorf("alice: expected ResourceExhausted, got %v", err)
+	}
+
+	if err := l.Allow("bob"); err != nil {
+		t.Fatalf("bob: unexpected error: %v", err)
+	}
+	l.Charge("bob", 5000)
+	if err := l.Allow("bob"); !isExhausted(err) {
+		t.Errorf("bob in debt: expected ResourceExhausted, got %v", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		if err := l.Allow("carol"); err != nil {
+			t.Fatalf("carol: unexpected error: %v", err)
+		}
+		l.Charge("carol", 1<<20)
+	}
+}
+
+func addrN(n int) common.Addr {
+	var block common.Block
+	block[0] = byte(n)
+	block[1] = byte(n >> 8)
+	return block.Addr()
+}
+
+func TestLedger(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ledger")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "owners")
+
+	var table Table
+	if err := table.Set("alice:blocks=2,*:blocks=1"); err != nil {
+		t.Fatal(err)
+	}
+	l, err := OpenLedger(path, table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := l.Reserve(addrN(i), "alice"); err != nil {
+			t.Fatalf("alice block %d: unexpected error: %v", i, err)
+		}
+	}
+	if err := l.Check(addrN(2), "alice"); !isExhausted(err) {
+		t.Errorf("alice check over quota: expected ResourceExhausted, got %v", err)
+	}
+	if err := l.Check(addrN(0), "alice"); err != nil {
+		t.Errorf("alice check of her own block: unexpected error: %v", err)
+	}
+	if n := l.Usage("alice"); n != 2 {
+		t.Errorf("alice: expected Check not to reserve anything, got %d blocks", n)
+	}
+	err = l.Reserve(addrN(2), "alice")
+	if !isExhausted(err) || !strings.Contains(err.Error(), `role "alice" already owns 2 blocks`) {
+		t.Errorf("alice over quota: expected ResourceExhausted, got %v", err)
+	}
+	if err := l.Reserve(addrN(0), "bob"); err != nil {
+		t.Errorf("bob storing alice's block: unexpected error: %v", err)
+	}
+	if err := l.Reserve(addrN(3), "bob"); err != nil {
+		t.Errorf("bob: unexpected error: %v", err)
+	}
+	l.Release(addrN(1))
+	if n := l.Usage("alice"); n != 1 {
+		t.Errorf("alice: expected 1 block after release, got %d", n)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err = OpenLedger(path, table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if n := l.Usage("alice"); n != 1 {
+		t.Errorf("alice after reopen: expected 1 block, got %d", n)
+	}
+	if n := l.Usage("bob"); n != 1 {
+		t.Errorf("bob after reopen: expected 1 block, got %d", n)
+	}
+	if err := l.Reserve(addrN(4), "bob"); !isExhausted(err) {
+		t.Errorf("bob over quota after reopen: expected ResourceExhausted, got %v", err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(data), "\n"); n != 2 {
+		t.Errorf("expected the journal to be compacted to 2 entries, got %d:\n%s", n, data)
+	}
+
+	var nilLedger *Ledger
+	if err := nilLedger.Reserve(addrN(0), auth.Anonymous); err != nil {
+		t.Errorf("nil ledger: unexpected error: %v", err)
+	}
+	nilLedger.Release(addrN(0))
+}
+
+func TestLedger_tornJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ledger")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "owners")
+
+	// A crash in the middle of an append leaves a partial last line.
+	full := addrN(1).String()
+	journal := "+" + full + "\talice\n+" + full[:10]
+	if err := ioutil.WriteFile(path, []byte(journal), 0666); err != nil {
+		t.Fatal(err)
+	}
+	var table Table
+	if err := table.Set("alice:blocks=2"); err != nil {
+		t.Fatal(err)
+	}
+	l, err := OpenLedger(path, table)
+	if err != nil {
+		t.Fatalf("expected the torn line to be ignored, got %v", err)
+	}
+	defer l.Close()
+	if n := l.Usage("alice"); n != 1 {
+		t.Errorf("expected alice to own 1 block, got %d", n)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "+"+full+"\talice\n" {
+		t.Errorf("expected the torn line to be compacted away, got %q", data)
+	}
+}
@@ -0,0 +1,149 @@
+// Package quota limits how fast each Role may use a CAS server, and how many
+// blocks it may own there.
+package quota
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cloud9-tools/go-cas/server/auth"
+)
+
+// Limits are the limits for one Role.  Zero means unlimited.
+type Limits struct {
+	// Requests is the number of RPCs per second.
+	Requests float64
+
+	// Bytes is the number of block bytes read or written per second.
+	Bytes float64
+
+	// Blocks is the number of blocks that the Role may own.
+	Blocks int64
+}
+
+// Table maps each Role to its Limits.  The limits for auth.Anybody apply to
+// every Role not listed; each such Role still gets its own buckets and quota.
+type Table map[auth.Role]Limits
+
+// For returns the limits for role.
+func (t Table) For(role auth.Role) Limits {
+	if limits, found := t[role]; found {
+		return limits
+	}
+	return t[auth.Anybody]
+}
+
+// HasRates returns true iff any Role is rate limited.
+func (t Table) HasRates() bool {
+	for _, limits := range t {
+		if limits.Requests > 0 || limits.Bytes > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// HasQuotas returns true iff any Role has a block quota.
+func (t Table) HasQuotas() bool {
+	for _, limits := range t {
+		if limits.Blocks > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (t Table) String() string {
+	roles := make([]string, 0, len(t))
+	for role := range t {
+		roles = append(roles, string(role))
+	}
+	sort.Strings(roles)
+	var buf bytes.Buffer
+	item := func(role, kind, value string) {
+		if buf.Len() > 0 {
+			buf.WriteByte(',')
+		}
+		fmt.Fprintf(&buf, "%s:%s=%s", role, kind, value)
+	}
+	for _, role := range roles {
+		limits := t[auth.Role(role)]
+		if limits.Requests > 0 {
+			item(role, "requests", strconv.FormatFloat(limits.Requests, 'g', -1, 64))
+		}
+		if limits.Bytes > 0 {
+			item(role, "bytes", strconv.FormatFloat(limits.Bytes, 'g', -1, 64))
+		}
+		if limits.Blocks > 0 {
+			item(role, "blocks", strconv.FormatInt(limits.Blocks, 10))
+		}
+	}
+	return buf.String()
+}
+
+// Set parses a comma-separated list of limits, each of the form
+// "role:kind=value", where kind is "requests" (per second), "bytes" (per
+// second), or "blocks".
+func (t *Table) Set(in string) error {
+	tmp := make(Table)
+	if strings.TrimSpace(in) == "" {
+		*t = tmp
+		return nil
+	}
+	for _, piece := range strings.Split(in, ",") {
+		kv := strings.SplitN(piece, "=", 2)
+		key := strings.TrimSpace(kv[0])
+		i := strings.LastIndexByte(key, ':')
+		if len(kv) != 2 || i < 0 {
+			return fmt.Errorf("limit %q: expected \"role:kind=value\"", piece)
+		}
+		role := auth.Role(strings.TrimSpace(key[:i]))
+		kind := strings.TrimSpace(key[i+1:])
+		value := strings.TrimSpace(kv[1])
+		if role == auth.Nobody {
+			return fmt.Errorf("limit %q: missing role", piece)
+		}
+		limits := tmp[role]
+		var err error
+		switch kind {
+		case "requests":
+			limits.Requests, err = parseRate(value)
+		case "bytes":
+			limits.Bytes, err = parseRate(value)
+		case "blocks":
+			limits.Blocks, err = strconv.ParseInt(value, 10, 64)
+			if err == nil && limits.Blocks < 0 {
+				err = fmt.Errorf("must not be negative")
+			}
+		default:
+			err = fmt.Errorf("unknown kind %q: expected \"requests\", \"bytes\", or \"blocks\"", kind)
+		}
+		if err != nil {
+			return fmt.Errorf("limit %q: %v", piece, err)
+		}
+		tmp[role] = limits
+	}
+	*t = tmp
+	return nil
+}
+
+func parseRate(in string) (float64, error) {
+	rate, err := strconv.ParseFloat(strings.TrimSuffix(in, "/s"), 64)
+	if err != nil {
+		return 0, err
+	}
+	if rate < 0 {
+		return 0, fmt.Errorf("must not be negative")
+	}
+	return rate, nil
+}
+
+func (t *Table) Get() interface{} {
+	return *t
+}
+
+var _ flag.Getter = (*Table)(nil)
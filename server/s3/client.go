@@ -0,0 +1,193 @@
+// Package s3 is a minimal client for S3-compatible object stores.
+//
+// It speaks just enough of the S3 REST API (path-style GET, PUT, DELETE, and
+// ListObjectsV2, signed with AWS Signature Version 4) to keep CAS blocks in a
+// bucket.  Multipart uploads are deliberately not supported: a CAS block is
+// never larger than common.BlockSize, so a single PUT always suffices.
+package s3
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var ErrNotFound = errors.New("go-cas/server/s3: no such key")
+
+// Error is an error response from the object store.
+type Error struct {
+	StatusCode int
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (err *Error) Error() string {
+	return fmt.Sprintf("go-cas/server/s3: HTTP %d: %s: %s", err.StatusCode, err.Code, err.Message)
+}
+
+// Temporary returns true iff the request might succeed if retried.
+func (err *Error) Temporary() bool {
+	return err.StatusCode >= 500 || err.Code == "SlowDown"
+}
+
+type Client struct {
+	// Endpoint is the base URL of the service, e.g. "https://s3.amazonaws.com".
+	Endpoint string
+
+	Bucket          string
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+
+	// HTTPClient is used to make requests.  If nil, http.DefaultClient.
+	HTTPClient *http.Client
+
+	// Retries is the number of times to retry a request that failed with a
+	// network error or a 5xx status.
+	Retries int
+
+	// RetryDelay is the delay before the first retry.  It doubles on each
+	// subsequent retry.
+	RetryDelay time.Duration
+}
+
+// Get retrieves the object named key.
+func (c *Client) Get(key string) ([]byte, error) {
+	resp, body, err := c.do("GET", key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	return body, nil
+}
+
+// Put stores data as the object named key, in a single request.
+func (c *Client) Put(key string, data []byte) error {
+	_, _, err := c.do("PUT", key, nil, data)
+	return err
+}
+
+// Delete removes the object named key.  Deleting a missing key succeeds.
+func (c *Client) Delete(key string) error {
+	_, _, err := c.do("DELETE", key, nil, nil)
+	return err
+}
+
+type listResult struct {
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+	Contents              []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+}
+
+// List calls fn for each key in the bucket that starts with prefix, in the
+// order returned by the service.
+func (c *Client) List(prefix string, fn func(key string) error) error {
+	token := ""
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		if prefix != "" {
+			query.Set("prefix", prefix)
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		_, body, err := c.do("GET", "", query, nil)
+		if err != nil {
+			return err
+		}
+		var result listResult
+		if err := xml.Unmarshal(body, &result); err != nil {
+			return fmt.Errorf("go-cas/server/s3: bad ListObjectsV2 response: %v", err)
+		}
+		for _, item := range result.Contents {
+			if err := fn(item.Key); err != nil {
+				return err
+			}
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (c *Client) do(method, key string, query url.Values, payload []byte) (*http.Response, []byte, error) {
+	delay := c.RetryDelay
+	if delay <= 0 {
+		delay = 100 * time.Millisecond
+	}
+	var err error
+	for attempt := 0; attempt <= c.Retries; attempt++ {
+		if attempt > 0 {
+			log.Printf("go-cas/server/s3: retrying %s %q after %v: %v", method, key, delay, err)
+			time.Sleep(delay)
+			delay *= 2
+		}
+		var resp *http.Response
+		var body []byte
+		resp, body, err = c.once(method, key, query, payload)
+		if err == nil {
+			return resp, body, nil
+		}
+		if e, ok := err.(*Error); ok && !e.Temporary() {
+			return nil, nil, err
+		}
+	}
+	return nil, nil, err
+}
+
+func (c *Client) once(method, key string, query url.Values, payload []byte) (*http.Response, []byte, error) {
+	u, err := url.Parse(strings.TrimSuffix(c.Endpoint, "/"))
+	if err != nil {
+		return nil, nil, err
+	}
+	u.Path = "/" + c.Bucket
+	if key != "" {
+		u.Path += "/" + key
+	}
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(payload))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.ContentLength = int64(len(payload))
+	c.sign(req, payload)
+
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode/100 == 2 {
+		return resp, body, nil
+	}
+	if resp.StatusCode == http.StatusNotFound && key != "" && (method == "GET" || method == "DELETE") {
+		return resp, nil, nil
+	}
+	e := &Error{StatusCode: resp.StatusCode}
+	if xml.Unmarshal(body, e) != nil || e.Code == "" {
+		e.Code = http.StatusText(resp.StatusCode)
+	}
+	return nil, nil, e
+}
@@ -0,0 +1,170 @@
+// Package s3test provides an in-process fake S3 server for tests.
+//
+// It understands only the requests that package s3 makes, keeps everything
+// in RAM, and does not check signatures.  It can simulate eventual
+// consistency and transient failures.
+package s3test
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type Server struct {
+	*httptest.Server
+
+	// PageSize is the maximum number of keys per ListObjectsV2 page.
+	PageSize int
+
+	// StaleReads is the number of GETs of a freshly PUT key that will
+	// report 404, to simulate eventual consistency.
+	StaleReads int
+
+	// FailNext is the number of upcoming requests that will fail with 503.
+	FailNext int
+
+	mutex   sync.Mutex
+	buckets map[string]map[string][]byte
+	stale   map[string]int
+}
+
+// NewServer starts a fake S3 server with the named buckets.
+func NewServer(buckets ...string) *Server {
+	srv := &Server{
+		PageSize: 1000,
+		buckets:  make(map[string]map[string][]byte),
+		stale:    make(map[string]int),
+	}
+	for _, name := range buckets {
+		srv.buckets[name] = make(map[string][]byte)
+	}
+	srv.Server = httptest.NewServer(srv)
+	return srv
+}
+
+// Objects returns a copy of the named bucket's contents.
+func (srv *Server) Objects(bucket string) map[string][]byte {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	out := make(map[string][]byte, len(srv.buckets[bucket]))
+	for k, v := range srv.buckets[bucket] {
+		out[k] = v
+	}
+	return out
+}
+
+func (srv *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+
+	if srv.FailNext > 0 {
+		srv.FailNext--
+		writeError(w, http.StatusServiceUnavailable, "SlowDown", "please reduce your request rate")
+		return
+	}
+	if r.Header.Get("x-amz-date") == "" {
+		writeError(w, http.StatusForbidden, "AccessDenied", "missing x-amz-date")
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	bucketName, key := path, ""
+	if i := strings.IndexByte(path, '/'); i >= 0 {
+		bucketName, key = path[:i], path[i+1:]
+	}
+	bucket, ok := srv.buckets[bucketName]
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchBucket", bucketName)
+		return
+	}
+
+	switch {
+	case key == "" && r.Method == "GET":
+		srv.list(w, r, bucket)
+
+	case r.Method == "GET":
+		data, found := bucket[key]
+		if found && srv.stale[bucketName+"/"+key] > 0 {
+			srv.stale[bucketName+"/"+key]--
+			found = false
+		}
+		if !found {
+			writeError(w, http.StatusNotFound, "NoSuchKey", key)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Write(data)
+
+	case r.Method == "PUT" && key != "":
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+			return
+		}
+		bucket[key] = data
+		srv.stale[bucketName+"/"+key] = srv.StaleReads
+
+	case r.Method == "DELETE" && key != "":
+		delete(bucket, key)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", r.Method)
+	}
+}
+
+type listResult struct {
+	XMLName               xml.Name `xml:"ListBucketResult"`
+	IsTruncated           bool     `xml:"IsTruncated"`
+	NextContinuationToken string   `xml:"NextContinuationToken,omitempty"`
+	Contents              []listItem
+}
+
+type listItem struct {
+	XMLName xml.Name `xml:"Contents"`
+	Key     string   `xml:"Key"`
+	Size    int      `xml:"Size"`
+}
+
+func (srv *Server) list(w http.ResponseWriter, r *http.Request, bucket map[string][]byte) {
+	prefix := r.URL.Query().Get("prefix")
+	after := r.URL.Query().Get("continuation-token")
+	var keys []string
+	for k := range bucket {
+		if strings.HasPrefix(k, prefix) && k > after {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var result listResult
+	if len(keys) > srv.PageSize {
+		keys = keys[:srv.PageSize]
+		result.IsTruncated = true
+		result.NextContinuationToken = keys[len(keys)-1]
+	}
+	for _, k := range keys {
+		result.Contents = append(result.Contents, listItem{Key: k, Size: len(bucket[k])})
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	fmt.Fprint(w, xml.Header)
+	xml.NewEncoder(w).Encode(result)
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprint(w, xml.Header)
+	xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string   `xml:"Code"`
+		Message string   `xml:"Message"`
+	}{Code: code, Message: message})
+}
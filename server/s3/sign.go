@@ -0,0 +1,112 @@
+package s3
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const amzDateFormat = "20060102T150405Z"
+
+// sign adds AWS Signature Version 4 headers to req.  req.URL.RawQuery must
+// already be in canonical form; see canonicalQuery.
+// See http://docs.aws.amazon.com/general/latest/gr/sigv4_signing.html
+func (c *Client) sign(req *http.Request, payload []byte) {
+	c.signAt(req, payload, time.Now())
+}
+
+func (c *Client) signAt(req *http.Request, payload []byte, t time.Time) {
+	amzDate := t.UTC().Format(amzDateFormat)
+	date := amzDate[:8]
+	payloadHash := hexSHA256(payload)
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+	if c.AccessKeyID == "" {
+		return
+	}
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := "host:" + req.URL.Host + "\n" +
+		"x-amz-content-sha256:" + payloadHash + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uriEncode(req.URL.Path, false),
+		req.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	region := c.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	scope := date + "/" + region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" +
+		hexSHA256([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+c.SecretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 "+
+		"Credential="+c.AccessKeyID+"/"+scope+", "+
+		"SignedHeaders="+signedHeaders+", "+
+		"Signature="+signature)
+}
+
+// canonicalQuery encodes query in the canonical form SigV4 requires: sorted
+// by key, with RFC 3986 percent-encoding.
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var pieces []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			pieces = append(pieces, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	return strings.Join(pieces, "&")
+}
+
+func uriEncode(in string, encodeSlash bool) string {
+	const hexDigits = "0123456789ABCDEF"
+	var out []byte
+	for i := 0; i < len(in); i++ {
+		ch := in[i]
+		switch {
+		case 'A' <= ch && ch <= 'Z', 'a' <= ch && ch <= 'z', '0' <= ch && ch <= '9':
+			out = append(out, ch)
+		case ch == '-', ch == '_', ch == '.', ch == '~':
+			out = append(out, ch)
+		case ch == '/' && !encodeSlash:
+			out = append(out, ch)
+		default:
+			out = append(out, '%', hexDigits[ch>>4], hexDigits[ch&15])
+		}
+	}
+	return string(out)
+}
+
+func hexSHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -6,7 +6,8 @@ import (
 	"syscall"
 )
 
-var IgnoreSignals = []os.Signal{
+// ReloadSignals ask a server to reload its configuration files.
+var ReloadSignals = []os.Signal{
 	syscall.SIGHUP,
 }
 var ShutdownSignals = []os.Signal{